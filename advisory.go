@@ -0,0 +1,105 @@
+package blocklist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+// Advisory flags content whose raw digest matches a blocked entry even though
+// its CID isn't blocked, usually because it was added with different chunker
+// settings.
+type Advisory struct {
+	Id        cid.Cid        // Id is the content that was checked.
+	Digest    cid.Cid        // Digest is the raw digest that matched.
+	Entry     *BlocklistItem // Entry is the blocked entry the digest belongs to.
+	CreatedAt time.Time
+}
+
+// ReviewQueue receives advisories that need a human decision.
+type ReviewQueue interface {
+	Enqueue(ctx context.Context, adv *Advisory) error
+}
+
+// VariantAdvisor detects chunking variants of blocked unixfs files. Entries
+// are only matched if they were blocked with BlockData.Rehash, or with their
+// raw digests in BlockData.Digests.
+//
+// Matches are queued for review instead of being blocked, since a matching
+// digest is strong evidence but the decision still belongs to a human.
+type VariantAdvisor struct {
+	blocklist Blocklist
+	datastore ds.Read
+	queue     ReviewQueue
+}
+
+func NewVariantAdvisor(b Blocklist, d ds.Read, q ReviewQueue) *VariantAdvisor {
+	return &VariantAdvisor{b, d, q}
+}
+
+// Check reassembles the content referenced by `id` from the local datastore
+// and queues an advisory if one of its raw digests is blocked while `id`
+// itself isn't. It returns the queued advisory, or nil if there was no match.
+func (a *VariantAdvisor) Check(ctx context.Context, id cid.Cid) (*Advisory, error) {
+	if exists, err := a.blocklist.Contains(ctx, id); err != nil || exists {
+		return nil, err
+	}
+
+	digests, err := LocalRawDigests(ctx, a.datastore, id)
+	if err == ErrNotFile {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, d := range digests {
+		if exists, err := a.blocklist.Contains(ctx, d); err != nil {
+			return nil, err
+		} else if !exists {
+			continue
+		}
+
+		entry, err := a.blocklist.Search(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+		adv := &Advisory{
+			Id:        id,
+			Digest:    d,
+			Entry:     entry,
+			CreatedAt: time.Now(),
+		}
+		log.Infof("%v matches raw digest %v of blocked %v, queueing for review", RedactID(id), RedactID(d), redactHash(entry.Hash))
+		if err := a.queue.Enqueue(ctx, adv); err != nil {
+			return nil, err
+		}
+		return adv, nil
+	}
+	return nil, nil
+}
+
+// MemoryReviewQueue is a ReviewQueue that keeps advisories in memory until
+// they are taken.
+type MemoryReviewQueue struct {
+	mu      sync.Mutex
+	pending []*Advisory
+}
+
+func (q *MemoryReviewQueue) Enqueue(ctx context.Context, adv *Advisory) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, adv)
+	return nil
+}
+
+// Take removes and returns all pending advisories, oldest first.
+func (q *MemoryReviewQueue) Take() []*Advisory {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
@@ -1,9 +1,12 @@
 package blocklist
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	logging "github.com/ipfs/go-log"
@@ -13,27 +16,127 @@ import (
 
 var log = logging.Logger("blocklist")
 
+// Errors returned by all backends, translated from their native errors so
+// that callers can check for them with errors.Is.
 var (
-	ErrNotFound = fmt.Errorf("blocklist item not found")
+	ErrNotFound           = fmt.Errorf("blocklist item not found")
+	ErrAlreadyBlocked     = fmt.Errorf("content is already blocked")
+	ErrBackendUnavailable = fmt.Errorf("blocklist backend unavailable")
+	ErrInvalidCID         = fmt.Errorf("invalid cid")
 )
 
 type Blocklist interface {
-	Block(id cid.Cid, data BlockData) (bool, error)
-	Unblock(id cid.Cid) error
-	Search(id cid.Cid) (*BlocklistItem, error)
-	Purge(id cid.Cid) error
-	GetLogs(limit int) ([]*Action, error)
-	AddLog(act *Action) error
+	Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error)
+	BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error)
+	Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error)
+	UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error)
+	Update(ctx context.Context, id cid.Cid, patch BlockData) error
+	Search(ctx context.Context, id cid.Cid) (*BlocklistItem, error)
+	AddComment(ctx context.Context, id cid.Cid, c *Comment) error
+	SearchMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]*BlocklistItem, error)
+	SearchByContent(ctx context.Context, url string) ([]*BlocklistItem, error)
+	SearchHistory(ctx context.Context, id cid.Cid) (*BlocklistItem, error)
+	List(ctx context.Context, opts ListOptions) (*ListPage, error)
+	Count(ctx context.Context) (int64, error)
+	Purge(ctx context.Context, id cid.Cid) error
+	GetLogs(ctx context.Context, q LogQuery) ([]*Action, error)
+	AddLog(ctx context.Context, act *Action) error
 	Contains(ctx context.Context, id cid.Cid) (bool, error)
+	ContainsMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error)
+	Healthy(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Capabilities describes the optional features of a backend, so that callers
+// can degrade gracefully instead of checking its type.
+type Capabilities struct {
+	Purge        bool // Purge removes content from a local datastore.
+	Rehash       bool // Rehash is whether BlockData.Rehash is honoured.
+	Transactions bool // Transactions is whether BlockMany and UnblockMany are atomic.
+	// ChronologicalList is whether List orders entries by the time they were
+	// blocked, rather than by CID.
+	ChronologicalList bool
+	// IndexedCount is whether Count is answered without going over every
+	// entry.
+	IndexedCount bool
+}
+
+// Capable is implemented by blocklists that report their Capabilities.
+type Capable interface {
+	Capabilities() Capabilities
+}
+
+// CapabilitiesOf returns the Capabilities of `b`, or none if it doesn't report
+// them.
+func CapabilitiesOf(b Blocklist) Capabilities {
+	if c, ok := b.(Capable); ok {
+		return c.Capabilities()
+	}
+	return Capabilities{}
 }
 
 // BlocklistItem packages information about why/when content was blocked, and by
 // whom.
 type BlocklistItem struct {
 	Hash    string
+	Digests []string // Digests are alternative hashes of the same content.
 	Content []string
 	Reason  string
 	User    string
+
+	Metadata map[string]string `json:",omitempty"`
+	// References are the tickets, court orders, emails, or reports the entry
+	// is based on.
+	References []Reference `json:",omitempty"`
+
+	CreatedAt time.Time // CreatedAt is when the content was blocked.
+	UpdatedAt time.Time
+	// UnblockedAt is when the content was unblocked, for entries returned by
+	// Unblock and SearchHistory, and the entries they supersede.
+	UnblockedAt *time.Time `json:",omitempty"`
+	// PurgedAt is when the content was purged from the local datastore while
+	// it was blocked, if it was.
+	PurgedAt *time.Time `json:",omitempty"`
+
+	// Comments are the review discussion of the entry, oldest first. They are
+	// only returned by Search, SearchMany, and SearchByContent.
+	Comments []Comment `json:",omitempty"`
+
+	// Supersedes is the entry of the same content that was unblocked before
+	// this one was blocked, if any. Following it gives the full history of
+	// the content.
+	Supersedes *BlocklistItem `json:",omitempty"`
+}
+
+// Comment is a note left by an operator on a blocklist entry. Comments can't
+// be edited or deleted.
+type Comment struct {
+	Author    string // Author is the email of the operator.
+	Text      string
+	CreatedAt time.Time
+}
+
+// validate returns a *ValidationError if the comment has no author or text.
+func (c *Comment) validate() error {
+	var errs []FieldError
+	if strings.TrimSpace(c.Author) == "" {
+		errs = append(errs, FieldError{"Author", c.Author, "empty"})
+	}
+	if strings.TrimSpace(c.Text) == "" {
+		errs = append(errs, FieldError{"Text", c.Text, "empty"})
+	}
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+	return nil
+}
+
+func (c *Comment) MarshalBinary() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *Comment) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, c)
 }
 
 func (b *BlocklistItem) MarshalBinary() ([]byte, error) {
@@ -44,22 +147,251 @@ func (b *BlocklistItem) UnmarshalBinary(data []byte) error {
 	return json.Unmarshal(data, &b)
 }
 
+// DefaultListLimit is the page size of List if none is given.
+const DefaultListLimit = 100
+
+// ListOrder is the order List returns entries in.
+type ListOrder int
+
+const (
+	ListAscending ListOrder = iota
+	ListDescending
+)
+
+// ListOptions selects the page of entries returned by List.
+type ListOptions struct {
+	Limit int // Limit is the page size, DefaultListLimit if zero.
+	// Offset skips the first entries. It is ignored if Cursor is set.
+	Offset int
+	// Cursor continues listing after the last entry of a previous page.
+	Cursor string
+	Order  ListOrder
+	// Ref only lists entries with a matching reference. Its empty fields
+	// match anything.
+	Ref Reference
+}
+
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultListLimit
+	}
+	return o.Limit
+}
+
+// ListPage is a page of blocklist entries.
+type ListPage struct {
+	Items []*BlocklistItem
+	// Next is the cursor of the next page, or empty if this is the last one.
+	Next string
+}
+
 // BlockData is what the "Block Content" form should be pre-populated with.
+// Backends normalize it with Validate, and reject it if it is invalid.
 type BlockData struct {
 	Blocked []string
 
+	// Digests are alternative hashes of the same content (for example, a
+	// blake2b or blake3 hash of a list entry that was published as sha256).
+	// They are stored with the entry and matched by Contains like the primary
+	// CID.
+	Digests []cid.Cid
+	// Rehash adds the raw digests of the content to Digests, if it is still
+	// stored locally, so that re-uploads with different chunking are matched
+	// by ContainsRawDigest. It has to be set before the content is purged.
+	Rehash bool
+
 	Content []string // Content is the URL/hash of the content to block.
 	Reason  string   // Reason is an explanation for why the content is being blocked.
 	User    string   // User is the email of the user that made the request.
+
+	// Metadata are free-form attributes of the entry, like ticket IDs,
+	// jurisdiction codes, or notice references.
+	Metadata map[string]string
+	// References are the external documents the entry is based on. They
+	// replace putting ticket or notice URLs in Reason.
+	References []Reference
+}
+
+// LogQuery selects the actions returned by GetLogs. Zero-valued fields don't
+// filter anything.
+type LogQuery struct {
+	Limit int
+	// Before only returns actions with a lower Seq. Passing the Seq of the
+	// last action of a page returns the next page.
+	Before uint64
+
+	User  string     // User only returns actions of this user.
+	Typ   ActionType // Typ only returns actions of this type.
+	Since time.Time  // Since only returns actions created at or after it.
+	Until time.Time  // Until only returns actions created before it.
+	Id    cid.Cid    // Id only returns actions on this content, under any CID.
+}
+
+// filters returns true if `q` filters on anything else than Limit and Before.
+func (q LogQuery) filters() bool {
+	return q.User != "" || q.Typ != "" || !q.Since.IsZero() || !q.Until.IsZero() || q.Id.Defined()
+}
+
+// Match returns true if `act` passes the filters of `q`, not considering
+// Limit and Before.
+func (q LogQuery) Match(act *Action) bool {
+	if q.User != "" && act.User != q.User {
+		return false
+	} else if q.Typ != "" && act.Typ != q.Typ {
+		return false
+	} else if !q.Since.IsZero() && act.CreatedAt.Before(q.Since) {
+		return false
+	} else if !q.Until.IsZero() && !act.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.Id.Defined() {
+		for _, id := range act.Ids {
+			if bytes.Equal(id.Hash(), q.Id.Hash()) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ActionType is the kind of an Action.
+type ActionType string
+
+const (
+	ActionBlock    ActionType = "block"
+	ActionUnblock  ActionType = "unblock"
+	ActionUndo     ActionType = "undo"     // ActionUndo inverts an earlier action.
+	ActionSchedule ActionType = "schedule" // ActionSchedule changes when a list is enforced.
+	ActionPurge    ActionType = "purge"
+	ActionUpdate   ActionType = "update" // ActionUpdate changes the metadata of entries.
+	ActionImport   ActionType = "import"
+	// ActionReport records that content was reported, before it is reviewed,
+	// so that the time it took to block it can be measured. See SLAStats.
+	ActionReport ActionType = "report"
+)
+
+// Valid returns true if `t` is one of the ActionType constants.
+func (t ActionType) Valid() bool {
+	switch t {
+	case ActionBlock, ActionUnblock, ActionUndo, ActionSchedule, ActionPurge, ActionUpdate, ActionImport, ActionReport:
+		return true
+	}
+	return false
 }
 
 // Action is an auditable action that a user requested us to perform.
 type Action struct {
-	Typ       string // Typ is either "block" or "unblock".
+	Seq       uint64 // Seq orders actions, and is set when they are logged.
+	Typ       ActionType
 	Ids       []cid.Cid
+	Undoes    uint64   // Undoes is the Seq of the action an ActionUndo inverted.
+	Changes   []Change `json:",omitempty"` // Changes are the fields an ActionUpdate changed.
 	Reason    string
 	User      string
 	CreatedAt time.Time
+
+	// References are the external documents that requested the action.
+	References []Reference `json:",omitempty"`
+	// HLC orders the action in audit logs merged across sites. It is set by
+	// ClockedBlocklist.
+	HLC *HLC `json:",omitempty"`
+}
+
+// Change is a field of a blocklist entry changed by an ActionUpdate.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Diff returns the metadata fields that differ between `old` and `new`.
+// Lists and References are compared joined by newlines, and Metadata key by
+// key, as "Metadata[key]".
+func Diff(old, new *BlocklistItem) []Change {
+	var changes []Change
+	for _, f := range []struct {
+		name     string
+		old, new string
+	}{
+		{"Content", strings.Join(old.Content, "\n"), strings.Join(new.Content, "\n")},
+		{"Digests", strings.Join(old.Digests, "\n"), strings.Join(new.Digests, "\n")},
+		{"Reason", old.Reason, new.Reason},
+		{"User", old.User, new.User},
+		{"References", joinReferences(old.References), joinReferences(new.References)},
+	} {
+		if f.old != f.new {
+			changes = append(changes, Change{f.name, f.old, f.new})
+		}
+	}
+
+	keys := make([]string, 0, len(old.Metadata)+len(new.Metadata))
+	for k := range old.Metadata {
+		keys = append(keys, k)
+	}
+	for k := range new.Metadata {
+		if _, ok := old.Metadata[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if o, n := old.Metadata[k], new.Metadata[k]; o != n {
+			changes = append(changes, Change{"Metadata[" + k + "]", o, n})
+		}
+	}
+	return changes
+}
+
+// patch returns a copy of `item` with the Reason, Content, Metadata, and
+// References set in `patch`. Fields that are empty in `patch` are kept.
+func (item BlocklistItem) patch(patch BlockData) *BlocklistItem {
+	if patch.Reason != "" {
+		item.Reason = patch.Reason
+	}
+	if patch.Content != nil {
+		item.Content = patch.Content
+	}
+	if patch.Metadata != nil {
+		item.Metadata = patch.Metadata
+	}
+	if patch.References != nil {
+		item.References = patch.References
+	}
+	return &item
+}
+
+// blockData returns the BlockData that blocks the content of `item` with the
+// same metadata, to copy it to another blocklist.
+func (item BlocklistItem) blockData() (BlockData, error) {
+	data := BlockData{
+		Content:    item.Content,
+		Reason:     item.Reason,
+		User:       item.User,
+		Metadata:   item.Metadata,
+		References: item.References,
+	}
+	for _, d := range item.Digests {
+		id, err := cid.Decode(d)
+		if err != nil {
+			return BlockData{}, fmt.Errorf("digest %q: %w: %v", d, ErrInvalidCID, err)
+		}
+		data.Digests = append(data.Digests, id)
+	}
+	return data, nil
+}
+
+// updateAction returns the action that logs the update of `id` from `old` to
+// `new` by `user`.
+func updateAction(id cid.Cid, old, new *BlocklistItem, user string) *Action {
+	return &Action{
+		Typ:       ActionUpdate,
+		Ids:       []cid.Cid{id},
+		Changes:   Diff(old, new),
+		Reason:    new.Reason,
+		User:      user,
+		CreatedAt: time.Now(),
+	}
 }
 
 func (l Action) MarshalBinary() ([]byte, error) {
@@ -71,5 +403,98 @@ func (l *Action) UnmarshalBinary(data []byte) error {
 }
 
 func (a *Action) String() string {
-	return fmt.Sprintf("%v\t %v by %v: %v: %v", a.CreatedAt.Format(time.RFC3339), a.Typ, a.User, a.Ids, a.Reason)
+	return fmt.Sprintf("%v\t %v by %v: %v: %v", a.CreatedAt.Format(time.RFC3339), a.Typ, a.User, redactIDs(a.Ids), a.Reason)
+}
+
+// ContainsAny returns whether any of `ids` is blocked, and the first of them
+// in order that is. It is meant for the CIDs a gateway resolves a path into,
+// root first, which are looked up with a single ContainsMany call: one
+// query on the SQL backends.
+func ContainsAny(ctx context.Context, b Blocklist, ids []cid.Cid) (bool, cid.Cid, error) {
+	if len(ids) == 0 {
+		return false, cid.Undef, nil
+	}
+	res, err := b.ContainsMany(ctx, ids)
+	if err != nil {
+		return false, cid.Undef, err
+	}
+	for _, id := range ids {
+		if res[id] {
+			return true, id, nil
+		}
+	}
+	return false, cid.Undef, nil
+}
+
+// Normalize returns `id` in the form entries are stored under by default:
+// CIDv0 is converted to CIDv1, and CIDv1 is kept as is.
+func Normalize(id cid.Cid) (cid.Cid, error) {
+	return normalize(CIDv1, id)
+}
+
+// NormalizeString parses `s` as a CID in any multibase, and returns the
+// string form of its Normalize.
+func NormalizeString(s string) (string, error) {
+	id, err := cid.Decode(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	id, err = Normalize(id)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// normalize applies `t` to `id`, or CIDv1 if `t` is nil. Any failure is
+// reported as ErrInvalidCID.
+func normalize(t Transformer, id cid.Cid) (cid.Cid, error) {
+	if !id.Defined() {
+		return cid.Undef, ErrInvalidCID
+	}
+	if t == nil {
+		t = CIDv1
+	}
+	id, err := t.Transform(id)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	return id, nil
+}
+
+// Error is returned by the backends, with the operation and content it failed
+// on. The error it wraps can be checked with errors.Is and errors.As.
+type Error struct {
+	Backend string  // Backend is the kind of backend, like "pg".
+	Op      string  // Op is the method that failed, like "contains".
+	Id      cid.Cid // Id is undefined for operations on several ids.
+	Err     error
+}
+
+func (e *Error) Error() string {
+	s := "blocklist: " + e.Backend + ": " + e.Op
+	if id, err := Normalize(e.Id); err == nil {
+		s += " " + RedactID(id)
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapError wraps *errp, if any, in an Error. Errors already wrapped by a
+// method called internally are rewrapped with the outer operation.
+func wrapError(errp *error, backend, op string, id cid.Cid) {
+	if *errp == nil {
+		return
+	}
+	err := *errp
+	if e, ok := err.(*Error); ok {
+		err = e.Err
+		if !id.Defined() {
+			id = e.Id
+		}
+	}
+	*errp = &Error{Backend: backend, Op: op, Id: id, Err: err}
 }
@@ -0,0 +1,921 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package blocklistrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BlocklistClient is the client API for Blocklist service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BlocklistClient interface {
+	Block(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error)
+	BlockMany(ctx context.Context, in *BlockManyRequest, opts ...grpc.CallOption) (*BlockManyResponse, error)
+	Unblock(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error)
+	UnblockMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*FoundResponse, error)
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Search(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error)
+	AddComment(ctx context.Context, in *AddCommentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	SearchMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*ItemsByCidResponse, error)
+	SearchByContent(ctx context.Context, in *SearchByContentRequest, opts ...grpc.CallOption) (*ItemsResponse, error)
+	SearchHistory(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	Count(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CountResponse, error)
+	Purge(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetLogs streams the actions that match the query, most recent first.
+	GetLogs(ctx context.Context, in *LogQuery, opts ...grpc.CallOption) (Blocklist_GetLogsClient, error)
+	// AddLog returns the action as it was logged, with its seq set.
+	AddLog(ctx context.Context, in *Action, opts ...grpc.CallOption) (*Action, error)
+	Contains(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ContainsResponse, error)
+	ContainsMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*FoundResponse, error)
+	Healthy(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Capabilities(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+	// Entries streams every entry, in the order of List, reading them a page
+	// of the request's limit at a time.
+	Entries(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Blocklist_EntriesClient, error)
+	// Lookup answers a stream of CIDs with whether each is blocked, in order,
+	// over one call.
+	Lookup(ctx context.Context, opts ...grpc.CallOption) (Blocklist_LookupClient, error)
+}
+
+type blocklistClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBlocklistClient(cc grpc.ClientConnInterface) BlocklistClient {
+	return &blocklistClient{cc}
+}
+
+func (c *blocklistClient) Block(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*BlockResponse, error) {
+	out := new(BlockResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Block", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) BlockMany(ctx context.Context, in *BlockManyRequest, opts ...grpc.CallOption) (*BlockManyResponse, error) {
+	out := new(BlockManyResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/BlockMany", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Unblock(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error) {
+	out := new(ItemResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Unblock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) UnblockMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*FoundResponse, error) {
+	out := new(FoundResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/UnblockMany", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Search(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error) {
+	out := new(ItemResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) AddComment(ctx context.Context, in *AddCommentRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/AddComment", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) SearchMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*ItemsByCidResponse, error) {
+	out := new(ItemsByCidResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/SearchMany", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) SearchByContent(ctx context.Context, in *SearchByContentRequest, opts ...grpc.CallOption) (*ItemsResponse, error) {
+	out := new(ItemsResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/SearchByContent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) SearchHistory(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ItemResponse, error) {
+	out := new(ItemResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/SearchHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/List", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Count(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CountResponse, error) {
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Count", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Purge(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Purge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) GetLogs(ctx context.Context, in *LogQuery, opts ...grpc.CallOption) (Blocklist_GetLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocklist_ServiceDesc.Streams[0], "/cloudflare.blocklist.v1.Blocklist/GetLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocklistGetLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Blocklist_GetLogsClient interface {
+	Recv() (*Action, error)
+	grpc.ClientStream
+}
+
+type blocklistGetLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocklistGetLogsClient) Recv() (*Action, error) {
+	m := new(Action)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blocklistClient) AddLog(ctx context.Context, in *Action, opts ...grpc.CallOption) (*Action, error) {
+	out := new(Action)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/AddLog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Contains(ctx context.Context, in *CidRequest, opts ...grpc.CallOption) (*ContainsResponse, error) {
+	out := new(ContainsResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Contains", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) ContainsMany(ctx context.Context, in *CidsRequest, opts ...grpc.CallOption) (*FoundResponse, error) {
+	out := new(FoundResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/ContainsMany", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Healthy(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Healthy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Capabilities(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	out := new(CapabilitiesResponse)
+	err := c.cc.Invoke(ctx, "/cloudflare.blocklist.v1.Blocklist/Capabilities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blocklistClient) Entries(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Blocklist_EntriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocklist_ServiceDesc.Streams[1], "/cloudflare.blocklist.v1.Blocklist/Entries", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocklistEntriesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Blocklist_EntriesClient interface {
+	Recv() (*Item, error)
+	grpc.ClientStream
+}
+
+type blocklistEntriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocklistEntriesClient) Recv() (*Item, error) {
+	m := new(Item)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blocklistClient) Lookup(ctx context.Context, opts ...grpc.CallOption) (Blocklist_LookupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocklist_ServiceDesc.Streams[2], "/cloudflare.blocklist.v1.Blocklist/Lookup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocklistLookupClient{stream}
+	return x, nil
+}
+
+type Blocklist_LookupClient interface {
+	Send(*CidRequest) error
+	Recv() (*LookupResponse, error)
+	grpc.ClientStream
+}
+
+type blocklistLookupClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocklistLookupClient) Send(m *CidRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *blocklistLookupClient) Recv() (*LookupResponse, error) {
+	m := new(LookupResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BlocklistServer is the server API for Blocklist service.
+// All implementations must embed UnimplementedBlocklistServer
+// for forward compatibility
+type BlocklistServer interface {
+	Block(context.Context, *BlockRequest) (*BlockResponse, error)
+	BlockMany(context.Context, *BlockManyRequest) (*BlockManyResponse, error)
+	Unblock(context.Context, *CidRequest) (*ItemResponse, error)
+	UnblockMany(context.Context, *CidsRequest) (*FoundResponse, error)
+	Update(context.Context, *UpdateRequest) (*emptypb.Empty, error)
+	Search(context.Context, *CidRequest) (*ItemResponse, error)
+	AddComment(context.Context, *AddCommentRequest) (*emptypb.Empty, error)
+	SearchMany(context.Context, *CidsRequest) (*ItemsByCidResponse, error)
+	SearchByContent(context.Context, *SearchByContentRequest) (*ItemsResponse, error)
+	SearchHistory(context.Context, *CidRequest) (*ItemResponse, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	Count(context.Context, *emptypb.Empty) (*CountResponse, error)
+	Purge(context.Context, *CidRequest) (*emptypb.Empty, error)
+	// GetLogs streams the actions that match the query, most recent first.
+	GetLogs(*LogQuery, Blocklist_GetLogsServer) error
+	// AddLog returns the action as it was logged, with its seq set.
+	AddLog(context.Context, *Action) (*Action, error)
+	Contains(context.Context, *CidRequest) (*ContainsResponse, error)
+	ContainsMany(context.Context, *CidsRequest) (*FoundResponse, error)
+	Healthy(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	Capabilities(context.Context, *emptypb.Empty) (*CapabilitiesResponse, error)
+	// Entries streams every entry, in the order of List, reading them a page
+	// of the request's limit at a time.
+	Entries(*ListRequest, Blocklist_EntriesServer) error
+	// Lookup answers a stream of CIDs with whether each is blocked, in order,
+	// over one call.
+	Lookup(Blocklist_LookupServer) error
+	mustEmbedUnimplementedBlocklistServer()
+}
+
+// UnimplementedBlocklistServer must be embedded to have forward compatible implementations.
+type UnimplementedBlocklistServer struct {
+}
+
+func (UnimplementedBlocklistServer) Block(context.Context, *BlockRequest) (*BlockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Block not implemented")
+}
+func (UnimplementedBlocklistServer) BlockMany(context.Context, *BlockManyRequest) (*BlockManyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BlockMany not implemented")
+}
+func (UnimplementedBlocklistServer) Unblock(context.Context, *CidRequest) (*ItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unblock not implemented")
+}
+func (UnimplementedBlocklistServer) UnblockMany(context.Context, *CidsRequest) (*FoundResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnblockMany not implemented")
+}
+func (UnimplementedBlocklistServer) Update(context.Context, *UpdateRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedBlocklistServer) Search(context.Context, *CidRequest) (*ItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedBlocklistServer) AddComment(context.Context, *AddCommentRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddComment not implemented")
+}
+func (UnimplementedBlocklistServer) SearchMany(context.Context, *CidsRequest) (*ItemsByCidResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchMany not implemented")
+}
+func (UnimplementedBlocklistServer) SearchByContent(context.Context, *SearchByContentRequest) (*ItemsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchByContent not implemented")
+}
+func (UnimplementedBlocklistServer) SearchHistory(context.Context, *CidRequest) (*ItemResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchHistory not implemented")
+}
+func (UnimplementedBlocklistServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedBlocklistServer) Count(context.Context, *emptypb.Empty) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedBlocklistServer) Purge(context.Context, *CidRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Purge not implemented")
+}
+func (UnimplementedBlocklistServer) GetLogs(*LogQuery, Blocklist_GetLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetLogs not implemented")
+}
+func (UnimplementedBlocklistServer) AddLog(context.Context, *Action) (*Action, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddLog not implemented")
+}
+func (UnimplementedBlocklistServer) Contains(context.Context, *CidRequest) (*ContainsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Contains not implemented")
+}
+func (UnimplementedBlocklistServer) ContainsMany(context.Context, *CidsRequest) (*FoundResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ContainsMany not implemented")
+}
+func (UnimplementedBlocklistServer) Healthy(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Healthy not implemented")
+}
+func (UnimplementedBlocklistServer) Capabilities(context.Context, *emptypb.Empty) (*CapabilitiesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (UnimplementedBlocklistServer) Entries(*ListRequest, Blocklist_EntriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method Entries not implemented")
+}
+func (UnimplementedBlocklistServer) Lookup(Blocklist_LookupServer) error {
+	return status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedBlocklistServer) mustEmbedUnimplementedBlocklistServer() {}
+
+// UnsafeBlocklistServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BlocklistServer will
+// result in compilation errors.
+type UnsafeBlocklistServer interface {
+	mustEmbedUnimplementedBlocklistServer()
+}
+
+func RegisterBlocklistServer(s grpc.ServiceRegistrar, srv BlocklistServer) {
+	s.RegisterService(&Blocklist_ServiceDesc, srv)
+}
+
+func _Blocklist_Block_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Block(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Block",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Block(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_BlockMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockManyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).BlockMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/BlockMany",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).BlockMany(ctx, req.(*BlockManyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Unblock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Unblock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Unblock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Unblock(ctx, req.(*CidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_UnblockMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).UnblockMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/UnblockMany",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).UnblockMany(ctx, req.(*CidsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Search(ctx, req.(*CidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_AddComment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddCommentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).AddComment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/AddComment",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).AddComment(ctx, req.(*AddCommentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_SearchMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).SearchMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/SearchMany",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).SearchMany(ctx, req.(*CidsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_SearchByContent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchByContentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).SearchByContent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/SearchByContent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).SearchByContent(ctx, req.(*SearchByContentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_SearchHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).SearchHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/SearchHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).SearchHistory(ctx, req.(*CidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Count",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Count(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Purge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Purge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Purge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Purge(ctx, req.(*CidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_GetLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(LogQuery)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlocklistServer).GetLogs(m, &blocklistGetLogsServer{stream})
+}
+
+type Blocklist_GetLogsServer interface {
+	Send(*Action) error
+	grpc.ServerStream
+}
+
+type blocklistGetLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocklistGetLogsServer) Send(m *Action) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Blocklist_AddLog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Action)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).AddLog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/AddLog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).AddLog(ctx, req.(*Action))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Contains_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Contains(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Contains",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Contains(ctx, req.(*CidRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_ContainsMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CidsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).ContainsMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/ContainsMany",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).ContainsMany(ctx, req.(*CidsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Healthy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Healthy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Healthy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Healthy(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlocklistServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudflare.blocklist.v1.Blocklist/Capabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlocklistServer).Capabilities(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Blocklist_Entries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlocklistServer).Entries(m, &blocklistEntriesServer{stream})
+}
+
+type Blocklist_EntriesServer interface {
+	Send(*Item) error
+	grpc.ServerStream
+}
+
+type blocklistEntriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocklistEntriesServer) Send(m *Item) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Blocklist_Lookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BlocklistServer).Lookup(&blocklistLookupServer{stream})
+}
+
+type Blocklist_LookupServer interface {
+	Send(*LookupResponse) error
+	Recv() (*CidRequest, error)
+	grpc.ServerStream
+}
+
+type blocklistLookupServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocklistLookupServer) Send(m *LookupResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *blocklistLookupServer) Recv() (*CidRequest, error) {
+	m := new(CidRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Blocklist_ServiceDesc is the grpc.ServiceDesc for Blocklist service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Blocklist_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cloudflare.blocklist.v1.Blocklist",
+	HandlerType: (*BlocklistServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Block",
+			Handler:    _Blocklist_Block_Handler,
+		},
+		{
+			MethodName: "BlockMany",
+			Handler:    _Blocklist_BlockMany_Handler,
+		},
+		{
+			MethodName: "Unblock",
+			Handler:    _Blocklist_Unblock_Handler,
+		},
+		{
+			MethodName: "UnblockMany",
+			Handler:    _Blocklist_UnblockMany_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Blocklist_Update_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _Blocklist_Search_Handler,
+		},
+		{
+			MethodName: "AddComment",
+			Handler:    _Blocklist_AddComment_Handler,
+		},
+		{
+			MethodName: "SearchMany",
+			Handler:    _Blocklist_SearchMany_Handler,
+		},
+		{
+			MethodName: "SearchByContent",
+			Handler:    _Blocklist_SearchByContent_Handler,
+		},
+		{
+			MethodName: "SearchHistory",
+			Handler:    _Blocklist_SearchHistory_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Blocklist_List_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _Blocklist_Count_Handler,
+		},
+		{
+			MethodName: "Purge",
+			Handler:    _Blocklist_Purge_Handler,
+		},
+		{
+			MethodName: "AddLog",
+			Handler:    _Blocklist_AddLog_Handler,
+		},
+		{
+			MethodName: "Contains",
+			Handler:    _Blocklist_Contains_Handler,
+		},
+		{
+			MethodName: "ContainsMany",
+			Handler:    _Blocklist_ContainsMany_Handler,
+		},
+		{
+			MethodName: "Healthy",
+			Handler:    _Blocklist_Healthy_Handler,
+		},
+		{
+			MethodName: "Capabilities",
+			Handler:    _Blocklist_Capabilities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetLogs",
+			Handler:       _Blocklist_GetLogs_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Entries",
+			Handler:       _Blocklist_Entries_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Lookup",
+			Handler:       _Blocklist_Lookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "blocklist.proto",
+}
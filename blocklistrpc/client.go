@@ -0,0 +1,294 @@
+package blocklistrpc
+
+import (
+	"context"
+	"io"
+	"time"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// capabilitiesTimeout is how long Capabilities waits for the server.
+const capabilitiesTimeout = 5 * time.Second
+
+// Client is a Blocklist served by a Server. Errors of the served blocklist
+// are returned as the errors of the blocklist package they stand for, in a
+// blocklist.Error of the "grpc" backend, and errors reaching the server as
+// ErrBackendUnavailable.
+type Client struct {
+	c BlocklistClient
+}
+
+var (
+	_ blocklist.Blocklist = (*Client)(nil)
+	_ blocklist.Capable   = (*Client)(nil)
+)
+
+// NewClient returns a client of the server at the other end of `cc`. The
+// connection is owned by the caller, and isn't closed by Close.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{c: NewBlocklistClient(cc)}
+}
+
+func (c *Client) Block(ctx context.Context, id cid.Cid, data blocklist.BlockData) (_ *blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "block", id)
+	resp, err := c.c.Block(ctx, &BlockRequest{Id: id.String(), Data: blockDataToProto(data)})
+	if err != nil {
+		return nil, err
+	}
+	return itemFromProto(resp.Existing), nil
+}
+
+func (c *Client) BlockMany(ctx context.Context, ids []cid.Cid, data blocklist.BlockData) (_ []cid.Cid, err error) {
+	defer wrapError(&err, "blockmany", cid.Undef)
+	resp, err := c.c.BlockMany(ctx, &BlockManyRequest{Ids: cidStrings(ids), Data: blockDataToProto(data)})
+	if err != nil {
+		return nil, err
+	}
+	blocked, _, err := parseCids(resp.Blocked)
+	return blocked, err
+}
+
+func (c *Client) Unblock(ctx context.Context, id cid.Cid) (_ *blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "unblock", id)
+	resp, err := c.c.Unblock(ctx, &CidRequest{Id: id.String()})
+	if err != nil {
+		return nil, err
+	}
+	return itemFromProto(resp.Item), nil
+}
+
+func (c *Client) UnblockMany(ctx context.Context, ids []cid.Cid) (_ map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "unblockmany", cid.Undef)
+	resp, err := c.c.UnblockMany(ctx, &CidsRequest{Ids: cidStrings(ids)})
+	if err != nil {
+		return nil, err
+	}
+	return foundFromProto(resp)
+}
+
+func (c *Client) Update(ctx context.Context, id cid.Cid, patch blocklist.BlockData) (err error) {
+	defer wrapError(&err, "update", id)
+	_, err = c.c.Update(ctx, &UpdateRequest{Id: id.String(), Patch: blockDataToProto(patch)})
+	return err
+}
+
+func (c *Client) Search(ctx context.Context, id cid.Cid) (_ *blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "search", id)
+	resp, err := c.c.Search(ctx, &CidRequest{Id: id.String()})
+	if err != nil {
+		return nil, err
+	}
+	return itemFromProto(resp.Item), nil
+}
+
+func (c *Client) AddComment(ctx context.Context, id cid.Cid, comment *blocklist.Comment) (err error) {
+	defer wrapError(&err, "addcomment", id)
+	_, err = c.c.AddComment(ctx, &AddCommentRequest{Id: id.String(), Comment: commentToProto(comment)})
+	return err
+}
+
+func (c *Client) SearchMany(ctx context.Context, ids []cid.Cid) (_ map[cid.Cid]*blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "searchmany", cid.Undef)
+	resp, err := c.c.SearchMany(ctx, &CidsRequest{Ids: cidStrings(ids)})
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[cid.Cid]*blocklist.BlocklistItem, len(resp.Items))
+	for s, p := range resp.Items {
+		id, err := parseCid(s)
+		if err != nil {
+			return nil, err
+		}
+		items[id] = itemFromProto(p)
+	}
+	return items, nil
+}
+
+func (c *Client) SearchByContent(ctx context.Context, url string) (_ []*blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "searchbycontent", cid.Undef)
+	resp, err := c.c.SearchByContent(ctx, &SearchByContentRequest{Url: url})
+	if err != nil {
+		return nil, err
+	}
+	return itemsFromProto(resp.Items), nil
+}
+
+func (c *Client) SearchHistory(ctx context.Context, id cid.Cid) (_ *blocklist.BlocklistItem, err error) {
+	defer wrapError(&err, "searchhistory", id)
+	resp, err := c.c.SearchHistory(ctx, &CidRequest{Id: id.String()})
+	if err != nil {
+		return nil, err
+	}
+	return itemFromProto(resp.Item), nil
+}
+
+func (c *Client) List(ctx context.Context, opts blocklist.ListOptions) (_ *blocklist.ListPage, err error) {
+	defer wrapError(&err, "list", cid.Undef)
+	resp, err := c.c.List(ctx, listOptionsToProto(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &blocklist.ListPage{Items: itemsFromProto(resp.Items), Next: resp.Next}, nil
+}
+
+func (c *Client) Count(ctx context.Context) (_ int64, err error) {
+	defer wrapError(&err, "count", cid.Undef)
+	resp, err := c.c.Count(ctx, &emptypb.Empty{})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func (c *Client) Purge(ctx context.Context, id cid.Cid) (err error) {
+	defer wrapError(&err, "purge", id)
+	_, err = c.c.Purge(ctx, &CidRequest{Id: id.String()})
+	return err
+}
+
+// GetLogs reads the actions matching `q` from the stream of the server.
+func (c *Client) GetLogs(ctx context.Context, q blocklist.LogQuery) (_ []*blocklist.Action, err error) {
+	defer wrapError(&err, "getlogs", q.Id)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.c.GetLogs(ctx, logQueryToProto(q))
+	if err != nil {
+		return nil, err
+	}
+	var acts []*blocklist.Action
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			return acts, nil
+		} else if err != nil {
+			return nil, err
+		}
+		act, err := actionFromProto(p)
+		if err != nil {
+			return nil, err
+		}
+		acts = append(acts, act)
+	}
+}
+
+// AddLog logs `act` on the server, and sets its Seq, and its CreatedAt if it
+// was zero, to those it was logged with.
+func (c *Client) AddLog(ctx context.Context, act *blocklist.Action) (err error) {
+	defer wrapError(&err, "addlog", cid.Undef)
+	p, err := c.c.AddLog(ctx, actionToProto(act))
+	if err != nil {
+		return err
+	}
+	logged, err := actionFromProto(p)
+	if err != nil {
+		return err
+	}
+	act.Seq = logged.Seq
+	if act.CreatedAt.IsZero() {
+		act.CreatedAt = logged.CreatedAt
+	}
+	return nil
+}
+
+func (c *Client) Contains(ctx context.Context, id cid.Cid) (_ bool, err error) {
+	defer wrapError(&err, "contains", id)
+	resp, err := c.c.Contains(ctx, &CidRequest{Id: id.String()})
+	if err != nil {
+		return false, err
+	}
+	return resp.Blocked, nil
+}
+
+func (c *Client) ContainsMany(ctx context.Context, ids []cid.Cid) (_ map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "containsmany", cid.Undef)
+	resp, err := c.c.ContainsMany(ctx, &CidsRequest{Ids: cidStrings(ids)})
+	if err != nil {
+		return nil, err
+	}
+	return foundFromProto(resp)
+}
+
+func (c *Client) Healthy(ctx context.Context) (err error) {
+	defer wrapError(&err, "healthy", cid.Undef)
+	_, err = c.c.Healthy(ctx, &emptypb.Empty{})
+	return err
+}
+
+// Capabilities returns those of the served blocklist, or none if the server
+// can't be reached.
+func (c *Client) Capabilities() blocklist.Capabilities {
+	ctx, cancel := context.WithTimeout(context.Background(), capabilitiesTimeout)
+	defer cancel()
+
+	resp, err := c.c.Capabilities(ctx, &emptypb.Empty{})
+	if err != nil {
+		return blocklist.Capabilities{}
+	}
+	return blocklist.Capabilities{
+		Purge:             resp.Purge,
+		Rehash:            resp.Rehash,
+		Transactions:      resp.Transactions,
+		ChronologicalList: resp.ChronologicalList,
+		IndexedCount:      resp.IndexedCount,
+	}
+}
+
+// Close does nothing: the connection belongs to the caller.
+func (c *Client) Close(ctx context.Context) error {
+	return nil
+}
+
+// Entries sends the entries listed with `opts` to the returned channel, as
+// the server streams them, like blocklist.EntriesIter but over one call. The
+// server reads them opts.Limit at a time.
+//
+// The channel is closed when all entries were sent, or `ctx` is done. The
+// error channel then receives the error that ended the iteration, or nil.
+func (c *Client) Entries(ctx context.Context, opts blocklist.ListOptions) (<-chan *blocklist.BlocklistItem, <-chan error) {
+	out, errc := make(chan *blocklist.BlocklistItem), make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		errc <- func() (err error) {
+			defer wrapError(&err, "entries", cid.Undef)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			stream, err := c.c.Entries(ctx, listOptionsToProto(opts))
+			if err != nil {
+				return err
+			}
+			for {
+				p, err := stream.Recv()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				select {
+				case out <- itemFromProto(p):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}()
+	}()
+	return out, errc
+}
+
+func foundFromProto(resp *FoundResponse) (map[cid.Cid]bool, error) {
+	found := make(map[cid.Cid]bool, len(resp.Found))
+	for s, ok := range resp.Found {
+		id, err := parseCid(s)
+		if err != nil {
+			return nil, err
+		}
+		found[id] = ok
+	}
+	return found, nil
+}
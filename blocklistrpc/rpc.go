@@ -0,0 +1,440 @@
+// Package blocklistrpc serves a blocklist.Blocklist over gRPC, so that
+// gateways can share one blocklist without a driver for its backend. Server
+// wraps any Blocklist, and Client is a Blocklist that calls a Server.
+//
+// The service is defined in blocklist.proto. The generated code is checked
+// in, and regenerated with buf, protoc-gen-go v1.27.1 and protoc-gen-go-grpc
+// v1.1.0 on the PATH.
+package blocklistrpc
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errorKinds are the errors of the blocklist package that are told apart over
+// the wire, with the status code they are returned with. Errors that match
+// several are returned as the first.
+var errorKinds = []struct {
+	err  error
+	kind ErrorKind
+	code codes.Code
+}{
+	{blocklist.ErrNotFound, ErrorKind_ERROR_NOT_FOUND, codes.NotFound},
+	{blocklist.ErrAlreadyBlocked, ErrorKind_ERROR_ALREADY_BLOCKED, codes.AlreadyExists},
+	{blocklist.ErrBackendUnavailable, ErrorKind_ERROR_BACKEND_UNAVAILABLE, codes.Unavailable},
+	{blocklist.ErrInvalidCID, ErrorKind_ERROR_INVALID_CID, codes.InvalidArgument},
+	{blocklist.ErrInvalidBlockData, ErrorKind_ERROR_INVALID_BLOCK_DATA, codes.InvalidArgument},
+	{blocklist.ErrReadOnly, ErrorKind_ERROR_READ_ONLY, codes.FailedPrecondition},
+	{blocklist.ErrLegalHold, ErrorKind_ERROR_LEGAL_HOLD, codes.FailedPrecondition},
+}
+
+// toStatus returns `err` as a status error, with an ErrorDetail if it is an
+// error of the blocklist package.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, k := range errorKinds {
+		if !errors.Is(err, k.err) {
+			continue
+		}
+		detail := &ErrorDetail{Kind: k.kind}
+		var verr *blocklist.ValidationError
+		if errors.As(err, &verr) {
+			for _, f := range verr.Fields {
+				detail.Fields = append(detail.Fields, &FieldError{Field: f.Field, Value: f.Value, Problem: f.Problem})
+			}
+		}
+		st := status.New(k.code, err.Error())
+		if withDetail, err := st.WithDetails(detail); err == nil {
+			st = withDetail
+		}
+		return st.Err()
+	}
+	if st := status.FromContextError(err); st.Code() != codes.Unknown {
+		return st.Err()
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// remoteError is an error returned by the server, with its message. It
+// unwraps to the error of the blocklist package it stands for, if any.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+// fromStatus returns the error of the blocklist package that the status error
+// `err` stands for. Statuses without an ErrorDetail are translated from their
+// code: Unavailable to ErrBackendUnavailable, so that unreachable servers
+// are reported as unavailable backends, and Canceled and DeadlineExceeded to
+// the errors of the context.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, d := range st.Details() {
+		detail, ok := d.(*ErrorDetail)
+		if !ok {
+			continue
+		}
+		if detail.Kind == ErrorKind_ERROR_INVALID_BLOCK_DATA && len(detail.Fields) > 0 {
+			verr := &blocklist.ValidationError{}
+			for _, f := range detail.Fields {
+				verr.Fields = append(verr.Fields, blocklist.FieldError{Field: f.Field, Value: f.Value, Problem: f.Problem})
+			}
+			return verr
+		}
+		for _, k := range errorKinds {
+			if k.kind == detail.Kind {
+				return &remoteError{msg: st.Message(), err: k.err}
+			}
+		}
+	}
+	switch st.Code() {
+	case codes.Unavailable:
+		return fmt.Errorf("%w: grpc: %v", blocklist.ErrBackendUnavailable, st.Message())
+	case codes.Canceled:
+		return &remoteError{msg: st.Message(), err: context.Canceled}
+	case codes.DeadlineExceeded:
+		return &remoteError{msg: st.Message(), err: context.DeadlineExceeded}
+	}
+	return &remoteError{msg: st.Message()}
+}
+
+// wrapError translates the status error *errp, if any, and wraps it in a
+// blocklist.Error of the "grpc" backend.
+func wrapError(errp *error, op string, id cid.Cid) {
+	if *errp == nil {
+		return
+	}
+	*errp = &blocklist.Error{Backend: "grpc", Op: op, Id: id, Err: fromStatus(*errp)}
+}
+
+// parseCid parses the CID `s` of a request.
+func parseCid(s string) (cid.Cid, error) {
+	id, err := cid.Decode(s)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %v", blocklist.ErrInvalidCID, err)
+	}
+	return id, nil
+}
+
+// parseCids parses the CIDs `ss` of a request, and returns the strings they
+// were parsed from, to answer with.
+func parseCids(ss []string) ([]cid.Cid, map[cid.Cid]string, error) {
+	ids := make([]cid.Cid, len(ss))
+	names := make(map[cid.Cid]string, len(ss))
+	for i, s := range ss {
+		id, err := parseCid(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids[i], names[id] = id, s
+	}
+	return ids, names, nil
+}
+
+func cidStrings(ids []cid.Cid) []string {
+	ss := make([]string, len(ids))
+	for i, id := range ids {
+		ss[i] = id.String()
+	}
+	return ss
+}
+
+func timeToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeFromProto(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func optionalTimeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func optionalTimeFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+func refsToProto(refs []blocklist.Reference) []*Reference {
+	var ps []*Reference
+	for _, r := range refs {
+		ps = append(ps, refToProto(r))
+	}
+	return ps
+}
+
+func refsFromProto(ps []*Reference) []blocklist.Reference {
+	var refs []blocklist.Reference
+	for _, p := range ps {
+		refs = append(refs, refFromProto(p))
+	}
+	return refs
+}
+
+func refToProto(r blocklist.Reference) *Reference {
+	return &Reference{Typ: string(r.Typ), Id: r.Id, Url: r.URL}
+}
+
+func refFromProto(p *Reference) blocklist.Reference {
+	if p == nil {
+		return blocklist.Reference{}
+	}
+	return blocklist.Reference{Typ: blocklist.RefType(p.Typ), Id: p.Id, URL: p.Url}
+}
+
+func commentToProto(c *blocklist.Comment) *Comment {
+	return &Comment{Author: c.Author, Text: c.Text, CreatedAt: timeToProto(c.CreatedAt)}
+}
+
+func commentFromProto(p *Comment) *blocklist.Comment {
+	if p == nil {
+		return &blocklist.Comment{}
+	}
+	return &blocklist.Comment{Author: p.Author, Text: p.Text, CreatedAt: timeFromProto(p.CreatedAt)}
+}
+
+func itemToProto(bi *blocklist.BlocklistItem) *Item {
+	if bi == nil {
+		return nil
+	}
+	p := &Item{
+		Hash:        bi.Hash,
+		Digests:     bi.Digests,
+		Content:     bi.Content,
+		Reason:      bi.Reason,
+		User:        bi.User,
+		Metadata:    bi.Metadata,
+		References:  refsToProto(bi.References),
+		CreatedAt:   timeToProto(bi.CreatedAt),
+		UpdatedAt:   timeToProto(bi.UpdatedAt),
+		UnblockedAt: optionalTimeToProto(bi.UnblockedAt),
+		PurgedAt:    optionalTimeToProto(bi.PurgedAt),
+		Supersedes:  itemToProto(bi.Supersedes),
+	}
+	for i := range bi.Comments {
+		p.Comments = append(p.Comments, commentToProto(&bi.Comments[i]))
+	}
+	return p
+}
+
+func itemFromProto(p *Item) *blocklist.BlocklistItem {
+	if p == nil {
+		return nil
+	}
+	bi := &blocklist.BlocklistItem{
+		Hash:        p.Hash,
+		Digests:     p.Digests,
+		Content:     p.Content,
+		Reason:      p.Reason,
+		User:        p.User,
+		Metadata:    p.Metadata,
+		References:  refsFromProto(p.References),
+		CreatedAt:   timeFromProto(p.CreatedAt),
+		UpdatedAt:   timeFromProto(p.UpdatedAt),
+		UnblockedAt: optionalTimeFromProto(p.UnblockedAt),
+		PurgedAt:    optionalTimeFromProto(p.PurgedAt),
+		Supersedes:  itemFromProto(p.Supersedes),
+	}
+	for _, c := range p.Comments {
+		bi.Comments = append(bi.Comments, *commentFromProto(c))
+	}
+	return bi
+}
+
+func itemsToProto(bis []*blocklist.BlocklistItem) []*Item {
+	ps := make([]*Item, len(bis))
+	for i, bi := range bis {
+		ps[i] = itemToProto(bi)
+	}
+	return ps
+}
+
+func itemsFromProto(ps []*Item) []*blocklist.BlocklistItem {
+	bis := make([]*blocklist.BlocklistItem, len(ps))
+	for i, p := range ps {
+		bis[i] = itemFromProto(p)
+	}
+	return bis
+}
+
+func blockDataToProto(data blocklist.BlockData) *BlockData {
+	return &BlockData{
+		Blocked:    data.Blocked,
+		Digests:    cidStrings(data.Digests),
+		Rehash:     data.Rehash,
+		Content:    data.Content,
+		Reason:     data.Reason,
+		User:       data.User,
+		Metadata:   data.Metadata,
+		References: refsToProto(data.References),
+	}
+}
+
+func blockDataFromProto(p *BlockData) (blocklist.BlockData, error) {
+	if p == nil {
+		return blocklist.BlockData{}, nil
+	}
+	data := blocklist.BlockData{
+		Blocked:    p.Blocked,
+		Rehash:     p.Rehash,
+		Content:    p.Content,
+		Reason:     p.Reason,
+		User:       p.User,
+		Metadata:   p.Metadata,
+		References: refsFromProto(p.References),
+	}
+	for _, s := range p.Digests {
+		id, err := parseCid(s)
+		if err != nil {
+			return blocklist.BlockData{}, err
+		}
+		data.Digests = append(data.Digests, id)
+	}
+	return data, nil
+}
+
+func listOptionsToProto(opts blocklist.ListOptions) *ListRequest {
+	req := &ListRequest{
+		Limit:  int32(opts.Limit),
+		Offset: int32(opts.Offset),
+		Cursor: opts.Cursor,
+	}
+	if opts.Order == blocklist.ListDescending {
+		req.Order = ListOrder_LIST_DESCENDING
+	}
+	if opts.Ref != (blocklist.Reference{}) {
+		req.Ref = refToProto(opts.Ref)
+	}
+	return req
+}
+
+func listOptionsFromProto(req *ListRequest) blocklist.ListOptions {
+	opts := blocklist.ListOptions{
+		Limit:  int(req.Limit),
+		Offset: int(req.Offset),
+		Cursor: req.Cursor,
+		Ref:    refFromProto(req.Ref),
+	}
+	if req.Order == ListOrder_LIST_DESCENDING {
+		opts.Order = blocklist.ListDescending
+	}
+	return opts
+}
+
+func logQueryToProto(q blocklist.LogQuery) *LogQuery {
+	p := &LogQuery{
+		Limit:  int32(q.Limit),
+		Before: q.Before,
+		User:   q.User,
+		Typ:    string(q.Typ),
+		Since:  timeToProto(q.Since),
+		Until:  timeToProto(q.Until),
+	}
+	if q.Id.Defined() {
+		p.Id = q.Id.String()
+	}
+	return p
+}
+
+func logQueryFromProto(p *LogQuery) (blocklist.LogQuery, error) {
+	q := blocklist.LogQuery{
+		Limit:  int(p.Limit),
+		Before: p.Before,
+		User:   p.User,
+		Typ:    blocklist.ActionType(p.Typ),
+		Since:  timeFromProto(p.Since),
+		Until:  timeFromProto(p.Until),
+	}
+	if p.Id != "" {
+		id, err := parseCid(p.Id)
+		if err != nil {
+			return blocklist.LogQuery{}, err
+		}
+		q.Id = id
+	}
+	return q, nil
+}
+
+func actionToProto(act *blocklist.Action) *Action {
+	p := &Action{
+		Seq:        act.Seq,
+		Typ:        string(act.Typ),
+		Ids:        cidStrings(act.Ids),
+		Undoes:     act.Undoes,
+		Reason:     act.Reason,
+		User:       act.User,
+		CreatedAt:  timeToProto(act.CreatedAt),
+		References: refsToProto(act.References),
+	}
+	for _, c := range act.Changes {
+		p.Changes = append(p.Changes, &Change{Field: c.Field, Old: c.Old, New: c.New})
+	}
+	if act.HLC != nil {
+		p.Hlc = &HLC{Wall: act.HLC.Wall, Logical: act.HLC.Logical, Node: act.HLC.Node}
+	}
+	return p
+}
+
+func actionFromProto(p *Action) (*blocklist.Action, error) {
+	act := &blocklist.Action{
+		Seq:        p.Seq,
+		Typ:        blocklist.ActionType(p.Typ),
+		Undoes:     p.Undoes,
+		Reason:     p.Reason,
+		User:       p.User,
+		CreatedAt:  timeFromProto(p.CreatedAt),
+		References: refsFromProto(p.References),
+	}
+	ids, _, err := parseCids(p.Ids)
+	if err != nil {
+		return nil, err
+	}
+	act.Ids = ids
+	for _, c := range p.Changes {
+		act.Changes = append(act.Changes, blocklist.Change{Field: c.Field, Old: c.Old, New: c.New})
+	}
+	if p.Hlc != nil {
+		act.HLC = &blocklist.HLC{Wall: p.Hlc.Wall, Logical: p.Hlc.Logical, Node: p.Hlc.Node}
+	}
+	return act, nil
+}
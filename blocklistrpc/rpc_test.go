@@ -0,0 +1,193 @@
+package blocklistrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testCID(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// serve serves `b` over an in-memory connection, and returns a client of it.
+func serve(t *testing.T, b blocklist.Blocklist) (*Client, *grpc.ClientConn) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterBlocklistServer(s, NewServer(b))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewClient(cc), cc
+}
+
+func TestClientServer(t *testing.T) {
+	ctx := context.Background()
+	c, _ := serve(t, blocklist.NewMemoryBlocklist())
+	first, second := testCID(t, "first"), testCID(t, "second")
+	digest := testCID(t, "digest")
+
+	data := blocklist.BlockData{
+		Reason:     "test",
+		User:       "u@x.com",
+		Digests:    []cid.Cid{digest},
+		Metadata:   map[string]string{"k": "v"},
+		References: []blocklist.Reference{{Typ: blocklist.RefTicket, Id: "T-1"}},
+	}
+	if existing, err := c.Block(ctx, first, data); err != nil || existing != nil {
+		t.Fatalf("Block = %v, %v, want nil, nil", existing, err)
+	}
+	if existing, err := c.Block(ctx, first, data); err != nil || existing == nil {
+		t.Fatalf("Block of a blocked CID = %v, %v, want the existing entry", existing, err)
+	}
+	if blocked, err := c.BlockMany(ctx, []cid.Cid{first, second}, blocklist.BlockData{Reason: "test", User: "u@x.com"}); err != nil || len(blocked) != 1 || !blocked[0].Equals(second) {
+		t.Fatalf("BlockMany = %v, %v, want [%v]", blocked, err, second)
+	}
+
+	bi, err := c.Search(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bi.Reason != "test" || bi.User != "u@x.com" || bi.Metadata["k"] != "v" || len(bi.References) != 1 || bi.CreatedAt.IsZero() {
+		t.Errorf("Search = %+v, want the entry as blocked", bi)
+	}
+	found, err := c.ContainsMany(ctx, []cid.Cid{first, digest, testCID(t, "other")})
+	if err != nil || !found[first] || !found[digest] || found[testCID(t, "other")] {
+		t.Errorf("ContainsMany = %v, %v", found, err)
+	}
+	if n, err := c.Count(ctx); err != nil || n != 2 {
+		t.Errorf("Count = %v, %v, want 2", n, err)
+	}
+
+	page, err := c.List(ctx, blocklist.ListOptions{Limit: 1})
+	if err != nil || len(page.Items) != 1 || page.Next == "" {
+		t.Fatalf("List = %+v, %v, want one entry and a cursor", page, err)
+	}
+	items, errc := c.Entries(ctx, blocklist.ListOptions{Limit: 1})
+	n := 0
+	for range items {
+		n++
+	}
+	if err := <-errc; err != nil || n != 2 {
+		t.Errorf("Entries streamed %v entries, %v, want 2", n, err)
+	}
+
+	if _, err := c.Unblock(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	for _, act := range []*blocklist.Action{
+		{Typ: blocklist.ActionBlock, Ids: []cid.Cid{first, second}, Reason: "test", User: "u@x.com"},
+		{Typ: blocklist.ActionUnblock, Ids: []cid.Cid{second}, User: "u@x.com"},
+	} {
+		if err := c.AddLog(ctx, act); err != nil || act.Seq == 0 {
+			t.Fatalf("AddLog = %v, set Seq %v", err, act.Seq)
+		}
+	}
+	acts, err := c.GetLogs(ctx, blocklist.LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acts) != 2 || acts[0].Typ != blocklist.ActionUnblock || !acts[0].Ids[0].Equals(second) || acts[0].Seq <= acts[1].Seq {
+		t.Errorf("GetLogs = %v, want the unblock and the block, most recent first", acts)
+	}
+	if acts, err := c.GetLogs(ctx, blocklist.LogQuery{Limit: 1}); err != nil || len(acts) != 1 {
+		t.Errorf("GetLogs with a limit of 1 = %v, %v", acts, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	c, _ := serve(t, blocklist.NewMemoryBlocklist())
+	id := testCID(t, "missing")
+
+	_, err := c.Search(ctx, id)
+	var berr *blocklist.Error
+	if !errors.Is(err, blocklist.ErrNotFound) || !errors.As(err, &berr) || berr.Backend != "grpc" || berr.Op != "search" {
+		t.Errorf("Search of a missing CID = %v, want ErrNotFound from grpc", err)
+	}
+
+	_, err = c.Block(ctx, id, blocklist.BlockData{User: "not an email"})
+	var verr *blocklist.ValidationError
+	if !errors.Is(err, blocklist.ErrInvalidBlockData) || !errors.As(err, &verr) || len(verr.Fields) == 0 {
+		t.Errorf("Block with invalid data = %v, want a ValidationError with its fields", err)
+	}
+
+	ro, _ := serve(t, blocklist.NewReadOnly(blocklist.NewMemoryBlocklist()))
+	if _, err := ro.Block(ctx, id, blocklist.BlockData{Reason: "test", User: "u@x.com"}); !errors.Is(err, blocklist.ErrReadOnly) {
+		t.Errorf("Block on a read-only blocklist = %v, want ErrReadOnly", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Contains(cancelled, id); !errors.Is(err, context.Canceled) {
+		t.Errorf("Contains with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestServerRejectsInvalidCIDs(t *testing.T) {
+	ctx := context.Background()
+	_, cc := serve(t, blocklist.NewMemoryBlocklist())
+
+	_, err := NewBlocklistClient(cc).Contains(ctx, &CidRequest{Id: "not a cid"})
+	if err := fromStatus(err); !errors.Is(err, blocklist.ErrInvalidCID) {
+		t.Errorf("Contains of an invalid CID = %v, want ErrInvalidCID", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	b := blocklist.NewMemoryBlocklist()
+	blocked := testCID(t, "blocked")
+	if _, err := b.Block(ctx, blocked, blocklist.BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	_, cc := serve(t, b)
+
+	stream, err := NewBlocklistClient(cc).Lookup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// CIDv0 is answered as it was asked, and matches the CIDv1 entry.
+	ids := []string{cid.NewCidV0(blocked.Hash()).String(), testCID(t, "other").String()}
+	for _, id := range ids {
+		if err := stream.Send(&CidRequest{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != id || resp.Blocked != (i == 0) {
+			t.Errorf("Lookup answer %v = %v, %v, want %v, %v", i, resp.Id, resp.Blocked, id, i == 0)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("Lookup didn't end after the last answer: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package blocklistrpc
+
+import (
+	"context"
+	"io"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	"github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Server serves a Blocklist. Register it with RegisterBlocklistServer.
+type Server struct {
+	UnimplementedBlocklistServer
+	b blocklist.Blocklist
+}
+
+// NewServer returns a server of `b`. Closing the server doesn't close `b`.
+func NewServer(b blocklist.Blocklist) *Server {
+	return &Server{b: b}
+}
+
+func (s *Server) Block(ctx context.Context, req *BlockRequest) (*BlockResponse, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	data, err := blockDataFromProto(req.Data)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	existing, err := s.b.Block(ctx, id, data)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BlockResponse{Existing: itemToProto(existing)}, nil
+}
+
+func (s *Server) BlockMany(ctx context.Context, req *BlockManyRequest) (*BlockManyResponse, error) {
+	ids, names, err := parseCids(req.Ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	data, err := blockDataFromProto(req.Data)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	blocked, err := s.b.BlockMany(ctx, ids, data)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &BlockManyResponse{}
+	for _, id := range blocked {
+		resp.Blocked = append(resp.Blocked, nameOf(names, id))
+	}
+	return resp, nil
+}
+
+func (s *Server) Unblock(ctx context.Context, req *CidRequest) (*ItemResponse, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	bi, err := s.b.Unblock(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ItemResponse{Item: itemToProto(bi)}, nil
+}
+
+func (s *Server) UnblockMany(ctx context.Context, req *CidsRequest) (*FoundResponse, error) {
+	ids, names, err := parseCids(req.Ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	found, err := s.b.UnblockMany(ctx, ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return foundResponse(found, names), nil
+}
+
+func (s *Server) Update(ctx context.Context, req *UpdateRequest) (*emptypb.Empty, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	patch, err := blockDataFromProto(req.Patch)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.b.Update(ctx, id, patch); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) Search(ctx context.Context, req *CidRequest) (*ItemResponse, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	bi, err := s.b.Search(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ItemResponse{Item: itemToProto(bi)}, nil
+}
+
+func (s *Server) AddComment(ctx context.Context, req *AddCommentRequest) (*emptypb.Empty, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.b.AddComment(ctx, id, commentFromProto(req.Comment)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) SearchMany(ctx context.Context, req *CidsRequest) (*ItemsByCidResponse, error) {
+	ids, names, err := parseCids(req.Ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	items, err := s.b.SearchMany(ctx, ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &ItemsByCidResponse{Items: make(map[string]*Item, len(items))}
+	for id, bi := range items {
+		resp.Items[nameOf(names, id)] = itemToProto(bi)
+	}
+	return resp, nil
+}
+
+func (s *Server) SearchByContent(ctx context.Context, req *SearchByContentRequest) (*ItemsResponse, error) {
+	items, err := s.b.SearchByContent(ctx, req.Url)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ItemsResponse{Items: itemsToProto(items)}, nil
+}
+
+func (s *Server) SearchHistory(ctx context.Context, req *CidRequest) (*ItemResponse, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	bi, err := s.b.SearchHistory(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ItemResponse{Item: itemToProto(bi)}, nil
+}
+
+func (s *Server) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	page, err := s.b.List(ctx, listOptionsFromProto(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ListResponse{Items: itemsToProto(page.Items), Next: page.Next}, nil
+}
+
+func (s *Server) Count(ctx context.Context, _ *emptypb.Empty) (*CountResponse, error) {
+	n, err := s.b.Count(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &CountResponse{Count: n}, nil
+}
+
+func (s *Server) Purge(ctx context.Context, req *CidRequest) (*emptypb.Empty, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.b.Purge(ctx, id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// GetLogs streams the actions matching the query, reading them from the
+// blocklist DefaultBulkBatchSize at a time.
+func (s *Server) GetLogs(req *LogQuery, stream Blocklist_GetLogsServer) error {
+	q, err := logQueryFromProto(req)
+	if err != nil {
+		return toStatus(err)
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	acts, errc := blocklist.LogsIter(ctx, s.b, q, 0)
+	for act := range acts {
+		if err := stream.Send(actionToProto(act)); err != nil {
+			return err
+		}
+	}
+	return toStatus(<-errc)
+}
+
+func (s *Server) AddLog(ctx context.Context, req *Action) (*Action, error) {
+	act, err := actionFromProto(req)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if err := s.b.AddLog(ctx, act); err != nil {
+		return nil, toStatus(err)
+	}
+	return actionToProto(act), nil
+}
+
+func (s *Server) Contains(ctx context.Context, req *CidRequest) (*ContainsResponse, error) {
+	id, err := parseCid(req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	blocked, err := s.b.Contains(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ContainsResponse{Blocked: blocked}, nil
+}
+
+func (s *Server) ContainsMany(ctx context.Context, req *CidsRequest) (*FoundResponse, error) {
+	ids, names, err := parseCids(req.Ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	found, err := s.b.ContainsMany(ctx, ids)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return foundResponse(found, names), nil
+}
+
+func (s *Server) Healthy(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.b.Healthy(ctx); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) Capabilities(ctx context.Context, _ *emptypb.Empty) (*CapabilitiesResponse, error) {
+	c := blocklist.CapabilitiesOf(s.b)
+	return &CapabilitiesResponse{
+		Purge:             c.Purge,
+		Rehash:            c.Rehash,
+		Transactions:      c.Transactions,
+		ChronologicalList: c.ChronologicalList,
+		IndexedCount:      c.IndexedCount,
+	}, nil
+}
+
+// Entries streams the entries listed with the request, reading them from the
+// blocklist a page at a time with EntriesIter.
+func (s *Server) Entries(req *ListRequest, stream Blocklist_EntriesServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	items, errc := blocklist.EntriesIter(ctx, s.b, listOptionsFromProto(req))
+	for bi := range items {
+		if err := stream.Send(itemToProto(bi)); err != nil {
+			return err
+		}
+	}
+	return toStatus(<-errc)
+}
+
+// Lookup answers each CID received with whether it is blocked, until the
+// client closes its side of the stream. It stops at the first error.
+func (s *Server) Lookup(stream Blocklist_LookupServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		id, err := parseCid(req.Id)
+		if err != nil {
+			return toStatus(err)
+		}
+		blocked, err := s.b.Contains(stream.Context(), id)
+		if err != nil {
+			return toStatus(err)
+		}
+		if err := stream.Send(&LookupResponse{Id: req.Id, Blocked: blocked}); err != nil {
+			return err
+		}
+	}
+}
+
+// nameOf returns the string `id` was parsed from, or its string form if it
+// wasn't in the request.
+func nameOf(names map[cid.Cid]string, id cid.Cid) string {
+	if s, ok := names[id]; ok {
+		return s
+	}
+	return id.String()
+}
+
+func foundResponse(found map[cid.Cid]bool, names map[cid.Cid]string) *FoundResponse {
+	resp := &FoundResponse{Found: make(map[string]bool, len(found))}
+	for id, ok := range found {
+		resp.Found[nameOf(names, id)] = ok
+	}
+	return resp
+}
@@ -0,0 +1,238 @@
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultBulkBatchSize is how many entries or actions the bulk APIs read or
+// write per request, unless told otherwise.
+const DefaultBulkBatchSize = 500
+
+// bulkBatchSize returns `n`, or DefaultBulkBatchSize if it isn't positive.
+func bulkBatchSize(n int) int {
+	if n <= 0 {
+		return DefaultBulkBatchSize
+	}
+	return n
+}
+
+// LogsIter sends the actions matching `q` to the returned channel, most
+// recent first, reading them `batch` at a time with GetLogs. The next batch is
+// only read once the previous one was received, so memory stays bounded
+// however long the log is. q.Limit caps the number of actions sent, if set.
+//
+// The channel is closed when all actions were sent, or `ctx` is done. The
+// error channel then receives the error that ended the iteration, or nil.
+// With Go 1.23, Logs can be ranged over instead.
+func LogsIter(ctx context.Context, b Blocklist, q LogQuery, batch int) (<-chan *Action, <-chan error) {
+	out, errc := make(chan *Action), make(chan error, 1)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		errc <- func() error {
+			batch, remaining := bulkBatchSize(batch), q.Limit
+			for {
+				page := q
+				page.Limit = batch
+				if remaining > 0 && remaining < batch {
+					page.Limit = remaining
+				}
+				acts, err := b.GetLogs(ctx, page)
+				if err != nil {
+					return err
+				}
+				for _, act := range acts {
+					select {
+					case out <- act:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				if remaining > 0 {
+					if remaining -= len(acts); remaining <= 0 {
+						return nil
+					}
+				}
+				if len(acts) < page.Limit || acts[len(acts)-1].Seq == 0 {
+					return nil
+				}
+				q.Before = acts[len(acts)-1].Seq
+			}
+		}()
+	}()
+	return out, errc
+}
+
+// EntriesIter sends the entries listed with `opts` to the returned channel,
+// reading them opts.Limit at a time, or DefaultBulkBatchSize if it is zero.
+// The next page is only read once the previous one was received, so memory
+// stays bounded however large the blocklist is.
+//
+// The channel is closed when all entries were sent, or `ctx` is done. The
+// error channel then receives the error that ended the iteration, or nil.
+// With Go 1.23, Entries can be ranged over instead.
+func EntriesIter(ctx context.Context, b Blocklist, opts ListOptions) (<-chan *BlocklistItem, <-chan error) {
+	out, errc := make(chan *BlocklistItem), make(chan error, 1)
+	opts.Limit = bulkBatchSize(opts.Limit)
+	go func() {
+		defer close(errc)
+		defer close(out)
+		errc <- func() error {
+			for {
+				page, err := b.List(ctx, opts)
+				if err != nil {
+					return err
+				}
+				for _, item := range page.Items {
+					select {
+					case out <- item:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				if page.Next == "" {
+					return nil
+				}
+				opts.Cursor = page.Next
+			}
+		}()
+	}()
+	return out, errc
+}
+
+// Export writes every entry of `b` to `w` as an import feed, one ImportRecord
+// per line, reading `batch` entries at a time. The feed can be imported into
+// another blocklist with an Importer. It returns the number of entries
+// written.
+func Export(ctx context.Context, b Blocklist, w io.Writer, batch int) (n int, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	items, errc := EntriesIter(ctx, b, ListOptions{Limit: batch})
+	for item := range items {
+		rec := ImportRecord{
+			Id:         item.Hash,
+			Digests:    item.Digests,
+			Content:    item.Content,
+			Reason:     item.Reason,
+			User:       item.User,
+			Metadata:   item.Metadata,
+			References: item.References,
+		}
+		if err := enc.Encode(rec); err != nil {
+			cancel()
+			<-errc
+			return n, err
+		}
+		n++
+	}
+	if err := <-errc; err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// PurgeMany purges the content of the ids received from `ids` until it is
+// closed, with up to `workers` purges at a time. Producers block while the
+// workers are busy, so a slow datastore throttles them instead of ids piling
+// up in memory. Ids the blocklist reports as not found are skipped. It logs
+// one "purge" action of `user` per `batch` ids purged, and returns how many
+// were purged.
+//
+// PurgeMany stops at the first error, which is returned. The ids purged
+// until then are logged. Check that the blocklist supports Purge with
+// CapabilitiesOf first.
+func PurgeMany(ctx context.Context, b Blocklist, ids <-chan cid.Cid, workers, batch int, user string) (purged int, err error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	batch = bulkBatchSize(batch)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		pending  []cid.Cid
+	)
+	// flush logs the pending ids. It must be called with mu held.
+	flush := func(ctx context.Context) error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := b.AddLog(ctx, &Action{
+			Typ:       ActionPurge,
+			Ids:       pending,
+			Reason:    fmt.Sprintf("purged %v entries", len(pending)),
+			User:      user,
+			CreatedAt: time.Now(),
+		})
+		pending = nil
+		return err
+	}
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var (
+					id cid.Cid
+					ok bool
+				)
+				select {
+				case id, ok = <-ids:
+				case <-ctx.Done():
+				}
+				if !ok {
+					return
+				}
+
+				err := b.Purge(ctx, id)
+				mu.Lock()
+				switch {
+				case errors.Is(err, ErrNotFound):
+				case err != nil:
+					fail(err)
+				default:
+					purged++
+					if pending = append(pending, id); len(pending) >= batch {
+						if err := flush(ctx); err != nil {
+							fail(err)
+						}
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	// Log what was purged even if PurgeMany failed or was canceled.
+	if err := flush(context.Background()); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return purged, firstErr
+}
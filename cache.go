@@ -0,0 +1,341 @@
+package blocklist
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// CachedBlocklist caches the positive results of Contains, and the entries
+// returned by Search, of the blocklist it wraps, so that lookups of hot CIDs
+// don't reach the backend. With WithNegativeTTL, it caches negative results
+// of Contains too. The least recently used results are evicted past the size
+// of the cache, and results expire after a TTL.
+//
+// Concurrent lookups of the same CID that miss the cache share one call to
+// the wrapped blocklist, see CoalescedBlocklist.
+//
+// Results are invalidated by the changes made through the CachedBlocklist.
+// Changes made elsewhere, like by another gateway, are only seen once the
+// results expire, unless they are passed to Invalidate: the TTL bounds how
+// long unblocked content stays blocked, and the negative TTL how long blocked
+// content is still served.
+type CachedBlocklist struct {
+	Blocklist
+	// Counters are first to keep them 64-bit aligned for atomic operations.
+	hits, misses uint64
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	lookups     *CoalescedBlocklist
+
+	mu      sync.Mutex
+	lru     *list.List // lru holds *cacheEntry, most recently used first.
+	entries map[cid.Cid]*list.Element
+	// gen counts invalidations, so that lookups that started before one
+	// don't cache their result.
+	gen uint64
+}
+
+// cacheEntry is the cached result of the lookups of a CID.
+type cacheEntry struct {
+	id      cid.Cid
+	blocked bool
+	item    *BlocklistItem // item is nil if only Contains was cached.
+	expires time.Time
+}
+
+// CacheOption configures a CachedBlocklist.
+type CacheOption func(*cacheOptions)
+
+type cacheOptions struct {
+	negativeTTL time.Duration
+}
+
+// WithNegativeTTL caches that content isn't blocked for `ttl`, which should
+// be short, as most lookups are of content that isn't blocked. Blocks made
+// through the CachedBlocklist, or passed to Invalidate, evict the negative
+// results of their CIDs. Search doesn't use negative results.
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// NewCached wraps `b` with a cache of `size` results, which expire after
+// `ttl`.
+func NewCached(b Blocklist, size int, ttl time.Duration, opts ...CacheOption) *CachedBlocklist {
+	o := &cacheOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &CachedBlocklist{
+		Blocklist:   b,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: o.negativeTTL,
+		lookups:     NewCoalesced(b),
+		lru:         list.New(),
+		entries:     make(map[cid.Cid]*list.Element),
+	}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *CachedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// Hits returns the number of lookups answered from the cache.
+func (b *CachedBlocklist) Hits() uint64 {
+	return atomic.LoadUint64(&b.hits)
+}
+
+// Misses returns the number of lookups that weren't answered from the cache.
+// Concurrent misses of the same CID share one call, and are also counted by
+// Coalesced.
+func (b *CachedBlocklist) Misses() uint64 {
+	return atomic.LoadUint64(&b.misses)
+}
+
+// Coalesced returns the number of Contains misses that shared the call of
+// another.
+func (b *CachedBlocklist) Coalesced() uint64 {
+	return b.lookups.Coalesced()
+}
+
+// generation returns the number of invalidations so far, to pass to put.
+func (b *CachedBlocklist) generation() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.gen
+}
+
+// Len returns the number of cached results, including expired ones that
+// weren't evicted yet.
+func (b *CachedBlocklist) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lru.Len()
+}
+
+// cacheKey returns the CIDv1-raw of the multihash of `id`, so that every
+// version and codec of a CID share their results, as PgBlocklist and
+// DatastoreBlocklist match entries on their multihashes.
+func cacheKey(id cid.Cid) cid.Cid {
+	if !id.Defined() {
+		return id
+	}
+	return cid.NewCidV1(cid.Raw, id.Hash())
+}
+
+// get returns a copy of the cached entry of `id`, and counts the lookup as a hit or a
+// miss. Search needs the entry of the content, and only counts as a hit if it
+// is cached.
+func (b *CachedBlocklist) get(id cid.Cid, search bool) (cacheEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[cacheKey(id)]; ok {
+		entry := e.Value.(*cacheEntry)
+		if !time.Now().Before(entry.expires) {
+			b.remove(e)
+		} else if !search || entry.item != nil {
+			b.lru.MoveToFront(e)
+			atomic.AddUint64(&b.hits, 1)
+			return *entry, true
+		}
+	}
+	atomic.AddUint64(&b.misses, 1)
+	return cacheEntry{}, false
+}
+
+// put caches whether `id` is blocked, by the entry `item` if it isn't nil,
+// unless the cache was invalidated since the generation `gen` the lookup
+// started at. Negative results are only cached with WithNegativeTTL.
+func (b *CachedBlocklist) put(gen uint64, id cid.Cid, blocked bool, item *BlocklistItem) {
+	ttl := b.ttl
+	if !blocked {
+		ttl = b.negativeTTL
+	}
+	if b.size <= 0 || ttl <= 0 {
+		return
+	}
+	key := cacheKey(id)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.gen != gen {
+		return
+	}
+	if e, ok := b.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if item != nil || entry.blocked != blocked {
+			entry.item = item
+		}
+		entry.blocked = blocked
+		entry.expires = time.Now().Add(ttl)
+		b.lru.MoveToFront(e)
+		return
+	}
+	b.entries[key] = b.lru.PushFront(&cacheEntry{id: key, blocked: blocked, item: item, expires: time.Now().Add(ttl)})
+	for b.lru.Len() > b.size {
+		b.remove(b.lru.Back())
+	}
+}
+
+// remove evicts the result `e`. b.mu has to be held.
+func (b *CachedBlocklist) remove(e *list.Element) {
+	b.lru.Remove(e)
+	delete(b.entries, e.Value.(*cacheEntry).id)
+}
+
+// Invalidate evicts the results of `ids`, and those of the entries with one
+// of `ids` as their hash or one of their digests. It is meant to be called
+// with the changes made elsewhere, like those seen by EtcdBlocklist.Watch or
+// ConsulBlocklist.Watch.
+func (b *CachedBlocklist) Invalidate(ids ...cid.Cid) {
+	hashes := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		hashes[cacheKey(id).String()] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gen++
+	// Lookups that read the new generation mustn't share a call that started
+	// before it, so the calls are forgotten before b.mu is released.
+	b.lookups.Forget(ids...)
+	for e := b.lru.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*cacheEntry)
+		if hashes[entry.id.String()] || (entry.item != nil && itemHasHash(entry.item, hashes)) {
+			b.remove(e)
+		}
+		e = next
+	}
+}
+
+// itemHasHash returns true if the hash or one of the digests of `item`, in
+// their normalized form, is in `hashes`.
+func itemHasHash(item *BlocklistItem, hashes map[string]bool) bool {
+	for _, h := range append([]string{item.Hash}, item.Digests...) {
+		if id, err := cid.Decode(h); err == nil && hashes[cacheKey(id).String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush evicts every cached result.
+func (b *CachedBlocklist) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.gen++
+	b.lookups.forgetAll()
+	b.lru.Init()
+	b.entries = make(map[cid.Cid]*list.Element)
+}
+
+// Contains returns the cached result of `id`, and asks the wrapped blocklist
+// if there is none.
+func (b *CachedBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	if entry, ok := b.get(id, false); ok {
+		return entry.blocked, nil
+	}
+	gen := b.generation()
+	exists, err := b.lookups.Contains(ctx, id)
+	if err == nil {
+		b.put(gen, id, exists, nil)
+	}
+	return exists, err
+}
+
+func (b *CachedBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res := make(map[cid.Cid]bool, len(ids))
+	var missed []cid.Cid
+	for _, id := range ids {
+		if entry, ok := b.get(id, false); ok {
+			res[id] = entry.blocked
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) == 0 {
+		return res, nil
+	}
+
+	gen := b.generation()
+	found, err := b.Blocklist.ContainsMany(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range missed {
+		res[id] = found[id]
+		b.put(gen, id, found[id], nil)
+	}
+	return res, nil
+}
+
+// Search returns the cached entry of `id`, or looks it up in the wrapped
+// blocklist. The entry is shared with the cache: it must not be modified.
+func (b *CachedBlocklist) Search(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	if entry, ok := b.get(id, true); ok {
+		return entry.item, nil
+	}
+	gen := b.generation()
+	item, err := b.Blocklist.Search(ctx, id)
+	if err == nil {
+		b.put(gen, id, true, item)
+	}
+	return item, err
+}
+
+func (b *CachedBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	defer b.Invalidate(append([]cid.Cid{id}, data.Digests...)...)
+	return b.Blocklist.Block(ctx, id, data)
+}
+
+func (b *CachedBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error) {
+	defer b.Invalidate(append(append([]cid.Cid{}, ids...), data.Digests...)...)
+	return b.Blocklist.BlockMany(ctx, ids, data)
+}
+
+// Unblock unblocks `id`, and evicts the results of its entry, including those
+// of its digests.
+func (b *CachedBlocklist) Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	removed, err := b.Blocklist.Unblock(ctx, id)
+	ids := []cid.Cid{id}
+	if removed != nil {
+		for _, d := range append([]string{removed.Hash}, removed.Digests...) {
+			if c, err := cid.Decode(d); err == nil {
+				ids = append(ids, c)
+			}
+		}
+	}
+	b.Invalidate(ids...)
+	return removed, err
+}
+
+// UnblockMany unblocks `ids`, and evicts every cached result, as the digests
+// of the unblocked entries aren't known.
+func (b *CachedBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	defer b.Flush()
+	return b.Blocklist.UnblockMany(ctx, ids)
+}
+
+func (b *CachedBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) error {
+	defer b.Invalidate(id)
+	return b.Blocklist.Update(ctx, id, patch)
+}
+
+func (b *CachedBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) error {
+	defer b.Invalidate(id)
+	return b.Blocklist.AddComment(ctx, id, c)
+}
+
+func (b *CachedBlocklist) Purge(ctx context.Context, id cid.Cid) error {
+	defer b.Invalidate(id)
+	return b.Blocklist.Purge(ctx, id)
+}
@@ -0,0 +1,90 @@
+package blocklist
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// gatedBlocklist holds its first Contains call after looking up the answer,
+// until release is closed.
+type gatedBlocklist struct {
+	Blocklist
+	gated   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *gatedBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	exists, err := b.Blocklist.Contains(ctx, id)
+	if atomic.CompareAndSwapInt32(&b.gated, 0, 1) {
+		close(b.started)
+		<-b.release
+	}
+	return exists, err
+}
+
+func TestCachedInvalidateDuringCoalescedLookup(t *testing.T) {
+	ctx := context.Background()
+	gated := &gatedBlocklist{Blocklist: NewMemoryBlocklist(), started: make(chan struct{}), release: make(chan struct{})}
+	b := NewCached(gated, 10, time.Minute, WithNegativeTTL(time.Minute))
+	id := testCID(t, "a")
+
+	// A lookup that started before the block answers false.
+	before := make(chan bool)
+	go func() {
+		exists, _ := b.Contains(ctx, id)
+		before <- exists
+	}()
+	<-gated.started
+
+	if _, err := b.Block(ctx, id, BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lookup that starts after the block doesn't share the call in flight.
+	after := make(chan bool)
+	go func() {
+		exists, _ := b.Contains(ctx, id)
+		after <- exists
+	}()
+	select {
+	case exists := <-after:
+		if !exists {
+			t.Error("lookup after the block got false")
+		}
+	case <-time.After(time.Second):
+		close(gated.release)
+		t.Fatalf("lookup after the block waited for the call that started before it, and got %v", <-after)
+	}
+
+	close(gated.release)
+	if exists := <-before; exists {
+		t.Error("lookup before the block got true")
+	}
+	if exists, err := b.Contains(ctx, id); err != nil || !exists {
+		t.Errorf("got %v, %v from the cache, want true", exists, err)
+	}
+}
+
+func TestCachedKeepsResultsOfBothCIDVersions(t *testing.T) {
+	ctx := context.Background()
+	b := NewCached(NewMemoryBlocklist(), 10, time.Minute)
+	v0 := cid.NewCidV0(testCID(t, "a").Hash())
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+	if _, err := b.Block(ctx, v0, BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []cid.Cid{v0, v1, v0} {
+		if exists, err := b.Contains(ctx, id); err != nil || !exists {
+			t.Errorf("%v: got %v, %v, want true", id, exists, err)
+		}
+	}
+	if b.Hits() != 2 {
+		t.Errorf("got %v hits, want 2", b.Hits())
+	}
+}
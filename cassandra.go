@@ -0,0 +1,747 @@
+//go:build cassandra
+// +build cassandra
+
+package blocklist
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Defaults of the Cassandra datastore and audit store.
+const (
+	DefaultCassandraTable      = "blocklist"
+	DefaultCassandraAuditTable = "audit_log"
+	DefaultCassandraBuckets    = 16
+)
+
+// cassandraPage is the number of rows Query reads per page of each bucket.
+const cassandraPage = 1000
+
+// cassandraBatchSize is the most statements of a logged batch, to stay under
+// the default batch_size_fail_threshold_in_kb of 50 KiB.
+const cassandraBatchSize = 64
+
+// CassandraOption configures a Cassandra or a CassandraAuditStore.
+type CassandraOption func(*cassandraOptions)
+
+type cassandraOptions struct {
+	table   string
+	buckets int
+}
+
+// WithCassandraTable stores rows in the table `name` of the keyspace of the
+// session, instead of DefaultCassandraTable for a Cassandra, or
+// DefaultCassandraAuditTable for a CassandraAuditStore.
+func WithCassandraTable(name string) CassandraOption {
+	return func(o *cassandraOptions) {
+		o.table = name
+	}
+}
+
+// WithCassandraBuckets partitions the keys of a Cassandra in `n` buckets,
+// from 1 to 256, instead of DefaultCassandraBuckets. It can't change once
+// keys are stored.
+func WithCassandraBuckets(n int) CassandraOption {
+	return func(o *cassandraOptions) {
+		o.buckets = n
+	}
+}
+
+// Cassandra is a datastore stored in a Cassandra or ScyllaDB table. Keys are
+// partitioned in buckets by the first byte of the digest of the multihash
+// they are named after, so that entries spread evenly over the cluster, and
+// reading or writing a key touches one partition. Queries read their range of
+// keys in every bucket, and merge them in key order.
+//
+// Batches are written in logged batches of up to 64 statements, so larger
+// batches aren't atomic.
+type Cassandra struct {
+	session *gocql.Session
+	table   string
+	buckets int
+}
+
+var _ ds.Batching = (*Cassandra)(nil)
+
+// NewCassandra returns a datastore stored in the keyspace of `s`. The session
+// is owned by the caller, and isn't closed by Close. Call Migrate to create
+// the table.
+func NewCassandra(s *gocql.Session, opts ...CassandraOption) *Cassandra {
+	o := &cassandraOptions{table: DefaultCassandraTable, buckets: DefaultCassandraBuckets}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.buckets < 1 {
+		o.buckets = 1
+	} else if o.buckets > 256 {
+		o.buckets = 256
+	}
+	return &Cassandra{session: s, table: o.table, buckets: o.buckets}
+}
+
+// cassandraError translates errors of the driver: missing rows to
+// ds.ErrNotFound, and unreachable or overloaded replicas to
+// ErrBackendUnavailable.
+func cassandraError(err error) error {
+	var (
+		unavailable  *gocql.RequestErrUnavailable
+		writeTimeout *gocql.RequestErrWriteTimeout
+		readTimeout  *gocql.RequestErrReadTimeout
+		netErr       net.Error
+	)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gocql.ErrNotFound):
+		return ds.ErrNotFound
+	case errors.Is(err, gocql.ErrNoConnections), errors.Is(err, gocql.ErrTimeoutNoResponse),
+		errors.Is(err, gocql.ErrConnectionClosed), errors.As(err, &unavailable),
+		errors.As(err, &writeTimeout), errors.As(err, &readTimeout), errors.As(err, &netErr):
+		return fmt.Errorf("%w: cassandra: %v", ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+// Migrate creates the table, if it doesn't exist.
+func (c *Cassandra) Migrate(ctx context.Context) (err error) {
+	defer wrapError(&err, "cassandra", "migrate", cid.Undef)
+
+	return cassandraError(c.session.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+		bucket int,
+		key text,
+		value blob,
+		PRIMARY KEY (bucket, key)
+	)`, c.table)).WithContext(ctx).Exec())
+}
+
+// bucket returns the partition of `k`: the first byte of the digest of the
+// multihash its last namespace encodes, or of the SHA-256 of `k` if it
+// doesn't encode one, modulo the number of buckets.
+func (c *Cassandra) bucket(k ds.Key) int {
+	if raw, err := dshelp.BinaryFromDsKey(ds.NewKey(k.BaseNamespace())); err == nil {
+		if h, err := mh.Decode(raw); err == nil && len(h.Digest) > 0 {
+			return int(h.Digest[0]) % c.buckets
+		}
+	}
+	sum := sha256.Sum256(k.Bytes())
+	return int(sum[0]) % c.buckets
+}
+
+func (c *Cassandra) Get(k ds.Key) ([]byte, error) {
+	var v []byte
+	err := c.session.Query(fmt.Sprintf("SELECT value FROM %v WHERE bucket = ? AND key = ?", c.table), c.bucket(k), k.String()).Scan(&v)
+	if err != nil {
+		return nil, cassandraError(err)
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	return v, nil
+}
+
+func (c *Cassandra) Has(k ds.Key) (bool, error) {
+	var key string
+	err := c.session.Query(fmt.Sprintf("SELECT key FROM %v WHERE bucket = ? AND key = ?", c.table), c.bucket(k), k.String()).Scan(&key)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, cassandraError(err)
+}
+
+func (c *Cassandra) GetSize(k ds.Key) (int, error) {
+	v, err := c.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (c *Cassandra) Put(k ds.Key, value []byte) error {
+	return cassandraError(c.session.Query(c.putStatement(), c.bucket(k), k.String(), value).Exec())
+}
+
+func (c *Cassandra) Delete(k ds.Key) error {
+	return cassandraError(c.session.Query(c.deleteStatement(), c.bucket(k), k.String()).Exec())
+}
+
+func (c *Cassandra) putStatement() string {
+	return fmt.Sprintf("INSERT INTO %v (bucket, key, value) VALUES (?, ?, ?)", c.table)
+}
+
+func (c *Cassandra) deleteStatement() string {
+	return fmt.Sprintf("DELETE FROM %v WHERE bucket = ? AND key = ?", c.table)
+}
+
+// putIfAbsent writes `value` at `k` in a lightweight transaction, unless `k`
+// exists. It returns whether it wrote it, and the value that exists
+// otherwise.
+func (c *Cassandra) putIfAbsent(ctx context.Context, k ds.Key, value []byte) ([]byte, bool, error) {
+	existing := make(map[string]interface{})
+	applied, err := c.session.Query(c.putStatement()+" IF NOT EXISTS", c.bucket(k), k.String(), value).WithContext(ctx).MapScanCAS(existing)
+	if err != nil || applied {
+		return nil, applied, cassandraError(err)
+	}
+	v, _ := existing["value"].([]byte)
+	return v, false, nil
+}
+
+// cassandraRange is the range of keys a query reads in each bucket.
+type cassandraRange struct {
+	lo, hi         string
+	loIncl, hiIncl bool
+}
+
+// restrict narrows the range with the key comparison `f`, and returns false
+// if it can't.
+func (r *cassandraRange) restrict(f dsq.FilterKeyCompare) bool {
+	switch f.Op {
+	case dsq.GreaterThan:
+		if f.Key >= r.lo {
+			r.lo, r.loIncl = f.Key, false
+		}
+	case dsq.GreaterThanOrEqual:
+		if f.Key > r.lo {
+			r.lo, r.loIncl = f.Key, true
+		}
+	case dsq.LessThan:
+		if f.Key <= r.hi {
+			r.hi, r.hiIncl = f.Key, false
+		}
+	case dsq.LessThanOrEqual:
+		if f.Key < r.hi {
+			r.hi, r.hiIncl = f.Key, true
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// where returns the conditions of the range on the key.
+func (r *cassandraRange) where() string {
+	lo, hi := ">", "<"
+	if r.loIncl {
+		lo = ">="
+	}
+	if r.hiIncl {
+		hi = "<="
+	}
+	return fmt.Sprintf("key %v ? AND key %v ?", lo, hi)
+}
+
+// Query reads the keys under the prefix of `q` in every bucket, a page at a
+// time, and merges them in ascending key order, or descending if `q` is
+// ordered by descending key. Key comparisons of `q` narrow the range read in
+// each bucket; other filters and orders are applied to the results.
+func (c *Cassandra) Query(q dsq.Query) (dsq.Results, error) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	r := cassandraRange{lo: lo, hi: hi, loIncl: true}
+	naive := q
+	naive.Filters = nil
+	for _, f := range q.Filters {
+		var fk dsq.FilterKeyCompare
+		switch f := f.(type) {
+		case dsq.FilterKeyCompare:
+			fk = f
+		case *dsq.FilterKeyCompare:
+			fk = *f
+		}
+		if !r.restrict(fk) {
+			naive.Filters = append(naive.Filters, f)
+		}
+	}
+	if r.lo > r.hi {
+		return dsq.ResultsWithEntries(q, nil), nil
+	}
+	desc := false
+	if len(q.Orders) == 1 {
+		switch q.Orders[0].(type) {
+		case dsq.OrderByKey, *dsq.OrderByKey:
+			naive.Orders = nil
+		case dsq.OrderByKeyDescending, *dsq.OrderByKeyDescending:
+			desc, naive.Orders = true, nil
+		}
+	}
+
+	cols := "key, value"
+	if q.KeysOnly {
+		cols = "key"
+	}
+	stmt := fmt.Sprintf("SELECT %v FROM %v WHERE bucket = ? AND %v", cols, c.table, r.where())
+	if desc {
+		stmt += " ORDER BY key DESC"
+	}
+	iters := make([]*gocql.Iter, c.buckets)
+	for i := range iters {
+		iters[i] = c.session.Query(stmt, i, r.lo, r.hi).PageSize(cassandraPage).Iter()
+	}
+
+	// read returns the next entry of the bucket `i`, or nil once it is read.
+	read := func(i int) (*dsq.Entry, error) {
+		it := iters[i]
+		if it == nil {
+			return nil, nil
+		}
+		e := &dsq.Entry{Size: -1}
+		var ok bool
+		if q.KeysOnly {
+			ok = it.Scan(&e.Key)
+		} else {
+			var v []byte
+			if ok = it.Scan(&e.Key, &v); ok {
+				if v == nil {
+					v = []byte{}
+				}
+				e.Value, e.Size = v, len(v)
+			}
+		}
+		if ok {
+			return e, nil
+		}
+		iters[i] = nil
+		return nil, cassandraError(it.Close())
+	}
+	closeAll := func() error {
+		var err error
+		for i, it := range iters {
+			if it == nil {
+				continue
+			}
+			if cerr := it.Close(); cerr != nil && err == nil {
+				err = cassandraError(cerr)
+			}
+			iters[i] = nil
+		}
+		return err
+	}
+
+	var (
+		heads   = make([]*dsq.Entry, c.buckets)
+		pending = make([]int, c.buckets) // pending are the buckets to read the next entry of.
+	)
+	for i := range pending {
+		pending[i] = i
+	}
+	next := func() (dsq.Result, bool) {
+		for _, i := range pending {
+			e, err := read(i)
+			if err != nil {
+				closeAll()
+				heads, pending = make([]*dsq.Entry, c.buckets), nil
+				return dsq.Result{Error: err}, true
+			}
+			heads[i] = e
+		}
+		pending = pending[:0]
+
+		first := -1
+		for i, e := range heads {
+			if e != nil && (first < 0 || (e.Key < heads[first].Key) != desc) {
+				first = i
+			}
+		}
+		if first < 0 {
+			return dsq.Result{}, false
+		}
+		e := heads[first]
+		heads[first], pending = nil, append(pending, first)
+		return dsq.Result{Entry: *e}, true
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next, Close: closeAll})), nil
+}
+
+// Sync does nothing: writes are durable once Cassandra acknowledges them, at
+// the consistency level of the session.
+func (c *Cassandra) Sync(prefix ds.Key) error {
+	return nil
+}
+
+// Close does nothing: the session belongs to the caller.
+func (c *Cassandra) Close() error {
+	return nil
+}
+
+func (c *Cassandra) Batch() (ds.Batch, error) {
+	return &cassandraBatch{cassandra: c, puts: make(map[ds.Key][]byte), deletes: make(map[ds.Key]bool)}, nil
+}
+
+// cassandraBatch buffers writes until Commit sends them.
+type cassandraBatch struct {
+	cassandra *Cassandra
+	puts      map[ds.Key][]byte
+	deletes   map[ds.Key]bool
+}
+
+func (b *cassandraBatch) Put(k ds.Key, value []byte) error {
+	delete(b.deletes, k)
+	b.puts[k] = value
+	return nil
+}
+
+func (b *cassandraBatch) Delete(k ds.Key) error {
+	delete(b.puts, k)
+	b.deletes[k] = true
+	return nil
+}
+
+// Commit writes the batch in logged batches of up to cassandraBatchSize
+// statements. If one fails, the batches written before it stay applied.
+func (b *cassandraBatch) Commit() error {
+	c := b.cassandra
+	batch := c.session.NewBatch(gocql.LoggedBatch)
+	flush := func() error {
+		if batch.Size() == 0 {
+			return nil
+		}
+		err := c.session.ExecuteBatch(batch)
+		batch = c.session.NewBatch(gocql.LoggedBatch)
+		return cassandraError(err)
+	}
+	for k, v := range b.puts {
+		batch.Query(c.putStatement(), c.bucket(k), k.String(), v)
+		if batch.Size() == cassandraBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	for k := range b.deletes {
+		batch.Query(c.deleteStatement(), c.bucket(k), k.String())
+		if batch.Size() == cassandraBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// CassandraBlocklist is a DatastoreBlocklist stored in Cassandra or ScyllaDB,
+// for gateways spread over several datacenters. Block claims the entry of
+// the content with a lightweight transaction, so that of concurrent Blocks of
+// the same content, by any process, one writes the entry and the others
+// return it. BlockMany, Unblock and Update write without transactions.
+//
+// Audit actions logged in the blocklist itself are numbered by the process
+// that logs them, so blocklists written by several processes should log them
+// in a CassandraAuditStore, with NewAudited.
+type CassandraBlocklist struct {
+	DatastoreBlocklist
+	cassandra *Cassandra
+}
+
+func NewCassandraBlocklist(c *Cassandra, opts ...DatastoreOption) (*CassandraBlocklist, error) {
+	b, err := NewDatastoreBlocklist(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &CassandraBlocklist{b, c}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *CassandraBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// discardWrite drops the writes to a namespace of a batch.
+type discardWrite struct{}
+
+func (discardWrite) Put(ds.Key, []byte) error { return nil }
+
+func (discardWrite) Delete(ds.Key) error { return nil }
+
+// Block adds `id` to the blocklist. If `id` was already blocked, the existing
+// entry is returned and kept as is. Otherwise, the returned entry is nil.
+//
+// The entry is inserted if it doesn't exist, in a lightweight transaction,
+// before its digests and indexes are written.
+func (b *CassandraBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	defer wrapError(&err, "cassandra", "block", id)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
+	}
+	if existing, err := b.Search(ctx, id); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	k, bi, err := b.entry(ctx, id, data)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := encode(b.entryCodec, bi)
+	if err != nil {
+		return nil, err
+	}
+	key := b.prefixes.root.Child(b.prefixes.entries).Child(k)
+	prev, applied, err := b.cassandra.putIfAbsent(ctx, key, raw)
+	if err != nil {
+		return nil, err
+	} else if !applied {
+		// Another process blocked it since the search.
+		existing := &BlocklistItem{}
+		return existing, decode(prev, existing)
+	}
+
+	w, commit, err := b.batches()
+	if err == nil {
+		w.entries = discardWrite{}
+		if err = b.put(k, bi, w); err == nil {
+			err = commit()
+		}
+	}
+	if err != nil {
+		// Without its digests, the entry would only block some of the
+		// content.
+		if derr := b.cassandra.Delete(key); derr != nil {
+			log.Warnf("failed to remove the entry of %v: %v", id, derr)
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// CassandraAuditStore stores the audit log of a blocklist in a Cassandra or
+// ScyllaDB table partitioned by the day actions are logged on, so that
+// partitions stay bounded however long the log grows. A second table lists
+// the days that have actions.
+//
+// GetLogs reads days from the most recent one, and filters their actions.
+// Since skips the days before it: actions are logged when they are created,
+// or after for imported ones. Other filters don't narrow the days read.
+type CassandraAuditStore struct {
+	session *gocql.Session
+	table   string
+
+	mu      sync.Mutex
+	lastSeq uint64 // lastSeq is the Seq of the last action logged.
+}
+
+var _ AuditStore = (*CassandraAuditStore)(nil)
+
+// NewCassandraAuditStore returns an audit store stored in the keyspace of
+// `s`. Call Migrate to create its tables.
+func NewCassandraAuditStore(s *gocql.Session, opts ...CassandraOption) *CassandraAuditStore {
+	o := &cassandraOptions{table: DefaultCassandraAuditTable}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &CassandraAuditStore{session: s, table: o.table}
+}
+
+// cassandraDay truncates times to the day of the audit partition they are in.
+const cassandraDay = 24 * time.Hour
+
+// Migrate creates the tables, if they don't exist.
+func (s *CassandraAuditStore) Migrate(ctx context.Context) (err error) {
+	defer wrapError(&err, "cassandra", "migrate", cid.Undef)
+
+	err = s.session.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+		day date,
+		seq bigint,
+		created_at timestamp,
+		typ text,
+		ids list<text>,
+		undoes bigint,
+		changes text,
+		refs text,
+		hlc text,
+		reason text,
+		username text,
+		PRIMARY KEY (day, seq)
+	) WITH CLUSTERING ORDER BY (seq DESC)`, s.table)).WithContext(ctx).Exec()
+	if err != nil {
+		return cassandraError(err)
+	}
+	// The days are in a single partition, as there is one row per day.
+	return cassandraError(s.session.Query(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v_days (
+		bucket int,
+		day date,
+		PRIMARY KEY (bucket, day)
+	) WITH CLUSTERING ORDER BY (day DESC)`, s.table)).WithContext(ctx).Exec())
+}
+
+// nextSeq returns the Seq of an action logged now.
+func (s *CassandraAuditStore) nextSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := uint64(time.Now().UnixNano())
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+	return seq
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq. Its
+// CreatedAt is set to now if it is zero.
+func (s *CassandraAuditStore) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "cassandra", "addlog", cid.Undef)
+
+	if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	ids := make([]string, len(act.Ids))
+	for i, id := range act.Ids {
+		ids[i] = id.String()
+	}
+	var changes, refs, hlc string
+	if len(act.Changes) > 0 {
+		raw, err := json.Marshal(act.Changes)
+		if err != nil {
+			return err
+		}
+		changes = string(raw)
+	}
+	if len(act.References) > 0 {
+		raw, err := json.Marshal(act.References)
+		if err != nil {
+			return err
+		}
+		refs = string(raw)
+	}
+	if act.HLC != nil {
+		hlc = act.HLC.String()
+	}
+	createdAt := act.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	// Cassandra stores timestamps in milliseconds.
+	createdAt = createdAt.UTC().Truncate(time.Millisecond)
+	seq := s.nextSeq()
+	day := time.Unix(0, int64(seq)).UTC().Truncate(cassandraDay)
+
+	// The day is listed first, so that GetLogs reads every action logged.
+	err = s.session.Query(fmt.Sprintf("INSERT INTO %v_days (bucket, day) VALUES (0, ?)", s.table), day).WithContext(ctx).Exec()
+	if err != nil {
+		return cassandraError(err)
+	}
+	err = s.session.Query(fmt.Sprintf(`INSERT INTO %v (day, seq, created_at, typ, ids, undoes, changes, refs, hlc, reason, username)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.table),
+		day, int64(seq), createdAt, string(act.Typ), ids, int64(act.Undoes), changes, refs, hlc, act.Reason, act.User,
+	).WithContext(ctx).Exec()
+	if err != nil {
+		return cassandraError(err)
+	}
+	act.Seq, act.CreatedAt = seq, createdAt
+	return nil
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+func (s *CassandraAuditStore) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "cassandra", "getlogs", cid.Undef)
+
+	daysStmt := fmt.Sprintf("SELECT day FROM %v_days WHERE bucket = 0", s.table)
+	daysArgs := []interface{}{}
+	actsStmt := fmt.Sprintf(`SELECT seq, created_at, typ, ids, undoes, changes, refs, hlc, reason, username
+		FROM %v WHERE day = ?`, s.table)
+	if q.Before > 0 {
+		daysStmt += " AND day <= ?"
+		daysArgs = append(daysArgs, time.Unix(0, int64(q.Before)).UTC().Truncate(cassandraDay))
+		actsStmt += " AND seq < ?"
+	}
+	var since time.Time
+	if !q.Since.IsZero() {
+		since = q.Since.UTC().Truncate(cassandraDay)
+	}
+
+	days := s.session.Query(daysStmt, daysArgs...).WithContext(ctx).Iter()
+	var day time.Time
+	for days.Scan(&day) {
+		if day.Before(since) {
+			break
+		}
+		args := []interface{}{day}
+		if q.Before > 0 {
+			args = append(args, int64(q.Before))
+		}
+		if acts, err = s.readDay(ctx, q, actsStmt, args, acts); err != nil {
+			days.Close()
+			return nil, err
+		} else if q.Limit > 0 && len(acts) >= q.Limit {
+			break
+		}
+	}
+	if err := days.Close(); err != nil {
+		return nil, cassandraError(err)
+	}
+	return acts, nil
+}
+
+// readDay appends the actions of a day that match `q` to `acts`, until there
+// are q.Limit of them.
+func (s *CassandraAuditStore) readDay(ctx context.Context, q LogQuery, stmt string, args []interface{}, acts []*Action) ([]*Action, error) {
+	var (
+		seq, undoes             int64
+		ids                     []string
+		typ, changes, refs, hlc string
+		reason, user            string
+		createdAt               time.Time
+	)
+	it := s.session.Query(stmt, args...).WithContext(ctx).PageSize(cassandraPage).Iter()
+	for it.Scan(&seq, &createdAt, &typ, &ids, &undoes, &changes, &refs, &hlc, &reason, &user) {
+		act := &Action{
+			Seq:       uint64(seq),
+			Typ:       ActionType(typ),
+			Undoes:    uint64(undoes),
+			Reason:    reason,
+			User:      user,
+			CreatedAt: createdAt.UTC(),
+		}
+		for _, r := range ids {
+			id, err := cid.Parse(r)
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			act.Ids = append(act.Ids, id)
+		}
+		if !q.Match(act) {
+			continue
+		}
+		if changes != "" {
+			if err := json.Unmarshal([]byte(changes), &act.Changes); err != nil {
+				it.Close()
+				return nil, err
+			}
+		}
+		if refs != "" {
+			if err := json.Unmarshal([]byte(refs), &act.References); err != nil {
+				it.Close()
+				return nil, err
+			}
+		}
+		if hlc != "" {
+			act.HLC = &HLC{}
+			if err := act.HLC.UnmarshalText([]byte(hlc)); err != nil {
+				it.Close()
+				return nil, err
+			}
+		}
+		acts = append(acts, act)
+		if q.Limit > 0 && len(acts) >= q.Limit {
+			break
+		}
+	}
+	return acts, cassandraError(it.Close())
+}
@@ -0,0 +1,153 @@
+package blocklist
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrBadSignature is returned when a change set isn't signed by any of the
+// trusted keys.
+var ErrBadSignature = fmt.Errorf("change set signature is invalid")
+
+// ChangeOp is one modification of a change set.
+type ChangeOp struct {
+	Typ ActionType // Typ is ActionBlock, ActionUnblock, or ActionUpdate.
+	Id  string
+	// Data is the patch for ActionUpdate, and ignored for ActionUnblock. Its
+	// User defaults to the author of the change set.
+	Data BlockData
+}
+
+// ChangeSet is a list of modifications prepared by one team, to be reviewed
+// and signed offline by an approver before another team applies it.
+type ChangeSet struct {
+	Ops       []ChangeOp
+	Reason    string
+	Author    string // Author is the email of who prepared the change set.
+	CreatedAt time.Time
+}
+
+func (cs *ChangeSet) MarshalBinary() ([]byte, error) {
+	return json.Marshal(cs)
+}
+
+func (cs *ChangeSet) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, cs)
+}
+
+// SignedChangeSet is a serialized change set and the signature of an
+// approver. The signature covers the exact bytes of ChangeSet, so they must
+// not be re-serialized.
+type SignedChangeSet struct {
+	ChangeSet []byte
+	Signature []byte
+}
+
+// SignChangeSet signs the serialized change set `raw` with the ed25519 key of
+// an approver.
+func SignChangeSet(raw []byte, key ed25519.PrivateKey) *SignedChangeSet {
+	return &SignedChangeSet{ChangeSet: raw, Signature: ed25519.Sign(key, raw)}
+}
+
+// Verify returns the change set if it is signed by one of `keys`, and
+// ErrBadSignature otherwise.
+func (s *SignedChangeSet) Verify(keys []ed25519.PublicKey) (*ChangeSet, error) {
+	for _, key := range keys {
+		if ed25519.Verify(key, s.ChangeSet, s.Signature) {
+			cs := &ChangeSet{}
+			if err := cs.UnmarshalBinary(s.ChangeSet); err != nil {
+				return nil, err
+			}
+			return cs, nil
+		}
+	}
+	return nil, ErrBadSignature
+}
+
+// changeOp is a validated ChangeOp.
+type changeOp struct {
+	typ  ActionType
+	id   cid.Cid
+	data BlockData
+}
+
+// validate checks every op of `cs` before any of them is applied, so that a
+// malformed change set changes nothing.
+func (cs *ChangeSet) validate() ([]changeOp, error) {
+	ops := make([]changeOp, len(cs.Ops))
+	for i, op := range cs.Ops {
+		id, err := cid.Decode(op.Id)
+		if err != nil {
+			return nil, fmt.Errorf("op %v: %w: %v", i, ErrInvalidCID, err)
+		}
+		data := op.Data
+		if data.User == "" {
+			data.User = cs.Author
+		}
+		switch op.Typ {
+		case ActionBlock:
+			data, err = data.Validate()
+		case ActionUpdate:
+			data, err = data.validate(true)
+		case ActionUnblock:
+		default:
+			err = fmt.Errorf("unexpected action type: '%v'", op.Typ)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("op %v: %w", i, err)
+		}
+		ops[i] = changeOp{op.Typ, id, data}
+	}
+	return ops, nil
+}
+
+// ApplyChangeSet verifies that `s` is signed by one of `keys`, and applies its
+// ops to `b` in order on behalf of `user`. Blocks and unblocks are logged as
+// actions of `user` that name the author of the change set, and updates by
+// Update itself. Applying a change set twice blocks and unblocks nothing new.
+func ApplyChangeSet(ctx context.Context, b Blocklist, s *SignedChangeSet, keys []ed25519.PublicKey, user string) (*ChangeSet, error) {
+	cs, err := s.Verify(keys)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := cs.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("%v (change set of %v)", cs.Reason, cs.Author)
+	for _, op := range ops {
+		act := &Action{Typ: op.typ, Ids: []cid.Cid{op.id}, Reason: reason, User: user}
+		switch op.typ {
+		case ActionBlock:
+			existing, err := b.Block(ctx, op.id, op.data)
+			if err != nil {
+				return nil, err
+			} else if existing != nil {
+				continue
+			}
+		case ActionUnblock:
+			if _, err := b.Unblock(ctx, op.id); errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		case ActionUpdate:
+			if err := b.Update(ctx, op.id, op.data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		act.CreatedAt = time.Now()
+		if err := b.AddLog(ctx, act); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
@@ -0,0 +1,385 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// AuditStore stores the audit log of a blocklist, apart from its entries.
+type AuditStore interface {
+	AddLog(ctx context.Context, act *Action) error
+	GetLogs(ctx context.Context, q LogQuery) ([]*Action, error)
+}
+
+// AuditedBlocklist logs the actions of a blocklist in a separate AuditStore,
+// so that years of audit actions don't bloat the operational database. The
+// actions logged in the blocklist itself before are left there.
+type AuditedBlocklist struct {
+	Blocklist
+	store AuditStore
+}
+
+// NewAudited returns `b`, with its audit log stored in `store`.
+func NewAudited(b Blocklist, store AuditStore) *AuditedBlocklist {
+	return &AuditedBlocklist{b, store}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *AuditedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+func (b *AuditedBlocklist) AddLog(ctx context.Context, act *Action) error {
+	return b.store.AddLog(ctx, act)
+}
+
+func (b *AuditedBlocklist) GetLogs(ctx context.Context, q LogQuery) ([]*Action, error) {
+	return b.store.GetLogs(ctx, q)
+}
+
+// DefaultClickHouseTimeout is how long requests to ClickHouse may take.
+const DefaultClickHouseTimeout = 30 * time.Second
+
+// clickHouseTimeLayout is the layout of DateTime64(6) values in the
+// JSONEachRow format.
+const clickHouseTimeLayout = "2006-01-02 15:04:05.000000"
+
+// ClickHouseOption configures a ClickHouseAuditStore.
+type ClickHouseOption func(*clickHouseOptions)
+
+type clickHouseOptions struct {
+	client   *http.Client
+	database string
+	table    string
+	user     string
+	password string
+}
+
+// WithClickHouseHTTPClient sends requests with `c` instead of a client with
+// a DefaultClickHouseTimeout timeout.
+func WithClickHouseHTTPClient(c *http.Client) ClickHouseOption {
+	return func(o *clickHouseOptions) {
+		o.client = c
+	}
+}
+
+// WithClickHouseTable stores actions in `table` of `database`, instead of
+// "audit_log" in the default database of the user.
+func WithClickHouseTable(database, table string) ClickHouseOption {
+	return func(o *clickHouseOptions) {
+		o.database, o.table = database, table
+	}
+}
+
+// WithClickHouseCredentials authenticates as `user`, instead of the default
+// user.
+func WithClickHouseCredentials(user, password string) ClickHouseOption {
+	return func(o *clickHouseOptions) {
+		o.user, o.password = user, password
+	}
+}
+
+// ClickHouseAuditStore is an AuditStore in a ClickHouse table, through its
+// HTTP interface. The table is partitioned by month, so that old actions can
+// be moved to cheaper storage or dropped with a TTL.
+//
+// ClickHouse has no sequences: the Seq of actions is the nanosecond time they
+// were logged at, kept increasing by each store. Actions logged at the same
+// nanosecond by several processes have the same Seq, and paging through them
+// with LogQuery.Before skips all but the first page of them.
+type ClickHouseAuditStore struct {
+	endpoint string
+	client   *http.Client
+	database string
+	table    string
+	user     string
+	password string
+
+	mu      sync.Mutex
+	lastSeq uint64
+}
+
+var _ AuditStore = (*ClickHouseAuditStore)(nil)
+
+// clickHouseIdentifier matches the database and table names that are used
+// unquoted.
+var clickHouseIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewClickHouseAuditStore returns an AuditStore in the ClickHouse server
+// `endpoint`, like "http://clickhouse.internal:8123". The table has to exist:
+// see Migrate.
+func NewClickHouseAuditStore(endpoint string, opts ...ClickHouseOption) (*ClickHouseAuditStore, error) {
+	o := &clickHouseOptions{
+		client: &http.Client{Timeout: DefaultClickHouseTimeout},
+		table:  "audit_log",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !clickHouseIdentifier.MatchString(o.table) || (o.database != "" && !clickHouseIdentifier.MatchString(o.database)) {
+		return nil, fmt.Errorf("invalid clickhouse table %q.%q", o.database, o.table)
+	}
+	return &ClickHouseAuditStore{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   o.client,
+		database: o.database,
+		table:    o.table,
+		user:     o.user,
+		password: o.password,
+	}, nil
+}
+
+// tableName returns the qualified name of the table.
+func (s *ClickHouseAuditStore) tableName() string {
+	if s.database != "" {
+		return s.database + "." + s.table
+	}
+	return s.table
+}
+
+// query runs `query` with the parameters `params`, referenced like
+// {name:Type} in the query, and `body` as its input data. It returns the
+// output of the query.
+func (s *ClickHouseAuditStore) query(ctx context.Context, query string, params map[string]string, body []byte) ([]byte, error) {
+	v := url.Values{}
+	v.Set("query", query)
+	v.Set("output_format_json_quote_64bit_integers", "0")
+	for name, value := range params {
+		v.Set("param_"+name, value)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/?"+v.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("clickhouse: %v: %v", resp.Status, strings.TrimSpace(string(raw)))
+		if resp.StatusCode >= http.StatusInternalServerError && resp.Header.Get("X-ClickHouse-Exception-Code") == "" {
+			err = fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+		}
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Migrate creates the table of the audit log, if it doesn't exist yet, and
+// adds the multihashes column to tables created before it. Actions logged
+// before that are only matched by LogQuery.Id under the CIDs they were
+// logged with.
+func (s *ClickHouseAuditStore) Migrate(ctx context.Context) (err error) {
+	defer wrapError(&err, "clickhouse", "migrate", cid.Undef)
+
+	_, err = s.query(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+		seq UInt64,
+		created_at DateTime64(6, 'UTC'),
+		typ LowCardinality(String),
+		ids Array(String),
+		multihashes Array(String),
+		undoes UInt64,
+		changes String,
+		refs String,
+		hlc String,
+		reason String,
+		user String
+	) ENGINE = MergeTree
+	PARTITION BY toYYYYMM(created_at)
+	ORDER BY seq`, s.tableName()), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.query(ctx, fmt.Sprintf("ALTER TABLE %v ADD COLUMN IF NOT EXISTS multihashes Array(String) AFTER ids", s.tableName()), nil, nil)
+	return err
+}
+
+// clickHouseLog is a row of the audit log table.
+type clickHouseLog struct {
+	Seq       uint64   `json:"seq"`
+	CreatedAt string   `json:"created_at"`
+	Typ       string   `json:"typ"`
+	Ids       []string `json:"ids"`
+	// Multihashes are the multihashes of Ids, in hex, that GetLogs filters
+	// on.
+	Multihashes []string `json:"multihashes"`
+	Undoes      uint64   `json:"undoes"`
+	Changes     string   `json:"changes"`
+	Refs        string   `json:"refs"`
+	HLC         string   `json:"hlc"`
+	Reason      string   `json:"reason"`
+	User        string   `json:"user"`
+}
+
+// nextSeq returns the Seq of an action logged now.
+func (s *ClickHouseAuditStore) nextSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := uint64(time.Now().UnixNano())
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+	return seq
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq. Its
+// CreatedAt is set to now if it is zero.
+func (s *ClickHouseAuditStore) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "clickhouse", "addlog", cid.Undef)
+
+	if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	row := clickHouseLog{
+		Typ:         string(act.Typ),
+		Ids:         make([]string, len(act.Ids)),
+		Multihashes: make([]string, len(act.Ids)),
+		Undoes:      act.Undoes,
+		Reason:      act.Reason,
+		User:        act.User,
+	}
+	for i, id := range act.Ids {
+		row.Ids[i], row.Multihashes[i] = id.String(), hex.EncodeToString(id.Hash())
+	}
+	if len(act.Changes) > 0 {
+		changes, err := json.Marshal(act.Changes)
+		if err != nil {
+			return err
+		}
+		row.Changes = string(changes)
+	}
+	if len(act.References) > 0 {
+		refs, err := json.Marshal(act.References)
+		if err != nil {
+			return err
+		}
+		row.Refs = string(refs)
+	}
+	if act.HLC != nil {
+		row.HLC = act.HLC.String()
+	}
+	createdAt := act.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	row.CreatedAt = createdAt.UTC().Format(clickHouseTimeLayout)
+	row.Seq = s.nextSeq()
+
+	body, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if _, err := s.query(ctx, fmt.Sprintf("INSERT INTO %v FORMAT JSONEachRow", s.tableName()), nil, body); err != nil {
+		return err
+	}
+	act.Seq, act.CreatedAt = row.Seq, createdAt
+	return nil
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+func (s *ClickHouseAuditStore) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "clickhouse", "getlogs", cid.Undef)
+
+	var (
+		where  []string
+		params = make(map[string]string)
+	)
+	if q.Before > 0 {
+		where, params["before"] = append(where, "seq < {before:UInt64}"), strconv.FormatUint(q.Before, 10)
+	}
+	if q.User != "" {
+		where, params["user"] = append(where, "user = {user:String}"), q.User
+	}
+	if q.Typ != "" {
+		where, params["typ"] = append(where, "typ = {typ:String}"), string(q.Typ)
+	}
+	if !q.Since.IsZero() {
+		where, params["since"] = append(where, "created_at >= {since:DateTime64(6, 'UTC')}"), q.Since.UTC().Format(clickHouseTimeLayout)
+	}
+	if !q.Until.IsZero() {
+		where, params["until"] = append(where, "created_at < {until:DateTime64(6, 'UTC')}"), q.Until.UTC().Format(clickHouseTimeLayout)
+	}
+	if q.Id.Defined() {
+		where = append(where, "(has(multihashes, {multihash:String}) OR has(ids, {id:String}))")
+		params["multihash"], params["id"] = hex.EncodeToString(q.Id.Hash()), q.Id.String()
+	}
+	sql := "SELECT * FROM " + s.tableName()
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY seq DESC"
+	if q.Limit > 0 {
+		sql += " LIMIT {limit:UInt64}"
+		params["limit"] = strconv.Itoa(q.Limit)
+	}
+	sql += " FORMAT JSONEachRow"
+
+	raw, err := s.query(ctx, sql, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for dec.More() {
+		var l clickHouseLog
+		if err := dec.Decode(&l); err != nil {
+			return nil, err
+		}
+		act := &Action{Seq: l.Seq, Typ: ActionType(l.Typ), Undoes: l.Undoes, Reason: l.Reason, User: l.User}
+		if act.CreatedAt, err = time.Parse(clickHouseTimeLayout, l.CreatedAt); err != nil {
+			return nil, err
+		}
+		for _, r := range l.Ids {
+			id, err := cid.Parse(r)
+			if err != nil {
+				return nil, err
+			}
+			act.Ids = append(act.Ids, id)
+		}
+		if l.Changes != "" {
+			if err := json.Unmarshal([]byte(l.Changes), &act.Changes); err != nil {
+				return nil, err
+			}
+		}
+		if l.Refs != "" {
+			if err := json.Unmarshal([]byte(l.Refs), &act.References); err != nil {
+				return nil, err
+			}
+		}
+		if l.HLC != "" {
+			act.HLC = &HLC{}
+			if err := act.HLC.UnmarshalText([]byte(l.HLC)); err != nil {
+				return nil, err
+			}
+		}
+		acts = append(acts, act)
+	}
+	return acts, nil
+}
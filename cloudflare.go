@@ -0,0 +1,92 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Defaults of the Cloudflare API clients of WorkersKV and D1Blocklist.
+const (
+	DefaultCloudflareEndpoint = "https://api.cloudflare.com/client/v4"
+	DefaultCloudflareTimeout  = 10 * time.Second
+)
+
+// cfAPI sends authenticated requests under one base URL of the Cloudflare API.
+type cfAPI struct {
+	client   *http.Client
+	base     string
+	apiToken string
+}
+
+// cfResponse is the envelope of the JSON responses of the API.
+type cfResponse struct {
+	Success bool
+	Errors  []struct {
+		Code    int
+		Message string
+	}
+	Result     json.RawMessage
+	ResultInfo struct {
+		Cursor string
+	} `json:"result_info"`
+}
+
+// cfError is an error response of the API.
+type cfError struct {
+	Method   string
+	Endpoint string
+	Status   int    // Status is the HTTP status code.
+	Code     int    // Code is the API error code, if the response had one.
+	Message  string // Message is the API error message, or the HTTP status.
+}
+
+func (e *cfError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("cloudflare api: %v %v: %v (code %v)", e.Method, e.Endpoint, e.Message, e.Code)
+	}
+	return fmt.Sprintf("cloudflare api: %v %v: %v", e.Method, e.Endpoint, e.Message)
+}
+
+// do sends a request to `endpoint`, and returns the body of a successful
+// response. Error responses are returned as a *cfError, wrapped in
+// ErrBackendUnavailable if they are worth retrying later, as are network
+// failures.
+func (api cfAPI) do(ctx context.Context, method, endpoint string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, api.base+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.apiToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := api.client.Do(req)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return raw, nil
+	}
+
+	apiErr := &cfError{Method: method, Endpoint: endpoint, Status: resp.StatusCode, Message: resp.Status}
+	var res cfResponse
+	if json.Unmarshal(raw, &res) == nil && len(res.Errors) > 0 {
+		apiErr.Code, apiErr.Message = res.Errors[0].Code, res.Errors[0].Message
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return nil, apiErr
+}
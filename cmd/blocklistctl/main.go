@@ -0,0 +1,251 @@
+// Command blocklistctl operates a blocklist from the command line. Its import
+// command is the fastest way to block many CIDs at once, during a mass
+// takedown:
+//
+//	blocklistctl import -backend postgres -dsn postgres://localhost/blocklist \
+//		-stdin -format lines -reason "court order 123" -user ops@example.com < cids.txt
+//
+// With -format lines, the input has one CID per line, blocked with the
+// shared -reason, -user, and -category; blank lines and lines starting with
+// "#" are skipped. They are blocked in one BlockMany call, which is a single
+// transaction on backends that support them, and logged as one "block"
+// action. With -format feed, the input is an import feed of JSON records, as
+// written by blocklist.Export, applied by a blocklist.Importer. With -copy,
+// the feed is instead copied into the postgres backend with
+// PgBlocklist.CopyImport, which is much faster for large feeds but doesn't
+// compare the metadata of content that is already blocked.
+//
+// A summary is printed once the import is done. The command exits with status
+// 1 if anything failed, and leaves the blocklist unchanged if it failed
+// before blocking.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	cid "github.com/ipfs/go-cid"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "import":
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: blocklistctl import [flags] [file]")
+	os.Exit(2)
+}
+
+// backendFlags are the flags that select the backend.
+type backendFlags struct {
+	backend, dsn, endpoints, address *string
+}
+
+func addBackendFlags(fs *flag.FlagSet) backendFlags {
+	return backendFlags{
+		backend:   fs.String("backend", "postgres", "backend: postgres, etcd, or consul"),
+		dsn:       fs.String("dsn", "", "DSN of the postgres backend"),
+		endpoints: fs.String("endpoints", "http://127.0.0.1:2379", "comma-separated endpoints of the etcd backend"),
+		address:   fs.String("address", "http://127.0.0.1:8500", "address of the agent of the consul backend"),
+	}
+}
+
+// open returns the backend selected by `f`.
+func (f backendFlags) open() (blocklist.Blocklist, error) {
+	switch *f.backend {
+	case "postgres":
+		return blocklist.NewPgBlocklist(*f.dsn)
+	case "etcd":
+		return blocklist.NewEtcdBlocklist(blocklist.NewEtcd(strings.Split(*f.endpoints, ",")))
+	case "consul":
+		return blocklist.NewConsulBlocklist(blocklist.NewConsul(*f.address))
+	}
+	return nil, fmt.Errorf("unknown backend %q", *f.backend)
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var (
+		bf       = addBackendFlags(fs)
+		stdin    = fs.Bool("stdin", false, "read from standard input instead of a file")
+		format   = fs.String("format", "lines", "input format: lines, one CID per line, or feed, JSON import records")
+		reason   = fs.String("reason", "", "reason of the blocks, with -format lines")
+		user     = fs.String("user", "", "email of the user blocking the content")
+		category = fs.String("category", "", "category of the blocks, with -format lines")
+		timeout  = fs.Duration("timeout", time.Hour, "how long the import may take")
+		useCopy  = fs.Bool("copy", false, "with -format feed, copy the feed into the postgres backend with COPY")
+	)
+	fs.Parse(args)
+
+	var in io.Reader
+	switch {
+	case *stdin && fs.NArg() == 0:
+		in = os.Stdin
+	case !*stdin && fs.NArg() == 1:
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	default:
+		return fmt.Errorf("import needs either -stdin or a file")
+	}
+
+	b, err := bf.open()
+	if err != nil {
+		return fmt.Errorf("opening %v backend: %w", *bf.backend, err)
+	}
+	defer b.Close(context.Background())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	switch *format {
+	case "lines":
+		data := blocklist.BlockData{Reason: *reason, User: *user}
+		if *category != "" {
+			data.Metadata = map[string]string{blocklist.DefaultCategoryKey: *category}
+		}
+		return importLines(ctx, b, in, data)
+	case "feed":
+		if *useCopy {
+			pg, ok := b.(*blocklist.PgBlocklist)
+			if !ok {
+				return fmt.Errorf("-copy needs the postgres backend")
+			}
+			return copyFeed(ctx, pg, in, *user)
+		}
+		return importFeed(ctx, b, in, *user)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+// importLines blocks the CIDs of `in`, one per line, with `data`, in one
+// BlockMany call.
+func importLines(ctx context.Context, b blocklist.Blocklist, in io.Reader, data blocklist.BlockData) error {
+	start := time.Now()
+	data, err := data.Validate()
+	if err != nil {
+		return err
+	}
+	if !blocklist.CapabilitiesOf(b).Transactions {
+		log.Print("warning: the backend has no transactions, a failed import may be partly applied")
+	}
+
+	var (
+		ids        []cid.Cid
+		seen       = make(map[cid.Cid]bool)
+		invalid    int
+		duplicates int
+		line       int
+		scanner    = bufio.NewScanner(in)
+	)
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		id, err := cid.Decode(s)
+		if err != nil {
+			log.Printf("line %v: invalid CID %q: %v", line, s, err)
+			invalid++
+			continue
+		}
+		if seen[id] {
+			duplicates++
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading input: %w", err)
+	}
+	if len(ids) == 0 {
+		log.Printf("nothing to import: %v invalid, %v duplicates", invalid, duplicates)
+		return nil
+	}
+
+	blocked, err := b.BlockMany(ctx, ids, data)
+	if err != nil {
+		return fmt.Errorf("blocking %v CIDs: %w", len(ids), err)
+	}
+	var logErr error
+	if len(blocked) > 0 {
+		logErr = b.AddLog(ctx, &blocklist.Action{
+			Typ: blocklist.ActionBlock, Ids: blocked,
+			Reason: data.Reason, User: data.User, CreatedAt: time.Now(),
+		})
+	}
+
+	log.Printf("imported %v lines in %v: %v blocked, %v already blocked, %v duplicates, %v invalid",
+		line, time.Since(start).Round(time.Millisecond), len(blocked), len(ids)-len(blocked), duplicates, invalid)
+	if logErr != nil {
+		return fmt.Errorf("logging the block of %v CIDs: %w", len(blocked), logErr)
+	} else if invalid > 0 {
+		return fmt.Errorf("%v invalid lines were skipped", invalid)
+	}
+	return nil
+}
+
+// importFeed applies the import feed `in` with an Importer, as `user`.
+func importFeed(ctx context.Context, b blocklist.Blocklist, in io.Reader, user string) error {
+	start := time.Now()
+	p, err := blocklist.NewImporter(b, nil).Import(ctx, in, user)
+	if err != nil {
+		return err
+	}
+	for _, e := range p.Invalid {
+		log.Print(e)
+	}
+	log.Printf("imported feed in %v: %v blocked, %v already blocked, %v conflicting, %v duplicates, %v invalid",
+		time.Since(start).Round(time.Millisecond), len(p.New), len(p.AlreadyBlocked), len(p.Conflicting), p.Duplicates, len(p.Invalid))
+	if len(p.Invalid) > 0 {
+		return fmt.Errorf("%v invalid records were skipped", len(p.Invalid))
+	}
+	return nil
+}
+
+// copyFeed copies the import feed `in` into `b` with COPY, as `user`.
+func copyFeed(ctx context.Context, b *blocklist.PgBlocklist, in io.Reader, user string) error {
+	start := time.Now()
+	stats, err := b.CopyImport(ctx, in, user)
+	if stats != nil {
+		for _, e := range stats.Invalid {
+			log.Print(e)
+		}
+		log.Printf("copied feed in %v: %v blocked, %v already blocked, %v duplicates, %v invalid",
+			time.Since(start).Round(time.Millisecond), stats.Inserted, stats.Skipped, stats.Duplicates, len(stats.Invalid))
+	}
+	if err != nil {
+		return err
+	} else if len(stats.Invalid) > 0 {
+		return fmt.Errorf("%v invalid records were skipped", len(stats.Invalid))
+	}
+	return nil
+}
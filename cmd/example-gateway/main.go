@@ -0,0 +1,214 @@
+// Command example-gateway is a reference deployment of the blocklist: an IPFS
+// gateway that refuses blocked content, backed by Postgres.
+//
+// It sits in front of the HTTP gateway of an IPFS node, like Kubo's on port
+// 8080, and proxies the requests that the blocklist allows:
+//
+//	example-gateway -upstream http://127.0.0.1:8080 -dsn postgres://localhost/blocklist
+//
+// Without -dsn, the blocklist is kept in memory, which is enough for
+// integration tests. The admin listener serves the metrics, the health of the
+// backend, and a minimal API to block and unblock content.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+	cid "github.com/ipfs/go-cid"
+)
+
+func main() {
+	var (
+		listen     = flag.String("listen", "127.0.0.1:8081", "address of the gateway")
+		admin      = flag.String("admin", "127.0.0.1:8082", "address of the admin API and metrics")
+		upstream   = flag.String("upstream", "http://127.0.0.1:8080", "URL of the gateway to proxy allowed requests to")
+		dsn        = flag.String("dsn", "", "Postgres DSN of the blocklist; in memory if empty")
+		timeout    = flag.Duration("timeout", blocklist.DefaultMiddlewareTimeout, "how long blocklist lookups may take")
+		failClosed = flag.Bool("fail-closed", false, "refuse requests when the blocklist doesn't answer in time")
+		gone       = flag.Bool("gone-for-purged", false, "respond 410 Gone for purged content")
+	)
+	flag.Parse()
+
+	target, err := url.Parse(*upstream)
+	if err != nil {
+		log.Fatalf("invalid upstream: %v", err)
+	}
+
+	backend, err := open(*dsn)
+	if err != nil {
+		log.Fatalf("opening blocklist: %v", err)
+	}
+	// Wrappers hide the schema checks of the backend: test it unwrapped.
+	testCtx, cancelTest := context.WithTimeout(context.Background(), 30*time.Second)
+	report := blocklist.SelfTest(testCtx, backend)
+	cancelTest()
+	log.Print(report)
+	if err := report.Err(); err != nil {
+		log.Fatal(err)
+	}
+	b := blocklist.NewMetrics(backend)
+
+	opts := []blocklist.MiddlewareOption{blocklist.WithTimeout(*timeout)}
+	if *failClosed {
+		opts = append(opts, blocklist.WithFailPolicy(blocklist.FailClosed))
+	}
+	if *gone {
+		opts = append(opts, blocklist.WithGoneForPurged())
+	}
+	mw := blocklist.NewMiddleware(b, opts...)
+
+	servers := []*http.Server{
+		{Addr: *listen, Handler: mw.Handler(httputil.NewSingleHostReverseProxy(target))},
+		{Addr: *admin, Handler: adminHandler(b, mw)},
+	}
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			log.Printf("listening on %v", srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(srv)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		log.Printf("serving: %v", err)
+	case s := <-sig:
+		log.Printf("received %v, shutting down", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutting down %v: %v", srv.Addr, err)
+		}
+	}
+	if err := b.Close(ctx); err != nil {
+		log.Printf("closing blocklist: %v", err)
+	}
+}
+
+// open returns the Postgres blocklist at `dsn`, or a memory one if `dsn` is
+// empty.
+func open(dsn string) (blocklist.Blocklist, error) {
+	if dsn == "" {
+		log.Print("no -dsn, keeping the blocklist in memory")
+		return blocklist.NewMemoryBlocklist(), nil
+	}
+	return blocklist.NewPgBlocklist(dsn)
+}
+
+// adminHandler serves:
+//
+//	GET    /metrics       the Stats of `b` and the Counters of `mw`, as JSON
+//	GET    /healthz       200 if the backend is healthy, 503 otherwise
+//	PUT    /block/<cid>   blocks <cid> with the BlockData in the body, or
+//	                      responds 409 with the entry if it is blocked already
+//	DELETE /block/<cid>   unblocks <cid>, as the user and reason in the query
+//
+// It is meant for tests and local setups, and doesn't authenticate requests.
+func adminHandler(b *blocklist.MetricsBlocklist, mw *blocklist.Middleware) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, struct {
+			Blocklist  blocklist.Stats
+			Middleware blocklist.MiddlewareCounters
+		}{b.Stats(), mw.Counters()})
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := b.Healthy(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/block/", func(w http.ResponseWriter, r *http.Request) {
+		id, err := cid.Decode(r.URL.Path[len("/block/"):])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			var data blocklist.BlockData
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			existing, err := b.Block(r.Context(), id, data)
+			switch {
+			case err != nil:
+				http.Error(w, err.Error(), status(err))
+			case existing != nil:
+				writeJSON(w, http.StatusConflict, existing)
+			default:
+				logAction(r.Context(), b, &blocklist.Action{
+					Typ: blocklist.ActionBlock, Ids: []cid.Cid{id},
+					Reason: data.Reason, User: data.User, References: data.References,
+				})
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case http.MethodDelete:
+			if _, err := b.Unblock(r.Context(), id); err != nil {
+				http.Error(w, err.Error(), status(err))
+				return
+			}
+			logAction(r.Context(), b, &blocklist.Action{
+				Typ: blocklist.ActionUnblock, Ids: []cid.Cid{id},
+				Reason: r.URL.Query().Get("reason"), User: r.URL.Query().Get("user"),
+			})
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// logAction adds `act` to the audit log of `b`. The change it logs was made
+// already, so failures are only reported.
+func logAction(ctx context.Context, b blocklist.Blocklist, act *blocklist.Action) {
+	act.CreatedAt = time.Now()
+	if err := b.AddLog(ctx, act); err != nil {
+		log.Printf("logging %v of %v: %v", act.Typ, act.Ids, err)
+	}
+}
+
+// status returns the HTTP status of a blocklist error.
+func status(err error) int {
+	var verr *blocklist.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		return http.StatusBadRequest
+	case errors.Is(err, blocklist.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, blocklist.ErrBackendUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
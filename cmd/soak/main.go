@@ -0,0 +1,150 @@
+// Command soak runs a soak test against a blocklist backend, to qualify it
+// before it goes to production:
+//
+//	soak -backend postgres -dsn postgres://localhost/soak -duration 6h -rate 2000
+//
+// It prints a report every -interval, and exits with status 1 if the error
+// rate or the heap growth exceeds -max-error-rate or -max-heap-growth. The
+// test blocks and unblocks content: point it to a dedicated database or
+// namespace.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	blocklist "github.com/cloudflare/go-ipfs-blocklist"
+)
+
+func main() {
+	var (
+		backend       = flag.String("backend", "memory", "backend to test: memory, postgres, etcd, or consul")
+		dsn           = flag.String("dsn", "", "DSN of the postgres backend")
+		endpoints     = flag.String("endpoints", "http://127.0.0.1:2379", "comma-separated endpoints of the etcd backend")
+		address       = flag.String("address", "http://127.0.0.1:8500", "address of the agent of the consul backend")
+		duration      = flag.Duration("duration", time.Hour, "how long to run")
+		interval      = flag.Duration("interval", time.Minute, "how often to print a report")
+		workers       = flag.Int("workers", 16, "concurrent operations")
+		rate          = flag.Int("rate", 0, "operations per second; unlimited if 0")
+		keyspace      = flag.Int("keyspace", 10000, "distinct CIDs to operate on")
+		mix           = flag.String("mix", "", "weights of the operations, like contains=950,block=25,unblock=20,getlogs=5")
+		maxErrorRate  = flag.Float64("max-error-rate", 0.001, "highest acceptable fraction of failed operations")
+		maxHeapGrowth = flag.Int64("max-heap-growth", 64<<20, "highest acceptable heap growth, in bytes")
+	)
+	flag.Parse()
+
+	m := blocklist.DefaultSoakMix
+	if *mix != "" {
+		var err error
+		if m, err = parseMix(*mix); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	b, err := open(*backend, *dsn, *endpoints, *address)
+	if err != nil {
+		log.Fatalf("opening %v backend: %v", *backend, err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	report, err := blocklist.Soak(ctx, b, *duration,
+		blocklist.WithSoakMix(m),
+		blocklist.WithSoakWorkers(*workers),
+		blocklist.WithSoakRate(*rate),
+		blocklist.WithSoakKeyspace(*keyspace),
+		blocklist.WithSoakReport(*interval, func(r blocklist.SoakReport) {
+			log.Print(r)
+		}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		log.Printf("closing backend: %v", err)
+	}
+
+	log.Printf("final: %v", report)
+	names := make([]string, 0, len(report.Ops))
+	for name := range report.Ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		op := report.Ops[name]
+		var mean time.Duration
+		if op.Calls > 0 {
+			mean = op.Duration / time.Duration(op.Calls)
+		}
+		log.Printf("  %-8v %10d calls %8d errors %12v avg", name, op.Calls, op.Errors, mean)
+	}
+	if report.LastError != nil {
+		log.Printf("last error: %v", report.LastError)
+	}
+
+	failed := false
+	if rate := report.ErrorRate(); rate > *maxErrorRate {
+		log.Printf("FAIL: error rate %.4f%% above %.4f%%", 100*rate, 100**maxErrorRate)
+		failed = true
+	}
+	if report.HeapGrowth > *maxHeapGrowth {
+		log.Printf("FAIL: heap grew by %d bytes, above %d", report.HeapGrowth, *maxHeapGrowth)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// open returns the backend named `backend`.
+func open(backend, dsn, endpoints, address string) (blocklist.Blocklist, error) {
+	switch backend {
+	case "memory":
+		return blocklist.NewMemoryBlocklist(), nil
+	case "postgres":
+		return blocklist.NewPgBlocklist(dsn)
+	case "etcd":
+		return blocklist.NewEtcdBlocklist(blocklist.NewEtcd(strings.Split(endpoints, ",")))
+	case "consul":
+		return blocklist.NewConsulBlocklist(blocklist.NewConsul(address))
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+// parseMix parses a mix like "contains=950,block=25,unblock=20,getlogs=5".
+// Operations left out have no weight.
+func parseMix(s string) (blocklist.SoakMix, error) {
+	var m blocklist.SoakMix
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return m, fmt.Errorf("invalid mix %q", s)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return m, fmt.Errorf("invalid weight of %v: %w", kv[0], err)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "contains":
+			m.Contains = w
+		case "block":
+			m.Block = w
+		case "unblock":
+			m.Unblock = w
+		case "getlogs":
+			m.GetLogs = w
+		default:
+			return m, fmt.Errorf("unknown operation %q in mix", kv[0])
+		}
+	}
+	return m, nil
+}
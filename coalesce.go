@@ -0,0 +1,133 @@
+package blocklist
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// CoalescedBlocklist collapses concurrent Contains lookups of the same CID
+// into one call to the wrapped blocklist, so that a thundering herd for a
+// newly viral CID doesn't reach the backend as hundreds of identical queries.
+// Lookups that start after the shared call returned make a new one: results
+// aren't cached.
+type CoalescedBlocklist struct {
+	Blocklist
+	// coalesced is first to keep it 64-bit aligned for atomic operations.
+	coalesced uint64
+
+	mu      sync.Mutex
+	flights map[cid.Cid]*flight
+}
+
+// flight is a Contains call shared by concurrent lookups.
+type flight struct {
+	done    chan struct{}
+	exists  bool
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCoalesced wraps `b` so that concurrent lookups of the same CID share one
+// call.
+func NewCoalesced(b Blocklist) *CoalescedBlocklist {
+	return &CoalescedBlocklist{Blocklist: b, flights: make(map[cid.Cid]*flight)}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *CoalescedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// Coalesced returns the number of lookups that shared the call of another.
+func (b *CoalescedBlocklist) Coalesced() uint64 {
+	return atomic.LoadUint64(&b.coalesced)
+}
+
+// Forget makes later lookups of `ids`, as any version of the CIDs, start a new
+// call instead of sharing the one in flight, which may have started before a
+// change to `ids`. Lookups already waiting for it still get its result.
+func (b *CoalescedBlocklist) Forget(ids ...cid.Cid) {
+	forget := make(map[cid.Cid]bool, len(ids))
+	for _, id := range ids {
+		forget[cacheKey(id)] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id := range b.flights {
+		if forget[cacheKey(id)] {
+			delete(b.flights, id)
+		}
+	}
+}
+
+// forgetAll is Forget for every CID.
+func (b *CoalescedBlocklist) forgetAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flights = make(map[cid.Cid]*flight)
+}
+
+// Contains returns true if the blocklist contains the content referenced by
+// `id`, sharing the call of a concurrent lookup of `id` if there is one.
+//
+// The shared call runs with the values of the context of the lookup that
+// started it, but isn't canceled with it: a lookup that times out returns
+// its context's error, and the call is only canceled once every lookup
+// waiting for it has returned.
+func (b *CoalescedBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	b.mu.Lock()
+	f, ok := b.flights[id]
+	if ok {
+		atomic.AddUint64(&b.coalesced, 1)
+	} else {
+		callCtx, cancel := context.WithCancel(detachedContext{ctx})
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		b.flights[id] = f
+		go func() {
+			f.exists, f.err = b.Blocklist.Contains(callCtx, id)
+			b.mu.Lock()
+			if b.flights[id] == f {
+				delete(b.flights, id)
+			}
+			b.mu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	b.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.exists, f.err
+	case <-ctx.Done():
+		b.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// Later lookups make a new call rather than join a canceled one.
+			if b.flights[id] == f {
+				delete(b.flights, id)
+			}
+			f.cancel()
+		}
+		b.mu.Unlock()
+		return false, ctx.Err()
+	}
+}
+
+// detachedContext has the values of its parent, but is never done.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,97 @@
+package blocklist
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec serializes the values a DatastoreBlocklist stores. Values are
+// prefixed with the Format byte of the codec that wrote them, so that values
+// written with any registered codec can be read back, whichever codec is
+// currently selected.
+type Codec interface {
+	Format() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Formats of the built-in codecs. JSON values aren't prefixed, as they always
+// start with '{', which keeps values written before codecs existed readable.
+const (
+	FormatJSON byte = '{'
+	FormatGob  byte = 'g'
+)
+
+var (
+	// JSONCodec is the default codec, for values that are easy to inspect.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec is a more compact binary codec.
+	GobCodec Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Format() byte                               { return FormatJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Format() byte { return FormatGob }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[byte]Codec
+}{m: map[byte]Codec{FormatJSON: JSONCodec, FormatGob: GobCodec}}
+
+// RegisterCodec makes values written with `c`, like CBOR or protobuf, readable
+// by every DatastoreBlocklist. It fails if the Format of `c` is taken.
+func RegisterCodec(c Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if _, ok := codecs.m[c.Format()]; ok {
+		return fmt.Errorf("codec format %q is already registered", c.Format())
+	}
+	codecs.m[c.Format()] = c
+	return nil
+}
+
+// encode serializes `v` with `c`, prefixed with its Format.
+func encode(c Codec, v interface{}) ([]byte, error) {
+	raw, err := c.Marshal(v)
+	if err != nil || c.Format() == FormatJSON {
+		return raw, err
+	}
+	return append([]byte{c.Format()}, raw...), nil
+}
+
+// decode deserializes `data` into `v`, with the codec its prefix refers to.
+func decode(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty value")
+	}
+	codecs.RLock()
+	c, ok := codecs.m[data[0]]
+	codecs.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown codec format %q", data[0])
+	} else if c.Format() != FormatJSON {
+		data = data[1:]
+	}
+	return c.Unmarshal(data, v)
+}
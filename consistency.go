@@ -0,0 +1,118 @@
+package blocklist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ReadYourWritesBlocklist makes Contains reflect the Block and Unblock calls
+// made through it, even if the backend only becomes consistent later, like a
+// replica catching up or a cache expiring. Writes are remembered for a fixed
+// window, which should be longer than the backend takes to catch up. Like
+// PgBlocklist and DatastoreBlocklist, writes apply to every version and codec
+// of their CIDs.
+type ReadYourWritesBlocklist struct {
+	Blocklist
+	window time.Duration
+
+	mu     sync.Mutex
+	writes map[cid.Cid]write // writes are keyed by cacheKey.
+}
+
+// write is a recent change of whether content is blocked.
+type write struct {
+	blocked bool
+	at      time.Time
+}
+
+func NewReadYourWrites(b Blocklist, window time.Duration) *ReadYourWritesBlocklist {
+	return &ReadYourWritesBlocklist{Blocklist: b, window: window, writes: make(map[cid.Cid]write)}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *ReadYourWritesBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// record remembers that `ids` were blocked or unblocked, and forgets writes
+// older than the window.
+func (b *ReadYourWritesBlocklist) record(blocked bool, ids ...cid.Cid) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for id, w := range b.writes {
+		if now.Sub(w.at) > b.window {
+			delete(b.writes, id)
+		}
+	}
+	for _, id := range ids {
+		b.writes[cacheKey(id)] = write{blocked, now}
+	}
+}
+
+// recent returns whether `id` was blocked or unblocked within the window.
+func (b *ReadYourWritesBlocklist) recent(id cid.Cid) (blocked, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, ok := b.writes[cacheKey(id)]
+	if !ok || time.Since(w.at) > b.window {
+		return false, false
+	}
+	return w.blocked, true
+}
+
+func (b *ReadYourWritesBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	existing, err := b.Blocklist.Block(ctx, id, data)
+	if err == nil {
+		b.record(true, append([]cid.Cid{id}, data.Digests...)...)
+	}
+	return existing, err
+}
+
+func (b *ReadYourWritesBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error) {
+	blocked, err := b.Blocklist.BlockMany(ctx, ids, data)
+	if err == nil {
+		// Ids that weren't blocked now were already.
+		b.record(true, ids...)
+	}
+	return blocked, err
+}
+
+func (b *ReadYourWritesBlocklist) Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	removed, err := b.Blocklist.Unblock(ctx, id)
+	if err == nil {
+		b.record(false, id)
+	}
+	return removed, err
+}
+
+func (b *ReadYourWritesBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res, err := b.Blocklist.UnblockMany(ctx, ids)
+	if err == nil {
+		b.record(false, ids...)
+	}
+	return res, err
+}
+
+func (b *ReadYourWritesBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	if blocked, ok := b.recent(id); ok {
+		return blocked, nil
+	}
+	return b.Blocklist.Contains(ctx, id)
+}
+
+func (b *ReadYourWritesBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res, err := b.Blocklist.ContainsMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if blocked, ok := b.recent(id); ok {
+			res[id] = blocked
+		}
+	}
+	return res, nil
+}
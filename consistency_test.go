@@ -0,0 +1,34 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// laggingBlocklist never sees writes, like a replica that didn't catch up.
+type laggingBlocklist struct {
+	*MemoryBlocklist
+}
+
+func (laggingBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	return false, nil
+}
+
+func TestReadYourWritesAcrossCIDVersions(t *testing.T) {
+	ctx := context.Background()
+	b := NewReadYourWrites(laggingBlocklist{NewMemoryBlocklist()}, time.Minute)
+	v0 := cid.NewCidV0(testCID(t, "a").Hash())
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+
+	if _, err := b.Block(ctx, v0, BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []cid.Cid{v0, v1} {
+		if exists, err := b.Contains(ctx, id); err != nil || !exists {
+			t.Errorf("%v: got %v, %v, want true", id, exists, err)
+		}
+	}
+}
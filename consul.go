@@ -0,0 +1,516 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// DefaultConsulTimeout is how long requests to Consul may take, except
+// blocking queries.
+const DefaultConsulTimeout = 5 * time.Second
+
+// consulWait is how long a blocking query waits for changes before Consul
+// answers with the same data.
+const consulWait = 5 * time.Minute
+
+// consulTxnOps is the maximum number of operations of one transaction.
+const consulTxnOps = 64
+
+// ConsulOption configures a Consul.
+type ConsulOption func(*consulOptions)
+
+type consulOptions struct {
+	client     *http.Client
+	timeout    time.Duration
+	token      string
+	datacenter string
+	consistent bool
+}
+
+// WithConsulHTTPClient sends requests with `c` instead of http.DefaultClient.
+// The client shouldn't have a Timeout shorter than five minutes, as it would
+// end blocking queries.
+func WithConsulHTTPClient(c *http.Client) ConsulOption {
+	return func(o *consulOptions) {
+		o.client = c
+	}
+}
+
+// WithConsulTimeout sets how long requests may take, instead of
+// DefaultConsulTimeout.
+func WithConsulTimeout(d time.Duration) ConsulOption {
+	return func(o *consulOptions) {
+		o.timeout = d
+	}
+}
+
+// WithConsulToken authenticates requests with the ACL token `token`.
+func WithConsulToken(token string) ConsulOption {
+	return func(o *consulOptions) {
+		o.token = token
+	}
+}
+
+// WithConsulDatacenter sends requests to the datacenter `dc` instead of the
+// one of the agent.
+func WithConsulDatacenter(dc string) ConsulOption {
+	return func(o *consulOptions) {
+		o.datacenter = dc
+	}
+}
+
+// WithConsulConsistent makes reads consistent: the leader confirms it still
+// is the leader before answering, at the cost of a round trip to a quorum of
+// servers. By default, a leader that was just replaced may answer with stale
+// data.
+func WithConsulConsistent() ConsulOption {
+	return func(o *consulOptions) {
+		o.consistent = true
+	}
+}
+
+// Consul is a datastore stored in Consul KV, through the HTTP API of an
+// agent. Keys are stored without their leading "/".
+//
+// Batches are written in transactions of up to 64 operations, the limit of
+// Consul, so larger batches aren't atomic. Consul doesn't page listings, so
+// Query reads all the keys under its prefix in one request.
+type Consul struct {
+	client     *http.Client
+	base       string
+	timeout    time.Duration
+	token      string
+	datacenter string
+	consistent bool
+}
+
+var _ ds.Batching = (*Consul)(nil)
+
+// NewConsul returns a datastore stored in the KV store of the Consul cluster
+// of the agent at `address`, like "http://127.0.0.1:8500".
+func NewConsul(address string, opts ...ConsulOption) *Consul {
+	o := &consulOptions{
+		client:  http.DefaultClient,
+		timeout: DefaultConsulTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Consul{
+		client:     o.client,
+		base:       strings.TrimSuffix(address, "/") + "/v1",
+		timeout:    o.timeout,
+		token:      o.token,
+		datacenter: o.datacenter,
+		consistent: o.consistent,
+	}
+}
+
+// consulError is an error response of the API.
+type consulError struct {
+	Method   string
+	Endpoint string
+	Status   int // Status is the HTTP status code.
+	Message  string
+}
+
+func (e *consulError) Error() string {
+	return fmt.Sprintf("consul: %v %v: %v", e.Method, e.Endpoint, e.Message)
+}
+
+// consulKey returns the name of `k` in Consul.
+func consulKey(k ds.Key) string {
+	return strings.TrimPrefix(k.String(), "/")
+}
+
+// consulPrefix returns the prefix of the names of the keys under `k`.
+func consulPrefix(k ds.Key) string {
+	if k.String() == "/" {
+		return ""
+	}
+	return consulKey(k) + "/"
+}
+
+// do sends a request to `endpoint` with the query parameters `params`, and
+// returns the response. Reads are made consistent if WithConsulConsistent is
+// set. Responses for missing keys are returned too, as they have an index.
+// Other error responses are returned as a *consulError, wrapped in
+// ErrBackendUnavailable if they are worth retrying later, as are network
+// failures.
+func (c *Consul) do(ctx context.Context, method, endpoint string, params url.Values, body io.Reader) (*http.Response, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	if c.datacenter != "" {
+		params.Set("dc", c.datacenter)
+	}
+	if c.consistent && method == http.MethodGet {
+		params.Set("consistent", "")
+	}
+	u := c.base + endpoint
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound && strings.HasPrefix(endpoint, "/kv/") {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	raw, _ := ioutil.ReadAll(resp.Body)
+	apiErr := &consulError{Method: method, Endpoint: endpoint, Status: resp.StatusCode, Message: resp.Status}
+	if msg := strings.TrimSpace(string(raw)); msg != "" {
+		apiErr.Message = msg
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		// Like "No cluster leader".
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return nil, apiErr
+}
+
+// read sends a request with the timeout of `c`, and returns the body of the
+// response. Missing keys are reported as ds.ErrNotFound.
+func (c *Consul) read(method, endpoint string, params url.Values, body io.Reader) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	resp, err := c.do(ctx, method, endpoint, params, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ds.ErrNotFound
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	return raw, nil
+}
+
+// kvEndpoint returns the endpoint of the key or prefix `name`.
+func kvEndpoint(name string) string {
+	return "/kv/" + (&url.URL{Path: name}).EscapedPath()
+}
+
+func (c *Consul) Get(k ds.Key) ([]byte, error) {
+	return c.read(http.MethodGet, kvEndpoint(consulKey(k)), url.Values{"raw": {""}}, nil)
+}
+
+func (c *Consul) Has(k ds.Key) (bool, error) {
+	_, err := c.Get(k)
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Consul) GetSize(k ds.Key) (int, error) {
+	v, err := c.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (c *Consul) Put(k ds.Key, value []byte) error {
+	_, err := c.read(http.MethodPut, kvEndpoint(consulKey(k)), nil, bytes.NewReader(value))
+	return err
+}
+
+func (c *Consul) Delete(k ds.Key) error {
+	_, err := c.read(http.MethodDelete, kvEndpoint(consulKey(k)), nil, nil)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// consulKV is a key and its value, as listed by the API.
+type consulKV struct {
+	Key         string
+	Value       []byte // Value is encoded in base64, like encoding/json does.
+	ModifyIndex uint64
+}
+
+// Query reads the keys under the prefix of `q`, and their values unless
+// q.KeysOnly is set, in one request. Ordering by anything else than
+// ascending key loads all results in memory.
+func (c *Consul) Query(q dsq.Query) (dsq.Results, error) {
+	prefix := consulPrefix(ds.NewKey(q.Prefix))
+	params := url.Values{"recurse": {""}}
+	if q.KeysOnly {
+		params = url.Values{"keys": {""}}
+	}
+	raw, err := c.read(http.MethodGet, kvEndpoint(prefix), params, nil)
+	if errors.Is(err, ds.ErrNotFound) {
+		raw, err = []byte("[]"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []dsq.Entry
+	if q.KeysOnly {
+		var keys []string
+		if err := json.Unmarshal(raw, &keys); err != nil {
+			return nil, fmt.Errorf("consul: listing keys: %w", err)
+		}
+		for _, k := range keys {
+			entries = append(entries, dsq.Entry{Key: "/" + k, Size: -1})
+		}
+	} else {
+		var kvs []consulKV
+		if err := json.Unmarshal(raw, &kvs); err != nil {
+			return nil, fmt.Errorf("consul: listing keys: %w", err)
+		}
+		for _, kv := range kvs {
+			entries = append(entries, dsq.Entry{Key: "/" + kv.Key, Value: kv.Value, Size: len(kv.Value)})
+		}
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are listed in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsWithEntries(q, entries)), nil
+}
+
+// Sync does nothing: writes are durable once Consul acknowledges them.
+func (c *Consul) Sync(prefix ds.Key) error {
+	return nil
+}
+
+func (c *Consul) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *Consul) Batch() (ds.Batch, error) {
+	return &consulBatch{consul: c, ops: make(map[ds.Key]consulOp)}, nil
+}
+
+// consulOp is an operation of a transaction.
+type consulOp struct {
+	KV struct {
+		Verb  string
+		Key   string
+		Value []byte `json:",omitempty"`
+	}
+}
+
+// consulBatch buffers writes until Commit sends them in transactions.
+type consulBatch struct {
+	consul *Consul
+	ops    map[ds.Key]consulOp
+}
+
+func (b *consulBatch) Put(k ds.Key, value []byte) error {
+	var op consulOp
+	op.KV.Verb, op.KV.Key, op.KV.Value = "set", consulKey(k), value
+	b.ops[k] = op
+	return nil
+}
+
+func (b *consulBatch) Delete(k ds.Key) error {
+	var op consulOp
+	op.KV.Verb, op.KV.Key = "delete", consulKey(k)
+	b.ops[k] = op
+	return nil
+}
+
+// Commit writes the batch in transactions of up to consulTxnOps operations.
+// If one fails, the transactions committed before it stay applied.
+func (b *consulBatch) Commit() error {
+	ops := make([]consulOp, 0, len(b.ops))
+	for _, op := range b.ops {
+		ops = append(ops, op)
+	}
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > consulTxnOps {
+			n = consulTxnOps
+		}
+		raw, err := json.Marshal(ops[:n])
+		if err != nil {
+			return err
+		}
+		if _, err := b.consul.read(http.MethodPut, "/txn", nil, bytes.NewReader(raw)); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// watchKeys calls `fn` with the keys under `prefix` and the index of the
+// listing, once right away and then every time they change, until `ctx` is
+// done or `fn` returns an error. It waits for changes with blocking queries.
+func (c *Consul) watchKeys(ctx context.Context, prefix string, fn func(keys []string, index uint64) error) error {
+	var index uint64
+	for {
+		params := url.Values{"keys": {""}}
+		if index > 0 {
+			params.Set("index", strconv.FormatUint(index, 10))
+			params.Set("wait", consulWait.String())
+		}
+		resp, err := c.do(ctx, http.MethodGet, kvEndpoint(prefix), params, nil)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if resp.StatusCode != http.StatusNotFound {
+			// Missing prefixes have no keys, but an index to wait on.
+			err = json.NewDecoder(resp.Body).Decode(&keys)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%w: consul: watching %v: %v", ErrBackendUnavailable, prefix, err)
+		}
+		if next == index {
+			// The wait elapsed without changes.
+			continue
+		}
+		if err := fn(keys, next); err != nil {
+			return err
+		}
+		if next < index {
+			// The index went backwards, after a restore of a snapshot.
+			next = 0
+		}
+		index = next
+	}
+}
+
+// ConsulBlocklist is a DatastoreBlocklist stored in Consul KV, for
+// deployments that already run Consul. Watch propagates changes to gateways
+// with blocking queries, without polling.
+//
+// Only one process should write to the blocklist: audit actions are numbered
+// by the writer.
+type ConsulBlocklist struct {
+	DatastoreBlocklist
+	consul  *Consul
+	entries ds.Key
+	digests ds.Key
+}
+
+func NewConsulBlocklist(c *Consul, opts ...DatastoreOption) (*ConsulBlocklist, error) {
+	o, err := newDatastoreOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewDatastoreBlocklist(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulBlocklist{b, c, o.root.Child(o.blocklist), o.root.Child(o.digest)}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *ConsulBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// ConsulEvent is a change of the blocklist, seen by ConsulBlocklist.Watch.
+type ConsulEvent struct {
+	// Id is the CIDv1-raw of the multihash an entry or one of its digests is
+	// stored under, that was blocked or unblocked.
+	Id      cid.Cid
+	Blocked bool
+	Index   uint64 // Index is the Consul index of the listing that had the change.
+}
+
+// Watch calls `fn` with every CID blocked, then with the CIDs blocked or
+// unblocked later, until `ctx` is done or `fn` returns an error. Updates of
+// entries aren't reported. Entries and digests are watched concurrently, but
+// `fn` is called by one goroutine at a time.
+//
+// Consul answers a blocking query with the whole listing, so Watch reads the
+// keys of every entry at each change. After an error, calling Watch again
+// reports every CID blocked again.
+func (b *ConsulBlocklist) Watch(ctx context.Context, fn func(ConsulEvent) error) (err error) {
+	defer wrapError(&err, "consul", "watch", cid.Undef)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	errs := make(chan error, 2)
+	for _, prefix := range []ds.Key{b.entries, b.digests} {
+		go func(prefix ds.Key) {
+			known := make(map[string]bool)
+			errs <- b.consul.watchKeys(ctx, consulPrefix(prefix), func(keys []string, index uint64) error {
+				mu.Lock()
+				defer mu.Unlock()
+				listed := make(map[string]bool, len(keys))
+				for _, k := range keys {
+					listed[k] = true
+					if !known[k] {
+						if err := b.notify(fn, k, true, index); err != nil {
+							return err
+						}
+					}
+				}
+				for k := range known {
+					if !listed[k] {
+						if err := b.notify(fn, k, false, index); err != nil {
+							return err
+						}
+					}
+				}
+				known = listed
+				return nil
+			})
+		}(prefix)
+	}
+
+	err = <-errs
+	cancel()
+	<-errs
+	return err
+}
+
+// notify calls `fn` with the event of the key `name`.
+func (b *ConsulBlocklist) notify(fn func(ConsulEvent) error, name string, blocked bool, index uint64) error {
+	k := ds.NewKey(name)
+	id, err := keyToCid(ds.NewKey(k.BaseNamespace()))
+	if err != nil {
+		log.Warnf("skipping key %v of unknown format in consul", k)
+		return nil
+	}
+	return fn(ConsulEvent{Id: id, Blocked: blocked, Index: index})
+}
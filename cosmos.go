@@ -0,0 +1,426 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// Defaults of the Cosmos DB client.
+const (
+	DefaultCosmosTimeout   = 10 * time.Second
+	DefaultCosmosPartition = "blocklist"
+)
+
+// cosmosVersion is the version of the REST API requests are made with.
+const cosmosVersion = "2018-12-31"
+
+// cosmosPage is the number of documents Query reads per request.
+const cosmosPage = 1000
+
+// CosmosOption configures a Cosmos.
+type CosmosOption func(*cosmosOptions)
+
+type cosmosOptions struct {
+	client    *http.Client
+	partition string
+}
+
+// WithCosmosHTTPClient sends requests with `c` instead of a client with a
+// DefaultCosmosTimeout timeout.
+func WithCosmosHTTPClient(c *http.Client) CosmosOption {
+	return func(o *cosmosOptions) {
+		o.client = c
+	}
+}
+
+// WithCosmosPartition stores documents in the logical partition `name`
+// instead of DefaultCosmosPartition, so that several datastores can share a
+// container.
+func WithCosmosPartition(name string) CosmosOption {
+	return func(o *cosmosOptions) {
+		o.partition = name
+	}
+}
+
+// Cosmos is a datastore stored in a container of an Azure Cosmos DB account
+// with the API for NoSQL, through the REST API. Each key is a document, with
+// the base64url encoding of the key as its id, the key in the "dsKey"
+// property and the value in the "dsValue" property.
+//
+// The partition key path of the container must be "/pk". Every document of
+// the datastore is in one logical partition, so that Query lists them in key
+// order within the partition. Cosmos DB limits a logical partition to 20 GB,
+// so the datastore, blocklist and audit log included, can't grow beyond
+// 20 GB: writes past it fail. Reads carry the session token of the last
+// response, so that they see the writes of the datastore with the default
+// Session consistency.
+//
+// Batches are written one document at a time, and aren't atomic.
+type Cosmos struct {
+	client    *http.Client
+	endpoint  string
+	coll      string
+	key       []byte
+	partition string
+
+	mu      sync.Mutex
+	session string
+}
+
+var _ ds.Batching = (*Cosmos)(nil)
+
+// NewCosmos returns a datastore stored in the container `container` of the
+// database `database` of the account at `endpoint`, like
+// "https://account.documents.azure.com". `key` is a primary or secondary key
+// of the account, in base64, as the Azure portal shows it.
+func NewCosmos(endpoint, database, container, key string, opts ...CosmosOption) (*Cosmos, error) {
+	o := &cosmosOptions{
+		client:    &http.Client{Timeout: DefaultCosmosTimeout},
+		partition: DefaultCosmosPartition,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("cosmos: invalid key: %w", err)
+	}
+	return &Cosmos{
+		client:    o.client,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		coll:      fmt.Sprintf("dbs/%v/colls/%v", database, container),
+		key:       k,
+		partition: o.partition,
+	}, nil
+}
+
+// cosmosError is an error response of the API.
+type cosmosError struct {
+	Method   string
+	Resource string
+	Status   int    // Status is the HTTP status code.
+	Code     string // Code is the error code, like "Conflict".
+	Message  string
+}
+
+func (e *cosmosError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("cosmos: %v %v: %v (%v)", e.Method, e.Resource, e.Message, e.Code)
+	}
+	return fmt.Sprintf("cosmos: %v %v: %v", e.Method, e.Resource, e.Message)
+}
+
+// sign returns the authorization of a request to the resource `link` of
+// the type `typ` at `date`, signed with the key of the account.
+func (c *Cosmos) sign(method, typ, link, date string) string {
+	mac := hmac.New(sha256.New, c.key)
+	fmt.Fprintf(mac, "%v\n%v\n%v\n%v\n\n", strings.ToLower(method), typ, link, strings.ToLower(date))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return url.QueryEscape("type=master&ver=1.0&sig=" + sig)
+}
+
+// do sends a request to the documents of the container, or to the document
+// `id` if it isn't empty, with the extra headers `header`, and returns the
+// body and headers of a successful response. Missing documents are reported
+// as ds.ErrNotFound. Other error responses are returned as a *cosmosError,
+// wrapped in ErrBackendUnavailable if they are worth retrying later, as are
+// network failures.
+func (c *Cosmos) do(method, id string, header http.Header, body []byte) ([]byte, http.Header, error) {
+	link, path := c.coll, c.coll+"/docs"
+	if id != "" {
+		link = path + "/" + id
+		path = link
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, c.endpoint+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	partition, _ := json.Marshal([]string{c.partition})
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", cosmosVersion)
+	req.Header.Set("x-ms-documentdb-partitionkey", string(partition))
+	req.Header.Set("Authorization", c.sign(method, "docs", link, date))
+	c.mu.Lock()
+	if c.session != "" {
+		req.Header.Set("x-ms-session-token", c.session)
+	}
+	c.mu.Unlock()
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if session := resp.Header.Get("x-ms-session-token"); session != "" {
+		c.mu.Lock()
+		c.session = session
+		c.mu.Unlock()
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return raw, resp.Header, nil
+	} else if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, ds.ErrNotFound
+	}
+
+	apiErr := &cosmosError{Method: method, Resource: link, Status: resp.StatusCode, Message: resp.Status}
+	var res struct {
+		Code    string
+		Message string
+	}
+	if json.Unmarshal(raw, &res) == nil && res.Message != "" {
+		apiErr.Code, apiErr.Message = res.Code, res.Message
+	}
+	// 449 is "Retry With", when concurrent writes conflict.
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == 449 || resp.StatusCode >= 500 {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return nil, nil, apiErr
+}
+
+// cosmosID returns the id of the document of `k`.
+func cosmosID(k ds.Key) string {
+	return base64.RawURLEncoding.EncodeToString(k.Bytes())
+}
+
+// cosmosDoc is a document of the container.
+type cosmosDoc struct {
+	ID        string `json:"id,omitempty"`
+	Partition string `json:"pk,omitempty"`
+	Key       string `json:"dsKey"`
+	Value     []byte `json:"dsValue,omitempty"` // Value is encoded in base64, like encoding/json does.
+}
+
+func (c *Cosmos) Get(k ds.Key) ([]byte, error) {
+	raw, _, err := c.do(http.MethodGet, cosmosID(k), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc cosmosDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("cosmos: reading %v: %w", k, err)
+	}
+	if doc.Value == nil {
+		doc.Value = []byte{}
+	}
+	return doc.Value, nil
+}
+
+func (c *Cosmos) Has(k ds.Key) (bool, error) {
+	_, err := c.Get(k)
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Cosmos) GetSize(k ds.Key) (int, error) {
+	v, err := c.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+// Put upserts the document of `k`.
+func (c *Cosmos) Put(k ds.Key, value []byte) error {
+	raw, err := json.Marshal(cosmosDoc{cosmosID(k), c.partition, k.String(), value})
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"Content-Type":              {"application/json"},
+		"X-Ms-Documentdb-Is-Upsert": {"True"},
+	}
+	_, _, err = c.do(http.MethodPost, "", header, raw)
+	return err
+}
+
+func (c *Cosmos) Delete(k ds.Key) error {
+	_, _, err := c.do(http.MethodDelete, cosmosID(k), nil, nil)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// query returns a page of the documents whose keys are in the range from
+// `lo` to `hi` excluded, from the continuation token `cont` if it isn't
+// empty, and the token of the next page, or "" if it is the last one.
+func (c *Cosmos) query(lo, hi, cont string, keysOnly bool) ([]cosmosDoc, string, error) {
+	fields := "c.dsKey, c.dsValue"
+	if keysOnly {
+		fields = "c.dsKey"
+	}
+	raw, err := json.Marshal(map[string]interface{}{
+		"query": fmt.Sprintf("SELECT %v FROM c WHERE c.dsKey >= @lo AND c.dsKey < @hi ORDER BY c.dsKey", fields),
+		"parameters": []interface{}{
+			map[string]string{"name": "@lo", "value": lo},
+			map[string]string{"name": "@hi", "value": hi},
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	header := http.Header{
+		"Content-Type":                               {"application/query+json"},
+		"X-Ms-Documentdb-Isquery":                    {"True"},
+		"X-Ms-Max-Item-Count":                        {strconv.Itoa(cosmosPage)},
+		"X-Ms-Documentdb-Query-Enablecrosspartition": {"False"},
+	}
+	if cont != "" {
+		header.Set("x-ms-continuation", cont)
+	}
+	raw, resHeader, err := c.do(http.MethodPost, "", header, raw)
+	if err != nil {
+		return nil, "", err
+	}
+	var res struct {
+		Documents []cosmosDoc
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, "", fmt.Errorf("cosmos: listing keys: %w", err)
+	}
+	return res.Documents, resHeader.Get("x-ms-continuation"), nil
+}
+
+// Query lists the documents under the prefix of `q` a page at a time, in
+// ascending key order. Ordering by anything else than ascending key loads
+// all results in memory.
+func (c *Cosmos) Query(q dsq.Query) (dsq.Results, error) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	var (
+		docs    []cosmosDoc
+		cont    string
+		started bool
+	)
+	next := func() (dsq.Result, bool) {
+		for len(docs) == 0 {
+			if started && cont == "" {
+				return dsq.Result{}, false
+			}
+			page, next, err := c.query(lo, hi, cont, q.KeysOnly)
+			if err != nil {
+				started, cont = true, ""
+				return dsq.Result{Error: err}, true
+			}
+			docs, cont, started = page, next, true
+		}
+		doc := docs[0]
+		docs = docs[1:]
+		if q.KeysOnly {
+			return dsq.Result{Entry: dsq.Entry{Key: doc.Key, Size: -1}}, true
+		}
+		if doc.Value == nil {
+			doc.Value = []byte{}
+		}
+		return dsq.Result{Entry: dsq.Entry{Key: doc.Key, Value: doc.Value, Size: len(doc.Value)}}, true
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are listed in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})), nil
+}
+
+// Sync does nothing: writes are durable once Cosmos DB acknowledges them.
+func (c *Cosmos) Sync(prefix ds.Key) error {
+	return nil
+}
+
+func (c *Cosmos) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *Cosmos) Batch() (ds.Batch, error) {
+	return &cosmosBatch{cosmos: c, puts: make(map[ds.Key][]byte), deletes: make(map[ds.Key]bool)}, nil
+}
+
+// cosmosBatch buffers writes until Commit sends them.
+type cosmosBatch struct {
+	cosmos  *Cosmos
+	puts    map[ds.Key][]byte
+	deletes map[ds.Key]bool
+}
+
+func (b *cosmosBatch) Put(k ds.Key, value []byte) error {
+	delete(b.deletes, k)
+	b.puts[k] = value
+	return nil
+}
+
+func (b *cosmosBatch) Delete(k ds.Key) error {
+	delete(b.puts, k)
+	b.deletes[k] = true
+	return nil
+}
+
+// Commit writes the documents of the batch one by one. If one fails, the
+// writes sent before it stay applied.
+func (b *cosmosBatch) Commit() error {
+	for k, v := range b.puts {
+		if err := b.cosmos.Put(k, v); err != nil {
+			return err
+		}
+	}
+	for k := range b.deletes {
+		if err := b.cosmos.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CosmosBlocklist is a DatastoreBlocklist stored in Cosmos DB, for gateways
+// deployed on Azure.
+//
+// Only one process should write to the partition: audit actions are
+// numbered by the writer. Other processes read with their own session, and
+// see the writer's changes within the replication lag of the account's
+// consistency level.
+type CosmosBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewCosmosBlocklist(c *Cosmos, opts ...DatastoreOption) (*CosmosBlocklist, error) {
+	b, err := NewDatastoreBlocklist(c, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &CosmosBlocklist{b}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *CosmosBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
@@ -0,0 +1,131 @@
+package blocklist
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCosmosPage is the most documents fakeCosmos returns per query, so
+// that Query follows continuations.
+const fakeCosmosPage = 100
+
+// fakeCosmos serves the document requests of Cosmos from memory, for the
+// container "coll" of the database "db".
+type fakeCosmos struct {
+	t *testing.T
+
+	mu   sync.Mutex
+	docs map[string]cosmosDoc // docs are keyed by id.
+}
+
+func (f *fakeCosmos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "type%3Dmaster") || r.Header.Get("x-ms-date") == "" {
+		http.Error(w, `{"code":"Unauthorized","message":"missing signature"}`, http.StatusUnauthorized)
+		return
+	} else if pk := r.Header.Get("x-ms-documentdb-partitionkey"); pk != `["`+DefaultCosmosPartition+`"]` {
+		f.t.Errorf("request to partition %v", pk)
+	}
+	w.Header().Set("x-ms-session-token", "0:1")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const docs = "/dbs/db/colls/coll/docs"
+	switch {
+	case r.URL.Path == docs && r.Method == http.MethodPost && r.Header.Get("x-ms-documentdb-isquery") == "True":
+		f.query(w, r)
+	case r.URL.Path == docs && r.Method == http.MethodPost:
+		var doc cosmosDoc
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if doc.Partition != DefaultCosmosPartition {
+			f.t.Errorf("document %v in partition %v", doc.ID, doc.Partition)
+		}
+		f.docs[doc.ID] = doc
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(doc)
+	case strings.HasPrefix(r.URL.Path, docs+"/"):
+		id := strings.TrimPrefix(r.URL.Path, docs+"/")
+		doc, ok := f.docs[id]
+		if !ok {
+			http.Error(w, `{"code":"NotFound","message":"Entity with the specified id does not exist in the system."}`, http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(doc)
+		case http.MethodDelete:
+			delete(f.docs, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+		}
+	default:
+		f.t.Errorf("unexpected request %v %v", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// query answers the range queries of Cosmos.query, a page at a time.
+func (f *fakeCosmos) query(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Query      string
+		Parameters []struct{ Name, Value string }
+	}
+	raw, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(raw, &q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := make(map[string]string)
+	for _, p := range q.Parameters {
+		params[p.Name] = p.Value
+	}
+	var matched []cosmosDoc
+	for _, doc := range f.docs {
+		if doc.Key >= params["@lo"] && doc.Key < params["@hi"] {
+			// Only the selected fields are returned.
+			doc = cosmosDoc{Key: doc.Key, Value: doc.Value}
+			if !strings.Contains(q.Query, "c.dsValue") {
+				doc.Value = nil
+			}
+			matched = append(matched, doc)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Key < matched[j].Key })
+
+	start, _ := strconv.Atoi(r.Header.Get("x-ms-continuation"))
+	size, _ := strconv.Atoi(r.Header.Get("x-ms-max-item-count"))
+	if size <= 0 || size > fakeCosmosPage {
+		size = fakeCosmosPage
+	}
+	end := start + size
+	if end < len(matched) {
+		w.Header().Set("x-ms-continuation", strconv.Itoa(end))
+	} else {
+		end = len(matched)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"Documents": matched[start:end]})
+}
+
+func TestCosmosConformance(t *testing.T) {
+	s := httptest.NewServer(&fakeCosmos{t: t, docs: make(map[string]cosmosDoc)})
+	defer s.Close()
+	c, err := NewCosmos(s.URL, "db", "coll", base64.StdEncoding.EncodeToString([]byte("key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCosmosBlocklist(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDatastoreConformance(t, c, b)
+}
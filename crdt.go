@@ -0,0 +1,1124 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsns "github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// CRDTPrefix is the default namespace of the operations of a CRDTBlocklist.
+var CRDTPrefix = ds.NewKey("crdt")
+
+// CRDTPolicy decides between a block and an unblock of the same content made
+// concurrently, by replicas that hadn't seen each other's change.
+type CRDTPolicy int
+
+const (
+	// UnblockWins drops the blocks made concurrently with an unblock, so
+	// that an unblock is never undone by a replica that hadn't seen it yet.
+	UnblockWins CRDTPolicy = iota
+	// BlockWins keeps the blocks made concurrently with an unblock: an
+	// unblock only removes the blocks it had seen.
+	BlockWins
+)
+
+// CRDTOpType is the type of a CRDTOp.
+type CRDTOpType string
+
+const (
+	CRDTAdd     CRDTOpType = "add"     // CRDTAdd blocks content, or updates its entry.
+	CRDTRemove  CRDTOpType = "remove"  // CRDTRemove unblocks content.
+	CRDTComment CRDTOpType = "comment" // CRDTComment comments on an entry.
+	CRDTLog     CRDTOpType = "log"     // CRDTLog adds an action to the audit log.
+)
+
+// CRDTDot identifies an operation: the Seq-th one of a replica.
+type CRDTDot struct {
+	Replica string
+	Seq     uint64
+}
+
+// CRDTOp is a change made at one replica of a CRDTBlocklist. Replicas
+// exchange their operations, and every replica that applied the same
+// operations has the same blocklist, whatever order they arrived in.
+type CRDTOp struct {
+	Replica string
+	Seq     uint64 // Seq numbers the operations of a replica from 1.
+	HLC     HLC
+	Typ     CRDTOpType
+	Hash    string `json:",omitempty"` // Hash is the normalized CID the operation is on.
+
+	Item *BlocklistItem `json:",omitempty"` // Item is the entry a CRDTAdd sets.
+	// Observed are the dots of the removes of the content a CRDTAdd had
+	// seen, or of the adds a CRDTRemove removes.
+	Observed []CRDTDot `json:",omitempty"`
+	// Supersedes are the dots of the adds that a CRDTAdd made by Update
+	// replaces.
+	Supersedes []CRDTDot `json:",omitempty"`
+	Comment    *Comment  `json:",omitempty"`
+	Action     *Action   `json:",omitempty"`
+}
+
+// Dot returns the dot of `op`.
+func (op *CRDTOp) Dot() CRDTDot {
+	return CRDTDot{op.Replica, op.Seq}
+}
+
+// validate returns an error if `op` can't be applied.
+func (op *CRDTOp) validate() error {
+	if op.Replica == "" || op.Seq == 0 {
+		return fmt.Errorf("crdt: operation without replica or seq")
+	}
+	switch op.Typ {
+	case CRDTAdd:
+		if op.Item == nil {
+			return fmt.Errorf("crdt: add %v/%d without entry", op.Replica, op.Seq)
+		}
+	case CRDTRemove:
+	case CRDTComment:
+		if op.Comment == nil {
+			return fmt.Errorf("crdt: comment %v/%d without comment", op.Replica, op.Seq)
+		}
+	case CRDTLog:
+		if op.Action == nil || !op.Action.Typ.Valid() {
+			return fmt.Errorf("crdt: log %v/%d without valid action", op.Replica, op.Seq)
+		}
+		return nil
+	default:
+		return fmt.Errorf("crdt: unknown operation type %q", op.Typ)
+	}
+	if _, err := cid.Decode(op.Hash); err != nil {
+		return fmt.Errorf("crdt: %v %v/%d: %w: %v", op.Typ, op.Replica, op.Seq, ErrInvalidCID, err)
+	}
+	return nil
+}
+
+// crdtElement is the state of one CID: the operations on it, and the entry
+// they add up to.
+type crdtElement struct {
+	adds     []*CRDTOp
+	removes  []*CRDTOp
+	comments []Comment
+
+	item    *BlocklistItem // item is the current entry, or nil if unblocked.
+	alive   []CRDTDot      // alive are the dots of the adds item comes from.
+	history *BlocklistItem // history is the last unblocked entry, if any.
+}
+
+// CRDTOption configures a CRDTBlocklist.
+type CRDTOption func(*crdtOptions)
+
+type crdtOptions struct {
+	policy CRDTPolicy
+	prefix ds.Key
+}
+
+// WithCRDTPolicy decides concurrent blocks and unblocks with `p`, instead of
+// UnblockWins. Every replica has to use the same policy.
+func WithCRDTPolicy(p CRDTPolicy) CRDTOption {
+	return func(o *crdtOptions) {
+		o.policy = p
+	}
+}
+
+// WithCRDTPrefix stores operations under `prefix` instead of CRDTPrefix.
+func WithCRDTPrefix(prefix ds.Key) CRDTOption {
+	return func(o *crdtOptions) {
+		o.prefix = prefix
+	}
+}
+
+// CRDTBlocklist is a blocklist replicated as an observed-remove set, for
+// gateways spread over several regions that each accept blocks locally and
+// converge without a central database. Every change is an operation stored
+// in the local datastore; replicas exchange the operations they miss with
+// SyncCRDT, in any order and as often as they like.
+//
+// Blocks add the content with a unique dot, and unblocks remove the dots they
+// have seen. When a block and an unblock of the same content are concurrent,
+// the CRDTPolicy decides which wins. Updates replace the dots they have seen
+// with a new one; of concurrent updates, the one with the latest HLC wins.
+// Comments and audit actions are only ever added. Content and digests are
+// matched on their multihash, whatever the codec of their CID, like
+// DatastoreBlocklist does.
+//
+// Operations are kept forever, as a replica can't know when every other one
+// has seen a remove, so the whole state is kept in memory. Content isn't
+// purged: purges are local to the datastore of each gateway.
+type CRDTBlocklist struct {
+	store   ds.Batching
+	replica string
+	policy  CRDTPolicy
+	clock   *Clock
+
+	mu       sync.RWMutex
+	stored   uint64                  // stored is the number of operations in the store.
+	version  map[string]uint64       // version is the last Seq applied of each replica.
+	ops      []*CRDTOp               // ops are in the order they were applied.
+	elements map[string]*crdtElement // elements are keyed by crdtKey.
+	digests  map[string]string       // digests maps the keys of digests to the key of their entry.
+	logs     []*Action
+}
+
+// NewCRDTBlocklist returns the replica `replica` of a CRDT blocklist stored in
+// `d`, and loads the operations stored there. `replica` has to be unique
+// among the replicas.
+func NewCRDTBlocklist(d ds.Batching, replica string, opts ...CRDTOption) (*CRDTBlocklist, error) {
+	o := &crdtOptions{policy: UnblockWins, prefix: CRDTPrefix}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if replica == "" {
+		return nil, fmt.Errorf("crdt: replica can't be empty")
+	}
+	b := &CRDTBlocklist{
+		store:    dsns.Wrap(d, o.prefix),
+		replica:  replica,
+		policy:   o.policy,
+		clock:    NewClock(replica),
+		version:  make(map[string]uint64),
+		elements: make(map[string]*crdtElement),
+		digests:  make(map[string]string),
+	}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// load applies the operations of the store, in the order they were applied.
+func (b *CRDTBlocklist) load() (err error) {
+	defer wrapError(&err, "crdt", "load", cid.Undef)
+
+	rr, err := b.store.Query(dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return res.Error
+		}
+		op := &CRDTOp{}
+		if err := json.Unmarshal(res.Value, op); err != nil {
+			return fmt.Errorf("crdt: operation %v: %w", res.Key, err)
+		}
+		if err := b.clock.Observe(&Action{HLC: &op.HLC}); err != nil {
+			return err
+		}
+		b.integrate(op)
+		b.stored++
+	}
+	return nil
+}
+
+// Capabilities returns the optional features of the blocklist. Entries are
+// listed by CID.
+func (b *CRDTBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// Replica returns the name of the replica.
+func (b *CRDTBlocklist) Replica() string {
+	return b.replica
+}
+
+// opKey returns the key of the `i`-th operation stored.
+func opKey(i uint64) ds.Key {
+	return ds.NewKey(fmt.Sprintf("%020d", i))
+}
+
+// commit stores `ops`, in one batch, and applies them. The lock has to be
+// held.
+func (b *CRDTBlocklist) commit(ops []*CRDTOp) error {
+	batch, err := b.store.Batch()
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		raw, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(opKey(b.stored+uint64(i)), raw); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		b.integrate(op)
+		b.stored++
+	}
+	return nil
+}
+
+// local returns a new operation of this replica. `n` is the number of
+// operations made earlier in the same commit. The lock has to be held.
+func (b *CRDTBlocklist) local(typ CRDTOpType, hash string, n int) *CRDTOp {
+	return &CRDTOp{
+		Replica: b.replica,
+		Seq:     b.version[b.replica] + uint64(n) + 1,
+		HLC:     b.clock.Now(),
+		Typ:     typ,
+		Hash:    hash,
+	}
+}
+
+// integrate applies `op` to the state. The lock has to be held.
+func (b *CRDTBlocklist) integrate(op *CRDTOp) {
+	b.ops = append(b.ops, op)
+	b.version[op.Replica] = op.Seq
+	if op.Typ == CRDTLog {
+		act := *op.Action
+		act.Seq = uint64(len(b.logs) + 1)
+		b.logs = append(b.logs, &act)
+		return
+	}
+
+	key := crdtKey(op.Hash)
+	e := b.elements[key]
+	if e == nil {
+		e = &crdtElement{}
+		b.elements[key] = e
+	}
+	switch op.Typ {
+	case CRDTAdd:
+		e.adds = append(e.adds, op)
+	case CRDTRemove:
+		e.removes = append(e.removes, op)
+	case CRDTComment:
+		e.comments = append(e.comments, *op.Comment)
+		sort.SliceStable(e.comments, func(i, j int) bool {
+			return e.comments[i].CreatedAt.Before(e.comments[j].CreatedAt)
+		})
+		return
+	}
+	b.evaluate(key, e)
+}
+
+// evaluate recomputes the entry of the element `key` from its operations.
+// The lock has to be held.
+func (b *CRDTBlocklist) evaluate(key string, e *crdtElement) {
+	removedBy := make(map[CRDTDot]*CRDTOp)
+	for _, r := range e.removes {
+		for _, d := range r.Observed {
+			removedBy[d] = r
+		}
+	}
+	superseded := make(map[CRDTDot]bool)
+	for _, a := range e.adds {
+		for _, d := range a.Supersedes {
+			superseded[d] = true
+		}
+	}
+
+	var alive, unblocked []*CRDTOp
+	for _, a := range e.adds {
+		switch {
+		case superseded[a.Dot()]:
+		case removedBy[a.Dot()] != nil:
+			unblocked = append(unblocked, a)
+		case b.policy == UnblockWins && !observesAll(a, e.removes):
+			unblocked = append(unblocked, a)
+		default:
+			alive = append(alive, a)
+		}
+	}
+
+	// Unblocked entries link to the ones unblocked before them.
+	sort.Slice(unblocked, func(i, j int) bool { return unblocked[i].HLC.Compare(unblocked[j].HLC) < 0 })
+	var history *BlocklistItem
+	for _, a := range unblocked {
+		item := *a.Item
+		item.Supersedes = history
+		at := lastRemove(a, e.removes).Time()
+		item.UnblockedAt = &at
+		history = &item
+	}
+
+	if e.item != nil {
+		for _, d := range e.item.Digests {
+			if h := crdtKey(d); b.digests[h] == key {
+				delete(b.digests, h)
+			}
+		}
+	}
+	e.item, e.alive, e.history = nil, nil, history
+	if len(alive) == 0 {
+		return
+	}
+	latest := alive[0]
+	for _, a := range alive {
+		e.alive = append(e.alive, a.Dot())
+		if a.HLC.Compare(latest.HLC) > 0 {
+			latest = a
+		}
+	}
+	item := *latest.Item
+	item.Supersedes = history
+	e.item = &item
+	for _, d := range item.Digests {
+		b.digests[crdtKey(d)] = key
+	}
+}
+
+// observesAll returns true if the add `a` had seen all of `removes`.
+func observesAll(a *CRDTOp, removes []*CRDTOp) bool {
+	seen := make(map[CRDTDot]bool, len(a.Observed))
+	for _, d := range a.Observed {
+		seen[d] = true
+	}
+	for _, r := range removes {
+		if !seen[r.Dot()] {
+			return false
+		}
+	}
+	return true
+}
+
+// lastRemove returns the HLC of the last of `removes` that removed `a`, or
+// was concurrent with it.
+func lastRemove(a *CRDTOp, removes []*CRDTOp) HLC {
+	seen := make(map[CRDTDot]bool, len(a.Observed))
+	for _, d := range a.Observed {
+		seen[d] = true
+	}
+	last := a.HLC
+	for _, r := range removes {
+		if !seen[r.Dot()] && r.HLC.Compare(last) > 0 {
+			last = r.HLC
+		}
+	}
+	return last
+}
+
+// removeDots returns the dots of the removes of `e`.
+func (e *crdtElement) removeDots() []CRDTDot {
+	dots := make([]CRDTDot, len(e.removes))
+	for i, r := range e.removes {
+		dots[i] = r.Dot()
+	}
+	return dots
+}
+
+// addDots returns the dots of the adds of `e`.
+func (e *crdtElement) addDots() []CRDTDot {
+	dots := make([]CRDTDot, len(e.adds))
+	for i, a := range e.adds {
+		dots[i] = a.Dot()
+	}
+	return dots
+}
+
+// hash returns the normalized form of `id`.
+func (b *CRDTBlocklist) hash(id cid.Cid) (string, error) {
+	id, err := Normalize(id)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// crdtKey returns the key of the element of the CID `hash`, or of the
+// digest `hash`: its multihash, like the keys of DatastoreBlocklist, so that
+// content blocked under one codec matches under the others.
+func crdtKey(hash string) string {
+	id, err := cid.Decode(strings.TrimSpace(hash))
+	if err != nil {
+		return hash
+	}
+	return dshelp.NewKeyFromBinary(id.Hash()).String()
+}
+
+// element returns the element of `hash`, or the element `hash` is a digest
+// of. The read lock has to be held.
+func (b *CRDTBlocklist) element(hash string) *crdtElement {
+	key := crdtKey(hash)
+	if e := b.elements[key]; e != nil && e.item != nil {
+		return e
+	}
+	if parent, ok := b.digests[key]; ok {
+		return b.elements[parent]
+	}
+	return b.elements[key]
+}
+
+// current returns a copy of the current entry of `e` with its comments, or
+// nil if it isn't blocked. The read lock has to be held.
+func (e *crdtElement) current() *BlocklistItem {
+	if e == nil || e.item == nil {
+		return nil
+	}
+	item := *e.item
+	item.Comments = append([]Comment(nil), e.comments...)
+	return &item
+}
+
+// Block adds `id` to the blocklist. If `id` was already blocked, the existing
+// entry is returned and kept as is. Otherwise, the returned entry is nil.
+func (b *CRDTBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "block", id)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
+	}
+	hash, err := b.hash(id)
+	if err != nil {
+		return nil, err
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if item := b.element(hash).current(); item != nil {
+		return item, nil
+	}
+	return nil, b.commit([]*CRDTOp{b.add(hash, data, 0)})
+}
+
+// add returns the operation that blocks `hash` with `data`. The lock has to
+// be held.
+func (b *CRDTBlocklist) add(hash string, data BlockData, n int) *CRDTOp {
+	op := b.local(CRDTAdd, hash, n)
+	now := op.HLC.Time()
+	op.Item = &BlocklistItem{
+		Hash:       hash,
+		Content:    data.Content,
+		Reason:     data.Reason,
+		User:       data.User,
+		Metadata:   data.Metadata,
+		References: data.References,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	for _, d := range data.Digests {
+		op.Item.Digests = append(op.Item.Digests, d.String())
+	}
+	if e := b.elements[crdtKey(hash)]; e != nil {
+		op.Observed = e.removeDots()
+	}
+	return op
+}
+
+// BlockMany blocks all of `ids` with the same metadata in one datastore
+// batch, and returns the ids that weren't already blocked. Digests in `data`
+// are ignored, as they can't belong to more than one piece of content.
+func (b *CRDTBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) (blocked []cid.Cid, err error) {
+	defer wrapError(&err, "crdt", "blockmany", cid.Undef)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
+	}
+	data.Digests = nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ops []*CRDTOp
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := b.hash(id)
+		if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		} else if seen[crdtKey(hash)] || b.element(hash).current() != nil {
+			continue
+		}
+		seen[crdtKey(hash)] = true
+		ops = append(ops, b.add(hash, data, len(ops)))
+		blocked = append(blocked, id)
+	}
+	if err := b.commit(ops); err != nil {
+		return nil, err
+	}
+	return blocked, nil
+}
+
+// remove returns the operation that unblocks the element `hash`, and the
+// entry it unblocks. The lock has to be held.
+func (b *CRDTBlocklist) remove(hash string, e *crdtElement, n int) (*CRDTOp, *BlocklistItem) {
+	op := b.local(CRDTRemove, hash, n)
+	op.Observed = e.addDots()
+	removed := e.current()
+	at := op.HLC.Time()
+	removed.UnblockedAt = &at
+	return op, removed
+}
+
+// Unblock removes `id` from the blocklist, and returns the removed entry.
+func (b *CRDTBlocklist) Unblock(ctx context.Context, id cid.Cid) (removed *BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "unblock", id)
+
+	hash, err := b.hash(id)
+	if err != nil {
+		return nil, err
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.element(hash)
+	if e.current() == nil {
+		return nil, ErrNotFound
+	}
+	op, removed := b.remove(e.item.Hash, e, 0)
+	if err := b.commit([]*CRDTOp{op}); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// UnblockMany unblocks all of `ids` in one datastore batch. The returned map
+// is true for the ids that were blocked and have been removed.
+func (b *CRDTBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "crdt", "unblockmany", cid.Undef)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ops []*CRDTOp
+	res = make(map[cid.Cid]bool, len(ids))
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := b.hash(id)
+		if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		e := b.element(hash)
+		switch {
+		case e.current() == nil:
+			res[id] = false
+		case removed[e.item.Hash]:
+			res[id] = true
+		default:
+			op, _ := b.remove(e.item.Hash, e, len(ops))
+			ops = append(ops, op)
+			res[id], removed[e.item.Hash] = true, true
+		}
+	}
+	if err := b.commit(ops); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Update changes the Reason, Content, Metadata, and References of the entry
+// `id` belongs to to those set in `patch`, and logs an "update" action of
+// `patch.User`. If nothing changes, nothing is logged.
+func (b *CRDTBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) (err error) {
+	defer wrapError(&err, "crdt", "update", id)
+
+	if patch, err = patch.validate(true); err != nil {
+		return err
+	}
+	hash, err := b.hash(id)
+	if err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.element(hash)
+	old := e.current()
+	if old == nil {
+		return ErrNotFound
+	}
+	old.Comments = nil
+	item := old.patch(patch)
+	act := updateAction(id, old, item, patch.User)
+	if len(act.Changes) == 0 {
+		return nil
+	}
+
+	op := b.local(CRDTAdd, old.Hash, 0)
+	item.UpdatedAt = op.HLC.Time()
+	item.Supersedes = nil
+	op.Item = item
+	op.Observed = e.removeDots()
+	op.Supersedes = e.alive
+	logOp := b.local(CRDTLog, "", 1)
+	logOp.Action = act
+	return b.commit([]*CRDTOp{op, logOp})
+}
+
+// Search returns the entry `id` belongs to, with its comments.
+func (b *CRDTBlocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "search", id)
+
+	hash, err := b.hash(id)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if item = b.element(hash).current(); item == nil {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+// SearchMany returns the metadata of all of `ids`. The returned map is nil for
+// the ids that aren't blocked.
+func (b *CRDTBlocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "searchmany", cid.Undef)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	res = make(map[cid.Cid]*BlocklistItem, len(ids))
+	for _, id := range ids {
+		hash, err := b.hash(id)
+		if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		res[id] = b.element(hash).current()
+	}
+	return res, nil
+}
+
+// SearchByContent returns the entries that have `url` as one of their Content
+// entries. It goes over every entry.
+func (b *CRDTBlocklist) SearchByContent(ctx context.Context, url string) (items []*BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "searchbycontent", cid.Undef)
+
+	url = strings.TrimSpace(url)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, e := range b.elements {
+		item := e.current()
+		if item == nil {
+			continue
+		}
+		for _, c := range item.Content {
+			if c == url {
+				items = append(items, item)
+				break
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Hash < items[j].Hash })
+	return items, nil
+}
+
+// SearchHistory returns the last unblocked entry of `id`, which links to the
+// entries unblocked before it through Supersedes. If `id` was never
+// unblocked, ErrNotFound is returned. Digests of unblocked entries aren't
+// matched.
+func (b *CRDTBlocklist) SearchHistory(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "crdt", "searchhistory", id)
+
+	hash, err := b.hash(id)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e := b.elements[crdtKey(hash)]
+	if e == nil || e.history == nil {
+		return nil, ErrNotFound
+	}
+	h := *e.history
+	return &h, nil
+}
+
+// AddComment appends `c` to the comments of the entry `id` belongs to, and
+// sets its CreatedAt.
+func (b *CRDTBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) (err error) {
+	defer wrapError(&err, "crdt", "addcomment", id)
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+	hash, err := b.hash(id)
+	if err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.element(hash)
+	if e.current() == nil {
+		return ErrNotFound
+	}
+	op := b.local(CRDTComment, e.item.Hash, 0)
+	c.CreatedAt = op.HLC.Time()
+	comment := *c
+	op.Comment = &comment
+	return b.commit([]*CRDTOp{op})
+}
+
+// List returns a page of blocklist entries, in the order of their CIDs.
+// Cursors are the CID of the last entry of the previous page.
+func (b *CRDTBlocklist) List(ctx context.Context, opts ListOptions) (page *ListPage, err error) {
+	defer wrapError(&err, "crdt", "list", cid.Undef)
+
+	b.mu.RLock()
+	var items []*BlocklistItem
+	for _, e := range b.elements {
+		if e.item != nil && (opts.Ref == (Reference{}) || hasReference(e.item.References, opts.Ref)) {
+			item := *e.item
+			items = append(items, &item)
+		}
+	}
+	b.mu.RUnlock()
+
+	desc := opts.Order == ListDescending
+	sort.Slice(items, func(i, j int) bool { return (items[i].Hash < items[j].Hash) != desc })
+	start := opts.Offset
+	if opts.Cursor != "" {
+		start = sort.Search(len(items), func(i int) bool {
+			if desc {
+				return items[i].Hash < opts.Cursor
+			}
+			return items[i].Hash > opts.Cursor
+		})
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	page = &ListPage{Items: items[start:]}
+	if limit := opts.limit(); len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.Next = page.Items[limit-1].Hash
+	}
+	return page, nil
+}
+
+// Count returns the number of blocklist entries.
+func (b *CRDTBlocklist) Count(ctx context.Context) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var n int64
+	for _, e := range b.elements {
+		if e.item != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Purge fails, as there is no content to purge.
+func (b *CRDTBlocklist) Purge(ctx context.Context, id cid.Cid) (err error) {
+	defer wrapError(&err, "crdt", "purge", id)
+	return fmt.Errorf("no datastore to purge from")
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+// Actions are numbered in the order this replica received them.
+func (b *CRDTBlocklist) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "crdt", "getlogs", cid.Undef)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := len(b.logs) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(acts) == q.Limit {
+			break
+		}
+		act := b.logs[i]
+		if (q.Before > 0 && act.Seq >= q.Before) || !q.Match(act) {
+			continue
+		}
+		c := *act
+		acts = append(acts, &c)
+	}
+	return acts, nil
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq.
+func (b *CRDTBlocklist) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "crdt", "addlog", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	op := b.local(CRDTLog, "", 0)
+	c := *act
+	op.Action = &c
+	if err := b.commit([]*CRDTOp{op}); err != nil {
+		return err
+	}
+	act.Seq = uint64(len(b.logs))
+	return nil
+}
+
+// Contains returns true if the blocklist contains the content referenced by
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (b *CRDTBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "crdt", "contains", id)
+
+	hash, err := b.hash(id)
+	if err != nil {
+		return false, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.element(hash).current() != nil, nil
+}
+
+// ContainsMany returns whether each of `ids` is blocked.
+func (b *CRDTBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "crdt", "containsmany", cid.Undef)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	res = make(map[cid.Cid]bool, len(ids))
+	for _, id := range ids {
+		hash, err := b.hash(id)
+		if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		e := b.element(hash)
+		res[id] = e != nil && e.item != nil
+	}
+	return res, nil
+}
+
+// Healthy returns an error if the datastore can't be read.
+func (b *CRDTBlocklist) Healthy(ctx context.Context) (err error) {
+	defer wrapError(&err, "crdt", "healthy", cid.Undef)
+	_, err = b.store.Has(opKey(0))
+	return err
+}
+
+// Close does nothing. The datastore isn't closed.
+func (b *CRDTBlocklist) Close(ctx context.Context) error {
+	return nil
+}
+
+// Version returns the Seq of the last operation of each replica applied here.
+func (b *CRDTBlocklist) Version(ctx context.Context) (map[string]uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	v := make(map[string]uint64, len(b.version))
+	for r, seq := range b.version {
+		v[r] = seq
+	}
+	return v, nil
+}
+
+// Delta returns the operations applied here that a replica at version
+// `since` misses, in the order they were applied.
+func (b *CRDTBlocklist) Delta(ctx context.Context, since map[string]uint64) ([]*CRDTOp, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var ops []*CRDTOp
+	for _, op := range b.ops {
+		if op.Seq > since[op.Replica] {
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// Apply applies the operations of other replicas, and returns how many were
+// new. Operations already applied are skipped, as are those that come after
+// a missing operation of their replica, until it is applied.
+func (b *CRDTBlocklist) Apply(ctx context.Context, ops []*CRDTOp) (applied int, err error) {
+	defer wrapError(&err, "crdt", "apply", cid.Undef)
+
+	sorted := append([]*CRDTOp(nil), ops...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Replica != sorted[j].Replica {
+			return sorted[i].Replica < sorted[j].Replica
+		}
+		return sorted[i].Seq < sorted[j].Seq
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	next := make(map[string]uint64)
+	var fresh []*CRDTOp
+	for _, op := range sorted {
+		if err := op.validate(); err != nil {
+			return 0, err
+		} else if op.Replica == b.replica {
+			continue
+		}
+		if _, ok := next[op.Replica]; !ok {
+			next[op.Replica] = b.version[op.Replica] + 1
+		}
+		if op.Seq != next[op.Replica] {
+			continue
+		}
+		if err := b.clock.Observe(&Action{HLC: &op.HLC}); err != nil {
+			return 0, err
+		}
+		next[op.Replica]++
+		fresh = append(fresh, op)
+	}
+	if err := b.commit(fresh); err != nil {
+		return 0, err
+	}
+	return len(fresh), nil
+}
+
+// CRDTPeer is a replica of a CRDT blocklist, local like a CRDTBlocklist or
+// remote like a CRDTRemote.
+type CRDTPeer interface {
+	Version(ctx context.Context) (map[string]uint64, error)
+	Delta(ctx context.Context, since map[string]uint64) ([]*CRDTOp, error)
+	Apply(ctx context.Context, ops []*CRDTOp) (int, error)
+}
+
+// SyncCRDT exchanges the operations `a` and `b` miss, so that they converge
+// to the same blocklist. Running it periodically between every replica and
+// one or two others is enough for all of them to converge.
+func SyncCRDT(ctx context.Context, a, b CRDTPeer) error {
+	for _, p := range [][2]CRDTPeer{{a, b}, {b, a}} {
+		from, to := p[0], p[1]
+		v, err := to.Version(ctx)
+		if err != nil {
+			return err
+		}
+		ops, err := from.Delta(ctx, v)
+		if err != nil {
+			return err
+		} else if len(ops) == 0 {
+			continue
+		}
+		if _, err := to.Apply(ctx, ops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultCRDTTimeout is how long requests to a CRDTRemote may take.
+const DefaultCRDTTimeout = 30 * time.Second
+
+// CRDTHandler serves the replica `b` to the CRDTRemotes of other replicas:
+//
+//	GET  /version  the Version of `b`
+//	POST /delta    the Delta of `b` since the version in the body
+//	POST /apply    applies the operations in the body to `b`
+//
+// It doesn't authenticate requests, which can change the blocklist: serve it
+// on a private network, or behind authentication.
+func CRDTHandler(b *CRDTBlocklist) http.Handler {
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, v interface{}, err error) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		v, err := b.Version(r.Context())
+		reply(w, v, err)
+	})
+	mux.HandleFunc("/delta", func(w http.ResponseWriter, r *http.Request) {
+		var since map[string]uint64
+		if err := json.NewDecoder(r.Body).Decode(&since); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ops, err := b.Delta(r.Context(), since)
+		reply(w, ops, err)
+	})
+	mux.HandleFunc("/apply", func(w http.ResponseWriter, r *http.Request) {
+		var ops []*CRDTOp
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := b.Apply(r.Context(), ops)
+		reply(w, n, err)
+	})
+	return mux
+}
+
+// CRDTRemote is a replica served by CRDTHandler at another gateway.
+type CRDTRemote struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewCRDTRemote returns the replica served by CRDTHandler at `endpoint`. If
+// `c` is nil, requests are sent with a client with a DefaultCRDTTimeout
+// timeout.
+func NewCRDTRemote(endpoint string, c *http.Client) *CRDTRemote {
+	if c == nil {
+		c = &http.Client{Timeout: DefaultCRDTTimeout}
+	}
+	return &CRDTRemote{endpoint: strings.TrimSuffix(endpoint, "/"), client: c}
+}
+
+// do sends `in` as JSON to `path`, and decodes the response into `out`.
+// Network failures and server errors are wrapped in ErrBackendUnavailable.
+func (r *CRDTRemote) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("crdt: %v %v: %v: %v", method, path, resp.Status, strings.TrimSpace(string(raw)))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			err = fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+		}
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (r *CRDTRemote) Version(ctx context.Context) (v map[string]uint64, err error) {
+	err = r.do(ctx, http.MethodGet, "/version", nil, &v)
+	return v, err
+}
+
+func (r *CRDTRemote) Delta(ctx context.Context, since map[string]uint64) (ops []*CRDTOp, err error) {
+	err = r.do(ctx, http.MethodPost, "/delta", since, &ops)
+	return ops, err
+}
+
+func (r *CRDTRemote) Apply(ctx context.Context, ops []*CRDTOp) (n int, err error) {
+	err = r.do(ctx, http.MethodPost, "/apply", ops, &n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestCRDTMatchesEveryCodec(t *testing.T) {
+	ctx := context.Background()
+	a, err := NewCRDTBlocklist(dssync.MutexWrap(ds.NewMapDatastore()), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCRDTBlocklist(dssync.MutexWrap(ds.NewMapDatastore()), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, digest := testCID(t, "content"), testCID(t, "digest")
+	dagpb := cid.NewCidV1(cid.DagProtobuf, raw.Hash())
+	if _, err := a.Block(ctx, raw, BlockData{Reason: "test", User: "u@x.com", Digests: []cid.Cid{digest}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncCRDT(ctx, a, b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*CRDTBlocklist{a, b} {
+		for _, id := range []cid.Cid{dagpb, cid.NewCidV0(raw.Hash()), cid.NewCidV1(cid.DagProtobuf, digest.Hash())} {
+			if ok, err := r.Contains(ctx, id); err != nil || !ok {
+				t.Errorf("replica %v: Contains(%v) = %v, %v, want true", r.Replica(), id, ok, err)
+			}
+		}
+	}
+	existing, err := b.Block(ctx, dagpb, BlockData{Reason: "again", User: "u@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	} else if existing == nil || existing.Reason != "test" {
+		t.Errorf("blocking another codec returned %+v, want the existing entry", existing)
+	}
+
+	if _, err := b.Unblock(ctx, dagpb); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncCRDT(ctx, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Contains(ctx, raw); err != nil || ok {
+		t.Errorf("Contains(%v) = %v, %v after unblocking its dag-pb CID, want false", raw, ok, err)
+	}
+	if ok, err := a.Contains(ctx, digest); err != nil || ok {
+		t.Errorf("digest %v is still matched after unblocking", digest)
+	}
+}
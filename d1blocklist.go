@@ -0,0 +1,1551 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// d1BatchSize is the number of rows inserted per statement by bulk operations.
+// Rows are passed as one JSON parameter, as D1 binds at most 100 parameters
+// per statement.
+const d1BatchSize = 100
+
+// d1TimeLayout is how times are stored. It has a fixed width, so that times
+// compare like strings.
+const d1TimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// D1Option configures a D1Blocklist.
+type D1Option func(*d1Options)
+
+type d1Options struct {
+	endpoint       string
+	client         *http.Client
+	blocklistTable string
+	auditTable     string
+	datastore      ds.Batching
+}
+
+// WithD1Endpoint sends requests to `endpoint` instead of
+// DefaultCloudflareEndpoint, like a proxy or a mock of the API.
+func WithD1Endpoint(endpoint string) D1Option {
+	return func(o *d1Options) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithD1HTTPClient sends requests with `c` instead of a client with a
+// DefaultCloudflareTimeout timeout.
+func WithD1HTTPClient(c *http.Client) D1Option {
+	return func(o *d1Options) {
+		o.client = c
+	}
+}
+
+// WithD1Tables stores entries in `blocklist`, and audit actions in `audit`,
+// instead of DefaultPgBlocklistTable and DefaultPgAuditTable.
+func WithD1Tables(blocklist, audit string) D1Option {
+	return func(o *d1Options) {
+		o.blocklistTable = blocklist
+		o.auditTable = audit
+	}
+}
+
+// WithD1Datastore sets the datastore that Purge removes content from, and
+// that BlockData.Rehash reads content from.
+func WithD1Datastore(d ds.Batching) D1Option {
+	return func(o *d1Options) {
+		o.datastore = d
+	}
+}
+
+// D1Blocklist stores the blocklist in a Cloudflare D1 database, through the
+// HTTP API, with the same tables and columns as PgBlocklist. It suits
+// deployments too small to run Postgres for. Like PgBlocklist, it matches
+// entries on their multihashes, so that an entry applies to its content under
+// any CID version, codec, or multibase. Multihashes are stored as hex, as
+// parameters are passed as JSON.
+//
+// Every method is one or two HTTP requests. Writes that touch several tables
+// are sent as one batch, which D1 runs as a transaction.
+type D1Blocklist struct {
+	api            cfAPI
+	blocklistTable string
+	auditTable     string
+	datastore      ds.Batching
+	transform      Transformer
+}
+
+// NewD1Blocklist returns a blocklist stored in the D1 database `database` of
+// the Cloudflare account `account`. `apiToken` needs the D1 edit permission.
+// The tables have to exist: see Migrate.
+func NewD1Blocklist(account, database, apiToken string, opts ...D1Option) *D1Blocklist {
+	o := &d1Options{
+		endpoint:       DefaultCloudflareEndpoint,
+		client:         &http.Client{Timeout: DefaultCloudflareTimeout},
+		blocklistTable: DefaultPgBlocklistTable,
+		auditTable:     DefaultPgAuditTable,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	base := fmt.Sprintf("%v/accounts/%v/d1/database/%v",
+		strings.TrimSuffix(o.endpoint, "/"), url.PathEscape(account), url.PathEscape(database))
+	return &D1Blocklist{
+		api:            cfAPI{o.client, base, apiToken},
+		blocklistTable: o.blocklistTable,
+		auditTable:     o.auditTable,
+		datastore:      o.datastore,
+	}
+}
+
+// WithTable returns a blocklist that shares the database of `b`, but stores
+// its entries in `table`.
+func (b *D1Blocklist) WithTable(table string) *D1Blocklist {
+	c := *b
+	c.blocklistTable = table
+	return &c
+}
+
+// WithTransformer returns a blocklist that shares the database and table of
+// `b`, but canonicalizes CIDs with `t` instead of CIDv1.
+func (b *D1Blocklist) WithTransformer(t Transformer) *D1Blocklist {
+	c := *b
+	c.transform = t
+	return &c
+}
+
+// Capabilities returns the optional features of the blocklist. Purge and
+// Rehash need WithD1Datastore. Count reads every row.
+func (b *D1Blocklist) Capabilities() Capabilities {
+	return Capabilities{
+		Purge:             b.datastore != nil,
+		Rehash:            b.datastore != nil,
+		Transactions:      true,
+		ChronologicalList: true,
+	}
+}
+
+// d1Quote quotes the identifier `name`.
+func d1Quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (b *D1Blocklist) table() string {
+	return d1Quote(b.blocklistTable)
+}
+
+func (b *D1Blocklist) digestTable() string {
+	return d1Quote(b.blocklistTable + "_digests")
+}
+
+func (b *D1Blocklist) historyTable() string {
+	return d1Quote(b.blocklistTable + "_history")
+}
+
+func (b *D1Blocklist) commentTable() string {
+	return d1Quote(b.blocklistTable + "_comments")
+}
+
+// Migrate creates the tables and indexes of the blocklist, if they don't
+// exist yet. Tables created before entries were matched on their multihashes
+// get the multihash column, filled from the hashes of their rows, see
+// fillMultihashes.
+func (b *D1Blocklist) Migrate(ctx context.Context) (err error) {
+	defer wrapError(&err, "d1", "migrate", cid.Undef)
+
+	entry := `id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TEXT, updated_at TEXT, deleted_at TEXT,
+		hash VARCHAR(100) NOT NULL %v,
+		multihash TEXT,
+		content VARCHAR(256) NOT NULL,
+		reason TEXT,
+		"user" VARCHAR(100) NOT NULL,
+		metadata TEXT NOT NULL DEFAULT '{}',
+		refs TEXT NOT NULL DEFAULT '[]',
+		purged_at TEXT,
+		supersedes INTEGER NOT NULL DEFAULT 0`
+	model := `id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TEXT, updated_at TEXT, deleted_at TEXT,`
+	_, err = b.batch(ctx,
+		d1Stmt{SQL: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v)", b.table(), fmt.Sprintf(entry, "UNIQUE"))},
+		d1Stmt{SQL: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v)", b.historyTable(), fmt.Sprintf(entry, ""))},
+		d1Stmt{SQL: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (hash)", d1Quote(b.blocklistTable+"_history_hash"), b.historyTable())},
+		d1Stmt{SQL: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (%v
+			hash VARCHAR(100) NOT NULL UNIQUE,
+			multihash TEXT,
+			parent VARCHAR(100) NOT NULL)`, b.digestTable(), model)},
+		d1Stmt{SQL: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (parent)", d1Quote(b.blocklistTable+"_digests_parent"), b.digestTable())},
+		d1Stmt{SQL: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (%v
+			parent VARCHAR(100) NOT NULL,
+			author VARCHAR(100) NOT NULL,
+			text TEXT NOT NULL)`, b.commentTable(), model)},
+		d1Stmt{SQL: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (parent)", d1Quote(b.blocklistTable+"_comments_parent"), b.commentTable())},
+		d1Stmt{SQL: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (%v
+			typ VARCHAR(10),
+			ids TEXT,
+			multihashes TEXT,
+			undoes INTEGER NOT NULL DEFAULT 0,
+			changes TEXT,
+			refs TEXT,
+			hlc TEXT,
+			reason TEXT,
+			"user" VARCHAR(100) NOT NULL)`, d1Quote(b.auditTable), model)},
+	)
+	if err != nil {
+		return err
+	}
+
+	// SQLite has no ADD COLUMN IF NOT EXISTS.
+	columns := map[string]string{b.auditTable: "multihashes"}
+	for _, table := range b.multihashTables() {
+		columns[table] = "multihash"
+	}
+	var stmts []d1Stmt
+	for table, column := range columns {
+		var cols []struct {
+			Name string `json:"name"`
+		}
+		if _, err := b.query(ctx, &cols, "SELECT name FROM pragma_table_info(?) WHERE name = ?", table, column); err != nil {
+			return err
+		} else if len(cols) == 0 {
+			stmts = append(stmts, d1Stmt{SQL: fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v TEXT", d1Quote(table), column)})
+		}
+	}
+	if len(stmts) > 0 {
+		if _, err := b.batch(ctx, stmts...); err != nil {
+			return err
+		}
+	}
+	return b.fillMultihashes(ctx)
+}
+
+// multihashTables returns the names of the tables with a multihash column.
+func (b *D1Blocklist) multihashTables() []string {
+	return []string{b.blocklistTable, b.blocklistTable + "_history", b.blocklistTable + "_digests"}
+}
+
+// fillMultihashes sets the multihash of the rows written before entries were
+// matched on their multihashes, from their hashes, then indexes the column.
+// It sets the multihashes of the actions logged before that too. Rows are
+// filled pgFillBatchSize at a time, one statement per batch, so an
+// interrupted fill is completed by running it again.
+//
+// Entries of the same content blocked under several CIDs now have the same
+// multihash. The unique index can't be created until all but one of them are
+// unblocked, and the error names them.
+func (b *D1Blocklist) fillMultihashes(ctx context.Context) error {
+	for _, table := range b.multihashTables() {
+		for {
+			var rows []struct {
+				ID   uint   `json:"id"`
+				Hash string `json:"hash"`
+			}
+			_, err := b.query(ctx, &rows, fmt.Sprintf(
+				"SELECT id, hash FROM %v WHERE multihash IS NULL ORDER BY id LIMIT ?", d1Quote(table)), pgFillBatchSize)
+			if err != nil {
+				return err
+			} else if len(rows) == 0 {
+				break
+			}
+			type fill struct {
+				ID        uint   `json:"id"`
+				Multihash string `json:"multihash"`
+			}
+			fills := make([]fill, len(rows))
+			for i, row := range rows {
+				id, err := cid.Decode(row.Hash)
+				if err != nil {
+					return fmt.Errorf("row %v of %v: %w", row.ID, table, err)
+				}
+				fills[i] = fill{row.ID, d1Multihash(id)}
+			}
+			_, err = b.query(ctx, nil, fmt.Sprintf(`UPDATE %[1]v SET multihash = (SELECT f.value->>'multihash' FROM json_each(?) f WHERE f.value->>'id' = %[1]v.id)
+				WHERE id IN (SELECT value->>'id' FROM json_each(?))`, d1Quote(table)), d1JSON(fills), d1JSON(fills))
+			if err != nil {
+				return err
+			}
+			log.Infof("filled the multihashes of %v rows of %v", len(rows), table)
+		}
+	}
+	for {
+		var rows []struct {
+			ID     uint    `json:"id"`
+			RawIds *string `json:"ids"`
+		}
+		_, err := b.query(ctx, &rows, fmt.Sprintf(
+			"SELECT id, ids FROM %v WHERE multihashes IS NULL ORDER BY id LIMIT ?", d1Quote(b.auditTable)), pgFillBatchSize)
+		if err != nil {
+			return err
+		} else if len(rows) == 0 {
+			break
+		}
+		type fill struct {
+			ID          uint   `json:"id"`
+			Multihashes string `json:"multihashes"`
+		}
+		fills := make([]fill, len(rows))
+		for i, row := range rows {
+			var ids []cid.Cid
+			if row.RawIds != nil {
+				if ids, err = pgLogIds(*row.RawIds); err != nil {
+					return fmt.Errorf("action %v of %v: %w", row.ID, b.auditTable, err)
+				}
+			}
+			fills[i] = fill{row.ID, pgLogMultihashes(ids)}
+		}
+		_, err = b.query(ctx, nil, fmt.Sprintf(`UPDATE %[1]v SET multihashes = (SELECT f.value->>'multihashes' FROM json_each(?) f WHERE f.value->>'id' = %[1]v.id)
+			WHERE id IN (SELECT value->>'id' FROM json_each(?))`, d1Quote(b.auditTable)), d1JSON(fills), d1JSON(fills))
+		if err != nil {
+			return err
+		}
+		log.Infof("filled the multihashes of %v actions of %v", len(rows), b.auditTable)
+	}
+
+	for _, table := range []string{b.blocklistTable, b.blocklistTable + "_digests"} {
+		var dups []struct {
+			Hash string `json:"hash"`
+		}
+		_, err := b.query(ctx, &dups, fmt.Sprintf(`SELECT hash FROM %[1]v
+			WHERE multihash IN (SELECT multihash FROM %[1]v GROUP BY multihash HAVING COUNT(*) > 1)
+			ORDER BY multihash LIMIT 10`, d1Quote(table)))
+		if err != nil {
+			return err
+		} else if len(dups) > 0 {
+			hashes := make([]string, len(dups))
+			for i, d := range dups {
+				hashes[i] = redactHash(d.Hash)
+			}
+			return fmt.Errorf("%v has several rows of the same content, unblock all but one of them: %v", table, strings.Join(hashes, ", "))
+		}
+	}
+	_, err := b.batch(ctx,
+		d1Stmt{SQL: fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %v ON %v (multihash)", d1Quote(b.blocklistTable+"_multihash"), b.table())},
+		d1Stmt{SQL: fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %v ON %v (multihash)", d1Quote(b.blocklistTable+"_digests_multihash"), b.digestTable())},
+		d1Stmt{SQL: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %v ON %v (multihash)", d1Quote(b.blocklistTable+"_history_multihash"), b.historyTable())},
+	)
+	return err
+}
+
+// missingObjects returns the names of `names` that aren't objects of type
+// `typ` in the database.
+func (b *D1Blocklist) missingObjects(ctx context.Context, typ string, names ...string) ([]string, error) {
+	var rows []struct {
+		Name string `json:"name"`
+	}
+	_, err := b.query(ctx, &rows, "SELECT name FROM sqlite_master WHERE type = ?", typ)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		exists[r.Name] = true
+	}
+	var missing []string
+	for _, name := range names {
+		if !exists[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// CheckSchema returns an error naming the tables created by Migrate that
+// don't exist.
+func (b *D1Blocklist) CheckSchema(ctx context.Context) (err error) {
+	defer wrapError(&err, "d1", "checkschema", cid.Undef)
+
+	missing, err := b.missingObjects(ctx, "table", b.blocklistTable, b.blocklistTable+"_history",
+		b.blocklistTable+"_digests", b.blocklistTable+"_comments", b.auditTable)
+	if err != nil {
+		return err
+	} else if len(missing) > 0 {
+		return fmt.Errorf("missing tables %v; run Migrate", strings.Join(missing, ", "))
+	}
+	filled := map[string]string{b.auditTable: "multihashes"}
+	for _, table := range b.multihashTables() {
+		filled[table] = "multihash"
+	}
+	for table, column := range filled {
+		var rows []struct {
+			Found int `json:"found"`
+		}
+		_, err := b.query(ctx, &rows, fmt.Sprintf(
+			"SELECT EXISTS (SELECT 1 FROM %v WHERE %v IS NULL) AS found", d1Quote(table), column))
+		if err != nil {
+			return err
+		} else if len(rows) > 0 && rows[0].Found != 0 {
+			missing = append(missing, "multihashes of rows of "+table)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %v; run Migrate", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CheckIndexes returns an error naming the indexes created by Migrate that
+// don't exist.
+func (b *D1Blocklist) CheckIndexes(ctx context.Context) (err error) {
+	defer wrapError(&err, "d1", "checkindexes", cid.Undef)
+
+	missing, err := b.missingObjects(ctx, "index", b.blocklistTable+"_history_hash",
+		b.blocklistTable+"_digests_parent", b.blocklistTable+"_comments_parent", b.blocklistTable+"_multihash",
+		b.blocklistTable+"_digests_multihash", b.blocklistTable+"_history_multihash")
+	if err != nil {
+		return err
+	} else if len(missing) > 0 {
+		return fmt.Errorf("missing indexes %v; run Migrate", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// d1Stmt is one SQL statement and its parameters.
+type d1Stmt struct {
+	SQL    string        `json:"sql"`
+	Params []interface{} `json:"params,omitempty"`
+}
+
+// d1Result is the result of one statement.
+type d1Result struct {
+	Results json.RawMessage
+	Success bool
+	Meta    struct {
+		Changes int64
+	}
+}
+
+// scan decodes the rows of `r` into `dst`, which has to be a pointer to a
+// slice of structs with json tags named after the columns.
+func (r d1Result) scan(dst interface{}) error {
+	if len(r.Results) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Results, dst)
+}
+
+// batch runs `stmts` in one request, in a transaction if there is more than
+// one.
+func (b *D1Blocklist) batch(ctx context.Context, stmts ...d1Stmt) ([]d1Result, error) {
+	var body interface{} = stmts[0]
+	if len(stmts) > 1 {
+		body = struct {
+			Batch []d1Stmt `json:"batch"`
+		}{stmts}
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	raw, err = b.api.do(ctx, http.MethodPost, "/query", bytes.NewReader(raw), "application/json")
+	if err != nil {
+		return nil, d1Error(err)
+	}
+
+	var res cfResponse
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("d1: %w", err)
+	}
+	var results []d1Result
+	if err := json.Unmarshal(res.Result, &results); err != nil {
+		return nil, fmt.Errorf("d1: %w", err)
+	} else if len(results) != len(stmts) {
+		return nil, fmt.Errorf("d1: %v results for %v statements", len(results), len(stmts))
+	}
+	for i, r := range results {
+		if !r.Success {
+			return nil, fmt.Errorf("d1: statement %v failed", i)
+		}
+	}
+	return results, nil
+}
+
+// query runs the statement `sql`, and decodes its rows into `dst` if it isn't
+// nil.
+func (b *D1Blocklist) query(ctx context.Context, dst interface{}, sql string, params ...interface{}) (d1Result, error) {
+	results, err := b.batch(ctx, d1Stmt{sql, params})
+	if err != nil {
+		return d1Result{}, err
+	}
+	if dst != nil {
+		if err := results[0].scan(dst); err != nil {
+			return d1Result{}, fmt.Errorf("d1: %w", err)
+		}
+	}
+	return results[0], nil
+}
+
+// d1Error translates errors of the API to the errors of the Blocklist
+// interface. Other errors are returned unchanged.
+func d1Error(err error) error {
+	var apiErr *cfError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "UNIQUE constraint failed") {
+		return fmt.Errorf("%w: %v", ErrAlreadyBlocked, err)
+	}
+	return err
+}
+
+// d1JSON returns `v` as a JSON parameter, for json_each.
+func d1JSON(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		// Only slices of strings and plain structs are passed.
+		panic(err)
+	}
+	return string(raw)
+}
+
+// d1Row is a row of the blocklist or history table, as returned by D1.
+type d1Row struct {
+	ID         uint    `json:"id"`
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+	DeletedAt  *string `json:"deleted_at"`
+	Hash       string  `json:"hash"`
+	Multihash  *string `json:"multihash"`
+	Content    string  `json:"content"`
+	Reason     *string `json:"reason"`
+	User       string  `json:"user"`
+	Metadata   string  `json:"metadata"`
+	Refs       string  `json:"refs"`
+	PurgedAt   *string `json:"purged_at"`
+	Supersedes uint    `json:"supersedes"`
+}
+
+// parseD1Time parses a time stored by D1Blocklist.
+func parseD1Time(s string) (time.Time, error) {
+	t, err := time.Parse(d1TimeLayout, s)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return t, nil
+}
+
+// pgRows converts `rows` to the rows of PgBlocklist.
+func pgRows(rows []d1Row) ([]PgBlocklistItem, error) {
+	out := make([]PgBlocklistItem, len(rows))
+	for i, r := range rows {
+		row := PgBlocklistItem{
+			Hash:       r.Hash,
+			Content:    r.Content,
+			User:       r.User,
+			Metadata:   r.Metadata,
+			Refs:       r.Refs,
+			Supersedes: r.Supersedes,
+		}
+		row.ID = r.ID
+		if r.Multihash != nil {
+			row.Multihash, _ = hex.DecodeString(*r.Multihash)
+		}
+		if r.Reason != nil {
+			row.Reason = *r.Reason
+		}
+		var err error
+		if row.CreatedAt, err = parseD1Time(r.CreatedAt); err != nil {
+			return nil, err
+		} else if row.UpdatedAt, err = parseD1Time(r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if r.DeletedAt != nil {
+			t, err := parseD1Time(*r.DeletedAt)
+			if err != nil {
+				return nil, err
+			}
+			row.DeletedAt = gorm.DeletedAt{Time: t, Valid: true}
+		}
+		if r.PurgedAt != nil {
+			t, err := parseD1Time(*r.PurgedAt)
+			if err != nil {
+				return nil, err
+			}
+			row.PurgedAt = &t
+		}
+		out[i] = row
+	}
+	return out, nil
+}
+
+// d1Multihash returns the multihash of `id` as it is stored.
+func d1Multihash(id cid.Cid) string {
+	return hex.EncodeToString(id.Hash())
+}
+
+// multihashes returns the stored multihashes `id` is matched on: that of
+// `id` after the Transformer of the blocklist, and that of `id` itself, for
+// entries blocked before they were transformed.
+func (b *D1Blocklist) multihashes(id cid.Cid) ([]string, error) {
+	key, err := normalize(b.transform, id)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(key.Hash(), id.Hash()) {
+		return []string{d1Multihash(key)}, nil
+	}
+	return []string{d1Multihash(key), d1Multihash(id)}, nil
+}
+
+// Contains returns true if the blocklist contains the content referenced by
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (b *D1Blocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "d1", "contains", id)
+
+	multihashes, err := b.multihashes(id)
+	if err != nil {
+		return false, err
+	}
+	var rows []struct {
+		Found int `json:"found"`
+	}
+	param := d1JSON(multihashes)
+	_, err = b.query(ctx, &rows, fmt.Sprintf(
+		"SELECT EXISTS (SELECT 1 FROM %v WHERE multihash IN (SELECT value FROM json_each(?))) OR EXISTS (SELECT 1 FROM %v WHERE multihash IN (SELECT value FROM json_each(?))) AS found",
+		b.table(), b.digestTable()), param, param)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0 && rows[0].Found != 0, nil
+}
+
+// ContainsMany returns which of `ids` the blocklist contains, with a single
+// query.
+func (b *D1Blocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "d1", "containsmany", cid.Undef)
+
+	// candidates maps every multihash an id could be matched on to the ids.
+	candidates := make(map[string][]cid.Cid, len(ids))
+	multihashes := make([]string, 0, len(ids))
+	res = make(map[cid.Cid]bool, len(ids))
+	for _, id := range ids {
+		ms, err := b.multihashes(id)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = false
+		for _, m := range ms {
+			if _, ok := candidates[m]; !ok {
+				multihashes = append(multihashes, m)
+			}
+			candidates[m] = append(candidates[m], id)
+		}
+	}
+	if len(multihashes) == 0 {
+		return res, nil
+	}
+
+	var found []struct {
+		Multihash string `json:"multihash"`
+	}
+	param := d1JSON(multihashes)
+	_, err = b.query(ctx, &found, fmt.Sprintf(
+		"SELECT multihash FROM %v WHERE multihash IN (SELECT value FROM json_each(?)) UNION SELECT multihash FROM %v WHERE multihash IN (SELECT value FROM json_each(?))",
+		b.table(), b.digestTable()), param, param)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range found {
+		for _, id := range candidates[f.Multihash] {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+// d1Entry is a new row of the blocklist table, as a JSON parameter.
+type d1Entry struct {
+	Hash      string `json:"hash"`
+	Multihash string `json:"multihash"`
+	Content   string `json:"content"`
+	Reason    string `json:"reason"`
+	User      string `json:"user"`
+	Metadata  string `json:"metadata"`
+	Refs      string `json:"refs"`
+}
+
+// entry returns the rows that block `id` with `data`.
+func (b *D1Blocklist) entry(ctx context.Context, id cid.Cid, data BlockData) (d1Entry, []PgDigestItem, error) {
+	key, err := normalize(b.transform, id)
+	if err != nil {
+		return d1Entry{}, nil, err
+	}
+	metadata, err := pgMetadata(data.Metadata)
+	if err != nil {
+		return d1Entry{}, nil, err
+	}
+	refs, err := pgReferences(data.References)
+	if err != nil {
+		return d1Entry{}, nil, err
+	}
+	item := d1Entry{
+		Hash:      key.String(),
+		Multihash: d1Multihash(key),
+		Content:   strings.Join(data.Content, "\n"),
+		Reason:    data.Reason,
+		User:      data.User,
+		Metadata:  metadata,
+		Refs:      refs,
+	}
+
+	data, err = rehash(ctx, b.datastore, id, data)
+	if err != nil {
+		return item, nil, err
+	}
+	digests := make([]PgDigestItem, 0, len(data.Digests))
+	for _, d := range data.Digests {
+		key, err := normalize(b.transform, d)
+		if err != nil {
+			return item, nil, err
+		}
+		digests = append(digests, PgDigestItem{Hash: key.String(), Multihash: key.Hash(), Parent: item.Hash})
+	}
+	return item, digests, nil
+}
+
+// insert returns the statements that insert `items` and `digests`. Entries
+// are linked to the most recent unblocked entry of the same content, under
+// any CID, if any.
+func (b *D1Blocklist) insert(items []d1Entry, digests []PgDigestItem) []d1Stmt {
+	now := time.Now().UTC().Format(d1TimeLayout)
+	var stmts []d1Stmt
+	for len(items) > 0 {
+		n := len(items)
+		if n > d1BatchSize {
+			n = d1BatchSize
+		}
+		stmts = append(stmts, d1Stmt{fmt.Sprintf(`INSERT INTO %v (created_at, updated_at, hash, multihash, content, reason, "user", metadata, refs, supersedes)
+			SELECT ?, ?, e.value->>'hash', e.value->>'multihash', e.value->>'content', e.value->>'reason', e.value->>'user', e.value->>'metadata', e.value->>'refs',
+				COALESCE((SELECT MAX(h.id) FROM %v h WHERE h.multihash = e.value->>'multihash'), 0)
+			FROM json_each(?) e`, b.table(), b.historyTable()),
+			[]interface{}{now, now, d1JSON(items[:n])}})
+		items = items[n:]
+	}
+
+	type d1Digest struct {
+		Hash      string `json:"hash"`
+		Multihash string `json:"multihash"`
+		Parent    string `json:"parent"`
+	}
+	rows := make([]d1Digest, len(digests))
+	for i, d := range digests {
+		rows[i] = d1Digest{d.Hash, hex.EncodeToString(d.Multihash), d.Parent}
+	}
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > d1BatchSize {
+			n = d1BatchSize
+		}
+		stmts = append(stmts, d1Stmt{fmt.Sprintf(`INSERT INTO %v (created_at, updated_at, hash, multihash, parent)
+			SELECT ?, ?, e.value->>'hash', e.value->>'multihash', e.value->>'parent' FROM json_each(?) e`, b.digestTable()),
+			[]interface{}{now, now, d1JSON(rows[:n])}})
+		rows = rows[n:]
+	}
+	return stmts
+}
+
+// Block adds `id` to the list of content we won't touch. If `id` was already
+// blocked, the existing entry is returned and its metadata are kept.
+// Otherwise, the returned entry is nil. Digests in `data` are stored
+// alongside the entry and matched by Contains.
+func (b *D1Blocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "block", id)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
+	}
+	if existing, err := b.Search(ctx, id); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	item, digests, err := b.entry(ctx, id, data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = b.batch(ctx, b.insert([]d1Entry{item}, digests)...)
+	if errors.Is(err, ErrAlreadyBlocked) {
+		// Blocked concurrently, since the check above.
+		return b.Search(ctx, id)
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// BlockMany blocks all of `ids` with the same metadata in one batch, and
+// returns the ids that weren't already blocked. Digests in `data` are
+// ignored, as they can't belong to more than one piece of content.
+func (b *D1Blocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) (blocked []cid.Cid, err error) {
+	defer wrapError(&err, "d1", "blockmany", cid.Undef)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
+	}
+	data.Digests = nil
+
+	multihashes := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		ms, err := b.multihashes(id)
+		if err != nil {
+			return nil, err
+		}
+		multihashes = append(multihashes, ms...)
+	}
+	if len(multihashes) == 0 {
+		return nil, nil
+	}
+	var existing []struct {
+		Multihash string `json:"multihash"`
+	}
+	param := d1JSON(multihashes)
+	_, err = b.query(ctx, &existing, fmt.Sprintf(
+		"SELECT multihash FROM %v WHERE multihash IN (SELECT value FROM json_each(?)) UNION SELECT multihash FROM %v WHERE multihash IN (SELECT value FROM json_each(?))",
+		b.table(), b.digestTable()), param, param)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, e := range existing {
+		seen[e.Multihash] = true
+	}
+
+	var items []d1Entry
+outer:
+	for _, id := range ids {
+		ms, _ := b.multihashes(id)
+		for _, m := range ms {
+			if seen[m] {
+				continue outer
+			}
+		}
+		for _, m := range ms {
+			seen[m] = true
+		}
+
+		item, _, err := b.entry(ctx, id, data)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		blocked = append(blocked, id)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	if _, err := b.batch(ctx, b.insert(items, nil)...); err != nil {
+		return nil, err
+	}
+	return blocked, nil
+}
+
+// unblock returns the statements that move the entries with `hashes` or
+// `multihashes` to the history table, and delete them and their digests. The
+// last one returns the hashes and multihashes of the deleted entries.
+func (b *D1Blocklist) unblock(hashes, multihashes []string) []d1Stmt {
+	now := time.Now().UTC().Format(d1TimeLayout)
+	where := "hash IN (SELECT value FROM json_each(?)) OR multihash IN (SELECT value FROM json_each(?))"
+	hashParam, multihashParam := d1JSON(hashes), d1JSON(multihashes)
+	return []d1Stmt{
+		{fmt.Sprintf(`INSERT INTO %v (created_at, updated_at, deleted_at, hash, multihash, content, reason, "user", metadata, refs, purged_at, supersedes)
+			SELECT created_at, updated_at, ?, hash, multihash, content, reason, "user", metadata, refs, purged_at, supersedes
+			FROM %v WHERE %v ORDER BY id`, b.historyTable(), b.table(), where),
+			[]interface{}{now, hashParam, multihashParam}},
+		{fmt.Sprintf("DELETE FROM %v WHERE parent IN (SELECT hash FROM %v WHERE %v)", b.digestTable(), b.table(), where),
+			[]interface{}{hashParam, multihashParam}},
+		{fmt.Sprintf("DELETE FROM %v WHERE %v RETURNING hash, multihash, ? AS deleted_at", b.table(), where),
+			[]interface{}{hashParam, multihashParam, now}},
+	}
+}
+
+// Unblock removes `id` from the list of blocked content, and returns the
+// removed entry. The entry is kept in the history table, and superseded if
+// the content is blocked again.
+func (b *D1Blocklist) Unblock(ctx context.Context, id cid.Cid) (removed *BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "unblock", id)
+
+	res, err := b.Search(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	results, err := b.batch(ctx, b.unblock([]string{res.Hash}, nil)...)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []struct {
+		DeletedAt string `json:"deleted_at"`
+	}
+	if err := results[2].scan(&deleted); err != nil {
+		return nil, fmt.Errorf("d1: %w", err)
+	} else if len(deleted) > 0 {
+		t, err := parseD1Time(deleted[0].DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		res.UnblockedAt = &t
+	}
+	return res, nil
+}
+
+// UnblockMany removes all of `ids` from the list of blocked content in one
+// batch. The returned map is true for the ids that were blocked and have been
+// removed.
+func (b *D1Blocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "d1", "unblockmany", cid.Undef)
+
+	// candidates maps every multihash an id could be matched on to the ids.
+	candidates := make(map[string][]cid.Cid, 2*len(ids))
+	multihashes := make([]string, 0, len(ids))
+	res = make(map[cid.Cid]bool, len(ids))
+	for _, id := range ids {
+		ms, err := b.multihashes(id)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = false
+		for _, m := range ms {
+			if _, ok := candidates[m]; !ok {
+				multihashes = append(multihashes, m)
+			}
+			candidates[m] = append(candidates[m], id)
+		}
+	}
+	if len(multihashes) == 0 {
+		return res, nil
+	}
+
+	var digests []struct {
+		Multihash string `json:"multihash"`
+		Parent    string `json:"parent"`
+	}
+	_, err = b.query(ctx, &digests, fmt.Sprintf(
+		"SELECT multihash, parent FROM %v WHERE multihash IN (SELECT value FROM json_each(?))", b.digestTable()), d1JSON(multihashes))
+	if err != nil {
+		return nil, err
+	}
+	// parents maps the hashes of the entries digests belong to to the ids.
+	var targets []string
+	parents := make(map[string][]cid.Cid, len(digests))
+	for _, d := range digests {
+		if _, ok := parents[d.Parent]; !ok {
+			targets = append(targets, d.Parent)
+		}
+		parents[d.Parent] = append(parents[d.Parent], candidates[d.Multihash]...)
+	}
+
+	results, err := b.batch(ctx, b.unblock(targets, multihashes)...)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []struct {
+		Hash      string  `json:"hash"`
+		Multihash *string `json:"multihash"`
+	}
+	if err := results[2].scan(&deleted); err != nil {
+		return nil, fmt.Errorf("d1: %w", err)
+	}
+	for _, d := range deleted {
+		if d.Multihash != nil {
+			for _, id := range candidates[*d.Multihash] {
+				res[id] = true
+			}
+		}
+		for _, id := range parents[d.Hash] {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+// Update changes the Reason, Content, Metadata, and References of the entry
+// `id` belongs to to those set in `patch`, and logs an "update" action of
+// `patch.User`. If nothing changes, nothing is logged.
+func (b *D1Blocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) (err error) {
+	defer wrapError(&err, "d1", "update", id)
+
+	if patch, err = patch.validate(true); err != nil {
+		return err
+	}
+	old, err := b.Search(ctx, id)
+	if err != nil {
+		return err
+	}
+	item := old.patch(patch)
+	act := updateAction(id, old, item, patch.User)
+	if len(act.Changes) == 0 {
+		return nil
+	}
+
+	metadata, err := pgMetadata(item.Metadata)
+	if err != nil {
+		return err
+	}
+	refs, err := pgReferences(item.References)
+	if err != nil {
+		return err
+	}
+	res, err := b.query(ctx, nil, fmt.Sprintf(
+		"UPDATE %v SET content = ?, reason = ?, metadata = ?, refs = ?, updated_at = ? WHERE hash = ?", b.table()),
+		strings.Join(item.Content, "\n"), item.Reason, metadata, refs, time.Now().UTC().Format(d1TimeLayout), old.Hash)
+	if err != nil {
+		return err
+	} else if res.Meta.Changes == 0 {
+		// Unblocked concurrently, since the search above.
+		return ErrNotFound
+	}
+	return b.AddLog(ctx, act)
+}
+
+// Search returns metadata about why/when the content identified by `id` was
+// blocked. `id` may be the primary hash of an entry or one of its digests. If
+// the content isn't blocked, ErrNotFound is returned.
+func (b *D1Blocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "search", id)
+
+	multihashes, err := b.multihashes(id)
+	if err != nil {
+		return nil, err
+	}
+	// Entries blocked before they were transformed are stored as is.
+	var rows []d1Row
+	param := d1JSON(multihashes)
+	_, err = b.query(ctx, &rows, fmt.Sprintf(`SELECT * FROM %v
+		WHERE hash IN (SELECT parent FROM %[2]v WHERE multihash IN (SELECT value FROM json_each(?)))
+			OR (multihash IN (SELECT value FROM json_each(?))
+				AND NOT EXISTS (SELECT 1 FROM %[2]v WHERE multihash IN (SELECT value FROM json_each(?))))
+		ORDER BY id LIMIT 1`, b.table(), b.digestTable()), param, param, param)
+	if err != nil {
+		return nil, err
+	} else if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+
+	items, err := b.items(ctx, rows)
+	if err != nil {
+		return nil, err
+	} else if err := b.comments(ctx, items); err != nil {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// SearchHistory returns the last unblocked entry of `id`, which links to the
+// entries unblocked before it through Supersedes. If `id` was never
+// unblocked, ErrNotFound is returned. Digests of unblocked entries aren't
+// matched.
+func (b *D1Blocklist) SearchHistory(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "searchhistory", id)
+
+	multihashes, err := b.multihashes(id)
+	if err != nil {
+		return nil, err
+	}
+	var last []struct {
+		ID uint `json:"id"`
+	}
+	_, err = b.query(ctx, &last, fmt.Sprintf(
+		"SELECT id FROM %v WHERE multihash IN (SELECT value FROM json_each(?)) ORDER BY id DESC LIMIT 1", b.historyTable()),
+		d1JSON(multihashes))
+	if err != nil {
+		return nil, err
+	} else if len(last) == 0 {
+		return nil, ErrNotFound
+	}
+	history, err := b.history(ctx, []uint{last[0].ID})
+	if err != nil {
+		return nil, err
+	}
+	return history[last[0].ID], nil
+}
+
+// AddComment appends `c` to the comments of the entry `id` belongs to, and
+// sets its CreatedAt.
+func (b *D1Blocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) (err error) {
+	defer wrapError(&err, "d1", "addcomment", id)
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+	item, err := b.Search(ctx, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = b.query(ctx, nil, fmt.Sprintf(
+		"INSERT INTO %v (created_at, updated_at, parent, author, text) VALUES (?, ?, ?, ?, ?)", b.commentTable()),
+		now.Format(d1TimeLayout), now.Format(d1TimeLayout), item.Hash, c.Author, c.Text)
+	if err != nil {
+		return err
+	}
+	c.CreatedAt = now
+	return nil
+}
+
+// comments loads the comments of `items`.
+func (b *D1Blocklist) comments(ctx context.Context, items []*BlocklistItem) error {
+	byHash := make(map[string][]*BlocklistItem, len(items))
+	hashes := make([]string, 0, len(items))
+	for _, item := range items {
+		if _, ok := byHash[item.Hash]; !ok {
+			hashes = append(hashes, item.Hash)
+		}
+		byHash[item.Hash] = append(byHash[item.Hash], item)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		Parent    string `json:"parent"`
+		Author    string `json:"author"`
+		Text      string `json:"text"`
+		CreatedAt string `json:"created_at"`
+	}
+	_, err := b.query(ctx, &rows, fmt.Sprintf(
+		"SELECT parent, author, text, created_at FROM %v WHERE parent IN (SELECT value FROM json_each(?)) ORDER BY id",
+		b.commentTable()), d1JSON(hashes))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		createdAt, err := parseD1Time(row.CreatedAt)
+		if err != nil {
+			return err
+		}
+		for _, item := range byHash[row.Parent] {
+			item.Comments = append(item.Comments, Comment{
+				Author:    row.Author,
+				Text:      row.Text,
+				CreatedAt: createdAt,
+			})
+		}
+	}
+	return nil
+}
+
+// SearchMany returns the metadata of all of `ids` with one query per table.
+// The returned map is nil for the ids that aren't blocked.
+func (b *D1Blocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "searchmany", cid.Undef)
+
+	// candidates maps every multihash an id could be matched on to the ids.
+	candidates := make(map[string][]cid.Cid, 2*len(ids))
+	multihashes := make([]string, 0, len(ids))
+	res = make(map[cid.Cid]*BlocklistItem, len(ids))
+	for _, id := range ids {
+		ms, err := b.multihashes(id)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = nil
+		for _, m := range ms {
+			if _, ok := candidates[m]; !ok {
+				multihashes = append(multihashes, m)
+			}
+			candidates[m] = append(candidates[m], id)
+		}
+	}
+	if len(multihashes) == 0 {
+		return res, nil
+	}
+
+	var digests []struct {
+		Multihash string `json:"multihash"`
+		Parent    string `json:"parent"`
+	}
+	_, err = b.query(ctx, &digests, fmt.Sprintf(
+		"SELECT multihash, parent FROM %v WHERE multihash IN (SELECT value FROM json_each(?))", b.digestTable()), d1JSON(multihashes))
+	if err != nil {
+		return nil, err
+	}
+	// parents maps the hashes of the entries digests belong to to the ids.
+	var parentHashes []string
+	parents := make(map[string][]cid.Cid, len(digests))
+	for _, d := range digests {
+		if _, ok := parents[d.Parent]; !ok {
+			parentHashes = append(parentHashes, d.Parent)
+		}
+		parents[d.Parent] = append(parents[d.Parent], candidates[d.Multihash]...)
+	}
+
+	var rows []d1Row
+	_, err = b.query(ctx, &rows, fmt.Sprintf(
+		"SELECT * FROM %v WHERE hash IN (SELECT value FROM json_each(?)) OR multihash IN (SELECT value FROM json_each(?))", b.table()),
+		d1JSON(parentHashes), d1JSON(multihashes))
+	if err != nil {
+		return nil, err
+	}
+	items, err := b.items(ctx, rows)
+	if err != nil {
+		return nil, err
+	} else if err := b.comments(ctx, items); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		if row.Multihash != nil {
+			for _, id := range candidates[*row.Multihash] {
+				res[id] = items[i]
+			}
+		}
+		for _, id := range parents[row.Hash] {
+			res[id] = items[i]
+		}
+	}
+	return res, nil
+}
+
+// SearchByContent returns the entries that have `url` as one of their Content
+// entries. It has to go over all entries.
+func (b *D1Blocklist) SearchByContent(ctx context.Context, url string) (items []*BlocklistItem, err error) {
+	defer wrapError(&err, "d1", "searchbycontent", cid.Undef)
+
+	var rows []d1Row
+	_, err = b.query(ctx, &rows, fmt.Sprintf(
+		"SELECT * FROM %v WHERE instr(char(10) || content || char(10), ?) > 0 ORDER BY id", b.table()),
+		"\n"+strings.TrimSpace(url)+"\n")
+	if err != nil {
+		return nil, err
+	}
+	if items, err = b.items(ctx, rows); err != nil {
+		return nil, err
+	} else if err := b.comments(ctx, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Count returns the number of blocklist entries.
+func (b *D1Blocklist) Count(ctx context.Context) (count int64, err error) {
+	defer wrapError(&err, "d1", "count", cid.Undef)
+
+	var rows []struct {
+		Count int64 `json:"count"`
+	}
+	if _, err := b.query(ctx, &rows, fmt.Sprintf("SELECT COUNT(*) AS count FROM %v", b.table())); err != nil {
+		return 0, err
+	} else if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Count, nil
+}
+
+// List returns a page of blocklist entries, ordered by when they were
+// blocked. Cursors are the ID of the last entry of the previous page.
+func (b *D1Blocklist) List(ctx context.Context, opts ListOptions) (page *ListPage, err error) {
+	defer wrapError(&err, "d1", "list", cid.Undef)
+
+	var (
+		where  []string
+		params []interface{}
+		order  = "id"
+	)
+	if opts.Order == ListDescending {
+		order = "id DESC"
+	}
+	if opts.Cursor != "" {
+		after, err := strconv.ParseUint(opts.Cursor, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor: %w", err)
+		}
+		if opts.Order == ListDescending {
+			where = append(where, "id < ?")
+		} else {
+			where = append(where, "id > ?")
+		}
+		params = append(params, after)
+	}
+	if opts.Ref != (Reference{}) {
+		var conds []string
+		for _, f := range []struct{ field, value string }{
+			{"Typ", string(opts.Ref.Typ)}, {"Id", opts.Ref.Id}, {"URL", opts.Ref.URL},
+		} {
+			if f.value != "" {
+				conds = append(conds, "r.value->>'"+f.field+"' = ?")
+				params = append(params, f.value)
+			}
+		}
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(refs) r WHERE "+strings.Join(conds, " AND ")+")")
+	}
+
+	limit := opts.limit()
+	sql := "SELECT * FROM " + b.table()
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY " + order + " LIMIT ?"
+	params = append(params, limit+1)
+	if opts.Cursor == "" && opts.Offset > 0 {
+		sql += " OFFSET ?"
+		params = append(params, opts.Offset)
+	}
+
+	var rows []d1Row
+	if _, err := b.query(ctx, &rows, sql, params...); err != nil {
+		return nil, err
+	}
+	page = &ListPage{}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		page.Next = strconv.FormatUint(uint64(rows[limit-1].ID), 10)
+	}
+	if page.Items, err = b.items(ctx, rows); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// items converts `rows` to BlocklistItems, loading their digests and history.
+func (b *D1Blocklist) items(ctx context.Context, rows []d1Row) ([]*BlocklistItem, error) {
+	pgrows, err := pgRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(rows))
+	var superseded []uint
+	for i, row := range rows {
+		hashes[i] = row.Hash
+		if row.Supersedes != 0 {
+			superseded = append(superseded, row.Supersedes)
+		}
+	}
+
+	var digests []struct {
+		Hash   string `json:"hash"`
+		Parent string `json:"parent"`
+	}
+	if len(hashes) > 0 {
+		_, err := b.query(ctx, &digests, fmt.Sprintf(
+			"SELECT hash, parent FROM %v WHERE parent IN (SELECT value FROM json_each(?)) ORDER BY id", b.digestTable()),
+			d1JSON(hashes))
+		if err != nil {
+			return nil, err
+		}
+	}
+	byParent := make(map[string][]string)
+	for _, d := range digests {
+		byParent[d.Parent] = append(byParent[d.Parent], d.Hash)
+	}
+
+	history, err := b.history(ctx, superseded)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*BlocklistItem, len(pgrows))
+	for i, row := range pgrows {
+		if items[i], err = row.item(); err != nil {
+			return nil, err
+		}
+		items[i].Digests = byParent[row.Hash]
+		items[i].Supersedes = history[row.Supersedes]
+	}
+	return items, nil
+}
+
+// history loads the unblocked entries with `ids`, and the entries they
+// superseded in turn. The returned map is keyed by history table ID.
+func (b *D1Blocklist) history(ctx context.Context, ids []uint) (map[uint]*BlocklistItem, error) {
+	loaded := make(map[uint]PgBlocklistItem)
+	for len(ids) > 0 {
+		var rows []d1Row
+		_, err := b.query(ctx, &rows, fmt.Sprintf(
+			"SELECT * FROM %v WHERE id IN (SELECT value FROM json_each(?))", b.historyTable()), d1JSON(ids))
+		if err != nil {
+			return nil, err
+		}
+		hist, err := pgRows(rows)
+		if err != nil {
+			return nil, err
+		}
+		ids = ids[:0]
+		for _, h := range hist {
+			loaded[h.ID] = h
+			if _, ok := loaded[h.Supersedes]; h.Supersedes != 0 && !ok {
+				ids = append(ids, h.Supersedes)
+			}
+		}
+	}
+	return linkHistory(loaded)
+}
+
+// Purge removes any copies of the content referenced by `id` from the
+// datastore, and marks its entry as purged if it is blocked.
+func (b *D1Blocklist) Purge(ctx context.Context, id cid.Cid) (err error) {
+	defer wrapError(&err, "d1", "purge", id)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if b.datastore == nil {
+		return fmt.Errorf("no datastore to purge from")
+	}
+	if err := b.datastore.Delete(dshelp.CidToDsKey(id)); err != nil {
+		return dsError(err)
+	}
+
+	multihashes, err := b.multihashes(id)
+	if err != nil {
+		return err
+	}
+	_, err = b.query(ctx, nil, fmt.Sprintf("UPDATE %v SET purged_at = ? WHERE multihash IN (SELECT value FROM json_each(?))", b.table()),
+		time.Now().UTC().Format(d1TimeLayout), d1JSON(multihashes))
+	return err
+}
+
+// Healthy returns an error if the database can't run a trivial query.
+func (b *D1Blocklist) Healthy(ctx context.Context) (err error) {
+	defer wrapError(&err, "d1", "healthy", cid.Undef)
+	_, err = b.query(ctx, nil, "SELECT 1")
+	return err
+}
+
+// Close does nothing, as requests don't hold connections open. The datastore
+// isn't closed.
+func (b *D1Blocklist) Close(ctx context.Context) error {
+	return nil
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+// Actions are ordered by their auto-incremented ID, which is also their Seq.
+func (b *D1Blocklist) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "d1", "getlogs", cid.Undef)
+
+	var (
+		where  []string
+		params []interface{}
+	)
+	if q.Before > 0 {
+		where, params = append(where, "id < ?"), append(params, q.Before)
+	}
+	if q.User != "" {
+		where, params = append(where, `"user" = ?`), append(params, q.User)
+	}
+	if q.Typ != "" {
+		where, params = append(where, "typ = ?"), append(params, string(q.Typ))
+	}
+	if !q.Since.IsZero() {
+		where, params = append(where, "created_at >= ?"), append(params, q.Since.UTC().Format(d1TimeLayout))
+	}
+	if !q.Until.IsZero() {
+		where, params = append(where, "created_at < ?"), append(params, q.Until.UTC().Format(d1TimeLayout))
+	}
+	if q.Id.Defined() {
+		where, params = append(where, "instr(';' || multihashes || ';', ?) > 0"), append(params, ";"+d1Multihash(q.Id)+";")
+	}
+	sql := "SELECT * FROM " + d1Quote(b.auditTable)
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		sql += " LIMIT ?"
+		params = append(params, q.Limit)
+	}
+
+	var logs []struct {
+		ID        uint64  `json:"id"`
+		CreatedAt string  `json:"created_at"`
+		Typ       string  `json:"typ"`
+		RawIds    *string `json:"ids"`
+		Undoes    uint64  `json:"undoes"`
+		Changes   *string `json:"changes"`
+		Refs      *string `json:"refs"`
+		HLC       *string `json:"hlc"`
+		Reason    *string `json:"reason"`
+		User      string  `json:"user"`
+	}
+	if _, err := b.query(ctx, &logs, sql, params...); err != nil {
+		return nil, err
+	}
+
+	acts = make([]*Action, len(logs))
+	for i, l := range logs {
+		act := &Action{Seq: l.ID, Typ: ActionType(l.Typ), Undoes: l.Undoes, User: l.User}
+		if act.CreatedAt, err = parseD1Time(l.CreatedAt); err != nil {
+			return nil, err
+		}
+		if l.Reason != nil {
+			act.Reason = *l.Reason
+		}
+		if l.RawIds != nil {
+			if act.Ids, err = pgLogIds(*l.RawIds); err != nil {
+				return nil, err
+			}
+		}
+		if l.Changes != nil && *l.Changes != "" {
+			if err := json.Unmarshal([]byte(*l.Changes), &act.Changes); err != nil {
+				return nil, err
+			}
+		}
+		if l.Refs != nil && *l.Refs != "" {
+			if err := json.Unmarshal([]byte(*l.Refs), &act.References); err != nil {
+				return nil, err
+			}
+		}
+		if l.HLC != nil && *l.HLC != "" {
+			act.HLC = &HLC{}
+			if err := act.HLC.UnmarshalText([]byte(*l.HLC)); err != nil {
+				return nil, err
+			}
+		}
+		acts[i] = act
+	}
+	return acts, nil
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq.
+func (b *D1Blocklist) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "d1", "addlog", cid.Undef)
+
+	if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	rawIds := make([]string, len(act.Ids))
+	for i, id := range act.Ids {
+		rawIds[i] = id.String()
+	}
+	var changes, refs, hlc interface{}
+	if len(act.Changes) > 0 {
+		changes = d1JSON(act.Changes)
+	}
+	if len(act.References) > 0 {
+		refs = d1JSON(act.References)
+	}
+	if act.HLC != nil {
+		hlc = act.HLC.String()
+	}
+
+	now := time.Now().UTC()
+	createdAt := now
+	if !act.CreatedAt.IsZero() {
+		createdAt = act.CreatedAt.UTC()
+	}
+	var rows []struct {
+		ID uint64 `json:"id"`
+	}
+	_, err = b.query(ctx, &rows, fmt.Sprintf(`INSERT INTO %v (created_at, updated_at, typ, ids, multihashes, undoes, changes, refs, hlc, reason, "user")
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`, d1Quote(b.auditTable)),
+		createdAt.Format(d1TimeLayout), now.Format(d1TimeLayout), string(act.Typ), strings.Join(rawIds, ";"), pgLogMultihashes(act.Ids),
+		act.Undoes, changes, refs, hlc, act.Reason, act.User)
+	if err != nil {
+		return err
+	} else if len(rows) == 0 {
+		return fmt.Errorf("d1: no id returned")
+	}
+	act.Seq = rows[0].ID
+	return nil
+}
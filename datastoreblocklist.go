@@ -2,7 +2,11 @@ package blocklist
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
@@ -13,158 +17,1538 @@ import (
 	mh "github.com/multiformats/go-multihash"
 )
 
-// SafemodePrefix namespaces safemodestore datastores
+// SafemodePrefix is the default namespace of everything a DatastoreBlocklist
+// stores.
 var SafemodePrefix = ds.NewKey("safemode")
 
-// Blocklist namespaces safemodestore datastores
+// BlocklistPrefix is the default namespace of blocklist entries, under the
+// root namespace.
 var BlocklistPrefix = ds.NewKey("blocklist")
 
-// Audit namespaces safemodestore datastores
+// AuditPrefix is the default namespace of audit actions, under the root
+// namespace.
 var AuditPrefix = ds.NewKey("audit")
 
-// PgBlocklist implements a programmatic way to determine if the gateway should
-// refuse to serve some content.
+// DigestPrefix is the default namespace of the alternative digests of blocked
+// content, under the root namespace.
+var DigestPrefix = ds.NewKey("digest")
+
+// HistoryPrefix is the default namespace of unblocked entries, kept to link
+// them to later entries of the same content, under the root namespace.
+var HistoryPrefix = ds.NewKey("history")
+
+// CommentPrefix is the default namespace of comments on entries, under the
+// root namespace.
+var CommentPrefix = ds.NewKey("comments")
+
+// ContentPrefix is the default namespace of the index of entries by their
+// content URLs, under the root namespace.
+var ContentPrefix = ds.NewKey("content")
+
+// LayoutPrefix is the namespace, under the root namespace, where a
+// DatastoreBlocklist records that the entries of its blocklist namespace are
+// keyed on multihashes, see migrateKeys.
+var LayoutPrefix = ds.NewKey("layout")
+
+// DatastoreOption configures a DatastoreBlocklist.
+type DatastoreOption func(*datastoreOptions)
+
+type datastoreOptions struct {
+	root      ds.Key
+	blocklist ds.Key
+	audit     ds.Key
+	digest    ds.Key
+	history   ds.Key
+	comment   ds.Key
+	content   ds.Key
+	codecs    map[ds.Key]Codec
+}
+
+// WithRootPrefix stores everything under `prefix` instead of SafemodePrefix,
+// so that several blocklists can share a datastore.
+func WithRootPrefix(prefix ds.Key) DatastoreOption {
+	return func(o *datastoreOptions) {
+		o.root = prefix
+	}
+}
+
+// WithPrefixes sets the namespaces of entries, audit actions, and digests
+// under the root prefix.
+func WithPrefixes(blocklist, audit, digest ds.Key) DatastoreOption {
+	return func(o *datastoreOptions) {
+		o.blocklist, o.audit, o.digest = blocklist, audit, digest
+	}
+}
+
+// WithHistoryPrefix sets the namespace of unblocked entries under the root
+// prefix.
+func WithHistoryPrefix(history ds.Key) DatastoreOption {
+	return func(o *datastoreOptions) {
+		o.history = history
+	}
+}
+
+// WithCommentPrefix sets the namespace of comments under the root prefix.
+func WithCommentPrefix(comment ds.Key) DatastoreOption {
+	return func(o *datastoreOptions) {
+		o.comment = comment
+	}
+}
+
+// WithContentPrefix sets the namespace of the content index under the root
+// prefix.
+func WithContentPrefix(content ds.Key) DatastoreOption {
+	return func(o *datastoreOptions) {
+		o.content = content
+	}
+}
+
+// WithCodec serializes the values stored in `namespace`, which has to be the
+// namespace of entries, audit actions, or comments, with `c` instead of
+// JSONCodec. Values written with other registered codecs stay readable.
+func WithCodec(namespace ds.Key, c Codec) DatastoreOption {
+	return func(o *datastoreOptions) {
+		if o.codecs == nil {
+			o.codecs = make(map[ds.Key]Codec)
+		}
+		o.codecs[namespace] = c
+	}
+}
+
+// codec returns the codec of the values stored in `namespace`.
+func (o *datastoreOptions) codec(namespace ds.Key) Codec {
+	if c, ok := o.codecs[namespace]; ok {
+		return c
+	}
+	return JSONCodec
+}
+
+// validate returns an error if any of the namespaces overlap.
+func (o *datastoreOptions) validate() error {
+	prefixes := []ds.Key{o.blocklist, o.audit, o.digest, o.history, o.comment, o.content, LayoutPrefix}
+	for i, a := range prefixes {
+		if a.String() == "/" {
+			return fmt.Errorf("empty namespace prefix")
+		}
+		for _, b := range prefixes[i+1:] {
+			if a.Equal(b) || a.IsAncestorOf(b) || b.IsAncestorOf(a) {
+				return fmt.Errorf("namespace prefixes %v and %v collide", a, b)
+			}
+		}
+	}
+	for ns := range o.codecs {
+		if !ns.Equal(o.blocklist) && !ns.Equal(o.audit) && !ns.Equal(o.comment) {
+			return fmt.Errorf("no codec can be set for namespace %v", ns)
+		}
+	}
+	return nil
+}
+
+// DatastoreBlocklist implements a programmatic way to determine if the gateway
+// should refuse to serve some content, on top of a datastore.
 type DatastoreBlocklist struct {
 	datastore     ds.Batching
+	rootstore     ds.Batching
 	auditstore    ds.Batching
 	safemodestore ds.Batching
+	digeststore   ds.Batching
+	historystore  ds.Batching
+	commentstore  ds.Batching
+	contentstore  ds.Batching
+	prefixes      dsPrefixes
+	seq           *logSeq
+	transform     Transformer
+	entryCodec    Codec
+	auditCodec    Codec
+	commentCodec  Codec
+}
+
+// newDatastoreOptions returns the defaults overridden by `opts`.
+func newDatastoreOptions(opts ...DatastoreOption) (*datastoreOptions, error) {
+	o := &datastoreOptions{
+		root:      SafemodePrefix,
+		blocklist: BlocklistPrefix,
+		audit:     AuditPrefix,
+		digest:    DigestPrefix,
+		history:   HistoryPrefix,
+		comment:   CommentPrefix,
+		content:   ContentPrefix,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// NewDatastoreBlocklist returns a blocklist stored in `d`. Entries are keyed
+// on the multihashes of their CIDs. Those of a store written when they were
+// keyed on their CIDs are rekeyed first, see migrateKeys.
+func NewDatastoreBlocklist(d ds.Batching, opts ...DatastoreOption) (DatastoreBlocklist, error) {
+	o, err := newDatastoreOptions(opts...)
+	if err != nil {
+		return DatastoreBlocklist{}, err
+	}
+
+	dd := dsns.Wrap(d, o.root)
+	var safemodestore, auditstore, digeststore, historystore, commentstore, contentstore ds.Batching
+	safemodestore = dsns.Wrap(dd, o.blocklist)
+	auditstore = dsns.Wrap(dd, o.audit)
+	digeststore = dsns.Wrap(dd, o.digest)
+	historystore = dsns.Wrap(dd, o.history)
+	commentstore = dsns.Wrap(dd, o.comment)
+	contentstore = dsns.Wrap(dd, o.content)
+	b := DatastoreBlocklist{
+		datastore:     d,
+		rootstore:     dd,
+		auditstore:    auditstore,
+		safemodestore: safemodestore,
+		digeststore:   digeststore,
+		historystore:  historystore,
+		commentstore:  commentstore,
+		contentstore:  contentstore,
+		prefixes:      dsPrefixes{o.root, o.blocklist, o.digest, o.history, o.content, o.comment},
+		seq:           &logSeq{},
+		transform:     CIDv1,
+		entryCodec:    o.codec(o.blocklist),
+		auditCodec:    o.codec(o.audit),
+		commentCodec:  o.codec(o.comment),
+	}
+	if err := b.migrateKeys(LayoutPrefix.Child(o.blocklist)); err != nil {
+		return DatastoreBlocklist{}, err
+	}
+	return b, nil
+}
+
+// WithTransformer returns a blocklist that shares the datastore of `b`, but
+// canonicalizes CIDs with `t` instead of CIDv1.
+func (b DatastoreBlocklist) WithTransformer(t Transformer) DatastoreBlocklist {
+	b.transform = t
+	return b
 }
 
-func NewDatastoreBlocklist(d ds.Batching) DatastoreBlocklist {
-	dd := dsns.Wrap(d, SafemodePrefix)
-	var safemodestore, auditstore ds.Batching
-	safemodestore = dsns.Wrap(dd, BlocklistPrefix)
-	auditstore = dsns.Wrap(dd, AuditPrefix)
-	return DatastoreBlocklist{d, auditstore, safemodestore}
+// Capabilities returns the optional features of the blocklist. Changes are
+// written in one batch of the datastore, which is only atomic if the
+// datastore's batches are, and List orders entries by key.
+func (b DatastoreBlocklist) Capabilities() Capabilities {
+	return Capabilities{Purge: true, Rehash: true}
 }
 
+// cidToKey returns the key `id` is stored under: the key of its multihash,
+// after the Transformer of the blocklist, so that an entry applies to its
+// content under any CID version, codec, or multibase.
 func (b DatastoreBlocklist) cidToKey(id cid.Cid) (ds.Key, error) {
-	// converting cidv0 to cidv1, as all CID are inserted as cidv1 in the compliance database
-	cidv1 := id
-	if id.Version() == 0 {
-		hash, err := mh.FromB58String(id.String())
-		if err != nil {
-			return ds.NewKey(""), err
-		}
-		cidv1 = cid.NewCidV1(cid.DagProtobuf, hash)
+	id, err := normalize(b.transform, id)
+	if err != nil {
+		return ds.NewKey(""), err
 	}
+	return dshelp.NewKeyFromBinary(id.Hash()), nil
+}
+
+// keyToCid returns the CID of the entry or digest stored at `k`: the
+// CIDv1-raw of its multihash, or the CID itself if `k` was written when
+// entries were keyed on their CIDs.
+func keyToCid(k ds.Key) (cid.Cid, error) {
+	raw, err := dshelp.BinaryFromDsKey(k)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if h, err := mh.Cast(raw); err == nil {
+		return cid.NewCidV1(cid.Raw, h), nil
+	}
+	return cid.Cast(raw)
+}
 
-	return dshelp.CidToDsKey(cidv1), nil
+// dsError translates errors of the datastore to the errors of the Blocklist
+// interface. Other errors are returned unchanged.
+func dsError(err error) error {
+	if errors.Is(err, ds.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
 }
 
 // Contains returns true if the blocklist contains the content referenced by
-// `id`.
-func (b DatastoreBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (b DatastoreBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "datastore", "contains", id)
+
 	if !id.Defined() {
 		log.Error("undefined cid in blockstore")
-		return false, ErrNotFound
+		return false, ErrInvalidCID
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 	k, err := b.cidToKey(id)
 	if err != nil {
 		return false, err
 	}
-	return b.safemodestore.Has(k)
+	if exists, err := b.safemodestore.Has(k); err != nil || exists {
+		return exists, err
+	}
+	return b.digeststore.Has(k)
 }
 
-func (b DatastoreBlocklist) Block(id cid.Cid, data BlockData) (bool, error) {
-	k, err := b.cidToKey(id)
-	if err != nil {
-		return false, err
+// containsWorkers is the number of concurrent lookups made by ContainsMany.
+const containsWorkers = 8
+
+// ContainsMany returns which of `ids` the blocklist contains, looking them up
+// concurrently.
+func (b DatastoreBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "datastore", "containsmany", cid.Undef)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	res = make(map[cid.Cid]bool, len(ids))
+	work := make(chan cid.Cid)
+	for i := 0; i < containsWorkers && i < len(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				exists, err := b.Contains(ctx, id)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				res[id] = exists
+				mu.Unlock()
+			}
+		}()
 	}
 
-	if exists, err := b.Contains(context.TODO(), id); err != nil {
-		return false, err
-	} else if exists {
-		return false, nil
+loop:
+	for _, id := range ids {
+		select {
+		case work <- id:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
-	bi := BlocklistItem{
-		Hash:    id.String(),
-		Content: data.Content,
-		User:    data.User,
-		Reason:  data.Reason,
+// entryKey returns the key of the entry `k` belongs to, resolving digests to
+// the primary hash they were blocked with.
+func (b DatastoreBlocklist) entryKey(k ds.Key) (ds.Key, error) {
+	parent, err := b.digeststore.Get(k)
+	if err == ds.ErrNotFound {
+		return k, nil
+	} else if err != nil {
+		return k, err
 	}
-	rawBi, err := bi.MarshalBinary()
+	return ds.RawKey(string(parent)), nil
+}
+
+// dsPrefixes are the namespaces of a DatastoreBlocklist: the root one, under
+// the datastore, and the others, under the root one.
+type dsPrefixes struct {
+	root, entries, digests, history, content, comments ds.Key
+}
+
+// dsWrites are where a change of the blocklist is written to: the namespaces
+// of one batch.
+type dsWrites struct {
+	entries ds.Write
+	digests ds.Write
+	history ds.Write
+	content ds.Write
+}
+
+// prefixedWrite writes to the namespace `prefix` of a batch.
+type prefixedWrite struct {
+	batch  ds.Batch
+	prefix ds.Key
+}
+
+func (w prefixedWrite) Put(k ds.Key, v []byte) error {
+	return w.batch.Put(w.prefix.Child(k), v)
+}
+
+func (w prefixedWrite) Delete(k ds.Key) error {
+	return w.batch.Delete(w.prefix.Child(k))
+}
+
+// batches returns the namespaces of a new batch of the root store, and a
+// function that commits it. Nothing is written until the batch is
+// committed: returning without committing it rolls the change back.
+func (b DatastoreBlocklist) batches() (dsWrites, func() error, error) {
+	batch, err := b.rootstore.Batch()
 	if err != nil {
-		return false, err
+		return dsWrites{}, nil, err
 	}
+	return dsWrites{
+		entries: prefixedWrite{batch, b.prefixes.entries},
+		digests: prefixedWrite{batch, b.prefixes.digests},
+		history: prefixedWrite{batch, b.prefixes.history},
+		content: prefixedWrite{batch, b.prefixes.content},
+	}, batch.Commit, nil
+}
 
-	if err := b.safemodestore.Put(k, rawBi); err != nil {
-		return false, err
+// Block adds `id` to the blocklist. If `id` was already blocked, the existing
+// entry is returned and kept as is. Otherwise, the returned entry is nil.
+func (b DatastoreBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "block", id)
+
+	if data, err = data.Validate(); err != nil {
+		return nil, err
 	}
-	return true, nil
+	return b.restore(ctx, id, data)
 }
 
-func (b DatastoreBlocklist) Unblock(id cid.Cid) error {
-	k, err := b.cidToKey(id)
+// restore is Block without validating `data`, for entries copied from another
+// blocklist as they were stored, like those TieredBlocklist fills its fast
+// layer with: they may predate the validation rules.
+func (b DatastoreBlocklist) restore(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	if existing, err := b.Search(ctx, id); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	k, bi, err := b.entry(ctx, id, data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	w, commit, err := b.batches()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.put(k, bi, w); err != nil {
+		return nil, err
 	}
-	return b.safemodestore.Delete(k)
+	return nil, commit()
 }
 
-func (b DatastoreBlocklist) Search(id cid.Cid) (*BlocklistItem, error) {
-	k, err := b.cidToKey(id)
-	if err != nil {
+// BlockMany blocks all of `ids` with the same metadata in one datastore
+// batch, and returns the ids that weren't already blocked. Digests in `data`
+// are ignored, as they can't belong to more than one piece of content.
+func (b DatastoreBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) (blocked []cid.Cid, err error) {
+	defer wrapError(&err, "datastore", "blockmany", cid.Undef)
+
+	if data, err = data.Validate(); err != nil {
 		return nil, err
 	}
+	data.Digests = nil
 
-	v, err := b.safemodestore.Get(k)
+	w, commit, err := b.batches()
 	if err != nil {
 		return nil, err
 	}
 
-	bi := &BlocklistItem{}
-	err = bi.UnmarshalBinary(v)
-	if err != nil {
+	seen := make(map[ds.Key]bool, len(ids))
+	for _, id := range ids {
+		if exists, err := b.Contains(ctx, id); err != nil {
+			return nil, err
+		} else if exists {
+			continue
+		}
+
+		k, bi, err := b.entry(ctx, id, data)
+		if err != nil {
+			return nil, err
+		} else if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		if err := b.put(k, bi, w); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, id)
+	}
+
+	if err := commit(); err != nil {
 		return nil, err
 	}
-	return bi, nil
+	return blocked, nil
 }
 
-func (b DatastoreBlocklist) Purge(id cid.Cid) error {
+// entry returns the key and entry that block `id` with `data`. If `id` was
+// blocked and unblocked before, the entry supersedes the unblocked one.
+func (b DatastoreBlocklist) entry(ctx context.Context, id cid.Cid, data BlockData) (ds.Key, *BlocklistItem, error) {
 	k, err := b.cidToKey(id)
 	if err != nil {
+		return k, nil, err
+	}
+
+	data, err = rehash(ctx, b.datastore, id, data)
+	if err != nil {
+		return k, nil, err
+	}
+	now := time.Now()
+	bi := &BlocklistItem{
+		Hash:      id.String(),
+		Content:   data.Content,
+		User:      data.User,
+		Reason:    data.Reason,
+		Metadata:  data.Metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+
+		References: data.References,
+	}
+	if prev, err := b.historystore.Get(k); err == nil {
+		bi.Supersedes = &BlocklistItem{}
+		if err := decode(prev, bi.Supersedes); err != nil {
+			return k, nil, err
+		}
+	} else if err != ds.ErrNotFound {
+		return k, nil, err
+	}
+	for _, d := range data.Digests {
+		bi.Digests = append(bi.Digests, d.String())
+	}
+	return k, bi, nil
+}
+
+// put writes the entry `bi` stored at `k` to `w`, with its digests and index
+// entries.
+func (b DatastoreBlocklist) put(k ds.Key, bi *BlocklistItem, w dsWrites) error {
+	for _, d := range bi.Digests {
+		dk, err := b.digestKey(d)
+		if err != nil {
+			return err
+		}
+		if err := w.digests.Put(dk, k.Bytes()); err != nil {
+			return err
+		}
+	}
+	for _, c := range bi.Content {
+		if err := w.content.Put(contentKey(c).Child(k), nil); err != nil {
+			return err
+		}
+	}
+	rawBi, err := encode(b.entryCodec, bi)
+	if err != nil {
+		return err
+	}
+	if err := w.entries.Put(k, rawBi); err != nil {
 		return err
 	}
-	return b.datastore.Delete(k)
+	return w.history.Delete(k)
 }
 
-func (b DatastoreBlocklist) GetLogs(limit int) ([]*Action, error) {
-	rr, err := b.auditstore.Query(dsq.Query{
-		Orders: []dsq.Order{dsq.OrderByKeyDescending{}},
-		Limit:  limit,
-	})
+// digestKey returns the key of the digest `d` of an entry.
+func (b DatastoreBlocklist) digestKey(d string) (ds.Key, error) {
+	dc, err := cid.Decode(d)
+	if err != nil {
+		return ds.Key{}, err
+	}
+	return b.cidToKey(dc)
+}
+
+// contentKey returns the key under which entries are indexed by their
+// content `c`.
+func contentKey(c string) ds.Key {
+	return dshelp.NewKeyFromBinary([]byte(c))
+}
+
+// Unblock removes `id` from the blocklist, and returns the removed entry. The
+// entry is kept in the history namespace, and superseded if the content is
+// blocked again.
+func (b DatastoreBlocklist) Unblock(ctx context.Context, id cid.Cid) (removed *BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "unblock", id)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k, err := b.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	w, commit, err := b.batches()
 	if err != nil {
 		return nil, err
 	}
+	removed, err = b.remove(k, w)
+	if err != nil {
+		return nil, dsError(err)
+	}
+	if err := commit(); err != nil {
+		return nil, err
+	}
+	removed.Comments, err = b.comments(k)
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
 
-	// Unsplit ids
-	acts := make([]*Action, 0, limit)
-	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
-		l := &Action{}
-		err := l.UnmarshalBinary(res.Value)
+// UnblockMany unblocks all of `ids` in one datastore batch. The returned map
+// is true for the ids that were blocked and have been removed.
+func (b DatastoreBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "datastore", "unblockmany", cid.Undef)
+
+	w, commit, err := b.batches()
+	if err != nil {
+		return nil, err
+	}
+
+	res = make(map[cid.Cid]bool, len(ids))
+	removed := make(map[ds.Key]bool, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		k, err := b.resolve(id)
 		if err != nil {
 			return nil, err
+		} else if removed[k] {
+			res[id] = true
+			continue
 		}
-		acts = append(acts, l)
+
+		_, err = b.remove(k, w)
+		err = dsError(err)
+		if err == ErrNotFound {
+			res[id] = false
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		res[id], removed[k] = true, true
 	}
 
-	return acts, nil
+	if err := commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
-func (b DatastoreBlocklist) AddLog(act *Action) error {
-	if act.Typ != "block" && act.Typ != "unblock" {
-		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+// Update changes the Reason, Content, Metadata, and References of the entry
+// `id` belongs to to those set in `patch`, and logs an "update" action of
+// `patch.User`. If nothing changes, nothing is logged.
+func (b DatastoreBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) (err error) {
+	defer wrapError(&err, "datastore", "update", id)
+
+	if patch, err = patch.validate(true); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+	k, err := b.resolve(id)
+	if err != nil {
+		return err
+	}
+	old, err := b.get(k)
+	if err != nil {
+		return err
+	}
+	bi := old.patch(patch)
+	act := updateAction(id, old, bi, patch.User)
+	if len(act.Changes) == 0 {
+		return nil
 	}
-	log.Info(act.String())
 
-	k := ds.NewKey(act.CreatedAt.Format(time.RFC3339))
-	rawLi, err := act.MarshalBinary()
+	bi.UpdatedAt = time.Now()
+	w, commit, err := b.batches()
 	if err != nil {
 		return err
 	}
-	b.auditstore.Put(k, rawLi)
+	for _, c := range old.Content {
+		if err := w.content.Delete(contentKey(c).Child(k)); err != nil {
+			return err
+		}
+	}
+	if err := b.put(k, bi, w); err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	return b.AddLog(ctx, act)
+}
 
-	return nil
+// resolve returns the key of the entry `id` belongs to.
+func (b DatastoreBlocklist) resolve(id cid.Cid) (ds.Key, error) {
+	k, err := b.cidToKey(id)
+	if err != nil {
+		return k, err
+	}
+	return b.entryKey(k)
+}
+
+// remove deletes the entry stored at `k` from `w`, with its digests and index
+// entries, and returns it. The entry is kept in the history, to be superseded
+// if the content is blocked again.
+func (b DatastoreBlocklist) remove(k ds.Key, w dsWrites) (*BlocklistItem, error) {
+	v, err := b.safemodestore.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	bi := &BlocklistItem{}
+	if err := decode(v, bi); err != nil {
+		return nil, err
+	}
+	for _, d := range bi.Digests {
+		dk, err := b.digestKey(d)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.digests.Delete(dk); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range bi.Content {
+		if err := w.content.Delete(contentKey(c).Child(k)); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	bi.UnblockedAt = &now
+	if v, err = encode(b.entryCodec, bi); err != nil {
+		return nil, err
+	}
+	if err := w.history.Put(k, v); err != nil {
+		return nil, err
+	}
+	return bi, w.entries.Delete(k)
+}
+
+func (b DatastoreBlocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "search", id)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k, err := b.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	bi, err := b.get(k)
+	if err != nil {
+		return nil, err
+	}
+	if bi.Comments, err = b.comments(k); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+// SearchHistory returns the last unblocked entry of `id`, which links to the
+// entries unblocked before it through Supersedes. If `id` was never
+// unblocked, ErrNotFound is returned. Digests of unblocked entries aren't
+// matched.
+func (b DatastoreBlocklist) SearchHistory(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "searchhistory", id)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k, err := b.cidToKey(id)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.historystore.Get(k)
+	if err != nil {
+		return nil, dsError(err)
+	}
+	item = &BlocklistItem{}
+	if err := decode(v, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// AddComment appends `c` to the comments of the entry `id` belongs to, and
+// sets its CreatedAt.
+func (b DatastoreBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) (err error) {
+	defer wrapError(&err, "datastore", "addcomment", id)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if err := c.validate(); err != nil {
+		return err
+	}
+	k, err := b.resolve(id)
+	if err != nil {
+		return err
+	}
+	if _, err := b.get(k); err != nil {
+		return err
+	}
+
+	c.CreatedAt = time.Now()
+	raw, err := encode(b.commentCodec, c)
+	if err != nil {
+		return err
+	}
+	// Comments sort by the time they were added.
+	return b.commentstore.Put(k.ChildString(fmt.Sprintf("%020d", c.CreatedAt.UnixNano())), raw)
+}
+
+// comments returns the comments on the entry stored at `k`, oldest first.
+func (b DatastoreBlocklist) comments(k ds.Key) ([]Comment, error) {
+	rr, err := b.commentstore.Query(dsq.Query{
+		Prefix: k.String(),
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+
+	var comments []Comment
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return nil, res.Error
+		} else if !ds.RawKey(res.Key).Parent().Equal(k) {
+			continue
+		}
+		var c Comment
+		if err := decode(res.Value, &c); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// SearchMany returns the metadata of all of `ids`. The returned map is nil for
+// the ids that aren't blocked.
+func (b DatastoreBlocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "searchmany", cid.Undef)
+
+	res = make(map[cid.Cid]*BlocklistItem, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Errors keep the failing id, see wrapError.
+		k, err := b.resolve(id)
+		if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		bi, err := b.get(k)
+		if err == ErrNotFound {
+			res[id] = nil
+			continue
+		} else if err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		if bi.Comments, err = b.comments(k); err != nil {
+			return nil, &Error{Id: id, Err: err}
+		}
+		res[id] = bi
+	}
+	return res, nil
+}
+
+// SearchByContent returns the entries that have `url` as one of their Content
+// entries, using the content index.
+func (b DatastoreBlocklist) SearchByContent(ctx context.Context, url string) (items []*BlocklistItem, err error) {
+	defer wrapError(&err, "datastore", "searchbycontent", cid.Undef)
+
+	ck := contentKey(strings.TrimSpace(url))
+	rr, err := b.contentstore.Query(dsq.Query{
+		Prefix:   ck.String(),
+		KeysOnly: true,
+		Orders:   []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		} else if res.Error != nil {
+			return nil, res.Error
+		}
+		ik := ds.RawKey(res.Key)
+		if !ik.Parent().Equal(ck) {
+			continue
+		}
+		k := ds.RawKey("/" + ik.BaseNamespace())
+		bi, err := b.get(k)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if bi.Comments, err = b.comments(k); err != nil {
+			return nil, err
+		}
+		items = append(items, bi)
+	}
+	return items, nil
+}
+
+func (b DatastoreBlocklist) get(k ds.Key) (*BlocklistItem, error) {
+	v, err := b.safemodestore.Get(k)
+	if err != nil {
+		return nil, dsError(err)
+	}
+
+	bi := &BlocklistItem{}
+	err = decode(v, bi)
+	if err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+func (b DatastoreBlocklist) Purge(ctx context.Context, id cid.Cid) (err error) {
+	defer wrapError(&err, "datastore", "purge", id)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Blocks are stored under their CIDv1, regardless of the Transformer.
+	block, err := Normalize(id)
+	if err != nil {
+		return err
+	}
+	// The block and the entry, which lives under the root namespace, are
+	// written in one batch of the datastore.
+	batch, err := b.datastore.Batch()
+	if err != nil {
+		return err
+	}
+	if err := batch.Delete(dshelp.CidToDsKey(block)); err != nil {
+		return dsError(err)
+	}
+
+	// Mark the entry of the content as purged, if it is blocked.
+	k, err := b.resolve(id)
+	if err != nil {
+		return err
+	}
+	bi, err := b.get(k)
+	if err == ErrNotFound {
+		return dsError(batch.Commit())
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	bi.PurgedAt = &now
+	raw, err := encode(b.entryCodec, bi)
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(b.prefixes.root.Child(b.prefixes.entries).Child(k), raw); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// Count returns the number of blocklist entries. It has to go over all keys.
+func (b DatastoreBlocklist) Count(ctx context.Context) (count int64, err error) {
+	defer wrapError(&err, "datastore", "count", cid.Undef)
+
+	rr, err := b.safemodestore.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		} else if res.Error != nil {
+			return 0, res.Error
+		}
+		count++
+	}
+	return count, nil
+}
+
+// List returns a page of blocklist entries, in the order of their keys.
+// Cursors are the key of the last entry of the previous page.
+func (b DatastoreBlocklist) List(ctx context.Context, opts ListOptions) (page *ListPage, err error) {
+	defer wrapError(&err, "datastore", "list", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	limit := opts.limit()
+	query := dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+		Limit:  limit + 1,
+	}
+	op := dsq.GreaterThan
+	if opts.Order == ListDescending {
+		query.Orders = []dsq.Order{dsq.OrderByKeyDescending{}}
+		op = dsq.LessThan
+	}
+	if opts.Cursor != "" {
+		query.Filters = []dsq.Filter{dsq.FilterKeyCompare{Op: op, Key: opts.Cursor}}
+	} else {
+		query.Offset = opts.Offset
+	}
+	if opts.Ref != (Reference{}) {
+		query.Filters = append(query.Filters, refFilter{opts.Ref})
+	}
+	rr, err := b.safemodestore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+
+	page = &ListPage{}
+	var lastKey string
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		} else if res.Error != nil {
+			return nil, res.Error
+		}
+		if len(page.Items) == limit {
+			page.Next = lastKey
+			break
+		}
+		bi := &BlocklistItem{}
+		if err := decode(res.Value, bi); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, bi)
+		lastKey = res.Key
+	}
+	return page, nil
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+func (b DatastoreBlocklist) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "datastore", "getlogs", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.seq.mu.Lock()
+	err = b.loadSeq()
+	b.seq.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	query := dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKeyDescending{}},
+		Limit:  q.Limit,
+	}
+	if q.Before > 0 {
+		query.Filters = append(query.Filters, dsq.FilterKeyCompare{
+			Op:  dsq.LessThan,
+			Key: seqKey(q.Before).String(),
+		})
+	}
+	if q.filters() {
+		query.Filters = append(query.Filters, logFilter{q})
+	}
+	rr, err := b.auditstore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rr.Close()
+
+	// Unsplit ids
+	acts = make([]*Action, 0, q.Limit)
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		} else if res.Error != nil {
+			return nil, res.Error
+		}
+		l := &Action{}
+		err := decode(res.Value, l)
+		if err != nil {
+			return nil, err
+		}
+		acts = append(acts, l)
+	}
+
+	return acts, nil
+}
+
+// logFilter filters audit actions with the filters of a LogQuery.
+type logFilter struct {
+	q LogQuery
+}
+
+func (f logFilter) Filter(e dsq.Entry) bool {
+	act := &Action{}
+	if err := decode(e.Value, act); err != nil {
+		return false
+	}
+	return f.q.Match(act)
+}
+
+// refFilter filters blocklist entries by reference.
+type refFilter struct {
+	ref Reference
+}
+
+func (f refFilter) Filter(e dsq.Entry) bool {
+	bi := &BlocklistItem{}
+	if err := decode(e.Value, bi); err != nil {
+		return false
+	}
+	return hasReference(bi.References, f.ref)
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq.
+func (b DatastoreBlocklist) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "datastore", "addlog", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	b.seq.mu.Lock()
+	defer b.seq.mu.Unlock()
+	if err := b.loadSeq(); err != nil {
+		return err
+	}
+
+	seq := b.seq.last + 1
+	act.Seq = seq
+	rawLi, err := encode(b.auditCodec, act)
+	if err != nil {
+		return err
+	}
+	if err := b.auditstore.Put(seqKey(seq), rawLi); err != nil {
+		return err
+	}
+	b.seq.last = seq
+
+	return nil
+}
+
+// Scan calls `fn` with every key and raw value stored by the blocklist under
+// `prefix`, like BlocklistPrefix or AuditPrefix. Both `prefix` and the keys
+// given to `fn` are relative to the root prefix. Scanning stops at the first
+// error returned by `fn`, which is returned.
+//
+// Scan is meant for operational tooling, like repair scripts and space
+// accounting. Values are encoded with the codec they were written with.
+func (b DatastoreBlocklist) Scan(ctx context.Context, prefix ds.Key, fn func(k ds.Key, v []byte) error) (err error) {
+	defer wrapError(&err, "datastore", "scan", cid.Undef)
+
+	rr, err := b.rootstore.Query(dsq.Query{
+		Prefix: prefix.String(),
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+	})
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+
+	root := prefix.String() == "/"
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if res.Error != nil {
+			return res.Error
+		}
+		// Prefixes match by string, so /blocklist would match /blocklist2.
+		k := ds.RawKey(res.Key)
+		if !root && !prefix.Equal(k) && !prefix.IsAncestorOf(k) {
+			continue
+		}
+		if err := fn(k, res.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Healthy returns an error if the datastore can't answer a lookup.
+func (b DatastoreBlocklist) Healthy(ctx context.Context) (err error) {
+	defer wrapError(&err, "datastore", "healthy", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err = b.safemodestore.Has(ds.NewKey("healthcheck"))
+	return err
+}
+
+// Close flushes every namespace of the blocklist to disk, and closes the
+// underlying datastore.
+func (b DatastoreBlocklist) Close(ctx context.Context) (err error) {
+	defer wrapError(&err, "datastore", "close", cid.Undef)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.rootstore.Sync(ds.NewKey("/")); err != nil {
+		return err
+	}
+	return b.datastore.Close()
+}
+
+// seqKey returns the key of the action with sequence number `seq`. Keys are
+// zero-padded so that they sort in sequence order.
+func seqKey(seq uint64) ds.Key {
+	return ds.NewKey(fmt.Sprintf("%020d", seq))
+}
+
+// logSeq hands out sequence numbers to actions added to an audit store.
+type logSeq struct {
+	mu     sync.Mutex
+	loaded bool
+	last   uint64
+}
+
+// loadSeq loads the last sequence number of the audit log, the first time it
+// is called. Actions written before actions had sequence numbers, under the
+// time they were created at, are renumbered first, see migrateLog. It must be
+// called with b.seq.mu held, and b.seq.last updated once an action is saved.
+func (b DatastoreBlocklist) loadSeq() error {
+	if b.seq.loaded {
+		return nil
+	}
+	rr, err := b.auditstore.Query(dsq.Query{
+		Orders:   []dsq.Order{dsq.OrderByKey{}},
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+
+	var legacy []ds.Key
+	last := uint64(0)
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return res.Error
+		}
+		k := ds.RawKey(res.Key)
+		if seq, err := strconv.ParseUint(k.Name(), 10, 64); err == nil {
+			if seq > last {
+				last = seq
+			}
+		} else {
+			legacy = append(legacy, k)
+		}
+	}
+	if len(legacy) > 0 {
+		if last, err = b.migrateLog(legacy); err != nil {
+			return err
+		}
+	}
+	b.seq.last, b.seq.loaded = last, true
+	return nil
+}
+
+// migrateLog renumbers the actions of the audit log so that those at the
+// `legacy` keys, written before actions had sequence numbers, come first, in
+// the order of their keys, which is the order they were created in. Actions
+// that have sequence numbers already are shifted after them, along with the
+// actions they undo. It returns the last sequence number.
+func (b DatastoreBlocklist) migrateLog(legacy []ds.Key) (uint64, error) {
+	shift := uint64(len(legacy))
+	batch, err := b.auditstore.Batch()
+	if err != nil {
+		return 0, err
+	}
+	// Deletes are queued before puts, so that a put of a renumbered action
+	// wins over the delete of the action that had its key.
+	var (
+		puts = make(map[ds.Key]*Action)
+		last = shift
+	)
+
+	rr, err := b.auditstore.Query(dsq.Query{})
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return 0, res.Error
+		}
+		k := ds.RawKey(res.Key)
+		seq, err := strconv.ParseUint(k.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		act := &Action{}
+		if err := decode(res.Value, act); err != nil {
+			return 0, fmt.Errorf("action %v: %w", seq, err)
+		}
+		act.Seq = seq + shift
+		if act.Undoes > 0 {
+			act.Undoes += shift
+		}
+		if act.Seq > last {
+			last = act.Seq
+		}
+		if err := batch.Delete(k); err != nil {
+			return 0, err
+		}
+		puts[seqKey(act.Seq)] = act
+	}
+	for i, k := range legacy {
+		raw, err := b.auditstore.Get(k)
+		if err != nil {
+			return 0, err
+		}
+		act := &Action{}
+		if err := decode(raw, act); err != nil {
+			return 0, fmt.Errorf("action %v: %w", k, err)
+		}
+		act.Seq = uint64(i + 1)
+		if err := batch.Delete(k); err != nil {
+			return 0, err
+		}
+		puts[seqKey(act.Seq)] = act
+	}
+
+	for k, act := range puts {
+		raw, err := encode(b.auditCodec, act)
+		if err != nil {
+			return 0, err
+		}
+		if err := batch.Put(k, raw); err != nil {
+			return 0, err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	log.Infof("renumbered %v audit actions written before sequence numbers", shift)
+	return last, nil
+}
+
+// multihashKey returns the key of the multihash of the CID `k` is the key of,
+// if `k` is the key of a CIDv1, as entries were keyed before they were keyed
+// on multihashes. Keys of multihashes are never keys of CIDv1s, as no hash
+// function has the code of CIDv1.
+func multihashKey(k ds.Key) (ds.Key, bool) {
+	raw, err := dshelp.BinaryFromDsKey(k)
+	if err != nil {
+		return k, false
+	}
+	id, err := cid.Cast(raw)
+	if err != nil || id.Version() == 0 {
+		return k, false
+	}
+	return dshelp.NewKeyFromBinary(id.Hash()), true
+}
+
+// migrateKeys rekeys the entries of a store written when they were keyed on
+// their CIDs to the multihashes of the CIDs, along with their history,
+// digests, comments, and content index, in one batch, and records at
+// `layout` that the store is keyed on multihashes. Once it is recorded,
+// nothing is scanned again.
+//
+// Entries of the same content blocked under several CIDs, like a CIDv1 of
+// each codec, are merged into the one blocked first, with the digests and
+// content of the others. Their unblocked entries are chained by the time
+// they were unblocked at. Writers of the store have to be upgraded together,
+// as entries written under CIDs after the migration aren't matched.
+func (b DatastoreBlocklist) migrateKeys(layout ds.Key) error {
+	if done, err := b.rootstore.Has(layout); err != nil || done {
+		return err
+	}
+	batch, err := b.rootstore.Batch()
+	if err != nil {
+		return err
+	}
+	// Deletes are queued before puts, so that a put of a rekeyed value wins
+	// over the delete of the value that had its key.
+	var (
+		puts    = make(map[ds.Key][]byte)
+		deletes int
+	)
+	rekey := func(store ds.Datastore, prefix ds.Key, fn func(k ds.Key, v []byte) (ds.Key, []byte, error)) error {
+		return scanAll(store, func(k ds.Key, v []byte) error {
+			nk, nv, err := fn(k, v)
+			if err != nil || nk.Equal(k) {
+				return err
+			}
+			if err := batch.Delete(prefix.Child(k)); err != nil {
+				return err
+			}
+			deletes++
+			if nv != nil {
+				puts[prefix.Child(nk)] = nv
+			}
+			return nil
+		})
+	}
+
+	for _, ns := range []struct {
+		store  ds.Datastore
+		prefix ds.Key
+		merge  func(a, b *BlocklistItem) *BlocklistItem
+	}{
+		{b.safemodestore, b.prefixes.entries, mergeEntries},
+		{b.historystore, b.prefixes.history, mergeHistory},
+	} {
+		items := make(map[ds.Key]*BlocklistItem)
+		err := rekey(ns.store, ns.prefix, func(k ds.Key, v []byte) (ds.Key, []byte, error) {
+			mk, ok := multihashKey(k)
+			if !ok {
+				return k, nil, nil
+			}
+			bi := &BlocklistItem{}
+			if err := decode(v, bi); err != nil {
+				return k, nil, fmt.Errorf("entry %v: %w", k, err)
+			}
+			if kept, ok := items[mk]; ok {
+				bi = ns.merge(kept, bi)
+			} else if v, err := ns.store.Get(mk); err == nil {
+				kept := &BlocklistItem{}
+				if err := decode(v, kept); err != nil {
+					return k, nil, fmt.Errorf("entry %v: %w", mk, err)
+				}
+				bi = ns.merge(kept, bi)
+			} else if err != ds.ErrNotFound {
+				return k, nil, err
+			}
+			items[mk] = bi
+			// The merged entry is put once all are read.
+			return mk, nil, nil
+		})
+		if err != nil {
+			return err
+		}
+		for mk, bi := range items {
+			v, err := encode(b.entryCodec, bi)
+			if err != nil {
+				return err
+			}
+			puts[ns.prefix.Child(mk)] = v
+		}
+	}
+
+	err = rekey(b.digeststore, b.prefixes.digests, func(k ds.Key, v []byte) (ds.Key, []byte, error) {
+		mk, ok := multihashKey(k)
+		if !ok {
+			return k, nil, nil
+		}
+		if parent, ok := multihashKey(ds.RawKey(string(v))); ok {
+			v = parent.Bytes()
+		}
+		return mk, v, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Comments are stored under the key of their entry, and the content
+	// index under the content then the key of the entry.
+	err = rekey(b.commentstore, b.prefixes.comments, func(k ds.Key, v []byte) (ds.Key, []byte, error) {
+		ns := k.Namespaces()
+		if len(ns) != 2 {
+			return k, nil, nil
+		}
+		mk, ok := multihashKey(ds.NewKey(ns[0]))
+		if !ok {
+			return k, nil, nil
+		}
+		return mk.ChildString(ns[1]), v, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = rekey(b.contentstore, b.prefixes.content, func(k ds.Key, v []byte) (ds.Key, []byte, error) {
+		mk, ok := multihashKey(ds.NewKey(k.Name()))
+		if !ok {
+			return k, nil, nil
+		}
+		return k.Parent().Child(mk), []byte{}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range puts {
+		if err := batch.Put(k, v); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(layout, []byte("multihash")); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	if deletes > 0 {
+		log.Infof("rekeyed %v values of the blocklist to the multihashes of their CIDs", deletes)
+	}
+	return nil
+}
+
+// scanAll calls `fn` with the key and value of everything stored in `store`.
+func scanAll(store ds.Datastore, fn func(k ds.Key, v []byte) error) error {
+	rr, err := store.Query(dsq.Query{})
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return res.Error
+		}
+		if err := fn(ds.RawKey(res.Key), res.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeEntries returns the one of the entries `a` and `b` of the same content
+// that was blocked first, with the digests and content of the other.
+func mergeEntries(a, b *BlocklistItem) *BlocklistItem {
+	if b.CreatedAt.Before(a.CreatedAt) {
+		a, b = b, a
+	}
+	log.Warnf("merging entry %v into entry %v of the same content", redactHash(b.Hash), redactHash(a.Hash))
+	a.Digests = appendMissing(a.Digests, b.Digests...)
+	a.Content = appendMissing(a.Content, b.Content...)
+	return a
+}
+
+// mergeHistory returns the one of the unblocked entries `a` and `b` of the
+// same content that was unblocked last, with the other at the end of the
+// entries it supersedes.
+func mergeHistory(a, b *BlocklistItem) *BlocklistItem {
+	if a.UnblockedAt != nil && b.UnblockedAt != nil && b.UnblockedAt.After(*a.UnblockedAt) {
+		a, b = b, a
+	}
+	last := a
+	for last.Supersedes != nil {
+		last = last.Supersedes
+	}
+	last.Supersedes = b
+	return a
+}
+
+// appendMissing appends the elements of `add` that `s` doesn't have to `s`.
+func appendMissing(s []string, add ...string) []string {
+	for _, v := range add {
+		found := false
+		for _, w := range s {
+			found = found || v == w
+		}
+		if !found {
+			s = append(s, v)
+		}
+	}
+	return s
 }
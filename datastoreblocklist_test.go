@@ -0,0 +1,351 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+func TestGetLogsPagesPastLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// Actions written before actions had sequence numbers are keyed by the
+	// time they were created at.
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		act := &Action{Typ: ActionBlock, Reason: "legacy", User: "u@x.com", CreatedAt: start.Add(time.Duration(i) * time.Hour)}
+		raw, err := json.Marshal(act)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := SafemodePrefix.Child(AuditPrefix).Child(ds.NewKey(act.CreatedAt.Format(time.RFC3339)))
+		if err := d.Put(k, raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := NewDatastoreBlocklist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := b.AddLog(ctx, &Action{Typ: ActionBlock, Reason: "new", User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var acts []*Action
+	q := LogQuery{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging doesn't end")
+		}
+		page, err := b.GetLogs(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acts = append(acts, page...)
+		if len(page) < q.Limit {
+			break
+		}
+		q.Before = page[len(page)-1].Seq
+	}
+
+	if len(acts) != 5 {
+		t.Fatalf("got %v actions, want 5", len(acts))
+	}
+	for i, act := range acts {
+		if want := uint64(5 - i); act.Seq != want {
+			t.Errorf("action %v has Seq %v, want %v", i, act.Seq, want)
+		}
+	}
+	if acts[0].Reason != "new" || acts[2].Reason != "legacy" || !acts[4].CreatedAt.Equal(start) {
+		t.Errorf("legacy actions aren't ordered before new ones: %+v", acts)
+	}
+}
+
+func TestMigrateLogShiftsSequencedActions(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	b, err := NewDatastoreBlocklist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLog(ctx, &Action{Typ: ActionBlock, User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLog(ctx, &Action{Typ: ActionUndo, Undoes: 1, User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A legacy action next to sequenced ones, as left by a store written
+	// before and after sequence numbers were added.
+	raw, err := json.Marshal(&Action{Typ: ActionBlock, Reason: "legacy", User: "u@x.com", CreatedAt: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(SafemodePrefix.Child(AuditPrefix).ChildString("2021-01-01T00:00:00Z"), raw); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = NewDatastoreBlocklist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acts, err := b.GetLogs(ctx, LogQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acts) != 3 {
+		t.Fatalf("got %v actions, want 3", len(acts))
+	}
+	if acts[0].Seq != 3 || acts[0].Undoes != 2 || acts[1].Seq != 2 || acts[2].Seq != 1 || acts[2].Reason != "legacy" {
+		t.Errorf("unexpected renumbering: %+v %+v %+v", acts[0], acts[1], acts[2])
+	}
+}
+
+func TestBlockAppliesToEveryCodec(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	raw := testCID(t, "content")
+	v0, dagpb := cid.NewCidV0(raw.Hash()), cid.NewCidV1(cid.DagProtobuf, raw.Hash())
+
+	if _, err := b.Block(ctx, v0, BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []cid.Cid{raw, dagpb} {
+		if ok, err := b.Contains(ctx, id); err != nil || !ok {
+			t.Errorf("Contains(%v) = %v, %v, want true", id, ok, err)
+		}
+	}
+	existing, err := b.Block(ctx, raw, BlockData{Reason: "again", User: "u@x.com"})
+	if err != nil {
+		t.Fatal(err)
+	} else if existing == nil || existing.Reason != "test" {
+		t.Errorf("blocking another codec returned %+v, want the existing entry", existing)
+	}
+	if _, err := b.Unblock(ctx, dagpb); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Contains(ctx, v0); err != nil || ok {
+		t.Errorf("Contains(%v) = %v, %v after unblocking, want false", v0, ok, err)
+	}
+}
+
+func TestGetLogsMatchesEveryCodec(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	raw := testCID(t, "content")
+	if err := b.AddLog(ctx, &Action{Typ: ActionBlock, Ids: []cid.Cid{raw}, Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[cid.Cid]int{
+		cid.NewCidV0(raw.Hash()):                  1,
+		cid.NewCidV1(cid.DagProtobuf, raw.Hash()): 1,
+		testCID(t, "other"):                       0,
+	} {
+		acts, err := b.GetLogs(ctx, LogQuery{Id: id})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(acts) != want {
+			t.Errorf("GetLogs(%v) returned %v actions, want %v", id, len(acts), want)
+		}
+	}
+}
+
+func TestMigrateKeysRekeysCIDKeys(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	root := SafemodePrefix
+
+	// Entries written when they were keyed on their CIDs, for the same content
+	// under two codecs, each with a digest.
+	raw := testCID(t, "content")
+	dagpb := cid.NewCidV1(cid.DagProtobuf, raw.Hash())
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	put := func(k ds.Key, v interface{}) {
+		t.Helper()
+		var data []byte
+		if v != nil {
+			var err error
+			if data, err = encode(JSONCodec, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := d.Put(root.Child(k), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, id := range []cid.Cid{dagpb, raw} {
+		k := dshelp.CidToDsKey(id)
+		digest := testCID(t, fmt.Sprintf("digest %v", i))
+		put(BlocklistPrefix.Child(k), &BlocklistItem{
+			Hash:      id.String(),
+			Reason:    fmt.Sprintf("entry %v", i),
+			User:      "u@x.com",
+			Content:   []string{fmt.Sprintf("https://example.com/%v", i)},
+			Digests:   []string{digest.String()},
+			CreatedAt: start.Add(time.Duration(i) * time.Hour),
+		})
+		if err := d.Put(root.Child(DigestPrefix).Child(dshelp.CidToDsKey(digest)), k.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		put(ContentPrefix.Child(contentKey(fmt.Sprintf("https://example.com/%v", i))).Child(k), nil)
+		put(CommentPrefix.Child(k).ChildString(fmt.Sprintf("%020d", i)), &Comment{Author: "u@x.com", Text: fmt.Sprintf("comment %v", i)})
+	}
+
+	b, err := NewDatastoreBlocklist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := b.Search(ctx, cid.NewCidV0(raw.Hash()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Reason != "entry 0" || len(item.Digests) != 2 || len(item.Content) != 2 || len(item.Comments) != 2 {
+		t.Errorf("entries weren't merged into the first one: %+v", item)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, err := b.Contains(ctx, testCID(t, fmt.Sprintf("digest %v", i))); err != nil || !ok {
+			t.Errorf("digest %v isn't matched: %v, %v", i, ok, err)
+		}
+		items, err := b.SearchByContent(ctx, fmt.Sprintf("https://example.com/%v", i))
+		if err != nil || len(items) != 1 {
+			t.Errorf("content %v isn't indexed: %v, %v", i, items, err)
+		}
+	}
+	if n, err := b.Count(ctx); err != nil || n != 1 {
+		t.Errorf("Count() = %v, %v, want 1", n, err)
+	}
+
+	// Unblocking the merged entry removes everything it had.
+	if _, err := b.Unblock(ctx, raw); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, err := b.Contains(ctx, testCID(t, fmt.Sprintf("digest %v", i))); err != nil || ok {
+			t.Errorf("digest %v is still matched: %v, %v", i, ok, err)
+		}
+	}
+
+	// Once migrated, the store isn't scanned again.
+	if ok, err := d.Has(root.Child(LayoutPrefix).Child(BlocklistPrefix)); err != nil || !ok {
+		t.Errorf("the layout isn't recorded: %v, %v", ok, err)
+	}
+}
+
+// testDatastoreConformance checks that `d` behaves like the datastores
+// DatastoreBlocklist is built on, and that `b`, stored in `d`, blocks, finds,
+// lists, logs, and unblocks content. Query is checked with enough keys for
+// several pages of the remote datastores.
+func testDatastoreConformance(t *testing.T, d ds.Batching, b Blocklist) {
+	t.Helper()
+	ctx := context.Background()
+
+	k := ds.NewKey("/test/key")
+	if _, err := d.Get(k); err != ds.ErrNotFound {
+		t.Fatalf("Get of a missing key = %v, want ds.ErrNotFound", err)
+	}
+	if ok, err := d.Has(k); err != nil || ok {
+		t.Fatalf("Has of a missing key = %v, %v, want false", ok, err)
+	}
+	if err := d.Put(k, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(k); err != nil || string(v) != "value" {
+		t.Fatalf("Get = %q, %v, want \"value\"", v, err)
+	}
+	if n, err := d.GetSize(k); err != nil || n != 5 {
+		t.Fatalf("GetSize = %v, %v, want 5", n, err)
+	}
+	if err := d.Put(k, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(k); err != nil || v == nil || len(v) != 0 {
+		t.Fatalf("Get of an empty value = %q, %v, want an empty slice", v, err)
+	}
+	if err := d.Delete(k); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.Has(k); err != nil || ok {
+		t.Fatalf("Has of a deleted key = %v, %v, want false", ok, err)
+	}
+	if err := d.Delete(k); err != nil {
+		t.Fatalf("Delete of a missing key = %v, want nil", err)
+	}
+
+	batch, err := d.Batch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 2*firestorePage + 1
+	for i := 0; i < n; i++ {
+		if err := batch.Put(ds.NewKey(fmt.Sprintf("/test/many/%04d", i)), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Keys that share the prefix as a string, but not as a key.
+	if err := batch.Put(ds.NewKey("/test/manyother"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, keysOnly := range []bool{false, true} {
+		res, err := d.Query(dsq.Query{Prefix: "/test/many", KeysOnly: keysOnly})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(entries) != n {
+			t.Fatalf("Query returned %v entries, want %v", len(entries), n)
+		}
+		for i, e := range entries {
+			if want := fmt.Sprintf("/test/many/%04d", i); e.Key != want {
+				t.Fatalf("entry %v is %v, want %v", i, e.Key, want)
+			} else if !keysOnly && (len(e.Value) != 1 || e.Value[0] != byte(i)) {
+				t.Fatalf("entry %v has value %v, want %v", e.Key, e.Value, []byte{byte(i)})
+			}
+		}
+	}
+
+	if r := SelfTest(ctx, b); !r.Ok() {
+		t.Fatal(r.Err())
+	}
+	raw, digest := testCID(t, "content"), testCID(t, "digest")
+	if _, err := b.Block(ctx, raw, BlockData{Reason: "test", User: "u@x.com", Digests: []cid.Cid{digest}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []cid.Cid{cid.NewCidV0(raw.Hash()), cid.NewCidV1(cid.DagProtobuf, digest.Hash())} {
+		if ok, err := b.Contains(ctx, id); err != nil || !ok {
+			t.Errorf("Contains(%v) = %v, %v, want true", id, ok, err)
+		}
+	}
+	if item, err := b.Search(ctx, digest); err != nil || item.Reason != "test" {
+		t.Errorf("Search of the digest = %+v, %v", item, err)
+	}
+	if page, err := b.List(ctx, ListOptions{}); err != nil || len(page.Items) != 1 {
+		t.Errorf("List = %+v, %v, want 1 entry", page, err)
+	}
+	if _, err := b.Unblock(ctx, cid.NewCidV1(cid.DagProtobuf, raw.Hash())); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.Contains(ctx, digest); err != nil || ok {
+		t.Errorf("Contains of the digest = %v, %v after unblocking, want false", ok, err)
+	}
+	if _, err := b.SearchHistory(ctx, raw); err != nil {
+		t.Errorf("SearchHistory: %v", err)
+	}
+}
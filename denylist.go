@@ -0,0 +1,331 @@
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultDenylistInterval is how often a DenylistBlocklist checks its file
+// for changes.
+const DefaultDenylistInterval = 5 * time.Second
+
+// Denylist is a parsed denylist file. Each line is a rule:
+//
+//	# comments and blank lines are skipped
+//	bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi
+//	/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi
+//	/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/some/path
+//	/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/dir/*
+//	/ipns/example.com
+//	!/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/some/path
+//
+// Rules starting with "!" allow what an earlier rule blocked. Paths ending in
+// "*" block every path they prefix. Files may start with a header ended by a
+// "---" line, like the compact denylist format, which is skipped.
+type Denylist struct {
+	ids   map[cid.Cid]bool
+	paths map[cid.Cid][]denyPath
+	names map[string]bool
+}
+
+// denyPath is a path rule under a CID.
+type denyPath struct {
+	path   string
+	prefix bool
+	allow  bool
+}
+
+// ParseDenylist parses the denylist `r`, normalizing CIDs with `t`, or CIDv1
+// if it is nil. Errors report the line of the rule that can't be parsed.
+func ParseDenylist(r io.Reader, t Transformer) (*Denylist, error) {
+	l := &Denylist{
+		ids:   make(map[cid.Cid]bool),
+		paths: make(map[cid.Cid][]denyPath),
+		names: make(map[string]bool),
+	}
+	var (
+		lines   []string
+		header  bool
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "---" {
+			header = true
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	inHeader := header
+	for i, line := range lines {
+		if inHeader {
+			inHeader = line != "---"
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := l.add(line, t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return l, nil
+}
+
+// add adds the rule `line`.
+func (l *Denylist) add(line string, t Transformer) error {
+	allow := strings.HasPrefix(line, "!")
+	rule := strings.TrimPrefix(line, "!")
+
+	if strings.HasPrefix(rule, "/ipns/") {
+		name := nameKey(rule)
+		if allow {
+			delete(l.names, name)
+		} else {
+			l.names[name] = true
+		}
+		return nil
+	}
+
+	rule = strings.TrimPrefix(rule, "/ipfs/")
+	root, path := rule, ""
+	if i := strings.IndexByte(rule, '/'); i >= 0 {
+		root, path = rule[:i], rule[i:]
+	}
+	id, err := cid.Decode(root)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	if id, err = normalize(t, id); err != nil {
+		return err
+	}
+
+	if path == "" || path == "/" {
+		if allow {
+			delete(l.ids, id)
+		} else {
+			l.ids[id] = true
+		}
+		return nil
+	}
+	p := denyPath{path: strings.TrimSuffix(path, "*"), prefix: strings.HasSuffix(path, "*"), allow: allow}
+	l.paths[id] = append(l.paths[id], p)
+	return nil
+}
+
+// Len returns the number of CID, path, and name rules, not counting allow
+// rules.
+func (l *Denylist) Len() int {
+	n := len(l.ids) + len(l.names)
+	for _, paths := range l.paths {
+		for _, p := range paths {
+			if !p.allow {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// blocksPath returns true if the rules of `path` under the normalized `id`
+// block it. The last matching rule wins.
+func (l *Denylist) blocksPath(id cid.Cid, path string) bool {
+	if l.ids[id] {
+		return true
+	}
+	blocked := false
+	for _, p := range l.paths[id] {
+		if path == p.path || (p.prefix && strings.HasPrefix(path, p.path)) {
+			blocked = !p.allow
+		}
+	}
+	return blocked
+}
+
+// DenylistOption configures a DenylistBlocklist.
+type DenylistOption func(*denylistOptions)
+
+type denylistOptions struct {
+	interval  time.Duration
+	transform Transformer
+}
+
+// WithDenylistInterval sets how often the file is checked for changes,
+// instead of DefaultDenylistInterval.
+func WithDenylistInterval(d time.Duration) DenylistOption {
+	return func(o *denylistOptions) {
+		o.interval = d
+	}
+}
+
+// WithDenylistTransformer canonicalizes CIDs with `t` instead of CIDv1, for
+// instance Multihash to match rules under any codec.
+func WithDenylistTransformer(t Transformer) DenylistOption {
+	return func(o *denylistOptions) {
+		o.transform = t
+	}
+}
+
+// DenylistBlocklist is a read-only blocklist loaded from a denylist file, for
+// operators who manage denylists with configuration management rather than a
+// database. The file is checked for changes every interval, and reloaded
+// when its size or modification time changed. The new rules replace the
+// previous ones at once; if the file can't be parsed, the previous rules
+// stay in place.
+type DenylistBlocklist struct {
+	path      string
+	transform Transformer
+	interval  time.Duration
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu      sync.RWMutex
+	current *Denylist
+	size    int64
+	modTime time.Time
+	lastErr error
+}
+
+// NewDenylistBlocklist loads the denylist file `path`, and reloads it until
+// Close is called. It fails if the file can't be loaded.
+func NewDenylistBlocklist(path string, opts ...DenylistOption) (*DenylistBlocklist, error) {
+	o := &denylistOptions{interval: DefaultDenylistInterval, transform: CIDv1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	b := &DenylistBlocklist{
+		path:      path,
+		transform: o.transform,
+		interval:  o.interval,
+		done:      make(chan struct{}),
+	}
+	if _, err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.reload(ctx)
+	return b, nil
+}
+
+// reload reloads the file every interval until `ctx` is done.
+func (b *DenylistBlocklist) reload(ctx context.Context) {
+	defer close(b.done)
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := b.Reload(); err != nil {
+				log.Warnf("reloading denylist %v: %v", b.path, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reload loads the file if it changed since it was last loaded, and returns
+// true if it did.
+func (b *DenylistBlocklist) Reload() (changed bool, err error) {
+	defer func() {
+		b.mu.Lock()
+		b.lastErr = err
+		b.mu.Unlock()
+	}()
+
+	f, err := os.Open(b.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	b.mu.RLock()
+	unchanged := b.current != nil && fi.Size() == b.size && fi.ModTime().Equal(b.modTime)
+	b.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	l, err := ParseDenylist(f, b.transform)
+	if err != nil {
+		return false, fmt.Errorf("%v: %w", b.path, err)
+	}
+	b.mu.Lock()
+	b.current, b.size, b.modTime = l, fi.Size(), fi.ModTime()
+	b.mu.Unlock()
+	log.Infof("loaded denylist %v with %v rules", b.path, l.Len())
+	return true, nil
+}
+
+// denylist returns the current rules.
+func (b *DenylistBlocklist) denylist() *Denylist {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.current
+}
+
+// Contains returns true if a rule blocks `id` as a whole.
+func (b *DenylistBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "denylist", "contains", id)
+
+	if id, err = normalize(b.transform, id); err != nil {
+		return false, err
+	}
+	return b.denylist().ids[id], nil
+}
+
+// ContainsPath returns true if a rule blocks `id` as a whole, or the path
+// `path` under it, like "/some/file".
+func (b *DenylistBlocklist) ContainsPath(ctx context.Context, id cid.Cid, path string) (exists bool, err error) {
+	defer wrapError(&err, "denylist", "containspath", id)
+
+	if id, err = normalize(b.transform, id); err != nil {
+		return false, err
+	}
+	return b.denylist().blocksPath(id, path), nil
+}
+
+// ContainsName returns true if a rule blocks the /ipns/ name `name`.
+func (b *DenylistBlocklist) ContainsName(ctx context.Context, name string) (bool, error) {
+	return b.denylist().names[nameKey(name)], nil
+}
+
+// Len returns the number of rules of the current file.
+func (b *DenylistBlocklist) Len() int {
+	return b.denylist().Len()
+}
+
+// Healthy returns the error of the last reload, if it failed.
+func (b *DenylistBlocklist) Healthy(ctx context.Context) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastErr
+}
+
+// Close stops reloading the file.
+func (b *DenylistBlocklist) Close(ctx context.Context) error {
+	b.cancel()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,597 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// DefaultEtcdTimeout is how long requests to etcd may take, except watches.
+const DefaultEtcdTimeout = 5 * time.Second
+
+// ErrCompacted is returned by EtcdBlocklist.Watch if the revision to watch
+// from was compacted. The watcher has to reload the blocklist.
+var ErrCompacted = fmt.Errorf("etcd revision was compacted")
+
+// etcdTxnOps is the maximum number of operations of one transaction, the
+// default --max-txn-ops of etcd.
+const etcdTxnOps = 128
+
+// etcdPageSize is the number of keys read per request by Query.
+const etcdPageSize = 1000
+
+// EtcdOption configures an Etcd.
+type EtcdOption func(*etcdOptions)
+
+type etcdOptions struct {
+	client   *http.Client
+	timeout  time.Duration
+	user     string
+	password string
+}
+
+// WithEtcdHTTPClient sends requests with `c` instead of http.DefaultClient.
+// The client shouldn't have a Timeout, as it would end watches.
+func WithEtcdHTTPClient(c *http.Client) EtcdOption {
+	return func(o *etcdOptions) {
+		o.client = c
+	}
+}
+
+// WithEtcdTimeout sets how long requests may take, instead of
+// DefaultEtcdTimeout.
+func WithEtcdTimeout(d time.Duration) EtcdOption {
+	return func(o *etcdOptions) {
+		o.timeout = d
+	}
+}
+
+// WithEtcdCredentials authenticates as `user`, if etcd has authentication
+// enabled.
+func WithEtcdCredentials(user, password string) EtcdOption {
+	return func(o *etcdOptions) {
+		o.user, o.password = user, password
+	}
+}
+
+// Etcd is a datastore stored in etcd, through the JSON gateway of its v3 API.
+// Reads are linearizable: they reflect every write acknowledged before them,
+// by any client.
+//
+// Requests go to the endpoint that answered last, and to the others in turn
+// if it is unreachable. Batches are written in transactions of up to 128
+// operations, the default limit of etcd, so larger batches aren't atomic.
+type Etcd struct {
+	endpoints []string
+	client    *http.Client
+	timeout   time.Duration
+	user      string
+	password  string
+
+	mu      sync.Mutex
+	current int    // current is the index of the endpoint that answered last.
+	token   string // token authenticates requests, if there are credentials.
+}
+
+var _ ds.Batching = (*Etcd)(nil)
+
+// NewEtcd returns a datastore stored in the etcd cluster serving
+// `endpoints`, like "https://etcd-0.internal:2379".
+func NewEtcd(endpoints []string, opts ...EtcdOption) *Etcd {
+	o := &etcdOptions{
+		client:  http.DefaultClient,
+		timeout: DefaultEtcdTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	trimmed := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		trimmed[i] = strings.TrimSuffix(e, "/")
+	}
+	return &Etcd{
+		endpoints: trimmed,
+		client:    o.client,
+		timeout:   o.timeout,
+		user:      o.user,
+		password:  o.password,
+	}
+}
+
+// etcdError is an error response of the gateway.
+type etcdError struct {
+	RPC     string
+	Status  int // Status is the HTTP status code.
+	Code    int // Code is the gRPC status code.
+	Message string
+}
+
+func (e *etcdError) Error() string {
+	return fmt.Sprintf("etcd: %v: %v (code %v)", e.RPC, e.Message, e.Code)
+}
+
+// gRPC status codes the gateway responds with.
+const (
+	etcdDeadlineExceeded = 4
+	etcdUnavailable      = 14
+	etcdUnauthenticated  = 16
+)
+
+// unavailable returns true if the request may succeed on another endpoint,
+// or later.
+func (e *etcdError) unavailable() bool {
+	return e.Status == http.StatusServiceUnavailable || e.Code == etcdUnavailable || e.Code == etcdDeadlineExceeded
+}
+
+// post sends `body` to the RPC `rpc`, like "/v3/kv/range", and returns the
+// response of the first endpoint that accepts it. Error responses are
+// returned as an *etcdError, wrapped in ErrBackendUnavailable if no endpoint
+// is available, as are network failures.
+func (e *Etcd) post(ctx context.Context, rpc string, body []byte, token string) (*http.Response, error) {
+	e.mu.Lock()
+	start := e.current
+	e.mu.Unlock()
+
+	var lastErr error
+	for i := range e.endpoints {
+		n := (start + i) % len(e.endpoints)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoints[n]+rpc, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := e.client.Do(req)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			e.mu.Lock()
+			e.current = n
+			e.mu.Unlock()
+			return resp, nil
+		}
+
+		raw, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		apiErr := &etcdError{RPC: rpc, Status: resp.StatusCode, Message: resp.Status}
+		var res struct {
+			Code    int
+			Message string
+		}
+		if json.Unmarshal(raw, &res) == nil && res.Message != "" {
+			apiErr.Code, apiErr.Message = res.Code, res.Message
+		}
+		if !apiErr.unavailable() {
+			return nil, apiErr
+		}
+		lastErr = apiErr
+	}
+	return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, lastErr)
+}
+
+// authenticate returns the token of the credentials of `e`, requesting a new
+// one if `renew` is true.
+func (e *Etcd) authenticate(ctx context.Context, renew bool) (string, error) {
+	if e.user == "" {
+		return "", nil
+	}
+	e.mu.Lock()
+	token := e.token
+	e.mu.Unlock()
+	if token != "" && !renew {
+		return token, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"name": e.user, "password": e.password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := e.post(ctx, "/v3/auth/authenticate", body, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Token string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("etcd: authenticating: %w", err)
+	}
+	e.mu.Lock()
+	e.token = res.Token
+	e.mu.Unlock()
+	return res.Token, nil
+}
+
+// open sends `req` as JSON to the RPC `rpc`, authenticating it if needed, and
+// returns the response.
+func (e *Etcd) open(ctx context.Context, rpc string, req interface{}) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	token, err := e.authenticate(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.post(ctx, rpc, body, token)
+	var apiErr *etcdError
+	if errors.As(err, &apiErr) && apiErr.Code == etcdUnauthenticated && e.user != "" {
+		// The token expired.
+		if token, err = e.authenticate(ctx, true); err != nil {
+			return nil, err
+		}
+		resp, err = e.post(ctx, rpc, body, token)
+	}
+	return resp, err
+}
+
+// call sends `req` to the RPC `rpc`, and decodes the response into `res`.
+func (e *Etcd) call(ctx context.Context, rpc string, req, res interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	resp, err := e.open(ctx, rpc, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("etcd: %v: %w", rpc, err)
+	}
+	return nil
+}
+
+// Keys and values are bytes, which encoding/json encodes in base64, like the
+// gateway. 64-bit integers are encoded as strings.
+type (
+	etcdHeader struct {
+		Revision int64 `json:"revision,string"`
+	}
+	etcdKV struct {
+		Key         []byte `json:"key"`
+		Value       []byte `json:"value,omitempty"`
+		ModRevision int64  `json:"mod_revision,string"`
+	}
+	etcdRangeRequest struct {
+		Key       []byte `json:"key"`
+		RangeEnd  []byte `json:"range_end,omitempty"`
+		Limit     int64  `json:"limit,string,omitempty"`
+		Revision  int64  `json:"revision,string,omitempty"`
+		KeysOnly  bool   `json:"keys_only,omitempty"`
+		CountOnly bool   `json:"count_only,omitempty"`
+	}
+	etcdRangeResponse struct {
+		Header etcdHeader
+		Kvs    []etcdKV
+		More   bool
+		Count  int64 `json:"count,string"`
+	}
+	etcdPutRequest struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	}
+	etcdDeleteRequest struct {
+		Key []byte `json:"key"`
+	}
+	etcdOp struct {
+		RequestPut         *etcdPutRequest    `json:"request_put,omitempty"`
+		RequestDeleteRange *etcdDeleteRequest `json:"request_delete_range,omitempty"`
+	}
+	etcdTxnRequest struct {
+		Success []etcdOp `json:"success"`
+	}
+)
+
+// etcdPrefixEnd returns the end of the range of keys that start with
+// `prefix`.
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every key is after the prefix.
+	return []byte{0}
+}
+
+// etcdKeyPrefix returns the prefix of the keys under `k`.
+func etcdKeyPrefix(k ds.Key) []byte {
+	if k.String() == "/" {
+		return []byte("/")
+	}
+	return []byte(k.String() + "/")
+}
+
+func (e *Etcd) Get(k ds.Key) ([]byte, error) {
+	var res etcdRangeResponse
+	if err := e.call(context.Background(), "/v3/kv/range", etcdRangeRequest{Key: k.Bytes()}, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, ds.ErrNotFound
+	}
+	return res.Kvs[0].Value, nil
+}
+
+func (e *Etcd) Has(k ds.Key) (bool, error) {
+	var res etcdRangeResponse
+	err := e.call(context.Background(), "/v3/kv/range", etcdRangeRequest{Key: k.Bytes(), CountOnly: true}, &res)
+	return res.Count > 0, err
+}
+
+func (e *Etcd) GetSize(k ds.Key) (int, error) {
+	v, err := e.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (e *Etcd) Put(k ds.Key, value []byte) error {
+	var res struct{}
+	return e.call(context.Background(), "/v3/kv/put", etcdPutRequest{k.Bytes(), value}, &res)
+}
+
+func (e *Etcd) Delete(k ds.Key) error {
+	var res struct{}
+	return e.call(context.Background(), "/v3/kv/deleterange", etcdDeleteRequest{k.Bytes()}, &res)
+}
+
+// Revision returns the current revision of the cluster, to watch changes
+// made after it.
+func (e *Etcd) Revision(ctx context.Context) (int64, error) {
+	var res etcdRangeResponse
+	err := e.call(ctx, "/v3/kv/range", etcdRangeRequest{Key: []byte{0}, CountOnly: true}, &res)
+	return res.Header.Revision, err
+}
+
+// Query reads the keys under the prefix of `q` a page at a time, all at the
+// revision of the first page. Ordering by anything else than ascending key
+// loads all results in memory.
+func (e *Etcd) Query(q dsq.Query) (dsq.Results, error) {
+	var (
+		from = etcdKeyPrefix(ds.NewKey(q.Prefix))
+		end  = etcdPrefixEnd(from)
+		rev  int64
+		kvs  []etcdKV
+		more = true
+	)
+	next := func() (dsq.Result, bool) {
+		for len(kvs) == 0 {
+			if !more {
+				return dsq.Result{}, false
+			}
+			var res etcdRangeResponse
+			err := e.call(context.Background(), "/v3/kv/range", etcdRangeRequest{
+				Key:      from,
+				RangeEnd: end,
+				Limit:    etcdPageSize,
+				Revision: rev,
+				KeysOnly: q.KeysOnly,
+			}, &res)
+			if err != nil {
+				more = false
+				return dsq.Result{Error: err}, true
+			}
+			kvs, more, rev = res.Kvs, res.More && len(res.Kvs) > 0, res.Header.Revision
+			if len(kvs) > 0 {
+				// The next page starts right after the last key.
+				last := kvs[len(kvs)-1].Key
+				from = append(append(make([]byte, 0, len(last)+1), last...), 0)
+			}
+		}
+		kv := kvs[0]
+		kvs = kvs[1:]
+		if q.KeysOnly {
+			return dsq.Result{Entry: dsq.Entry{Key: string(kv.Key), Size: -1}}, true
+		}
+		return dsq.Result{Entry: dsq.Entry{Key: string(kv.Key), Value: kv.Value, Size: len(kv.Value)}}, true
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are read in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})), nil
+}
+
+// Sync does nothing: writes are durable once etcd acknowledges them.
+func (e *Etcd) Sync(prefix ds.Key) error {
+	return nil
+}
+
+func (e *Etcd) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func (e *Etcd) Batch() (ds.Batch, error) {
+	return &etcdBatch{etcd: e, ops: make(map[ds.Key]etcdOp)}, nil
+}
+
+// etcdBatch buffers writes until Commit sends them in transactions.
+type etcdBatch struct {
+	etcd *Etcd
+	ops  map[ds.Key]etcdOp
+}
+
+func (b *etcdBatch) Put(k ds.Key, value []byte) error {
+	b.ops[k] = etcdOp{RequestPut: &etcdPutRequest{k.Bytes(), value}}
+	return nil
+}
+
+func (b *etcdBatch) Delete(k ds.Key) error {
+	b.ops[k] = etcdOp{RequestDeleteRange: &etcdDeleteRequest{k.Bytes()}}
+	return nil
+}
+
+// Commit writes the batch in transactions of up to etcdTxnOps operations. If
+// one fails, the transactions committed before it stay applied.
+func (b *etcdBatch) Commit() error {
+	ops := make([]etcdOp, 0, len(b.ops))
+	for _, op := range b.ops {
+		ops = append(ops, op)
+	}
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > etcdTxnOps {
+			n = etcdTxnOps
+		}
+		var res struct{}
+		if err := b.etcd.call(context.Background(), "/v3/kv/txn", etcdTxnRequest{ops[:n]}, &res); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// etcdWatchResponse is one message of the stream of a watch.
+type etcdWatchResponse struct {
+	Result struct {
+		Header          etcdHeader
+		Canceled        bool
+		CancelReason    string `json:"cancel_reason"`
+		CompactRevision int64  `json:"compact_revision,string"`
+		Events          []struct {
+			Type string // Type is "DELETE", or empty for puts.
+			Kv   etcdKV
+		}
+	}
+	Error *struct {
+		Code    int `json:"grpc_code"`
+		Message string
+	}
+}
+
+// watch calls `fn` with the keys under `prefix` that change after the
+// revision `rev`, and whether they were deleted, until `ctx` is done or `fn`
+// returns an error.
+func (e *Etcd) watch(ctx context.Context, prefix []byte, rev int64, fn func(kv etcdKV, deleted bool) error) error {
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            prefix,
+			"range_end":      etcdPrefixEnd(prefix),
+			"start_revision": fmt.Sprint(rev + 1),
+		},
+	}
+	resp, err := e.open(ctx, "/v3/watch", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg etcdWatchResponse
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: etcd: watch interrupted: %v", ErrBackendUnavailable, err)
+		}
+		switch {
+		case msg.Error != nil:
+			return &etcdError{RPC: "/v3/watch", Code: msg.Error.Code, Message: msg.Error.Message}
+		case msg.Result.CompactRevision > 0:
+			return fmt.Errorf("%w: watching from %v, compacted up to %v", ErrCompacted, rev+1, msg.Result.CompactRevision)
+		case msg.Result.Canceled:
+			return fmt.Errorf("%w: etcd: watch canceled: %v", ErrBackendUnavailable, msg.Result.CancelReason)
+		}
+		for _, ev := range msg.Result.Events {
+			if err := fn(ev.Kv, ev.Type == "DELETE"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// EtcdBlocklist is a DatastoreBlocklist stored in etcd, for fleets of gateways
+// that have to agree on what is blocked. Contains is linearizable, and Watch
+// tells gateways about changes within milliseconds, without polling, to keep
+// local caches up to date.
+//
+// Only one process should write to the blocklist: audit actions are numbered
+// by the writer.
+type EtcdBlocklist struct {
+	DatastoreBlocklist
+	etcd    *Etcd
+	root    ds.Key
+	entries ds.Key
+	digests ds.Key
+}
+
+func NewEtcdBlocklist(e *Etcd, opts ...DatastoreOption) (*EtcdBlocklist, error) {
+	o, err := newDatastoreOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewDatastoreBlocklist(e, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdBlocklist{b, e, o.root, o.root.Child(o.blocklist), o.root.Child(o.digest)}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *EtcdBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// EtcdEvent is a change of the blocklist, seen by EtcdBlocklist.Watch.
+type EtcdEvent struct {
+	// Id is the CIDv1-raw of the multihash an entry or one of its digests is
+	// stored under, that was blocked or unblocked.
+	Id       cid.Cid
+	Blocked  bool
+	Revision int64 // Revision is the etcd revision of the change.
+}
+
+// Watch calls `fn` with the CIDs blocked or unblocked after the revision
+// `rev`, as returned by Etcd.Revision, until `ctx` is done or `fn` returns an
+// error. Updates of entries are reported as blocks. A gateway loads the
+// blocklist, then watches it from the revision it was loaded at. If Watch
+// returns ErrCompacted, changes were lost, and the blocklist has to be loaded
+// again.
+//
+// The watch covers every key of the blocklist, so that one stream suffices,
+// and changes of the audit log and comments are read and skipped.
+func (b *EtcdBlocklist) Watch(ctx context.Context, rev int64, fn func(EtcdEvent) error) (err error) {
+	defer wrapError(&err, "etcd", "watch", cid.Undef)
+
+	return b.etcd.watch(ctx, etcdKeyPrefix(b.root), rev, func(kv etcdKV, deleted bool) error {
+		k := ds.RawKey(string(kv.Key))
+		if !b.entries.Equal(k.Parent()) && !b.digests.Equal(k.Parent()) {
+			return nil
+		}
+		id, err := keyToCid(ds.NewKey(k.BaseNamespace()))
+		if err != nil {
+			log.Warnf("skipping key %v of unknown format in etcd", k)
+			return nil
+		}
+		return fn(EtcdEvent{Id: id, Blocked: !deleted, Revision: kv.ModRevision})
+	})
+}
@@ -0,0 +1,201 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultFailoverCooldown is how long a FailoverBlocklist reads from the
+// secondary blocklist after the primary one failed, before trying it again.
+const DefaultFailoverCooldown = 10 * time.Second
+
+// FailoverOption configures a FailoverBlocklist.
+type FailoverOption func(*failoverOptions)
+
+type failoverOptions struct {
+	cooldown time.Duration
+	policy   FailPolicy
+	answer   bool
+}
+
+// WithFailoverCooldown sets how long the secondary blocklist is read from
+// after the primary one failed, instead of DefaultFailoverCooldown. With
+// zero, every read tries the primary blocklist first.
+func WithFailoverCooldown(d time.Duration) FailoverOption {
+	return func(o *failoverOptions) {
+		o.cooldown = d
+	}
+}
+
+// WithFailoverPolicy makes Contains and ContainsMany answer according to `p`
+// when both blocklists fail: FailOpen reports content as not blocked, and
+// FailClosed as blocked. The failure is logged. By default, the error of the
+// secondary blocklist is returned, and the caller decides, like Middleware
+// does with its own FailPolicy.
+func WithFailoverPolicy(p FailPolicy) FailoverOption {
+	return func(o *failoverOptions) {
+		o.policy = p
+		o.answer = true
+	}
+}
+
+// FailoverBlocklist reads from a primary blocklist, and from a secondary one,
+// like a local snapshot of it, while the primary one fails. Contains,
+// ContainsMany, Search, and SearchMany fail over. Other methods, including
+// every change, only use the primary blocklist: the secondary one is never
+// written to.
+//
+// Errors that are about the request rather than the backend, like
+// ErrNotFound or ErrInvalidCID, don't fail over, and neither do lookups whose
+// context is done.
+type FailoverBlocklist struct {
+	Blocklist
+	// failovers is first to keep it 64-bit aligned for atomic operations.
+	failovers uint64
+
+	secondary Blocklist
+	cooldown  time.Duration
+	policy    FailPolicy
+	answer    bool
+
+	mu     sync.Mutex
+	failed time.Time // failed is when the primary blocklist last failed.
+}
+
+// NewFailover returns a blocklist that reads from `secondary` while `primary`
+// fails.
+func NewFailover(primary, secondary Blocklist, opts ...FailoverOption) *FailoverBlocklist {
+	o := &failoverOptions{cooldown: DefaultFailoverCooldown}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &FailoverBlocklist{
+		Blocklist: primary,
+		secondary: secondary,
+		cooldown:  o.cooldown,
+		policy:    o.policy,
+		answer:    o.answer,
+	}
+}
+
+// Capabilities returns the Capabilities of the primary blocklist.
+func (b *FailoverBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// Failovers returns the number of reads served by the secondary blocklist.
+func (b *FailoverBlocklist) Failovers() uint64 {
+	return atomic.LoadUint64(&b.failovers)
+}
+
+// FailedOver returns true if reads go to the secondary blocklist, as the
+// primary one failed less than the cooldown ago.
+func (b *FailoverBlocklist) FailedOver() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.failed.IsZero() && time.Since(b.failed) < b.cooldown
+}
+
+// failover returns true if a read that failed with `err` should be retried on
+// the secondary blocklist, and records the failure of the primary one.
+func (b *FailoverBlocklist) failover(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	for _, e := range []error{ErrNotFound, ErrAlreadyBlocked, ErrInvalidCID, ErrInvalidBlockData} {
+		if errors.Is(err, e) {
+			return false
+		}
+	}
+	b.mu.Lock()
+	first := b.failed.IsZero() || time.Since(b.failed) >= b.cooldown
+	b.failed = time.Now()
+	b.mu.Unlock()
+	if first {
+		log.Warnf("primary blocklist failed, failing over for %v: %v", b.cooldown, err)
+	}
+	return true
+}
+
+// read calls `fn` with the primary blocklist, or with the secondary one while
+// failed over or if the primary one fails.
+func (b *FailoverBlocklist) read(ctx context.Context, fn func(Blocklist) error) error {
+	if !b.FailedOver() {
+		err := fn(b.Blocklist)
+		if !b.failover(ctx, err) {
+			return err
+		}
+	}
+	atomic.AddUint64(&b.failovers, 1)
+	return fn(b.secondary)
+}
+
+// Contains returns whether `id` is blocked, according to the primary
+// blocklist or, while it fails, the secondary one.
+func (b *FailoverBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	err = b.read(ctx, func(l Blocklist) (err error) {
+		exists, err = l.Contains(ctx, id)
+		return err
+	})
+	if err != nil && b.answer && ctx.Err() == nil {
+		log.Warnf("both blocklists failed to look up %v: %v", RedactID(id), err)
+		return b.policy == FailClosed, nil
+	}
+	return exists, err
+}
+
+func (b *FailoverBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	err = b.read(ctx, func(l Blocklist) (err error) {
+		res, err = l.ContainsMany(ctx, ids)
+		return err
+	})
+	if err != nil && b.answer && ctx.Err() == nil {
+		log.Warnf("both blocklists failed to look up %v CIDs: %v", len(ids), err)
+		res = make(map[cid.Cid]bool, len(ids))
+		for _, id := range ids {
+			res[id] = b.policy == FailClosed
+		}
+		return res, nil
+	}
+	return res, err
+}
+
+func (b *FailoverBlocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	err = b.read(ctx, func(l Blocklist) (err error) {
+		item, err = l.Search(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (b *FailoverBlocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	err = b.read(ctx, func(l Blocklist) (err error) {
+		res, err = l.SearchMany(ctx, ids)
+		return err
+	})
+	return res, err
+}
+
+// Healthy returns nil if either blocklist is healthy, as reads are served
+// while one of them is. Otherwise, it returns the error of the primary one.
+func (b *FailoverBlocklist) Healthy(ctx context.Context) error {
+	err := b.Blocklist.Healthy(ctx)
+	if err != nil && b.secondary.Healthy(ctx) == nil {
+		return nil
+	}
+	return err
+}
+
+// Close closes both blocklists.
+func (b *FailoverBlocklist) Close(ctx context.Context) error {
+	err := b.secondary.Close(ctx)
+	if perr := b.Blocklist.Close(ctx); perr != nil {
+		return perr
+	}
+	return err
+}
@@ -0,0 +1,394 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// Defaults of the Firestore client.
+const (
+	DefaultFirestoreEndpoint   = "https://firestore.googleapis.com"
+	DefaultFirestoreTimeout    = 10 * time.Second
+	DefaultFirestoreCollection = "blocklist"
+)
+
+// firestoreWrites is the maximum number of writes of one commit.
+const firestoreWrites = 500
+
+// firestorePage is the number of documents Query reads per request.
+const firestorePage = 300
+
+// FirestoreOption configures a Firestore.
+type FirestoreOption func(*firestoreOptions)
+
+type firestoreOptions struct {
+	endpoint   string
+	client     *http.Client
+	database   string
+	collection string
+}
+
+// WithFirestoreEndpoint sends requests to `endpoint` instead of
+// DefaultFirestoreEndpoint, like the Firestore emulator.
+func WithFirestoreEndpoint(endpoint string) FirestoreOption {
+	return func(o *firestoreOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithFirestoreHTTPClient sends requests with `c` instead of a client with a
+// DefaultFirestoreTimeout timeout.
+func WithFirestoreHTTPClient(c *http.Client) FirestoreOption {
+	return func(o *firestoreOptions) {
+		o.client = c
+	}
+}
+
+// WithFirestoreDatabase stores documents in the database `name` instead of
+// "(default)".
+func WithFirestoreDatabase(name string) FirestoreOption {
+	return func(o *firestoreOptions) {
+		o.database = name
+	}
+}
+
+// WithFirestoreCollection stores documents in the collection `name` instead
+// of DefaultFirestoreCollection.
+func WithFirestoreCollection(name string) FirestoreOption {
+	return func(o *firestoreOptions) {
+		o.collection = name
+	}
+}
+
+// Firestore is a datastore stored in a collection of a Firestore database in
+// Native mode, through the REST API. Each key is a document, named after the
+// base64url encoding of the key, with the key in the "dsKey" field and the
+// value in the "dsValue" field. Query lists documents by ranges of dsKey,
+// with the single-field index Firestore creates by default.
+//
+// Batches are written in commits of up to 500 writes, the limit of
+// Firestore, so larger batches aren't atomic.
+type Firestore struct {
+	api        gcpAPI
+	documents  string
+	collection string
+}
+
+var _ ds.Batching = (*Firestore)(nil)
+
+// NewFirestore returns a datastore stored in the Firestore database of the
+// Google Cloud project `project`. `token` returns the OAuth 2.0 access token
+// of each request, which needs the datastore scope, like the token source of
+// a service account would.
+func NewFirestore(project string, token func(context.Context) (string, error), opts ...FirestoreOption) *Firestore {
+	o := &firestoreOptions{
+		endpoint:   DefaultFirestoreEndpoint,
+		client:     &http.Client{Timeout: DefaultFirestoreTimeout},
+		database:   "(default)",
+		collection: DefaultFirestoreCollection,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	documents := fmt.Sprintf("projects/%v/databases/%v/documents", project, o.database)
+	return &Firestore{
+		api:        gcpAPI{"firestore", o.client, strings.TrimSuffix(o.endpoint, "/") + "/v1/", token},
+		documents:  documents,
+		collection: o.collection,
+	}
+}
+
+// do sends a request to `endpoint`, relative to the version of the API, and
+// returns the body of a successful response. Missing documents are reported
+// as ds.ErrNotFound.
+func (f *Firestore) do(method, endpoint string, body interface{}) ([]byte, error) {
+	raw, err := f.api.do(context.Background(), method, endpoint, body)
+	var apiErr *gcpError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil, ds.ErrNotFound
+	}
+	return raw, err
+}
+
+// docName returns the resource name of the document of `k`.
+func (f *Firestore) docName(k ds.Key) string {
+	return f.documents + "/" + f.collection + "/" + base64.RawURLEncoding.EncodeToString(k.Bytes())
+}
+
+// firestoreValue is a value of a field of a document.
+type firestoreValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BytesValue  *[]byte `json:"bytesValue,omitempty"` // BytesValue is encoded in base64, like encoding/json does.
+}
+
+// firestoreDoc is a document of the collection.
+type firestoreDoc struct {
+	Name   string                    `json:"name,omitempty"`
+	Fields map[string]firestoreValue `json:"fields"`
+}
+
+// entry returns the datastore entry of the document.
+func (d *firestoreDoc) entry() (dsq.Entry, error) {
+	k := d.Fields["dsKey"].StringValue
+	if k == nil {
+		return dsq.Entry{}, fmt.Errorf("firestore: document %v has no key", d.Name)
+	}
+	v := []byte{}
+	if p := d.Fields["dsValue"].BytesValue; p != nil {
+		v = *p
+	}
+	return dsq.Entry{Key: *k, Value: v, Size: len(v)}, nil
+}
+
+// newFirestoreDoc returns the document of `k` and `value`.
+func newFirestoreDoc(name string, k ds.Key, value []byte) *firestoreDoc {
+	key := k.String()
+	if value == nil {
+		// encoding/json encodes nil bytes as null, which has no type.
+		value = []byte{}
+	}
+	return &firestoreDoc{Name: name, Fields: map[string]firestoreValue{
+		"dsKey":   {StringValue: &key},
+		"dsValue": {BytesValue: &value},
+	}}
+}
+
+func (f *Firestore) Get(k ds.Key) ([]byte, error) {
+	raw, err := f.do(http.MethodGet, f.docName(k), nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc firestoreDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("firestore: reading %v: %w", k, err)
+	}
+	e, err := doc.entry()
+	if err != nil {
+		return nil, err
+	}
+	return e.Value, nil
+}
+
+// Has reads only the key of the document of `k`, to avoid transferring its
+// value.
+func (f *Firestore) Has(k ds.Key) (bool, error) {
+	_, err := f.do(http.MethodGet, f.docName(k)+"?mask.fieldPaths=dsKey", nil)
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f *Firestore) GetSize(k ds.Key) (int, error) {
+	v, err := f.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (f *Firestore) Put(k ds.Key, value []byte) error {
+	_, err := f.do(http.MethodPatch, f.docName(k), newFirestoreDoc("", k, value))
+	return err
+}
+
+// Delete removes the document of `k`. Firestore doesn't report whether it
+// existed.
+func (f *Firestore) Delete(k ds.Key) error {
+	_, err := f.do(http.MethodDelete, f.docName(k), nil)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// prefixRange returns the range of the keys under `prefix`, from `lo` to
+// `hi` excluded, as strings ordered byte by byte.
+func prefixRange(prefix ds.Key) (lo, hi string) {
+	lo = prefix.String()
+	if lo != "/" {
+		lo += "/"
+	}
+	// Keys under the prefix continue with any byte after its last "/", and
+	// "0" is the byte after "/".
+	return lo, lo[:len(lo)-1] + "0"
+}
+
+// query returns a page of the documents whose keys are in the range from
+// `lo` to `hi` excluded, from after `after` if it isn't empty.
+func (f *Firestore) query(lo, hi, after string, keysOnly bool) ([]dsq.Entry, error) {
+	field := map[string]string{"fieldPath": "dsKey"}
+	filter := func(op, v string) interface{} {
+		return map[string]interface{}{"fieldFilter": map[string]interface{}{
+			"field": field, "op": op, "value": firestoreValue{StringValue: &v},
+		}}
+	}
+	q := map[string]interface{}{
+		"from": []interface{}{map[string]string{"collectionId": f.collection}},
+		"where": map[string]interface{}{"compositeFilter": map[string]interface{}{
+			"op":      "AND",
+			"filters": []interface{}{filter("GREATER_THAN_OR_EQUAL", lo), filter("LESS_THAN", hi)},
+		}},
+		"orderBy": []interface{}{map[string]interface{}{"field": field, "direction": "ASCENDING"}},
+		"limit":   firestorePage,
+	}
+	if after != "" {
+		q["startAt"] = map[string]interface{}{
+			"values": []firestoreValue{{StringValue: &after}},
+			"before": false,
+		}
+	}
+	if keysOnly {
+		q["select"] = map[string]interface{}{"fields": []interface{}{field}}
+	}
+
+	raw, err := f.do(http.MethodPost, f.documents+":runQuery", map[string]interface{}{"structuredQuery": q})
+	if err != nil {
+		return nil, err
+	}
+	var res []struct {
+		Document *firestoreDoc
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("firestore: listing keys: %w", err)
+	}
+	var entries []dsq.Entry
+	for _, r := range res {
+		if r.Document == nil {
+			// Progress of the query, without a document.
+			continue
+		}
+		e, err := r.Document.entry()
+		if err != nil {
+			return nil, err
+		}
+		if keysOnly {
+			e.Value, e.Size = nil, -1
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Query lists the documents under the prefix of `q` a page at a time, in
+// ascending key order. Ordering by anything else than ascending key loads
+// all results in memory.
+func (f *Firestore) Query(q dsq.Query) (dsq.Results, error) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	var (
+		entries []dsq.Entry
+		after   string
+		done    bool
+	)
+	next := func() (dsq.Result, bool) {
+		for len(entries) == 0 {
+			if done {
+				return dsq.Result{}, false
+			}
+			page, err := f.query(lo, hi, after, q.KeysOnly)
+			if err != nil {
+				done = true
+				return dsq.Result{Error: err}, true
+			}
+			entries, done = page, len(page) < firestorePage
+			if len(page) > 0 {
+				after = page[len(page)-1].Key
+			}
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return dsq.Result{Entry: e}, true
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are listed in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})), nil
+}
+
+// Sync does nothing: writes are durable once Firestore acknowledges them.
+func (f *Firestore) Sync(prefix ds.Key) error {
+	return nil
+}
+
+func (f *Firestore) Close() error {
+	f.api.client.CloseIdleConnections()
+	return nil
+}
+
+func (f *Firestore) Batch() (ds.Batch, error) {
+	return &firestoreBatch{firestore: f, writes: make(map[ds.Key]interface{})}, nil
+}
+
+// firestoreBatch buffers writes until Commit sends them in commits.
+type firestoreBatch struct {
+	firestore *Firestore
+	writes    map[ds.Key]interface{}
+}
+
+func (b *firestoreBatch) Put(k ds.Key, value []byte) error {
+	b.writes[k] = map[string]interface{}{"update": newFirestoreDoc(b.firestore.docName(k), k, value)}
+	return nil
+}
+
+func (b *firestoreBatch) Delete(k ds.Key) error {
+	b.writes[k] = map[string]string{"delete": b.firestore.docName(k)}
+	return nil
+}
+
+// Commit writes the batch in commits of up to firestoreWrites writes. If one
+// fails, the commits sent before it stay applied.
+func (b *firestoreBatch) Commit() error {
+	writes := make([]interface{}, 0, len(b.writes))
+	for _, w := range b.writes {
+		writes = append(writes, w)
+	}
+	for len(writes) > 0 {
+		n := len(writes)
+		if n > firestoreWrites {
+			n = firestoreWrites
+		}
+		body := map[string]interface{}{"writes": writes[:n]}
+		if _, err := b.firestore.do(http.MethodPost, b.firestore.documents+":commit", body); err != nil {
+			return err
+		}
+		writes = writes[n:]
+	}
+	return nil
+}
+
+// FirestoreBlocklist is a DatastoreBlocklist stored in Firestore, for
+// gateways deployed on Google Cloud. Reads are strongly consistent.
+//
+// Only one process should write to the collection: audit actions are
+// numbered by the writer.
+type FirestoreBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewFirestoreBlocklist(f *Firestore, opts ...DatastoreOption) (*FirestoreBlocklist, error) {
+	b, err := NewDatastoreBlocklist(f, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreBlocklist{b}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *FirestoreBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
@@ -0,0 +1,179 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeFirestore serves the document requests of Firestore from memory, for
+// the default database of the project "p".
+type fakeFirestore struct {
+	t *testing.T
+
+	mu   sync.Mutex
+	docs map[string]*firestoreDoc // docs are keyed by name.
+}
+
+func (f *fakeFirestore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		f.error(w, http.StatusUnauthorized, "UNAUTHENTICATED")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const documents = "projects/p/databases/(default)/documents"
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case name == documents+":runQuery" && r.Method == http.MethodPost:
+		f.runQuery(w, r)
+	case name == documents+":commit" && r.Method == http.MethodPost:
+		var req struct {
+			Writes []struct {
+				Update *firestoreDoc
+				Delete string
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			f.error(w, http.StatusBadRequest, "INVALID_ARGUMENT")
+			return
+		} else if len(req.Writes) > firestoreWrites {
+			f.error(w, http.StatusBadRequest, "INVALID_ARGUMENT")
+			return
+		}
+		for _, wr := range req.Writes {
+			if wr.Update != nil {
+				f.docs[wr.Update.Name] = wr.Update
+			} else {
+				delete(f.docs, wr.Delete)
+			}
+		}
+		w.Write([]byte(`{}`))
+	case strings.HasPrefix(name, documents+"/blocklist/"):
+		switch r.Method {
+		case http.MethodGet:
+			doc, ok := f.docs[name]
+			if !ok {
+				f.error(w, http.StatusNotFound, "NOT_FOUND")
+				return
+			}
+			if r.URL.Query().Get("mask.fieldPaths") == "dsKey" {
+				doc = &firestoreDoc{Name: doc.Name, Fields: map[string]firestoreValue{"dsKey": doc.Fields["dsKey"]}}
+			}
+			json.NewEncoder(w).Encode(doc)
+		case http.MethodPatch:
+			var doc firestoreDoc
+			if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+				f.error(w, http.StatusBadRequest, "INVALID_ARGUMENT")
+				return
+			}
+			doc.Name = name
+			f.docs[name] = &doc
+			json.NewEncoder(w).Encode(doc)
+		case http.MethodDelete:
+			// Firestore doesn't report missing documents.
+			delete(f.docs, name)
+			w.Write([]byte(`{}`))
+		}
+	default:
+		f.t.Errorf("unexpected request %v %v", r.Method, r.URL)
+		f.error(w, http.StatusNotFound, "NOT_FOUND")
+	}
+}
+
+func (f *fakeFirestore) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": status, "status": code, "message": http.StatusText(status)},
+	})
+}
+
+// runQuery answers the range queries of Firestore.query.
+func (f *fakeFirestore) runQuery(w http.ResponseWriter, r *http.Request) {
+	type filter struct {
+		FieldFilter struct {
+			Op    string
+			Value firestoreValue
+		}
+	}
+	var req struct {
+		StructuredQuery struct {
+			From  []struct{ CollectionId string }
+			Where struct {
+				CompositeFilter struct{ Filters []filter }
+			}
+			StartAt *struct{ Values []firestoreValue }
+			Select  *json.RawMessage
+			Limit   int
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		f.error(w, http.StatusBadRequest, "INVALID_ARGUMENT")
+		return
+	}
+	q := req.StructuredQuery
+	if len(q.From) != 1 || q.From[0].CollectionId != "blocklist" {
+		f.t.Errorf("query of collections %+v", q.From)
+	}
+	var lo, hi, after string
+	for _, fl := range q.Where.CompositeFilter.Filters {
+		switch fl.FieldFilter.Op {
+		case "GREATER_THAN_OR_EQUAL":
+			lo = *fl.FieldFilter.Value.StringValue
+		case "LESS_THAN":
+			hi = *fl.FieldFilter.Value.StringValue
+		}
+	}
+	if q.StartAt != nil {
+		after = *q.StartAt.Values[0].StringValue
+	}
+
+	var matched []*firestoreDoc
+	for _, doc := range f.docs {
+		key := *doc.Fields["dsKey"].StringValue
+		if key >= lo && key < hi && (after == "" || key > after) {
+			if q.Select != nil {
+				doc = &firestoreDoc{Name: doc.Name, Fields: map[string]firestoreValue{"dsKey": doc.Fields["dsKey"]}}
+			}
+			matched = append(matched, doc)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return *matched[i].Fields["dsKey"].StringValue < *matched[j].Fields["dsKey"].StringValue
+	})
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+
+	type result struct {
+		Document *firestoreDoc `json:"document,omitempty"`
+		ReadTime string        `json:"readTime"`
+	}
+	res := make([]result, 0, len(matched)+1)
+	for _, doc := range matched {
+		res = append(res, result{doc, "2024-01-01T00:00:00Z"})
+	}
+	if len(matched) == 0 {
+		// An empty result only has the progress of the query.
+		res = append(res, result{ReadTime: "2024-01-01T00:00:00Z"})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestFirestoreConformance(t *testing.T) {
+	s := httptest.NewServer(&fakeFirestore{t: t, docs: make(map[string]*firestoreDoc)})
+	defer s.Close()
+	token := func(context.Context) (string, error) { return "token", nil }
+	f := NewFirestore("p", token, WithFirestoreEndpoint(s.URL))
+	b, err := NewFirestoreBlocklist(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDatastoreConformance(t, f, b)
+}
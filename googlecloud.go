@@ -0,0 +1,95 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// gcpAPI sends authenticated requests under one base URL of a Google Cloud
+// REST API, for Firestore and Spanner.
+type gcpAPI struct {
+	service string // service names the API in errors.
+	client  *http.Client
+	base    string
+	token   func(context.Context) (string, error)
+}
+
+// gcpError is an error response of a Google Cloud API.
+type gcpError struct {
+	Service  string
+	Method   string
+	Endpoint string
+	Status   int    // Status is the HTTP status code.
+	Code     string // Code is the canonical error code, like "NOT_FOUND".
+	Message  string
+}
+
+func (e *gcpError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%v: %v %v: %v (%v)", e.Service, e.Method, e.Endpoint, e.Message, e.Code)
+	}
+	return fmt.Sprintf("%v: %v %v: %v", e.Service, e.Method, e.Endpoint, e.Message)
+}
+
+// do sends `body` as JSON to `endpoint`, and returns the body of a
+// successful response. Error responses are returned as a *gcpError, wrapped
+// in ErrBackendUnavailable if they are worth retrying later, as are network
+// failures.
+func (api gcpAPI) do(ctx context.Context, method, endpoint string, body interface{}) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, api.base+endpoint, r)
+	if err != nil {
+		return nil, err
+	}
+	token, err := api.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%v: getting a token: %w", api.service, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := api.client.Do(req)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return raw, nil
+	}
+
+	apiErr := &gcpError{Service: api.service, Method: method, Endpoint: endpoint, Status: resp.StatusCode, Message: resp.Status}
+	var res struct {
+		Error struct {
+			Status  string
+			Message string
+		}
+	}
+	if json.Unmarshal(raw, &res) == nil && res.Error.Message != "" {
+		apiErr.Code, apiErr.Message = res.Error.Status, res.Error.Message
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusConflict || resp.StatusCode >= 500 {
+		// Like "ABORTED", when transactions contend on the same documents or
+		// rows.
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return nil, apiErr
+}
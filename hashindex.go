@@ -0,0 +1,377 @@
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrInvalidHashIndex is returned when loading a hash index or bloom file
+// that is truncated or wasn't written by WriteHashIndex.
+var ErrInvalidHashIndex = fmt.Errorf("invalid hash index")
+
+// A hash index file is a 16-byte header followed by the sorted SHA-256 of the
+// multihash of every normalized CID the blocklist contains, so that content
+// matches under any version or codec of its CID. Fixed-size keys let
+// readers binary-search a read-only mapping of the file, without parsing it.
+// Its bloom sidecar is a 16-byte header followed by the bits of the filter.
+// Both headers are big-endian. Version 1 keyed whole CIDs, and isn't read
+// anymore: its files have to be written again.
+const (
+	hashIndexMagic   = "BLHI"
+	bloomMagic       = "BLBF"
+	hashIndexVersion = 2
+	hashIndexHeader  = 16
+	hashIndexKeySize = sha256.Size
+)
+
+// DefaultBloomBitsPerKey sizes the bloom sidecar for a false positive rate
+// of about 1%.
+const DefaultBloomBitsPerKey = 10
+
+// HashIndexOption configures how a hash index is written or read.
+type HashIndexOption func(*hashIndexOptions)
+
+type hashIndexOptions struct {
+	transform  Transformer
+	bitsPerKey int
+	batch      int
+}
+
+func newHashIndexOptions(opts []HashIndexOption) *hashIndexOptions {
+	o := &hashIndexOptions{transform: CIDv1, bitsPerKey: DefaultBloomBitsPerKey}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHashIndexTransformer sets the Transformer of the blocklist the index is
+// written from, instead of CIDv1. Readers must use the same one.
+func WithHashIndexTransformer(t Transformer) HashIndexOption {
+	return func(o *hashIndexOptions) {
+		o.transform = t
+	}
+}
+
+// WithBloomBitsPerKey sets the size of the bloom sidecar, in bits per key.
+// More bits mean fewer lookups in the index. The default is
+// DefaultBloomBitsPerKey.
+func WithBloomBitsPerKey(n int) HashIndexOption {
+	return func(o *hashIndexOptions) {
+		o.bitsPerKey = n
+	}
+}
+
+// WithHashIndexBatchSize sets how many entries are listed per request while
+// writing the index, instead of DefaultBulkBatchSize.
+func WithHashIndexBatchSize(n int) HashIndexOption {
+	return func(o *hashIndexOptions) {
+		o.batch = n
+	}
+}
+
+// hashIndexKey returns the key of the normalized `id` in a hash index: the
+// SHA-256 of its multihash.
+func hashIndexKey(id cid.Cid) [hashIndexKeySize]byte {
+	return sha256.Sum256(id.Hash())
+}
+
+// WriteHashIndex writes a hash index of the entries and digests of `b` to
+// `index`, and its bloom sidecar to `bloom`, which may be nil. It returns the
+// number of keys written. Sorting the keys takes 32 bytes of memory per
+// entry and digest, however large their metadata.
+func WriteHashIndex(ctx context.Context, b Blocklist, index, bloom io.Writer, opts ...HashIndexOption) (n int, err error) {
+	o := newHashIndexOptions(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var keys [][hashIndexKeySize]byte
+	items, errc := EntriesIter(ctx, b, ListOptions{Limit: o.batch})
+	for item := range items {
+		// Entries are stored under their transformed CID already, but
+		// digests aren't. Both are keyed on their multihash.
+		id, err := cid.Decode(item.Hash)
+		if err != nil {
+			return 0, fmt.Errorf("entry %q: %w: %v", redactHash(item.Hash), ErrInvalidCID, err)
+		}
+		keys = append(keys, hashIndexKey(id))
+		for _, d := range item.Digests {
+			dc, err := cid.Decode(d)
+			if err != nil {
+				return 0, fmt.Errorf("digest %q: %w: %v", d, ErrInvalidCID, err)
+			}
+			if dc, err = normalize(o.transform, dc); err != nil {
+				return 0, err
+			}
+			keys = append(keys, hashIndexKey(dc))
+		}
+	}
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	uniq := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			uniq = append(uniq, k)
+		}
+	}
+	keys = uniq
+
+	bw := bufio.NewWriter(index)
+	bw.Write(hashIndexHeaderBytes(hashIndexMagic, hashIndexKeySize, uint64(len(keys))))
+	for i := range keys {
+		bw.Write(keys[i][:])
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+
+	if bloom != nil {
+		f := newBloom(len(keys), o.bitsPerKey)
+		for i := range keys {
+			f.add(keys[i])
+		}
+		if _, err := bloom.Write(hashIndexHeaderBytes(bloomMagic, f.k, f.m)); err != nil {
+			return 0, err
+		}
+		if _, err := bloom.Write(f.bits); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// WriteHashIndexFiles writes a hash index of `b` to the file `path`, and its
+// bloom sidecar to `path`.bloom. Both files are replaced atomically, so that
+// edge processes can reopen them at any time while a publisher regenerates
+// them on each sync.
+func WriteHashIndexFiles(ctx context.Context, b Blocklist, path string, opts ...HashIndexOption) (n int, err error) {
+	dir := filepath.Dir(path)
+	index, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(index.Name())
+	defer index.Close()
+	bloom, err := os.CreateTemp(dir, filepath.Base(path)+".bloom.*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(bloom.Name())
+	defer bloom.Close()
+
+	if n, err = WriteHashIndex(ctx, b, index, bloom, opts...); err != nil {
+		return 0, err
+	}
+	for _, f := range []*os.File{index, bloom} {
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+	}
+	// The bloom is renamed first. A reader that opens the new bloom with the
+	// old index misses nothing the old index contains and is still blocked,
+	// while the old bloom would hide the entries added to the new index.
+	if err := os.Rename(bloom.Name(), path+".bloom"); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(index.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// hashIndexHeaderBytes returns the header of a hash index or bloom file.
+func hashIndexHeaderBytes(magic string, param int, count uint64) []byte {
+	h := make([]byte, hashIndexHeader)
+	copy(h, magic)
+	h[4] = hashIndexVersion
+	h[5] = byte(param)
+	binary.BigEndian.PutUint64(h[8:], count)
+	return h
+}
+
+// parseHashIndexHeader checks the header of `data`, and returns its
+// parameter and count.
+func parseHashIndexHeader(data []byte, magic string) (param int, count uint64, err error) {
+	if len(data) < hashIndexHeader || string(data[:4]) != magic {
+		return 0, 0, fmt.Errorf("%w: bad magic", ErrInvalidHashIndex)
+	} else if data[4] != hashIndexVersion {
+		return 0, 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidHashIndex, data[4])
+	}
+	return int(data[5]), binary.BigEndian.Uint64(data[8:hashIndexHeader]), nil
+}
+
+// bloom is a bloom filter over hash index keys. As keys are SHA-256 already,
+// its k hashes are derived from their first 16 bytes by double hashing.
+type bloom struct {
+	k    int
+	m    uint64
+	bits []byte
+}
+
+// newBloom returns an empty bloom filter sized for `n` keys.
+func newBloom(n, bitsPerKey int) *bloom {
+	if bitsPerKey <= 0 {
+		bitsPerKey = DefaultBloomBitsPerKey
+	}
+	m := uint64(n) * uint64(bitsPerKey)
+	if m < 64 {
+		m = 64
+	}
+	m = (m + 7) / 8 * 8
+	k := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &bloom{k: k, m: m, bits: make([]byte, m/8)}
+}
+
+func (f *bloom) positions(key [hashIndexKeySize]byte, fn func(bit uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(key[0:8])
+	h2 := binary.BigEndian.Uint64(key[8:16]) | 1
+	for i := 0; i < f.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % f.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloom) add(key [hashIndexKeySize]byte) {
+	f.positions(key, func(bit uint64) bool {
+		f.bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (f *bloom) mayContain(key [hashIndexKeySize]byte) bool {
+	return f.positions(key, func(bit uint64) bool {
+		return f.bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// HashIndex is a read-only blocklist loaded from the files written by
+// WriteHashIndex. It only answers Contains, which checks the bloom sidecar,
+// if any, then binary-searches the index. It implements Checker, so edge
+// processes can use it with Middleware.
+type HashIndex struct {
+	keys      []byte
+	n         int
+	bloom     *bloom
+	transform Transformer
+	close     func() error
+}
+
+// LoadHashIndex returns a HashIndex over the contents of an index file and
+// of its bloom sidecar, which may be nil. The slices are used as is, and
+// must not be modified.
+func LoadHashIndex(index, bloomData []byte, opts ...HashIndexOption) (*HashIndex, error) {
+	o := newHashIndexOptions(opts)
+	size, count, err := parseHashIndexHeader(index, hashIndexMagic)
+	if err != nil {
+		return nil, err
+	}
+	if size != hashIndexKeySize {
+		return nil, fmt.Errorf("%w: unsupported key size %d", ErrInvalidHashIndex, size)
+	} else if rest := uint64(len(index) - hashIndexHeader); rest/hashIndexKeySize != count || rest%hashIndexKeySize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes of keys for %d keys", ErrInvalidHashIndex, rest, count)
+	}
+	hi := &HashIndex{keys: index[hashIndexHeader:], n: int(count), transform: o.transform}
+
+	if bloomData != nil {
+		k, m, err := parseHashIndexHeader(bloomData, bloomMagic)
+		if err != nil {
+			return nil, err
+		}
+		if k == 0 || m == 0 || m%8 != 0 || uint64(len(bloomData)-hashIndexHeader) != m/8 {
+			return nil, fmt.Errorf("%w: bloom of %d bits in %d bytes", ErrInvalidHashIndex, m, len(bloomData))
+		}
+		hi.bloom = &bloom{k: k, m: m, bits: bloomData[hashIndexHeader:]}
+	}
+	return hi, nil
+}
+
+// OpenHashIndex maps the index file `path` and its bloom sidecar
+// `path`.bloom read-only, and returns a HashIndex over them. The bloom is
+// skipped if it doesn't exist. Close the index to unmap the files; reopen it
+// to pick up files that were regenerated.
+func OpenHashIndex(path string, opts ...HashIndexOption) (*HashIndex, error) {
+	index, unmapIndex, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bloomData, unmapBloom, err := mapFile(path + ".bloom")
+	if os.IsNotExist(err) {
+		bloomData, unmapBloom = nil, func() error { return nil }
+	} else if err != nil {
+		unmapIndex()
+		return nil, err
+	}
+	hi, err := LoadHashIndex(index, bloomData, opts...)
+	if err != nil {
+		unmapIndex()
+		unmapBloom()
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	hi.close = func() error {
+		err := unmapIndex()
+		if err2 := unmapBloom(); err == nil {
+			err = err2
+		}
+		return err
+	}
+	return hi, nil
+}
+
+// Len returns the number of keys of the index.
+func (hi *HashIndex) Len() int {
+	return hi.n
+}
+
+// Contains returns true if the index contains the content referenced by
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (hi *HashIndex) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	id, err := normalize(hi.transform, id)
+	if err != nil {
+		return false, err
+	}
+	key := hashIndexKey(id)
+	if hi.bloom != nil && !hi.bloom.mayContain(key) {
+		return false, nil
+	}
+	i := sort.Search(hi.n, func(i int) bool {
+		return bytes.Compare(hi.keys[i*hashIndexKeySize:(i+1)*hashIndexKeySize], key[:]) >= 0
+	})
+	return i < hi.n && bytes.Equal(hi.keys[i*hashIndexKeySize:(i+1)*hashIndexKeySize], key[:]), nil
+}
+
+// Close unmaps the files of an index opened with OpenHashIndex. The index
+// must not be used afterwards.
+func (hi *HashIndex) Close() error {
+	if hi.close == nil {
+		return nil
+	}
+	err := hi.close()
+	hi.close, hi.keys, hi.n, hi.bloom = nil, nil, 0, nil
+	return err
+}
@@ -0,0 +1,69 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestHashIndexMatchesEveryCodec(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	raw, digest := testCID(t, "content"), testCID(t, "digest")
+	if _, err := b.Block(ctx, raw, BlockData{Reason: "test", User: "u@x.com", Digests: []cid.Cid{cid.NewCidV0(digest.Hash())}}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "index")
+	if n, err := WriteHashIndexFiles(ctx, b, path); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("wrote %v keys, want 2", n)
+	}
+	hi, err := OpenHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hi.Close()
+
+	for id, want := range map[cid.Cid]bool{
+		cid.NewCidV0(raw.Hash()):                  true,
+		cid.NewCidV1(cid.DagProtobuf, raw.Hash()): true,
+		digest:                                   true,
+		cid.NewCidV1(cid.DagCBOR, digest.Hash()): true,
+		testCID(t, "other"):                      false,
+		cid.NewCidV0(testCID(t, "other").Hash()): false,
+	} {
+		if ok, err := hi.Contains(ctx, id); err != nil || ok != want {
+			t.Errorf("Contains(%v) = %v, %v, want %v", id, ok, err, want)
+		}
+	}
+}
+
+func TestHashIndexRejectsVersion1(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	if _, err := b.Block(ctx, testCID(t, "content"), BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index")
+	if _, err := WriteHashIndexFiles(ctx, b, path); err != nil {
+		t.Fatal(err)
+	}
+	// Files written before keys were multihashes have the same layout.
+	index, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index[4] = 1
+	if err := os.WriteFile(path, index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHashIndex(path); !errors.Is(err, ErrInvalidHashIndex) {
+		t.Errorf("OpenHashIndex of a version 1 index = %v, want ErrInvalidHashIndex", err)
+	}
+}
@@ -0,0 +1,213 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClockSkew is returned by Clock.Observe for timestamps too far ahead of
+// the local clock.
+var ErrClockSkew = fmt.Errorf("remote clock is too far ahead")
+
+// HLC is a hybrid logical clock timestamp. It stays close to wall-clock time,
+// but orders an action after every action its site had seen when logging it,
+// even if the clocks of the sites disagree.
+type HLC struct {
+	Wall    int64  // Wall is the physical part, in nanoseconds since the Unix epoch.
+	Logical uint32 // Logical orders timestamps with the same Wall.
+	Node    string // Node is the site that issued the timestamp, and breaks ties.
+}
+
+// Compare returns -1, 0, or 1 if `h` is before, equal to, or after `o`.
+func (h HLC) Compare(o HLC) int {
+	switch {
+	case h.Wall != o.Wall:
+		if h.Wall < o.Wall {
+			return -1
+		}
+		return 1
+	case h.Logical != o.Logical:
+		if h.Logical < o.Logical {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(h.Node, o.Node)
+}
+
+// Time returns the physical part of `h`.
+func (h HLC) Time() time.Time {
+	return time.Unix(0, h.Wall).UTC()
+}
+
+// String returns `h` in a fixed-width form that sorts like Compare.
+func (h HLC) String() string {
+	return fmt.Sprintf("%019d.%010d@%v", h.Wall, h.Logical, h.Node)
+}
+
+func (h HLC) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *HLC) UnmarshalText(text []byte) error {
+	s := string(text)
+	at := strings.IndexByte(s, '@')
+	if at < 0 {
+		return fmt.Errorf("invalid hlc timestamp %q", s)
+	}
+	if _, err := fmt.Sscanf(s[:at], "%d.%d", &h.Wall, &h.Logical); err != nil {
+		return fmt.Errorf("invalid hlc timestamp %q: %w", s, err)
+	}
+	h.Node = s[at+1:]
+	return nil
+}
+
+// Clock issues the HLC timestamps of one site.
+type Clock struct {
+	node      string
+	now       func() time.Time
+	maxOffset time.Duration
+
+	mu   sync.Mutex
+	last HLC
+}
+
+// ClockOption configures a Clock.
+type ClockOption func(*Clock)
+
+// WithPhysicalClock reads the physical time from `now` instead of time.Now.
+func WithPhysicalClock(now func() time.Time) ClockOption {
+	return func(c *Clock) {
+		c.now = now
+	}
+}
+
+// WithMaxOffset makes Observe reject timestamps more than `d` ahead of the
+// physical clock, so that a site with a broken clock can't drag the others
+// into the future. Zero accepts any timestamp.
+func WithMaxOffset(d time.Duration) ClockOption {
+	return func(c *Clock) {
+		c.maxOffset = d
+	}
+}
+
+// NewClock returns the clock of the site `node`, which has to be unique among
+// the sites whose logs are merged.
+func NewClock(node string, opts ...ClockOption) *Clock {
+	c := &Clock{node: node, now: time.Now}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Now returns a timestamp for a local action, after all the timestamps issued
+// or observed before.
+func (c *Clock) Now() HLC {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.now().UnixNano(); pt > c.last.Wall {
+		c.last = HLC{Wall: pt}
+	} else {
+		c.last.Logical++
+	}
+	c.last.Node = c.node
+	return c.last
+}
+
+// Observe advances the clock past the timestamps of `acts`, which were
+// received from other sites, so that the actions logged here next are
+// ordered after them. Actions without a timestamp are skipped.
+func (c *Clock) Observe(acts ...*Action) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.now().UnixNano()
+	for _, act := range acts {
+		if act.HLC == nil {
+			continue
+		}
+		m := *act.HLC
+		if c.maxOffset > 0 && m.Wall-pt > int64(c.maxOffset) {
+			return fmt.Errorf("%w: %v is %v ahead", ErrClockSkew, m, time.Duration(m.Wall-pt))
+		}
+		switch {
+		case pt > c.last.Wall && pt > m.Wall:
+			c.last = HLC{Wall: pt}
+		case m.Wall > c.last.Wall:
+			c.last = HLC{Wall: m.Wall, Logical: m.Logical + 1}
+		case m.Wall == c.last.Wall && m.Logical >= c.last.Logical:
+			c.last.Logical = m.Logical + 1
+		default:
+			c.last.Logical++
+		}
+		c.last.Node = c.node
+	}
+	return nil
+}
+
+// ClockedBlocklist stamps the actions logged through it with the HLC of its
+// site, if they don't have one yet. Actions replicated from other sites keep
+// their timestamps; pass them to Clock.Observe first.
+type ClockedBlocklist struct {
+	Blocklist
+	clock *Clock
+}
+
+func NewClocked(b Blocklist, c *Clock) *ClockedBlocklist {
+	return &ClockedBlocklist{b, c}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *ClockedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+func (b *ClockedBlocklist) AddLog(ctx context.Context, act *Action) error {
+	if act.HLC == nil {
+		hlc := b.clock.Now()
+		act.HLC = &hlc
+	}
+	return b.Blocklist.AddLog(ctx, act)
+}
+
+// LogOrder reports whether `a` happened before `b`, to merge audit logs.
+type LogOrder func(a, b *Action) bool
+
+// ByCreatedAt orders actions by their wall-clock time. Actions of sites with
+// skewed clocks may appear before the actions that caused them.
+func ByCreatedAt(a, b *Action) bool {
+	return a.CreatedAt.Before(b.CreatedAt)
+}
+
+// ByHLC orders actions by their HLC timestamp. Actions logged without one,
+// before ClockedBlocklist was deployed, are placed by their CreatedAt.
+func ByHLC(a, b *Action) bool {
+	return actionHLC(a).Compare(actionHLC(b)) < 0
+}
+
+// actionHLC returns the HLC of `act`, or one derived from its CreatedAt.
+func actionHLC(act *Action) HLC {
+	if act.HLC != nil {
+		return *act.HLC
+	}
+	return HLC{Wall: act.CreatedAt.UnixNano()}
+}
+
+// MergeLogs merges the audit logs of several sites, as returned by GetLogs,
+// into one log ordered by `order`, most recent first. Actions that compare
+// equal keep the order of `logs`. Seq is only unique within a site, so the
+// merged log can't be paged with LogQuery.Before.
+func MergeLogs(order LogOrder, logs ...[]*Action) []*Action {
+	var merged []*Action
+	for _, l := range logs {
+		merged = append(merged, l...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return order(merged[j], merged[i])
+	})
+	return merged
+}
@@ -0,0 +1,236 @@
+package blocklist
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ImportRecord is one entry of an import feed. Feeds have one JSON record per
+// line.
+type ImportRecord struct {
+	Id         string
+	Digests    []string `json:",omitempty"`
+	Content    []string
+	Reason     string
+	User       string
+	Metadata   map[string]string
+	References []Reference
+}
+
+// data returns the BlockData of the record.
+func (rec ImportRecord) data() (BlockData, error) {
+	data := BlockData{Content: rec.Content, Reason: rec.Reason, User: rec.User, Metadata: rec.Metadata, References: rec.References}
+	for _, d := range rec.Digests {
+		id, err := cid.Decode(strings.TrimSpace(d))
+		if err != nil {
+			return BlockData{}, fmt.Errorf("digest %q: %w: %v", d, ErrInvalidCID, err)
+		}
+		data.Digests = append(data.Digests, id)
+	}
+	return data, nil
+}
+
+// ImportError is an invalid record of an import feed.
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+// ImportPreview reports what importing a feed would change.
+type ImportPreview struct {
+	New            []cid.Cid // New are the ids that aren't blocked yet.
+	AlreadyBlocked []cid.Cid // AlreadyBlocked are blocked with the same metadata.
+	// Conflicting are blocked with a different Reason, Content, Metadata, or
+	// References.
+	// The changes are what Update would apply.
+	Conflicting map[cid.Cid][]Change
+	Allowlisted []cid.Cid // Allowlisted are on the allowlist, and skipped.
+	Duplicates  int       // Duplicates counts the records of ids seen before in the feed.
+	Invalid     []ImportError
+}
+
+// Importer blocks the entries of feeds in a blocklist, skipping the content on
+// an optional allowlist. Feeds are read and applied in batches, so that only
+// the ids of a large feed are kept in memory, not its records.
+type Importer struct {
+	blocklist Blocklist
+	allowlist Checker
+	batch     int
+}
+
+// NewImporter returns an Importer into `b`. `allow` may be nil.
+func NewImporter(b Blocklist, allow Checker) *Importer {
+	return &Importer{b, allow, DefaultBulkBatchSize}
+}
+
+// WithBatchSize returns an Importer that reads and applies feeds `n` records
+// at a time, instead of DefaultBulkBatchSize.
+func (im *Importer) WithBatchSize(n int) *Importer {
+	c := *im
+	c.batch = bulkBatchSize(n)
+	return &c
+}
+
+// importRecord is a valid record of a feed.
+type importRecord struct {
+	id   cid.Cid
+	data BlockData
+}
+
+// read parses the feed `r`, and calls `fn` with its records, im.batch at a
+// time. Invalid records and duplicates are reported in `preview`, and left
+// out of the records.
+func (im *Importer) read(r io.Reader, preview *ImportPreview, fn func([]importRecord) error) error {
+	var (
+		records []importRecord
+		seen    = make(map[cid.Cid]bool)
+		scanner = bufio.NewScanner(r)
+		line    int
+	)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec ImportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			preview.Invalid = append(preview.Invalid, ImportError{line, err})
+			continue
+		}
+		id, err := cid.Decode(strings.TrimSpace(rec.Id))
+		if err != nil {
+			preview.Invalid = append(preview.Invalid, ImportError{line, fmt.Errorf("%w: %v", ErrInvalidCID, err)})
+			continue
+		}
+		data, err := rec.data()
+		if err == nil {
+			data, err = data.Validate()
+		}
+		if err != nil {
+			preview.Invalid = append(preview.Invalid, ImportError{line, err})
+			continue
+		}
+		if seen[id] {
+			preview.Duplicates++
+			continue
+		}
+		seen[id] = true
+		if records = append(records, importRecord{id, data}); len(records) >= im.batch {
+			if err := fn(records); err != nil {
+				return err
+			}
+			records = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		return fn(records)
+	}
+	return nil
+}
+
+// Preview reports what importing the feed `r` would change, without changing
+// anything.
+func (im *Importer) Preview(ctx context.Context, r io.Reader) (*ImportPreview, error) {
+	preview := &ImportPreview{Conflicting: make(map[cid.Cid][]Change)}
+	err := im.read(r, preview, func(records []importRecord) error {
+		_, err := im.classify(ctx, records, preview)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// classify sorts `records` into `preview`, and returns the ones to block.
+func (im *Importer) classify(ctx context.Context, records []importRecord, preview *ImportPreview) ([]importRecord, error) {
+	ids := make([]cid.Cid, len(records))
+	for i, rec := range records {
+		ids[i] = rec.id
+	}
+	existing, err := im.blocklist.SearchMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var todo []importRecord
+	for _, rec := range records {
+		if im.allowlist != nil {
+			if allowed, err := im.allowlist.Contains(ctx, rec.id); err != nil {
+				return nil, err
+			} else if allowed {
+				preview.Allowlisted = append(preview.Allowlisted, rec.id)
+				continue
+			}
+		}
+		old := existing[rec.id]
+		if old == nil {
+			preview.New = append(preview.New, rec.id)
+			todo = append(todo, rec)
+			continue
+		}
+		patch := rec.data
+		patch.User = old.User
+		if changes := Diff(old, old.patch(patch)); len(changes) > 0 {
+			preview.Conflicting[rec.id] = changes
+		} else {
+			preview.AlreadyBlocked = append(preview.AlreadyBlocked, rec.id)
+		}
+	}
+	return todo, nil
+}
+
+// Import blocks the new entries of the feed `r`, and logs an "import" action
+// of `user` with their ids per batch. Entries that are already blocked are
+// left as is, even if their metadata conflicts. The returned preview reports
+// what was done. If the import fails, the batches applied before stay
+// applied.
+func (im *Importer) Import(ctx context.Context, r io.Reader, user string) (*ImportPreview, error) {
+	preview := &ImportPreview{Conflicting: make(map[cid.Cid][]Change)}
+	err := im.read(r, preview, func(records []importRecord) error {
+		classified := len(preview.New)
+		todo, err := im.classify(ctx, records, preview)
+		if err != nil {
+			return err
+		}
+
+		preview.New = preview.New[:classified]
+		for _, rec := range todo {
+			if existing, err := im.blocklist.Block(ctx, rec.id, rec.data); err != nil {
+				return err
+			} else if existing == nil {
+				preview.New = append(preview.New, rec.id)
+			}
+		}
+		blocked := preview.New[classified:]
+		if len(blocked) == 0 {
+			return nil
+		}
+		return im.blocklist.AddLog(ctx, &Action{
+			Typ:       ActionImport,
+			Ids:       blocked,
+			Reason:    fmt.Sprintf("imported %v entries", len(blocked)),
+			User:      user,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
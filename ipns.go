@@ -0,0 +1,183 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrNameBlocked is returned when resolving an IPNS name that resolved to
+// blocked content recently.
+var ErrNameBlocked = fmt.Errorf("IPNS name resolves to blocked content")
+
+// DefaultNameCooldown is how long an IPNS name stays blocked after it
+// resolved to blocked content.
+const DefaultNameCooldown = time.Hour
+
+// SourceDerived is the Source of blocks derived from other blocks, rather
+// than requested by a user.
+const SourceDerived = "derived"
+
+// DerivedBlock is an IPNS name blocked because it resolved to blocked
+// content. It expires on its own: names can be repointed, and the block only
+// stops the name from being re-resolved to the blocked content, or to a copy
+// of it under another CID, for a while.
+type DerivedBlock struct {
+	Name      string  // Name is the /ipns/ name, without a path.
+	Id        cid.Cid // Id is the blocked CID the name resolved to.
+	Source    string  // Source is SourceDerived.
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// NameBlockOption configures a BlockingNameResolver.
+type NameBlockOption func(*BlockingNameResolver)
+
+// WithNameCooldown sets how long names stay blocked, instead of
+// DefaultNameCooldown.
+func WithNameCooldown(d time.Duration) NameBlockOption {
+	return func(r *BlockingNameResolver) {
+		r.cooldown = d
+	}
+}
+
+// WithDerivedBlockHook calls `fn` with every derived block, for instance to
+// log or count them. It is called synchronously, from Resolve.
+func WithDerivedBlockHook(fn func(DerivedBlock)) NameBlockOption {
+	return func(r *BlockingNameResolver) {
+		r.hook = fn
+	}
+}
+
+// BlockingNameResolver is a NameResolver that blocks the IPNS names that
+// resolve to blocked content, for a cooldown period. While a name is
+// blocked, Resolve returns ErrNameBlocked without resolving it again. It can
+// be given to a gateway as its name system, to URLResolver, and to
+// Middleware with WithNameBlocks.
+//
+// Derived blocks are kept in memory, by each gateway, and aren't logged in
+// the audit log.
+type BlockingNameResolver struct {
+	names    NameResolver
+	checker  Checker
+	cooldown time.Duration
+	hook     func(DerivedBlock)
+	now      func() time.Time
+
+	mu     sync.Mutex
+	blocks map[string]DerivedBlock
+}
+
+// NewBlockingNameResolver returns a NameResolver that resolves names with
+// `names`, and checks what they resolve to against `c`.
+func NewBlockingNameResolver(names NameResolver, c Checker, opts ...NameBlockOption) *BlockingNameResolver {
+	r := &BlockingNameResolver{
+		names:    names,
+		checker:  c,
+		cooldown: DefaultNameCooldown,
+		now:      time.Now,
+		blocks:   make(map[string]DerivedBlock),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// nameKey returns the /ipns/ name of the path `name`, without the rest of
+// the path.
+func nameKey(name string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "/"), "ipns/")
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name = name[:i]
+	}
+	return "/ipns/" + name
+}
+
+// Resolve resolves the /ipns/ path `name`. If the name is blocked, or
+// resolves to blocked content, an error wrapping ErrNameBlocked is returned.
+func (r *BlockingNameResolver) Resolve(ctx context.Context, name string) (string, error) {
+	key := nameKey(name)
+	if b, ok := r.Blocked(key); ok {
+		return "", fmt.Errorf("%v: %w (%v, until %v)", key, ErrNameBlocked, RedactID(b.Id), b.ExpiresAt.Format(time.RFC3339))
+	}
+
+	resolved, err := r.names.Resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	ns, root, _, err := splitIPFSURL(resolved)
+	if err != nil || ns != "ipfs" {
+		// Names resolving to other names are checked when those are
+		// resolved.
+		return resolved, nil
+	}
+	id, err := cid.Decode(root)
+	if err != nil {
+		return "", fmt.Errorf("resolving %v: %w: %v", key, ErrInvalidCID, err)
+	}
+	blocked, err := r.checker.Contains(ctx, id)
+	if err != nil {
+		return "", err
+	} else if !blocked {
+		return resolved, nil
+	}
+
+	now := r.now()
+	b := DerivedBlock{Name: key, Id: id, Source: SourceDerived, CreatedAt: now, ExpiresAt: now.Add(r.cooldown)}
+	r.mu.Lock()
+	r.blocks[key] = b
+	r.mu.Unlock()
+	log.Infof("blocked %v until %v: resolved to blocked %v", key, b.ExpiresAt.Format(time.RFC3339), RedactID(id))
+	if r.hook != nil {
+		r.hook(b)
+	}
+	return "", fmt.Errorf("%v: %w (%v)", key, ErrNameBlocked, RedactID(id))
+}
+
+// Blocked returns the derived block of `name`, if it has one that hasn't
+// expired.
+func (r *BlockingNameResolver) Blocked(name string) (DerivedBlock, bool) {
+	key := nameKey(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.blocks[key]
+	if ok && !r.now().Before(b.ExpiresAt) {
+		delete(r.blocks, key)
+		return DerivedBlock{}, false
+	}
+	return b, ok
+}
+
+// DerivedBlocks returns the derived blocks that haven't expired, by name.
+// Expired ones are dropped.
+func (r *BlockingNameResolver) DerivedBlocks() []DerivedBlock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	blocks := make([]DerivedBlock, 0, len(r.blocks))
+	for key, b := range r.blocks {
+		if !now.Before(b.ExpiresAt) {
+			delete(r.blocks, key)
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Name < blocks[j].Name
+	})
+	return blocks
+}
+
+// Forget lifts the derived block of `name` before it expires, for instance
+// after the content it resolved to was unblocked.
+func (r *BlockingNameResolver) Forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.blocks, nameKey(name))
+}
@@ -0,0 +1,332 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Defaults of the Kafka REST Proxy client.
+const (
+	DefaultKafkaTimeout  = 30 * time.Second
+	DefaultKafkaInterval = 5 * time.Second
+)
+
+// Content types of the v2 API of the REST Proxy.
+const (
+	kafkaAPI  = "application/vnd.kafka.v2+json"
+	kafkaJSON = "application/vnd.kafka.json.v2+json"
+)
+
+// KafkaOption configures a KafkaTopic.
+type KafkaOption func(*kafkaOptions)
+
+type kafkaOptions struct {
+	client   *http.Client
+	user     string
+	password string
+	interval time.Duration
+}
+
+// WithKafkaHTTPClient sends requests with `c` instead of a client with a
+// DefaultKafkaTimeout timeout.
+func WithKafkaHTTPClient(c *http.Client) KafkaOption {
+	return func(o *kafkaOptions) {
+		o.client = c
+	}
+}
+
+// WithKafkaBasicAuth authenticates requests to the proxy with HTTP basic
+// authentication.
+func WithKafkaBasicAuth(user, password string) KafkaOption {
+	return func(o *kafkaOptions) {
+		o.user, o.password = user, password
+	}
+}
+
+// WithKafkaInterval sets how long SyncKafka waits after reading every record
+// of the topic, instead of DefaultKafkaInterval.
+func WithKafkaInterval(d time.Duration) KafkaOption {
+	return func(o *kafkaOptions) {
+		o.interval = d
+	}
+}
+
+// KafkaTopic is a Kafka topic that the replicas of a CRDTBlocklist append
+// their operations to, as an event log that every replica materializes. It
+// is reached through a REST Proxy with the v2 API, like the Confluent REST
+// Proxy or the HTTP proxy of Redpanda.
+//
+// Records are keyed by replica, so that the operations of a replica stay in
+// order in one partition, and their values are the operations as JSON. The
+// topic has to keep records forever, without compaction, for new replicas to
+// rebuild the blocklist from the start of the topic.
+type KafkaTopic struct {
+	client   *http.Client
+	base     string
+	topic    string
+	user     string
+	password string
+	interval time.Duration
+}
+
+// NewKafkaTopic returns the topic `topic` of the cluster behind the REST
+// Proxy at `proxy`, like "http://127.0.0.1:8082".
+func NewKafkaTopic(proxy, topic string, opts ...KafkaOption) *KafkaTopic {
+	o := &kafkaOptions{
+		client:   &http.Client{Timeout: DefaultKafkaTimeout},
+		interval: DefaultKafkaInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &KafkaTopic{
+		client:   o.client,
+		base:     strings.TrimSuffix(proxy, "/"),
+		topic:    topic,
+		user:     o.user,
+		password: o.password,
+		interval: o.interval,
+	}
+}
+
+// kafkaError is an error response of the proxy.
+type kafkaError struct {
+	Method   string
+	Endpoint string
+	Status   int    // Status is the HTTP status code.
+	Code     int    // Code is the error code of the proxy, if the response had one.
+	Message  string // Message is the error message, or the HTTP status.
+}
+
+func (e *kafkaError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("kafka: %v %v: %v (code %v)", e.Method, e.Endpoint, e.Message, e.Code)
+	}
+	return fmt.Sprintf("kafka: %v %v: %v", e.Method, e.Endpoint, e.Message)
+}
+
+// do sends `in` as JSON of the content type `contentType` to `endpoint`, a
+// path of the proxy or a URL it returned, and decodes the response into
+// `out`, unless it is nil. Error responses are returned as a *kafkaError,
+// wrapped in ErrBackendUnavailable if they are worth retrying later, as are
+// network failures.
+func (t *KafkaTopic) do(ctx context.Context, method, endpoint, contentType string, in, out interface{}) error {
+	u := endpoint
+	if strings.HasPrefix(endpoint, "/") {
+		u = t.base + endpoint
+	}
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	if t.user != "" {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	resp, err := t.client.Do(req)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil || len(raw) == 0 {
+			return nil
+		}
+		return json.Unmarshal(raw, out)
+	}
+
+	apiErr := &kafkaError{Method: method, Endpoint: endpoint, Status: resp.StatusCode, Message: resp.Status}
+	var res struct {
+		ErrorCode int `json:"error_code"`
+		Message   string
+	}
+	if json.Unmarshal(raw, &res) == nil && res.Message != "" {
+		apiErr.Code, apiErr.Message = res.ErrorCode, res.Message
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return apiErr
+}
+
+// kafkaRecord is a record of the topic, as produced and consumed.
+type kafkaRecord struct {
+	Key   string  `json:"key"`
+	Value *CRDTOp `json:"value"`
+}
+
+// Publish appends `ops` to the topic, in order. If a record fails, Publish
+// returns an error, and the records after it may have been appended: the
+// operations have to be published again, and replicas skip those they
+// already applied.
+func (t *KafkaTopic) Publish(ctx context.Context, ops []*CRDTOp) (err error) {
+	defer wrapError(&err, "kafka", "publish", cid.Undef)
+
+	records := make([]kafkaRecord, len(ops))
+	for i, op := range ops {
+		records[i] = kafkaRecord{op.Replica, op}
+	}
+	var res struct {
+		Offsets []struct {
+			Error *string
+		}
+	}
+	endpoint := "/topics/" + url.PathEscape(t.topic)
+	if err := t.do(ctx, http.MethodPost, endpoint, kafkaJSON, map[string]interface{}{"records": records}, &res); err != nil {
+		return err
+	}
+	for i, o := range res.Offsets {
+		if o.Error != nil {
+			return fmt.Errorf("%w: kafka: publishing %v/%d: %v", ErrBackendUnavailable, ops[i].Replica, ops[i].Seq, *o.Error)
+		}
+	}
+	return nil
+}
+
+// kafkaConsumer is a consumer instance of the proxy, subscribed to the
+// topic.
+type kafkaConsumer struct {
+	topic *KafkaTopic
+	base  string // base is the URL of the instance.
+}
+
+// consume creates a consumer instance in the group `group`, and subscribes it
+// to the topic. The group never commits offsets, so the instance reads the
+// topic from the start.
+func (t *KafkaTopic) consume(ctx context.Context, group string) (*kafkaConsumer, error) {
+	var res struct {
+		BaseURI string `json:"base_uri"`
+	}
+	err := t.do(ctx, http.MethodPost, "/consumers/"+url.PathEscape(group), kafkaAPI, map[string]string{
+		"format":             "json",
+		"auto.offset.reset":  "earliest",
+		"auto.commit.enable": "false",
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConsumer{topic: t, base: res.BaseURI}
+	if err := t.do(ctx, http.MethodPost, c.base+"/subscription", kafkaAPI, map[string][]string{"topics": {t.topic}}, nil); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// poll returns the operations of the next records of the topic, or none if
+// there were no new records before the proxy's request timeout.
+func (c *kafkaConsumer) poll(ctx context.Context) ([]*CRDTOp, error) {
+	var records []kafkaRecord
+	if err := c.topic.do(ctx, http.MethodGet, c.base+"/records", kafkaJSON, nil, &records); err != nil {
+		return nil, err
+	}
+	ops := make([]*CRDTOp, 0, len(records))
+	for _, r := range records {
+		if r.Value == nil {
+			log.Warnf("skipping record of replica %q without operation in kafka", r.Key)
+			continue
+		} else if err := r.Value.validate(); err != nil {
+			// Apply would fail on it every time the topic is replayed.
+			log.Warnf("skipping invalid record in kafka: %v", err)
+			continue
+		}
+		ops = append(ops, r.Value)
+	}
+	return ops, nil
+}
+
+// close deletes the consumer instance, so that the proxy releases it before
+// it times out.
+func (c *kafkaConsumer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultKafkaTimeout)
+	defer cancel()
+	if err := c.topic.do(ctx, http.MethodDelete, c.base, kafkaAPI, nil, nil); err != nil {
+		log.Warnf("deleting the kafka consumer %v: %v", c.base, err)
+	}
+}
+
+// SyncKafka makes `b` a replica of the event log in `t`, until `ctx` is done
+// or a request fails. It reads every operation of the topic from the start,
+// and applies those of other replicas. Once it has read every record, it
+// publishes the operations of `b` that aren't in the topic yet, then reads
+// the operations appended since, every WithKafkaInterval.
+//
+// Every replica reads the topic with its own consumer group, named after
+// the replica, and builds its own view of the blocklist. After an error,
+// calling SyncKafka again replays the topic, skipping the operations `b`
+// already applied.
+func SyncKafka(ctx context.Context, b *CRDTBlocklist, t *KafkaTopic) (err error) {
+	defer wrapError(&err, "kafka", "sync", cid.Undef)
+
+	c, err := t.consume(ctx, "blocklist-"+b.Replica())
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	// published is the Seq of the last operation of `b` in the topic.
+	var published uint64
+	for {
+		ops, err := c.poll(ctx)
+		if err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if op.Replica == b.Replica() && op.Seq > published {
+				published = op.Seq
+			}
+		}
+		if _, err := b.Apply(ctx, ops); err != nil {
+			return err
+		}
+		if len(ops) > 0 {
+			continue
+		}
+
+		// The topic is read up to its end: publish what it misses.
+		since, err := b.Version(ctx)
+		if err != nil {
+			return err
+		}
+		since[b.Replica()] = published
+		local, err := b.Delta(ctx, since)
+		if err != nil {
+			return err
+		}
+		if len(local) > 0 {
+			if err := t.Publish(ctx, local); err != nil {
+				return err
+			}
+			published = local[len(local)-1].Seq
+		}
+
+		select {
+		case <-time.After(t.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+var (
+	ErrUnknownList   = fmt.Errorf("unknown blocklist")
+	ErrDuplicateList = fmt.Errorf("blocklist already exists")
+)
+
+// Lists groups named blocklists, like "legal" or "malware-feed", so that one
+// Contains can check several of them. The lists usually share a backend, with
+// one table (see PgBlocklist.WithTable) or root prefix (see WithRootPrefix)
+// each. A list can be disabled without deleting its entries.
+type Lists struct {
+	mu        sync.RWMutex
+	lists     map[string]Blocklist
+	disabled  map[string]bool
+	schedules map[string]Schedule
+	store     ds.Datastore // store persists schedules, if set.
+}
+
+// NewLists returns Lists that keep their schedules in memory only, see
+// LoadLists.
+func NewLists() *Lists {
+	return &Lists{
+		lists:     make(map[string]Blocklist),
+		disabled:  make(map[string]bool),
+		schedules: make(map[string]Schedule),
+	}
+}
+
+// ScheduleStorePrefix is the namespace schedules are stored under by
+// LoadLists.
+var ScheduleStorePrefix = ds.NewKey("schedule")
+
+// LoadLists returns Lists that persist the schedules set with SetSchedule in
+// `d`, usually the datastore the lists themselves are in, and loads the
+// schedules stored there, so that scheduled lists stay scheduled across
+// restarts. Schedules of lists that aren't added yet apply once they are.
+func LoadLists(d ds.Datastore) (*Lists, error) {
+	l := NewLists()
+	l.store = d
+	rr, err := d.Query(dsq.Query{Prefix: ScheduleStorePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+	for res, ok := rr.NextSync(); ok; res, ok = rr.NextSync() {
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		k := ds.RawKey(res.Key)
+		if !ScheduleStorePrefix.IsAncestorOf(k) {
+			continue
+		}
+		var s Schedule
+		if err := json.Unmarshal(res.Value, &s); err != nil {
+			return nil, fmt.Errorf("schedule of %q: %w", k.Name(), err)
+		}
+		l.schedules[k.Name()] = s
+	}
+	return l, nil
+}
+
+// scheduleKey returns the key the schedule of the list `name` is stored at.
+func scheduleKey(name string) ds.Key {
+	return ScheduleStorePrefix.Child(ds.RawKey("/" + name))
+}
+
+// Window is a period of time, from Start until End.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%v-%v", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339))
+}
+
+// Schedule restricts the enforcement of a list to a set of windows, like the
+// broadcast times of a sporting event. A list without a schedule is always
+// enforced.
+type Schedule []Window
+
+// Active returns true if `t` is within one of the windows of `s`.
+func (s Schedule) Active(t time.Time) bool {
+	for _, w := range s {
+		if !t.Before(w.Start) && t.Before(w.End) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) String() string {
+	if len(s) == 0 {
+		return "always"
+	}
+	windows := make([]string, len(s))
+	for i, w := range s {
+		windows[i] = w.String()
+	}
+	return strings.Join(windows, ", ")
+}
+
+// Add registers `b` as the enabled list `name`.
+func (l *Lists) Add(name string, b Blocklist) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.lists[name]; ok {
+		return ErrDuplicateList
+	}
+	l.lists[name] = b
+	return nil
+}
+
+// Get returns the list `name`, whether it is enabled or not.
+func (l *Lists) Get(name string) (Blocklist, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	b, ok := l.lists[name]
+	return b, ok
+}
+
+// Names returns the names of all lists, sorted.
+func (l *Lists) Names() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.lists))
+	for name := range l.lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enable makes Contains check the list `name` again.
+func (l *Lists) Enable(name string) error {
+	return l.setEnabled(name, true)
+}
+
+// Disable makes Contains skip the list `name`, until it is enabled again.
+func (l *Lists) Disable(name string) error {
+	return l.setEnabled(name, false)
+}
+
+func (l *Lists) setEnabled(name string, enabled bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.lists[name]; !ok {
+		return ErrUnknownList
+	}
+	if enabled {
+		delete(l.disabled, name)
+	} else {
+		l.disabled[name] = true
+	}
+	return nil
+}
+
+// Enabled returns true if the list `name` exists and is enabled.
+func (l *Lists) Enabled(name string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.lists[name]
+	return ok && !l.disabled[name]
+}
+
+// SetSchedule restricts enforcement of the list `name` to the windows of `s`,
+// or lifts the restriction if `s` is empty. The change is stored, if the
+// Lists were loaded with LoadLists, and logged to the list as a "schedule"
+// action of `user`. If logging fails, the schedule is left unchanged.
+func (l *Lists) SetSchedule(ctx context.Context, name string, s Schedule, user string) error {
+	b, ok := l.Get(name)
+	if !ok {
+		return ErrUnknownList
+	} else if strings.Contains(name, "/") && l.store != nil {
+		return fmt.Errorf("list name %q can't be stored", name)
+	}
+	old := l.Schedule(name)
+	if err := l.storeSchedule(name, s); err != nil {
+		return err
+	}
+	err := b.AddLog(ctx, &Action{
+		Typ:       ActionSchedule,
+		Reason:    fmt.Sprintf("%v: %v", name, s),
+		User:      user,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if serr := l.storeSchedule(name, old); serr != nil {
+			log.Warnf("restoring the schedule of %v: %v", name, serr)
+		}
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(s) == 0 {
+		delete(l.schedules, name)
+	} else {
+		l.schedules[name] = s
+	}
+	return nil
+}
+
+// storeSchedule stores `s` as the schedule of the list `name`, if the Lists
+// have a store.
+func (l *Lists) storeSchedule(name string, s Schedule) error {
+	if l.store == nil {
+		return nil
+	} else if len(s) == 0 {
+		return l.store.Delete(scheduleKey(name))
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return l.store.Put(scheduleKey(name), raw)
+}
+
+// Schedule returns the schedule of the list `name`, or nil if it is always
+// enforced.
+func (l *Lists) Schedule(name string) Schedule {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.schedules[name]
+}
+
+// Enforced returns true if the list `name` is enabled and, if it has a
+// schedule, `t` is within one of its windows.
+func (l *Lists) Enforced(name string, t time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.lists[name]
+	s, scheduled := l.schedules[name]
+	return ok && !l.disabled[name] && (!scheduled || s.Active(t))
+}
+
+// Contains returns true if any of the enforced lists among `names` contains
+// `id`. If no names are given, all enforced lists are checked.
+func (l *Lists) Contains(ctx context.Context, id cid.Cid, names ...string) (bool, error) {
+	name, err := l.Match(ctx, id, names...)
+	return name != "", err
+}
+
+// Match is like Contains, but returns the name of the first list, in the
+// order of `names`, that contains `id`, or an empty string if none does.
+func (l *Lists) Match(ctx context.Context, id cid.Cid, names ...string) (string, error) {
+	if len(names) == 0 {
+		names = l.Names()
+	}
+	now := time.Now()
+	for _, name := range names {
+		b, ok := l.Get(name)
+		if !ok {
+			return "", ErrUnknownList
+		} else if !l.Enforced(name, now) {
+			continue
+		}
+		if exists, err := b.Contains(ctx, id); err != nil {
+			return "", err
+		} else if exists {
+			return name, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,52 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+func TestListSchedulesSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	now := time.Now()
+	s := Schedule{{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}}
+
+	l, err := LoadLists(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewMemoryBlocklist()
+	if err := l.Add("sports", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetSchedule(ctx, "sports", s, "u@x.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = LoadLists(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Add("sports", b); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Schedule("sports"); len(got) != 1 || !got[0].Start.Equal(s[0].Start) {
+		t.Fatalf("got schedule %v, want %v", got, s)
+	}
+	if l.Enforced("sports", now) || !l.Enforced("sports", now.Add(90*time.Minute)) {
+		t.Error("loaded schedule isn't enforced")
+	}
+
+	if err := l.SetSchedule(ctx, "sports", nil, "u@x.com"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = LoadLists(d); err != nil {
+		t.Fatal(err)
+	} else if got := l.Schedule("sports"); got != nil {
+		t.Errorf("got schedule %v after lifting it, want none", got)
+	}
+}
@@ -0,0 +1,29 @@
+package blocklist
+
+import (
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+// MemoryBlocklist keeps a blocklist and its audit log in memory, for tests of
+// downstream services and for gateways that load a denylist at startup. It is
+// a DatastoreBlocklist over a thread-safe map, so it behaves like the
+// datastore backend in every respect. Purge has no content to remove.
+type MemoryBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewMemoryBlocklist() *MemoryBlocklist {
+	b, err := NewDatastoreBlocklist(dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		// The default prefixes are valid.
+		panic(err)
+	}
+	return &MemoryBlocklist{b}
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash.
+func (b *MemoryBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
@@ -0,0 +1,232 @@
+package blocklist
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// MethodStats counts the calls to one Blocklist method.
+type MethodStats struct {
+	Calls    uint64
+	Errors   uint64
+	Duration time.Duration // Duration is the total time spent in the method.
+}
+
+// PoolStats describes the connection pool of a database-backed blocklist, so
+// that slow lookups caused by pool starvation can be told apart from slow
+// queries.
+type PoolStats struct {
+	sql.DBStats
+	// PreparedStatements is the number of statements in the prepared
+	// statement cache.
+	PreparedStatements int
+}
+
+// Pooled is implemented by blocklists that keep a connection pool.
+type Pooled interface {
+	PoolStats() PoolStats
+}
+
+// Stats is a snapshot of the metrics collected by MetricsBlocklist.
+type Stats struct {
+	Methods map[string]MethodStats
+	Pool    *PoolStats // Pool is nil if the backend isn't Pooled.
+	SLA     *SLAStats  // SLA is nil unless WithSLAMetrics is set.
+}
+
+// MetricsBlocklist records call counts, errors, and latency of every method of
+// the blocklist it wraps.
+type MetricsBlocklist struct {
+	Blocklist
+
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+	sla     *SLAStats
+}
+
+// MetricsOption configures a MetricsBlocklist.
+type MetricsOption func(*metricsOptions)
+
+type metricsOptions struct {
+	sla bool
+}
+
+// WithSLAMetrics measures the compliance SLAs of the blocks and purges logged
+// with AddLog, see SLAStats. Each of their CIDs costs up to two GetLogs calls
+// after the action is logged. Errors of those calls are logged and leave the
+// action out of the SLAs.
+func WithSLAMetrics() MetricsOption {
+	return func(o *metricsOptions) {
+		o.sla = true
+	}
+}
+
+func NewMetrics(b Blocklist, opts ...MetricsOption) *MetricsBlocklist {
+	o := &metricsOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	m := &MetricsBlocklist{Blocklist: b, methods: make(map[string]*MethodStats)}
+	if o.sla {
+		m.sla = &SLAStats{}
+	}
+	return m
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *MetricsBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// Stats returns a snapshot of the metrics collected so far.
+func (b *MetricsBlocklist) Stats() Stats {
+	b.mu.Lock()
+	methods := make(map[string]MethodStats, len(b.methods))
+	for name, m := range b.methods {
+		methods[name] = *m
+	}
+	stats := Stats{Methods: methods}
+	if b.sla != nil {
+		sla := *b.sla
+		stats.SLA = &sla
+	}
+	b.mu.Unlock()
+
+	if p, ok := b.Blocklist.(Pooled); ok {
+		pool := p.PoolStats()
+		stats.Pool = &pool
+	}
+	return stats
+}
+
+func (b *MetricsBlocklist) observe(method string, start time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.methods[method]
+	if !ok {
+		m = &MethodStats{}
+		b.methods[method] = m
+	}
+	m.Calls++
+	if err != nil {
+		m.Errors++
+	}
+	m.Duration += time.Since(start)
+}
+
+func (b *MetricsBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("Block", start, err) }(time.Now())
+	return b.Blocklist.Block(ctx, id, data)
+}
+
+func (b *MetricsBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) (blocked []cid.Cid, err error) {
+	defer func(start time.Time) { b.observe("BlockMany", start, err) }(time.Now())
+	return b.Blocklist.BlockMany(ctx, ids, data)
+}
+
+func (b *MetricsBlocklist) Unblock(ctx context.Context, id cid.Cid) (removed *BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("Unblock", start, err) }(time.Now())
+	return b.Blocklist.Unblock(ctx, id)
+}
+
+func (b *MetricsBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer func(start time.Time) { b.observe("UnblockMany", start, err) }(time.Now())
+	return b.Blocklist.UnblockMany(ctx, ids)
+}
+
+func (b *MetricsBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) (err error) {
+	defer func(start time.Time) { b.observe("Update", start, err) }(time.Now())
+	return b.Blocklist.Update(ctx, id, patch)
+}
+
+func (b *MetricsBlocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("Search", start, err) }(time.Now())
+	return b.Blocklist.Search(ctx, id)
+}
+
+func (b *MetricsBlocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("SearchMany", start, err) }(time.Now())
+	return b.Blocklist.SearchMany(ctx, ids)
+}
+
+func (b *MetricsBlocklist) SearchByContent(ctx context.Context, url string) (items []*BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("SearchByContent", start, err) }(time.Now())
+	return b.Blocklist.SearchByContent(ctx, url)
+}
+
+func (b *MetricsBlocklist) SearchHistory(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	defer func(start time.Time) { b.observe("SearchHistory", start, err) }(time.Now())
+	return b.Blocklist.SearchHistory(ctx, id)
+}
+
+func (b *MetricsBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) (err error) {
+	defer func(start time.Time) { b.observe("AddComment", start, err) }(time.Now())
+	return b.Blocklist.AddComment(ctx, id, c)
+}
+
+func (b *MetricsBlocklist) List(ctx context.Context, opts ListOptions) (page *ListPage, err error) {
+	defer func(start time.Time) { b.observe("List", start, err) }(time.Now())
+	return b.Blocklist.List(ctx, opts)
+}
+
+func (b *MetricsBlocklist) Count(ctx context.Context) (count int64, err error) {
+	defer func(start time.Time) { b.observe("Count", start, err) }(time.Now())
+	return b.Blocklist.Count(ctx)
+}
+
+func (b *MetricsBlocklist) Purge(ctx context.Context, id cid.Cid) (err error) {
+	defer func(start time.Time) { b.observe("Purge", start, err) }(time.Now())
+	return b.Blocklist.Purge(ctx, id)
+}
+
+func (b *MetricsBlocklist) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer func(start time.Time) { b.observe("GetLogs", start, err) }(time.Now())
+	return b.Blocklist.GetLogs(ctx, q)
+}
+
+func (b *MetricsBlocklist) AddLog(ctx context.Context, act *Action) (err error) {
+	start := time.Now()
+	err = b.Blocklist.AddLog(ctx, act)
+	b.observe("AddLog", start, err)
+	if err == nil && b.sla != nil {
+		b.observeSLA(ctx, act)
+	}
+	return err
+}
+
+// observeSLA adds the SLAs ended by `act` to the metrics.
+func (b *MetricsBlocklist) observeSLA(ctx context.Context, act *Action) {
+	var sla SLAStats
+	if err := sla.observeSLA(ctx, b.Blocklist, act); err != nil {
+		log.Warnf("measuring the SLAs of %v: %v", act, err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sla.IntakeToBlock.merge(sla.IntakeToBlock)
+	b.sla.BlockToPurge.merge(sla.BlockToPurge)
+}
+
+func (b *MetricsBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer func(start time.Time) { b.observe("Contains", start, err) }(time.Now())
+	return b.Blocklist.Contains(ctx, id)
+}
+
+func (b *MetricsBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer func(start time.Time) { b.observe("ContainsMany", start, err) }(time.Now())
+	return b.Blocklist.ContainsMany(ctx, ids)
+}
+
+func (b *MetricsBlocklist) Healthy(ctx context.Context) (err error) {
+	defer func(start time.Time) { b.observe("Healthy", start, err) }(time.Now())
+	return b.Blocklist.Healthy(ctx)
+}
+
+func (b *MetricsBlocklist) Close(ctx context.Context) (err error) {
+	defer func(start time.Time) { b.observe("Close", start, err) }(time.Now())
+	return b.Blocklist.Close(ctx)
+}
@@ -0,0 +1,232 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultMiddlewareTimeout is how long the middleware waits for the blocklist
+// before applying its FailPolicy.
+const DefaultMiddlewareTimeout = 20 * time.Millisecond
+
+// FailPolicy is what the middleware does when the blocklist doesn't answer in
+// time, or fails.
+type FailPolicy int
+
+const (
+	// FailOpen serves the content.
+	FailOpen FailPolicy = iota
+	// FailClosed refuses to serve the content, with 503 Service Unavailable.
+	FailClosed
+)
+
+// Checker is the part of a Blocklist the middleware needs.
+type Checker interface {
+	Contains(ctx context.Context, id cid.Cid) (bool, error)
+}
+
+// Searcher is the part of a Blocklist the middleware needs to tell purged
+// content apart.
+type Searcher interface {
+	Search(ctx context.Context, id cid.Cid) (*BlocklistItem, error)
+}
+
+// MiddlewareCounters counts the decisions made by the middleware.
+type MiddlewareCounters struct {
+	Allowed          uint64
+	Blocked          uint64
+	DeadlineExceeded uint64 // DeadlineExceeded counts lookups that timed out.
+	Errors           uint64 // Errors counts lookups that failed otherwise.
+}
+
+// Middleware refuses gateway requests for blocked content with 451
+// Unavailable For Legal Reasons, or 410 Gone if it was purged and
+// WithGoneForPurged is set. Lookups have their own short deadline, so
+// that a slow blocklist never dominates the time to first byte.
+type Middleware struct {
+	// Counters are first to keep them 64-bit aligned for atomic operations.
+	allowed, blocked, deadlineExceeded, errors uint64
+
+	checker  Checker
+	timeout  time.Duration
+	policy   FailPolicy
+	gone     bool
+	names    *BlockingNameResolver
+	deadline func(ctx context.Context) (context.Context, context.CancelFunc)
+}
+
+// MiddlewareOption configures a Middleware.
+type MiddlewareOption func(*Middleware)
+
+// WithTimeout sets how long lookups may take, instead of
+// DefaultMiddlewareTimeout.
+func WithTimeout(d time.Duration) MiddlewareOption {
+	return func(m *Middleware) {
+		m.timeout = d
+	}
+}
+
+// WithFailPolicy sets what happens when a lookup times out or fails. The
+// default is FailOpen.
+func WithFailPolicy(p FailPolicy) MiddlewareOption {
+	return func(m *Middleware) {
+		m.policy = p
+	}
+}
+
+// WithGoneForPurged responds 410 Gone instead of 451 to requests for blocked
+// content that was also purged locally. The Checker has to be a Searcher, like
+// a Blocklist. If the entry can't be looked up in time, 451 is used.
+func WithGoneForPurged() MiddlewareOption {
+	return func(m *Middleware) {
+		m.gone = true
+	}
+}
+
+// WithNameBlocks also refuses requests for /ipns/ names while `r` blocks
+// them, with 451. Names are only checked, not resolved: the gateway resolves
+// them with `r`, which blocks the names that resolve to blocked content.
+func WithNameBlocks(r *BlockingNameResolver) MiddlewareOption {
+	return func(m *Middleware) {
+		m.names = r
+	}
+}
+
+// WithDeadlineFunc replaces how the lookup context is derived from the request
+// context. It is meant for tests, which can make lookups time out
+// deterministically.
+func WithDeadlineFunc(fn func(ctx context.Context) (context.Context, context.CancelFunc)) MiddlewareOption {
+	return func(m *Middleware) {
+		m.deadline = fn
+	}
+}
+
+func NewMiddleware(c Checker, opts ...MiddlewareOption) *Middleware {
+	m := &Middleware{
+		checker: c,
+		timeout: DefaultMiddlewareTimeout,
+		policy:  FailOpen,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.deadline == nil {
+		m.deadline = func(ctx context.Context) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(ctx, m.timeout)
+		}
+	}
+	return m
+}
+
+// Counters returns the decisions made so far.
+func (m *Middleware) Counters() MiddlewareCounters {
+	return MiddlewareCounters{
+		Allowed:          atomic.LoadUint64(&m.allowed),
+		Blocked:          atomic.LoadUint64(&m.blocked),
+		DeadlineExceeded: atomic.LoadUint64(&m.deadlineExceeded),
+		Errors:           atomic.LoadUint64(&m.errors),
+	}
+}
+
+// Handler wraps `next`, refusing requests for blocked content.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := requestCid(r)
+		if !ok {
+			if name, ok := requestName(r); ok && m.names != nil {
+				if _, blocked := m.names.Blocked(name); blocked {
+					atomic.AddUint64(&m.blocked, 1)
+					status := http.StatusUnavailableForLegalReasons
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := m.deadline(r.Context())
+		exists, err := m.checker.Contains(ctx, id)
+		cancel()
+
+		switch {
+		case err != nil:
+			if errors.Is(err, context.DeadlineExceeded) {
+				atomic.AddUint64(&m.deadlineExceeded, 1)
+			} else {
+				atomic.AddUint64(&m.errors, 1)
+			}
+			log.Warnf("blocklist lookup of %v failed: %v", RedactID(id), err)
+			if m.policy == FailClosed {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		case exists:
+			atomic.AddUint64(&m.blocked, 1)
+			status := http.StatusUnavailableForLegalReasons
+			if m.purged(r.Context(), id) {
+				status = http.StatusGone
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		default:
+			atomic.AddUint64(&m.allowed, 1)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// purged returns true if WithGoneForPurged is set and the blocked content `id`
+// was purged.
+func (m *Middleware) purged(ctx context.Context, id cid.Cid) bool {
+	s, ok := m.checker.(Searcher)
+	if !m.gone || !ok {
+		return false
+	}
+	ctx, cancel := m.deadline(ctx)
+	defer cancel()
+	item, err := s.Search(ctx, id)
+	if err != nil {
+		log.Warnf("blocklist search of %v failed: %v", RedactID(id), err)
+		return false
+	}
+	return item.PurgedAt != nil
+}
+
+// requestCid returns the CID a gateway request is for, from either a
+// /ipfs/<cid> path or a <cid>.ipfs.<domain> host.
+func requestCid(r *http.Request) (cid.Cid, bool) {
+	if labels := strings.SplitN(r.Host, ".", 3); len(labels) == 3 && labels[1] == "ipfs" {
+		if id, err := cid.Decode(labels[0]); err == nil {
+			return id, true
+		}
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(segments) >= 2 && segments[0] == "ipfs" {
+		if id, err := cid.Decode(segments[1]); err == nil {
+			return id, true
+		}
+	}
+	return cid.Undef, false
+}
+
+// requestName returns the IPNS name a gateway request is for, from either a
+// /ipns/<name> path or a <name>.ipns.<domain> host.
+func requestName(r *http.Request) (string, bool) {
+	if labels := strings.SplitN(r.Host, ".", 3); len(labels) == 3 && labels[1] == "ipns" && labels[0] != "" {
+		return "/ipns/" + decodeDNSLinkLabel(labels[0]), true
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(segments) >= 2 && segments[0] == "ipns" && segments[1] != "" {
+		return "/ipns/" + segments[1], true
+	}
+	return "", false
+}
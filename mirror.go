@@ -0,0 +1,332 @@
+package blocklist
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Defaults of the options of NewMirror.
+const (
+	DefaultMirrorInterval = 10 * time.Second
+	DefaultMirrorOverlap  = time.Minute
+)
+
+// Changes are the entries of a blocklist changed after a watermark, as
+// returned by ChangeLister.ChangesSince.
+type Changes struct {
+	// Blocked are the entries blocked or updated, with their digests.
+	Blocked []*BlocklistItem
+	// Unblocked are the entries unblocked, without their digests. Entries of
+	// content blocked again are in Blocked too.
+	Unblocked []*BlocklistItem
+	// Watermark is the time of the most recent change, or the watermark the
+	// changes were listed from if there were none.
+	Watermark time.Time
+}
+
+// ChangeLister is implemented by blocklists that can list their changes
+// incrementally, like PgBlocklist.
+type ChangeLister interface {
+	ChangesSince(ctx context.Context, since time.Time) (*Changes, error)
+}
+
+// MirrorOption configures a MirrorBlocklist.
+type MirrorOption func(*mirrorOptions)
+
+type mirrorOptions struct {
+	interval time.Duration
+	overlap  time.Duration
+}
+
+// WithMirrorInterval sets how often the mirror is refreshed, instead of
+// DefaultMirrorInterval.
+func WithMirrorInterval(d time.Duration) MirrorOption {
+	return func(o *mirrorOptions) {
+		o.interval = d
+	}
+}
+
+// WithMirrorOverlap sets how long before the last watermark changes are
+// listed again, instead of DefaultMirrorOverlap. It has to cover the clock
+// skew between the writers of the blocklist, and their longest transactions.
+func WithMirrorOverlap(d time.Duration) MirrorOption {
+	return func(o *mirrorOptions) {
+		o.overlap = d
+	}
+}
+
+// MirrorBlocklist keeps the hashes and digests of every entry of the
+// blocklist it wraps in memory, so that Contains and ContainsMany are map
+// lookups, and keep being answered while the backend is unavailable. Other
+// methods use the wrapped blocklist.
+//
+// The mirror is refreshed in the background. If the wrapped blocklist is a
+// ChangeLister, only the changes since the last refresh are read; otherwise,
+// the whole blocklist is listed again. Changes made through the
+// MirrorBlocklist are mirrored as soon as they are made.
+//
+// Hashes and digests are mirrored as their multihashes, so that the mirror
+// matches content under any version or codec of its CID, like the backends
+// it wraps.
+type MirrorBlocklist struct {
+	Blocklist
+	overlap time.Duration
+
+	mu        sync.RWMutex
+	entries   map[string][]string // entries maps multihashes to those of their digests.
+	digests   map[string]string   // digests maps multihashes of digests to their entry's.
+	watermark time.Time
+	refreshed time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMirror loads `b` into memory, and refreshes it until Close is called.
+// It fails if `b` can't be loaded.
+func NewMirror(ctx context.Context, b Blocklist, opts ...MirrorOption) (*MirrorBlocklist, error) {
+	o := &mirrorOptions{interval: DefaultMirrorInterval, overlap: DefaultMirrorOverlap}
+	for _, opt := range opts {
+		opt(o)
+	}
+	m := &MirrorBlocklist{Blocklist: b, overlap: o.overlap, done: make(chan struct{})}
+	if err := m.load(ctx); err != nil {
+		return nil, err
+	}
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(o.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := m.Refresh(loopCtx); err != nil && loopCtx.Err() == nil {
+					log.Warnf("refreshing the mirror, last refreshed %v: %v", m.Refreshed(), err)
+				}
+			case <-loopCtx.Done():
+				return
+			}
+		}
+	}()
+	return m, nil
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (m *MirrorBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(m.Blocklist)
+}
+
+// Len returns the number of mirrored entries.
+func (m *MirrorBlocklist) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// Refreshed returns when the mirror was last loaded or refreshed.
+func (m *MirrorBlocklist) Refreshed() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.refreshed
+}
+
+// load replaces the mirror by every entry of the wrapped blocklist.
+func (m *MirrorBlocklist) load(ctx context.Context) error {
+	start := time.Now()
+	entries, digests := make(map[string][]string), make(map[string]string)
+	items, errc := EntriesIter(ctx, m.Blocklist, ListOptions{})
+	for item := range items {
+		mirror(entries, digests, item)
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries, m.digests = entries, digests
+	m.watermark, m.refreshed = start, time.Now()
+	return nil
+}
+
+// Refresh reads the changes of the wrapped blocklist since the last refresh,
+// or the whole blocklist if it isn't a ChangeLister.
+func (m *MirrorBlocklist) Refresh(ctx context.Context) error {
+	cl, ok := m.Blocklist.(ChangeLister)
+	if !ok {
+		return m.load(ctx)
+	}
+
+	m.mu.RLock()
+	since := m.watermark.Add(-m.overlap)
+	m.mu.RUnlock()
+	changes, err := cl.ChangesSince(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range changes.Unblocked {
+		unmirror(m.entries, m.digests, item.Hash)
+	}
+	for _, item := range changes.Blocked {
+		mirror(m.entries, m.digests, item)
+	}
+	if changes.Watermark.After(m.watermark) {
+		m.watermark = changes.Watermark
+	}
+	m.refreshed = time.Now()
+	return nil
+}
+
+// mirrorHash returns the multihash of the stored hash `s`, as bytes in a
+// string, or `s` if it isn't a CID.
+func mirrorHash(s string) string {
+	if id, err := cid.Decode(strings.TrimSpace(s)); err == nil {
+		return string(id.Hash())
+	}
+	return s
+}
+
+// mirror adds `item` to `entries` and `digests`.
+func mirror(entries map[string][]string, digests map[string]string, item *BlocklistItem) {
+	hash := mirrorHash(item.Hash)
+	unmirror(entries, digests, hash)
+	hashes := make([]string, len(item.Digests))
+	for i, d := range item.Digests {
+		hashes[i] = mirrorHash(d)
+		digests[hashes[i]] = hash
+	}
+	entries[hash] = hashes
+}
+
+// unmirror removes the entry `hash` and its digests from `entries` and
+// `digests`.
+func unmirror(entries map[string][]string, digests map[string]string, hash string) {
+	hash = mirrorHash(hash)
+	for _, d := range entries[hash] {
+		if digests[d] == hash {
+			delete(digests, d)
+		}
+	}
+	delete(entries, hash)
+}
+
+// contains returns whether `id` is mirrored. m.mu has to be held.
+func (m *MirrorBlocklist) contains(id cid.Cid) (bool, error) {
+	if _, err := Normalize(id); err != nil {
+		return false, err
+	}
+	hash := string(id.Hash())
+	if _, ok := m.entries[hash]; ok {
+		return true, nil
+	}
+	_, ok := m.digests[hash]
+	return ok, nil
+}
+
+// Contains returns whether `id` is the hash or a digest of a mirrored entry.
+func (m *MirrorBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "mirror", "contains", id)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.contains(id)
+}
+
+func (m *MirrorBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "mirror", "containsmany", cid.Undef)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res = make(map[cid.Cid]bool, len(ids))
+	for _, id := range ids {
+		if res[id], err = m.contains(id); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// add mirrors the entries of `ids` blocked through the MirrorBlocklist.
+func (m *MirrorBlocklist) add(ids []cid.Cid, data BlockData) {
+	item := &BlocklistItem{}
+	for _, d := range data.Digests {
+		item.Digests = append(item.Digests, d.String())
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		item.Hash = id.String()
+		if _, ok := m.entries[mirrorHash(item.Hash)]; !ok {
+			mirror(m.entries, m.digests, item)
+		}
+	}
+}
+
+// remove unmirrors the entries of `ids` unblocked through the
+// MirrorBlocklist. They can be digests of their entries.
+func (m *MirrorBlocklist) remove(ids ...cid.Cid) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		hash := mirrorHash(id.String())
+		if parent, ok := m.digests[hash]; ok {
+			hash = parent
+		}
+		unmirror(m.entries, m.digests, hash)
+	}
+}
+
+func (m *MirrorBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	existing, err := m.Blocklist.Block(ctx, id, data)
+	if err == nil {
+		m.add([]cid.Cid{id}, data)
+	}
+	return existing, err
+}
+
+func (m *MirrorBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error) {
+	blocked, err := m.Blocklist.BlockMany(ctx, ids, data)
+	if err == nil {
+		m.add(blocked, data)
+	}
+	return blocked, err
+}
+
+func (m *MirrorBlocklist) Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	removed, err := m.Blocklist.Unblock(ctx, id)
+	if err == nil {
+		m.remove(id)
+	}
+	return removed, err
+}
+
+func (m *MirrorBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res, err := m.Blocklist.UnblockMany(ctx, ids)
+	if err == nil {
+		for id, ok := range res {
+			if ok {
+				m.remove(id)
+			}
+		}
+	}
+	return res, err
+}
+
+// Close stops refreshing the mirror, and closes the wrapped blocklist.
+func (m *MirrorBlocklist) Close(ctx context.Context) error {
+	m.cancel()
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return m.Blocklist.Close(ctx)
+}
@@ -0,0 +1,69 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// changeListerBlocklist answers ChangesSince with `changes`, and records the
+// watermark it was asked for.
+type changeListerBlocklist struct {
+	*MemoryBlocklist
+	changes *Changes
+	since   []time.Time
+}
+
+func (b *changeListerBlocklist) ChangesSince(ctx context.Context, since time.Time) (*Changes, error) {
+	b.since = append(b.since, since)
+	return b.changes, nil
+}
+
+func TestMirrorMatchesEveryCodec(t *testing.T) {
+	ctx := context.Background()
+	b := &changeListerBlocklist{MemoryBlocklist: NewMemoryBlocklist()}
+	first, digest := testCID(t, "first"), testCID(t, "digest")
+	if _, err := b.Block(ctx, first, BlockData{Reason: "test", User: "u@x.com", Digests: []cid.Cid{digest}}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMirror(ctx, b, WithMirrorInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close(ctx)
+	if m.Len() != 1 {
+		t.Fatalf("mirrored %v entries, want 1", m.Len())
+	}
+	for _, id := range []cid.Cid{
+		cid.NewCidV0(first.Hash()),
+		cid.NewCidV1(cid.DagProtobuf, first.Hash()),
+		cid.NewCidV1(cid.DagCBOR, digest.Hash()),
+	} {
+		if ok, err := m.Contains(ctx, id); err != nil || !ok {
+			t.Errorf("Contains(%v) = %v, %v after load, want true", id, ok, err)
+		}
+	}
+
+	// The refresh only reads the changes, which are stored under other codecs
+	// than they are looked up with.
+	second := testCID(t, "second")
+	b.changes = &Changes{
+		Blocked:   []*BlocklistItem{{Hash: cid.NewCidV1(cid.DagProtobuf, second.Hash()).String()}},
+		Unblocked: []*BlocklistItem{{Hash: cid.NewCidV0(first.Hash()).String()}},
+		Watermark: time.Now(),
+	}
+	if err := m.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.since) != 1 {
+		t.Fatalf("ChangesSince was called %v times, want 1", len(b.since))
+	}
+	for id, want := range map[cid.Cid]bool{second: true, first: false, digest: false} {
+		if ok, err := m.Contains(ctx, id); err != nil || ok != want {
+			t.Errorf("Contains(%v) = %v, %v after refresh, want %v", id, ok, err, want)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+//go:build mongodb
+// +build mongodb
+
+package blocklist
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultMongoTimeout is how long requests to MongoDB may take, except change
+// streams and the pages of queries after the first one.
+const DefaultMongoTimeout = 10 * time.Second
+
+// ErrHistoryLost is returned by MongoBlocklist.Watch if the change stream
+// can't resume from the token it was given: the oplog was truncated past it.
+// The watcher has to reload the blocklist.
+var ErrHistoryLost = fmt.Errorf("mongodb change stream history was lost")
+
+// mongoPage is the number of documents Query reads per batch.
+const mongoPage = 1000
+
+// mongoHistoryLost is the code of the ChangeStreamHistoryLost server error.
+const mongoHistoryLost = 286
+
+// MongoOption configures a Mongo.
+type MongoOption func(*mongoOptions)
+
+type mongoOptions struct {
+	timeout time.Duration
+}
+
+// WithMongoTimeout sets how long requests may take, instead of
+// DefaultMongoTimeout.
+func WithMongoTimeout(d time.Duration) MongoOption {
+	return func(o *mongoOptions) {
+		o.timeout = d
+	}
+}
+
+// Mongo is a datastore stored in a MongoDB collection, with a document per
+// key. Keys are the _id of their documents, so the unique index of _id keeps
+// one entry per normalized multihash, and key ranges are read from it in
+// order.
+//
+// Queries run as aggregation pipelines: the prefix, key filters and key order
+// of a query, and then its offset and limit, are applied by the server.
+// Batches are sent as unordered bulk writes, so they aren't atomic.
+type Mongo struct {
+	coll    *mongo.Collection
+	timeout time.Duration
+}
+
+var _ ds.Batching = (*Mongo)(nil)
+
+// NewMongo returns a datastore stored in `coll`. The client of `coll` is
+// owned by the caller, and isn't disconnected by Close.
+func NewMongo(coll *mongo.Collection, opts ...MongoOption) *Mongo {
+	o := &mongoOptions{timeout: DefaultMongoTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Mongo{coll: coll, timeout: o.timeout}
+}
+
+// mongoDoc is the document a key is stored in.
+type mongoDoc struct {
+	Key   string `bson:"_id"`
+	Value []byte `bson:"value,omitempty"`
+}
+
+// mongoError translates errors of the driver: missing documents to
+// ds.ErrNotFound, and network failures and timeouts to ErrBackendUnavailable.
+func mongoError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ds.ErrNotFound
+	case mongo.IsNetworkError(err) || mongo.IsTimeout(err):
+		return fmt.Errorf("%w: mongodb: %v", ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+func (m *Mongo) Get(k ds.Key) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var doc mongoDoc
+	if err := m.coll.FindOne(ctx, bson.M{"_id": k.String()}).Decode(&doc); err != nil {
+		return nil, mongoError(err)
+	}
+	if doc.Value == nil {
+		doc.Value = []byte{}
+	}
+	return doc.Value, nil
+}
+
+func (m *Mongo) Has(k ds.Key) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	n, err := m.coll.CountDocuments(ctx, bson.M{"_id": k.String()}, options.Count().SetLimit(1))
+	return n > 0, mongoError(err)
+}
+
+func (m *Mongo) GetSize(k ds.Key) (int, error) {
+	v, err := m.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (m *Mongo) Put(k ds.Key, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"_id": k.String()}, mongoDoc{k.String(), value}, options.Replace().SetUpsert(true))
+	return mongoError(err)
+}
+
+func (m *Mongo) Delete(k ds.Key) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": k.String()})
+	return mongoError(err)
+}
+
+// mongoOps are the query operators of the key comparisons of queries.
+var mongoOps = map[dsq.Op]string{
+	dsq.Equal:              "$eq",
+	dsq.NotEqual:           "$ne",
+	dsq.GreaterThan:        "$gt",
+	dsq.GreaterThanOrEqual: "$gte",
+	dsq.LessThan:           "$lt",
+	dsq.LessThanOrEqual:    "$lte",
+}
+
+// pipeline returns the aggregation pipeline of `q`, and the query that is
+// left to apply to its results.
+func (m *Mongo) pipeline(q dsq.Query) (mongo.Pipeline, dsq.Query) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	match := bson.A{bson.M{"_id": bson.M{"$gte": lo, "$lt": hi}}}
+	naive := q
+	naive.Filters = nil
+	for _, f := range q.Filters {
+		var fk dsq.FilterKeyCompare
+		switch f := f.(type) {
+		case dsq.FilterKeyCompare:
+			fk = f
+		case *dsq.FilterKeyCompare:
+			fk = *f
+		}
+		op, ok := mongoOps[fk.Op]
+		if !ok {
+			naive.Filters = append(naive.Filters, f)
+			continue
+		}
+		match = append(match, bson.M{"_id": bson.M{op: fk.Key}})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"$and": match}}}}
+
+	order := 1
+	if len(q.Orders) == 1 {
+		switch q.Orders[0].(type) {
+		case dsq.OrderByKey, *dsq.OrderByKey:
+			naive.Orders = nil
+		case dsq.OrderByKeyDescending, *dsq.OrderByKeyDescending:
+			order, naive.Orders = -1, nil
+		}
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.M{"_id": order}}})
+	if len(naive.Filters) == 0 && len(naive.Orders) == 0 {
+		// The server returns the results in their final order, so it can
+		// page them.
+		if q.Offset > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Offset}})
+		}
+		if q.Limit > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+		}
+		naive.Offset, naive.Limit = 0, 0
+	}
+	if q.KeysOnly {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{"value": 0}}})
+	}
+	return pipeline, naive
+}
+
+// Query runs `q` as an aggregation pipeline, and reads its results a batch at
+// a time. Filters other than key comparisons, and orders other than by key,
+// are applied to the results.
+func (m *Mongo) Query(q dsq.Query) (dsq.Results, error) {
+	pipeline, naive := m.pipeline(q)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	cur, err := m.coll.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(mongoPage))
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	next := func() (dsq.Result, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		if !cur.Next(ctx) {
+			if err := cur.Err(); err != nil {
+				return dsq.Result{Error: mongoError(err)}, true
+			}
+			return dsq.Result{}, false
+		}
+		var doc mongoDoc
+		if err := cur.Decode(&doc); err != nil {
+			return dsq.Result{Error: err}, true
+		}
+		if q.KeysOnly {
+			return dsq.Result{Entry: dsq.Entry{Key: doc.Key, Size: -1}}, true
+		}
+		if doc.Value == nil {
+			doc.Value = []byte{}
+		}
+		return dsq.Result{Entry: dsq.Entry{Key: doc.Key, Value: doc.Value, Size: len(doc.Value)}}, true
+	}
+	closeCursor := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		return mongoError(cur.Close(ctx))
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next, Close: closeCursor})), nil
+}
+
+// Sync does nothing: writes are durable once MongoDB acknowledges them, with
+// the write concern of the collection.
+func (m *Mongo) Sync(prefix ds.Key) error {
+	return nil
+}
+
+// Close does nothing: the client belongs to the caller.
+func (m *Mongo) Close() error {
+	return nil
+}
+
+func (m *Mongo) Batch() (ds.Batch, error) {
+	return &mongoBatch{mongo: m, ops: make(map[ds.Key]mongo.WriteModel)}, nil
+}
+
+// mongoBatch buffers writes until Commit sends them.
+type mongoBatch struct {
+	mongo *Mongo
+	ops   map[ds.Key]mongo.WriteModel
+}
+
+func (b *mongoBatch) Put(k ds.Key, value []byte) error {
+	b.ops[k] = mongo.NewReplaceOneModel().
+		SetFilter(bson.M{"_id": k.String()}).
+		SetReplacement(mongoDoc{k.String(), value}).
+		SetUpsert(true)
+	return nil
+}
+
+func (b *mongoBatch) Delete(k ds.Key) error {
+	b.ops[k] = mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": k.String()})
+	return nil
+}
+
+// Commit sends the batch as one unordered bulk write, which the driver splits
+// in as many commands as the server needs. If one fails, the other writes
+// stay applied.
+func (b *mongoBatch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	ops := make([]mongo.WriteModel, 0, len(b.ops))
+	for _, op := range b.ops {
+		ops = append(ops, op)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.mongo.timeout)
+	defer cancel()
+	_, err := b.mongo.coll.BulkWrite(ctx, ops, options.BulkWrite().SetOrdered(false))
+	return mongoError(err)
+}
+
+// ResumeToken returns a token that resumes a change stream of the collection
+// from now, to watch changes made after it.
+func (m *Mongo) ResumeToken(ctx context.Context) (bson.Raw, error) {
+	cs, err := m.coll.Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	defer cs.Close(ctx)
+	return cs.ResumeToken(), nil
+}
+
+// mongoChange is an event of a change stream.
+type mongoChange struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		Key string `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// watch calls `fn` with the keys in the range from `lo` to `hi` excluded that
+// change after the resume token `token`, whether they were deleted, and the
+// token that resumes after them, until `ctx` is done or `fn` returns an
+// error.
+func (m *Mongo) watch(ctx context.Context, lo, hi string, token bson.Raw, fn func(k ds.Key, deleted bool, token bson.Raw) error) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType":   bson.M{"$in": bson.A{"insert", "replace", "update", "delete"}},
+		"documentKey._id": bson.M{"$gte": lo, "$lt": hi},
+	}}}}
+	opts := options.ChangeStream()
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	cs, err := m.coll.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return m.watchError(token, err)
+	}
+	defer cs.Close(context.Background())
+
+	for cs.Next(ctx) {
+		var ch mongoChange
+		if err := cs.Decode(&ch); err != nil {
+			return err
+		}
+		if err := fn(ds.RawKey(ch.DocumentKey.Key), ch.OperationType == "delete", cs.ResumeToken()); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return m.watchError(token, cs.Err())
+}
+
+// watchError translates the errors of a change stream, resumed from `token`.
+func (m *Mongo) watchError(token bson.Raw, err error) error {
+	var se mongo.ServerError
+	if errors.As(err, &se) && se.HasErrorCode(mongoHistoryLost) {
+		return fmt.Errorf("%w: resuming after %v", ErrHistoryLost, token)
+	} else if err != nil && !mongo.IsNetworkError(err) && !mongo.IsTimeout(err) {
+		return err
+	}
+	return fmt.Errorf("%w: mongodb: change stream interrupted: %v", ErrBackendUnavailable, err)
+}
+
+// MongoBlocklist is a DatastoreBlocklist stored in a MongoDB collection of its
+// own, for gateways that already run a replica set. Watch follows the change
+// stream of the collection, so that other nodes learn of changes as they
+// are made, without polling.
+//
+// Only one process should write to the blocklist: audit actions are numbered
+// by the writer. MongoAuditStore keeps them in a collection that can be
+// queried by user, type, time and content instead.
+type MongoBlocklist struct {
+	DatastoreBlocklist
+	mongo   *Mongo
+	root    ds.Key
+	entries ds.Key
+	digests ds.Key
+}
+
+func NewMongoBlocklist(m *Mongo, opts ...DatastoreOption) (*MongoBlocklist, error) {
+	o, err := newDatastoreOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewDatastoreBlocklist(m, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoBlocklist{b, m, o.root, o.root.Child(o.blocklist), o.root.Child(o.digest)}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *MongoBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// MongoEvent is a change of the blocklist, seen by MongoBlocklist.Watch.
+type MongoEvent struct {
+	// Id is the CIDv1-raw of the multihash an entry or one of its digests is
+	// stored under, that was blocked or unblocked.
+	Id      cid.Cid
+	Blocked bool
+	// ResumeToken resumes a watch right after the change.
+	ResumeToken bson.Raw
+}
+
+// Watch calls `fn` with the CIDs blocked or unblocked after the resume token
+// `token`, as returned by Mongo.ResumeToken or a previous event, until `ctx`
+// is done or `fn` returns an error. Updates of entries are reported as
+// blocks. A gateway takes a token, loads the blocklist, then watches it from
+// the token. If Watch returns ErrHistoryLost, changes were lost, and the
+// blocklist has to be loaded again.
+//
+// The change stream requires a replica set or a sharded cluster. The server
+// only sends changes of the blocklist's keys, and those of the audit log and
+// comments are skipped.
+func (b *MongoBlocklist) Watch(ctx context.Context, token bson.Raw, fn func(MongoEvent) error) (err error) {
+	defer wrapError(&err, "mongodb", "watch", cid.Undef)
+
+	lo, hi := prefixRange(b.root)
+	return b.mongo.watch(ctx, lo, hi, token, func(k ds.Key, deleted bool, token bson.Raw) error {
+		if !b.entries.Equal(k.Parent()) && !b.digests.Equal(k.Parent()) {
+			return nil
+		}
+		id, err := keyToCid(ds.NewKey(k.BaseNamespace()))
+		if err != nil {
+			log.Warnf("skipping key %v of unknown format in mongodb", k)
+			return nil
+		}
+		return fn(MongoEvent{Id: id, Blocked: !deleted, ResumeToken: token})
+	})
+}
+
+// MongoAuditStore stores the audit log of a blocklist in a MongoDB collection,
+// with a document per action. GetLogs runs as an aggregation pipeline over
+// indexes of the fields it filters on, so that audits of a user or a piece of
+// content don't read the whole log.
+type MongoAuditStore struct {
+	coll    *mongo.Collection
+	timeout time.Duration
+
+	mu      sync.Mutex
+	lastSeq uint64 // lastSeq is the Seq of the last action logged.
+}
+
+var _ AuditStore = (*MongoAuditStore)(nil)
+
+// NewMongoAuditStore returns an audit store stored in `coll`. Call Migrate
+// to create its indexes.
+func NewMongoAuditStore(coll *mongo.Collection, opts ...MongoOption) *MongoAuditStore {
+	o := &mongoOptions{timeout: DefaultMongoTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &MongoAuditStore{coll: coll, timeout: o.timeout}
+}
+
+// Migrate creates the indexes of the collection, if they don't exist.
+func (s *MongoAuditStore) Migrate(ctx context.Context) (err error) {
+	defer wrapError(&err, "mongodb", "migrate", cid.Undef)
+
+	_, err = s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "typ", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "multihashes", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return mongoError(err)
+}
+
+// mongoLog is the document of an action.
+type mongoLog struct {
+	Seq       int64     `bson:"_id"`
+	CreatedAt time.Time `bson:"created_at"`
+	Typ       string    `bson:"typ"`
+	Ids       []string  `bson:"ids"`
+	// Multihashes are the multihashes of Ids, in hex, that GetLogs filters
+	// on.
+	Multihashes []string    `bson:"multihashes"`
+	Undoes      uint64      `bson:"undoes,omitempty"`
+	Changes     []Change    `bson:"changes,omitempty"`
+	Refs        []Reference `bson:"refs,omitempty"`
+	HLC         string      `bson:"hlc,omitempty"`
+	Reason      string      `bson:"reason"`
+	User        string      `bson:"user"`
+}
+
+// nextSeq returns the Seq of an action logged now.
+func (s *MongoAuditStore) nextSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := uint64(time.Now().UnixNano())
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+	return seq
+}
+
+// AddLog saves a record that `act` took place, and sets its Seq. Its
+// CreatedAt is set to now if it is zero.
+func (s *MongoAuditStore) AddLog(ctx context.Context, act *Action) (err error) {
+	defer wrapError(&err, "mongodb", "addlog", cid.Undef)
+
+	if !act.Typ.Valid() {
+		return fmt.Errorf("unexpected action type: '%v'", act.Typ)
+	}
+	log.Info(act.String())
+
+	doc := mongoLog{
+		Typ:         string(act.Typ),
+		Ids:         make([]string, len(act.Ids)),
+		Multihashes: make([]string, len(act.Ids)),
+		Undoes:      act.Undoes,
+		Changes:     act.Changes,
+		Refs:        act.References,
+		Reason:      act.Reason,
+		User:        act.User,
+	}
+	for i, id := range act.Ids {
+		doc.Ids[i], doc.Multihashes[i] = id.String(), hex.EncodeToString(id.Hash())
+	}
+	if act.HLC != nil {
+		doc.HLC = act.HLC.String()
+	}
+	createdAt := act.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	// MongoDB stores times in milliseconds.
+	doc.CreatedAt = createdAt.UTC().Truncate(time.Millisecond)
+	seq := s.nextSeq()
+	doc.Seq = int64(seq)
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if _, err := s.coll.InsertOne(ctx, doc); err != nil {
+		return mongoError(err)
+	}
+	act.Seq, act.CreatedAt = seq, doc.CreatedAt
+	return nil
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first.
+func (s *MongoAuditStore) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	defer wrapError(&err, "mongodb", "getlogs", cid.Undef)
+
+	match := bson.M{}
+	if q.Before > 0 {
+		match["_id"] = bson.M{"$lt": int64(q.Before)}
+	}
+	if q.User != "" {
+		match["user"] = q.User
+	}
+	if q.Typ != "" {
+		match["typ"] = string(q.Typ)
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		created := bson.M{}
+		if !q.Since.IsZero() {
+			created["$gte"] = q.Since
+		}
+		if !q.Until.IsZero() {
+			created["$lt"] = q.Until
+		}
+		match["created_at"] = created
+	}
+	if q.Id.Defined() {
+		match["$or"] = bson.A{
+			bson.M{"multihashes": hex.EncodeToString(q.Id.Hash())},
+			bson.M{"ids": q.Id.String()},
+		}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	cur, err := s.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var l mongoLog
+		if err := cur.Decode(&l); err != nil {
+			return nil, err
+		}
+		act := &Action{
+			Seq:        uint64(l.Seq),
+			Typ:        ActionType(l.Typ),
+			Undoes:     l.Undoes,
+			Changes:    l.Changes,
+			References: l.Refs,
+			Reason:     l.Reason,
+			User:       l.User,
+			CreatedAt:  l.CreatedAt,
+		}
+		for _, r := range l.Ids {
+			id, err := cid.Parse(r)
+			if err != nil {
+				return nil, err
+			}
+			act.Ids = append(act.Ids, id)
+		}
+		if l.HLC != "" {
+			act.HLC = &HLC{}
+			if err := act.HLC.UnmarshalText([]byte(l.HLC)); err != nil {
+				return nil, err
+			}
+		}
+		acts = append(acts, act)
+	}
+	return acts, mongoError(cur.Err())
+}
@@ -0,0 +1,105 @@
+package blocklist
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// IDRedactor formats the CIDs of log messages and errors, for deployments
+// where blocked CIDs must not appear in plaintext outside of the blocklist.
+type IDRedactor func(id cid.Cid) string
+
+// PlainIDs logs CIDs as they are. It is the default.
+func PlainIDs(id cid.Cid) string {
+	return id.String()
+}
+
+// OmitIDs replaces CIDs by Redacted.
+func OmitIDs(cid.Cid) string {
+	return Redacted
+}
+
+// TruncateIDs keeps the last `n` characters of CIDs, enough to tell entries
+// apart in a log without being able to fetch them. The start of CIDs is left
+// out as it is mostly their version, codec, and hash function.
+func TruncateIDs(n int) IDRedactor {
+	return func(id cid.Cid) string {
+		s := id.String()
+		if len(s) <= n {
+			return s
+		}
+		return "…" + s[len(s)-n:]
+	}
+}
+
+// HashIDs replaces CIDs by the start of their HMAC-SHA256 with `key`, so that
+// log lines about the same content can be correlated. The key keeps CIDs from
+// being recovered by hashing known ones: share it only with those who may
+// see the CIDs.
+func HashIDs(key []byte) IDRedactor {
+	return func(id cid.Cid) string {
+		if n, err := Normalize(id); err == nil {
+			id = n
+		}
+		m := hmac.New(sha256.New, key)
+		m.Write(id.Bytes())
+		return "hmac:" + hex.EncodeToString(m.Sum(nil)[:8])
+	}
+}
+
+// idRedactor holds the IDRedactor set with SetIDRedactor.
+var idRedactor atomic.Value
+
+// SetIDRedactor formats the CIDs of every log message of the package, of the
+// errors of the backends, and of Action.String, with `r`. A nil `r` restores
+// PlainIDs. The package doesn't label metrics with CIDs.
+//
+// It is meant to be called once at startup, before the blocklist is used.
+func SetIDRedactor(r IDRedactor) {
+	idRedactor.Store(r)
+}
+
+// currentRedactor returns the IDRedactor set with SetIDRedactor, or nil for
+// PlainIDs.
+func currentRedactor() IDRedactor {
+	r, _ := idRedactor.Load().(IDRedactor)
+	return r
+}
+
+// RedactID formats `id` with the IDRedactor set with SetIDRedactor, for
+// callers that log CIDs alongside the package.
+func RedactID(id cid.Cid) string {
+	r := currentRedactor()
+	if r == nil || !id.Defined() {
+		return id.String()
+	}
+	return r(id)
+}
+
+// redactIDs formats `ids` like a slice, with RedactID.
+func redactIDs(ids []cid.Cid) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = RedactID(id)
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// redactHash formats the stored string form of a CID with RedactID. Strings
+// that aren't CIDs are replaced by Redacted unless CIDs are logged as they
+// are, as they may be one in a form the package can't parse.
+func redactHash(hash string) string {
+	if currentRedactor() == nil {
+		return hash
+	}
+	id, err := cid.Decode(hash)
+	if err != nil {
+		return Redacted
+	}
+	return RedactID(id)
+}
@@ -0,0 +1,128 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DefaultSlowQuery is how long queries run before PgQueryLogger reports them
+// as slow, if it isn't given a threshold.
+const DefaultSlowQuery = 100 * time.Millisecond
+
+// PgQueryLogger logs the queries of a PgBlocklist, and of the lookups of a
+// PgxBlocklist, with the logger of the package, as structured fields: "sql",
+// "elapsed", "rows", and "err". Failed queries are logged as errors, queries
+// slower than the threshold as warnings, and, with LogMode(logger.Info),
+// every query at the debug level.
+//
+// Unless CIDs are logged as they are, the string values of the queries are
+// replaced by '?', see SetIDRedactor.
+type PgQueryLogger struct {
+	slow  time.Duration
+	level logger.LogLevel
+}
+
+// NewPgQueryLogger returns a PgQueryLogger that reports queries slower than
+// `slow`, or DefaultSlowQuery if it is zero.
+func NewPgQueryLogger(slow time.Duration) *PgQueryLogger {
+	if slow <= 0 {
+		slow = DefaultSlowQuery
+	}
+	return &PgQueryLogger{slow: slow, level: logger.Warn}
+}
+
+// WithSlowQueryLog logs the queries of the blocklist with a PgQueryLogger
+// that reports queries slower than `slow`.
+func WithSlowQueryLog(slow time.Duration) PgOption {
+	return WithLogger(NewPgQueryLogger(slow))
+}
+
+// LogMode returns a copy of the logger that logs at `level`.
+func (l *PgQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	c := *l
+	c.level = level
+	return &c
+}
+
+func (l *PgQueryLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		log.Infof(msg, args...)
+	}
+}
+
+func (l *PgQueryLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		log.Warnf(msg, args...)
+	}
+}
+
+func (l *PgQueryLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		log.Errorf(msg, args...)
+	}
+}
+
+// Trace logs the query run by gorm at `begin`.
+func (l *PgQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	elapsed := time.Since(begin)
+	if err == nil && elapsed < l.slow && l.level < logger.Info {
+		return
+	}
+	sql, rows := fc()
+	l.log(redactSQL(sql), elapsed, rows, err)
+}
+
+// Log logs the queries of pgx, which only reports the time of those that
+// succeed.
+func (l *PgQueryLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	sql, ok := data["sql"].(string)
+	if !ok || l.level <= logger.Silent {
+		return
+	}
+	elapsed, _ := data["time"].(time.Duration)
+	rows, _ := data["rowCount"].(int)
+	err, _ := data["err"].(error)
+	if level <= pgx.LogLevelError && err == nil {
+		err = errors.New(msg)
+	}
+	if err == nil && elapsed < l.slow && l.level < logger.Info {
+		return
+	}
+	// pgx queries have placeholders, and their values are left out.
+	l.log(sql, elapsed, int64(rows), err)
+}
+
+func (l *PgQueryLogger) log(sql string, elapsed time.Duration, rows int64, err error) {
+	switch {
+	case err != nil && l.level >= logger.Error:
+		log.Errorw("query failed", "sql", sql, "elapsed", elapsed, "rows", rows, "err", err)
+	case err == nil && elapsed >= l.slow && l.level >= logger.Warn:
+		log.Warnw("slow query", "sql", sql, "elapsed", elapsed, "rows", rows)
+	case err == nil && l.level >= logger.Info:
+		log.Debugw("query", "sql", sql, "elapsed", elapsed, "rows", rows)
+	}
+}
+
+// sqlString matches the string literals of SQL.
+var sqlString = regexp.MustCompile(`'(?:[^']|'')*'`)
+
+// redactSQL replaces the string literals of `sql` by '?', unless CIDs are
+// logged as they are.
+func redactSQL(sql string) string {
+	if currentRedactor() == nil {
+		return sql
+	}
+	return sqlString.ReplaceAllString(sql, "'?'")
+}
@@ -0,0 +1,201 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// PgxBlocklist is a PgBlocklist whose lookups run on a pgx connection pool
+// with hand-written queries, without the reflection and query building of
+// gorm. Contains, ContainsMany, and Healthy use the pgx pool. Other methods
+// are those of the PgBlocklist, on the same tables.
+type PgxBlocklist struct {
+	*PgBlocklist
+	pool *pgxpool.Pool
+
+	containsQuery     string
+	containsManyQuery string
+}
+
+// NewPgxBlocklist connects to the database at `dsn` like NewPgBlocklist, and
+// opens a pgx connection pool for lookups next to it. Both pools are sized by
+// the options.
+//
+// Queries are prepared once per connection, unless WithPreparedStatements
+// disables it, for instance behind PgBouncer in transaction pooling mode. A
+// PgQueryLogger set with WithLogger logs the lookups too.
+func NewPgxBlocklist(dsn string, opts ...PgOption) (*PgxBlocklist, error) {
+	pg, err := NewPgBlocklist(dsn, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newPgOptions(opts)
+	dsn, err = dsnWithSSLMode(dsn, o.sslmode)
+	if err != nil {
+		pg.Close(context.Background())
+		return nil, err
+	}
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		pg.Close(context.Background())
+		return nil, fmt.Errorf("invalid dsn: %w", err)
+	}
+	if o.maxOpenConns > 0 {
+		config.MaxConns = int32(o.maxOpenConns)
+	}
+	// Zero means forever for the options, but right away for pgx.
+	config.MaxConnLifetime, config.MaxConnIdleTime = o.connMaxLifetime, o.connMaxIdleTime
+	if o.connMaxLifetime <= 0 {
+		config.MaxConnLifetime = math.MaxInt64
+	}
+	if o.connMaxIdleTime <= 0 {
+		config.MaxConnIdleTime = math.MaxInt64
+	}
+	if l, ok := o.logger.(*PgQueryLogger); ok {
+		config.ConnConfig.Logger = l
+		config.ConnConfig.LogLevel = pgx.LogLevelInfo
+	}
+	if !o.prepareStmt {
+		config.ConnConfig.BuildStatementCache = nil
+		config.ConnConfig.PreferSimpleProtocol = true
+	}
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		pg.Close(context.Background())
+		return nil, pgError(err)
+	}
+
+	return newPgxBlocklist(pg, pool), nil
+}
+
+// newPgxBlocklist returns a PgxBlocklist that looks up the tables of `pg`
+// with `pool`.
+func newPgxBlocklist(pg *PgBlocklist, pool *pgxpool.Pool) *PgxBlocklist {
+	table, digests := pgQuote(pg.blocklistTable), pgQuote(pg.digestTable())
+	return &PgxBlocklist{
+		PgBlocklist: pg,
+		pool:        pool,
+		containsQuery: fmt.Sprintf(
+			"SELECT EXISTS (SELECT 1 FROM %v WHERE multihash = ANY($1) OR hash IN (SELECT parent FROM %v WHERE multihash = ANY($1)))",
+			table, digests),
+		containsManyQuery: fmt.Sprintf(
+			"SELECT multihash FROM %v WHERE multihash = ANY($1) UNION SELECT multihash FROM %v WHERE multihash = ANY($1)",
+			table, digests),
+	}
+}
+
+// WithTable returns a blocklist that shares the connections of `b`, but
+// stores its entries in `table`, like PgBlocklist.WithTable.
+func (b *PgxBlocklist) WithTable(table string) *PgxBlocklist {
+	return newPgxBlocklist(b.PgBlocklist.WithTable(table), b.pool)
+}
+
+// WithTransformer returns a blocklist that shares the connections and table
+// of `b`, but canonicalizes CIDs with `t` instead of CIDv1.
+func (b *PgxBlocklist) WithTransformer(t Transformer) *PgxBlocklist {
+	return newPgxBlocklist(b.PgBlocklist.WithTransformer(t), b.pool)
+}
+
+// Pool returns the pgx connection pool for direct queries.
+func (b *PgxBlocklist) Pool() *pgxpool.Pool {
+	return b.pool
+}
+
+// PoolStats returns statistics about the pgx connection pool, which serves
+// the lookups. Those of the gorm pool are returned by b.PgBlocklist.PoolStats.
+func (b *PgxBlocklist) PoolStats() PoolStats {
+	s := b.pool.Stat()
+	var stats PoolStats
+	stats.MaxOpenConnections = int(s.MaxConns())
+	stats.OpenConnections = int(s.TotalConns())
+	stats.InUse = int(s.AcquiredConns())
+	stats.Idle = int(s.IdleConns())
+	stats.WaitCount = s.EmptyAcquireCount()
+	stats.WaitDuration = s.AcquireDuration()
+	return stats
+}
+
+// Contains returns true if the blocklist contains the content referenced by
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (b *PgxBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "pgx", "contains", id)
+
+	multihashes, err := b.multihashes(id)
+	if err != nil {
+		return false, err
+	}
+	if err := b.pool.QueryRow(ctx, b.containsQuery, multihashes).Scan(&exists); err != nil {
+		return false, pgError(err)
+	}
+	return exists, nil
+}
+
+// ContainsMany returns which of `ids` the blocklist contains, with a single
+// query.
+func (b *PgxBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	defer wrapError(&err, "pgx", "containsmany", cid.Undef)
+
+	// candidates maps every multihash an id could be matched on to the ids.
+	candidates := make(map[string][]cid.Cid, len(ids))
+	res = make(map[cid.Cid]bool, len(ids))
+	multihashes := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		ms, err := b.multihashes(id)
+		if err != nil {
+			return nil, err
+		}
+		res[id] = false
+		for _, m := range ms {
+			if _, ok := candidates[string(m)]; !ok {
+				multihashes = append(multihashes, m)
+			}
+			candidates[string(m)] = append(candidates[string(m)], id)
+		}
+	}
+	if len(multihashes) == 0 {
+		return res, nil
+	}
+
+	rows, err := b.pool.Query(ctx, b.containsManyQuery, multihashes)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m []byte
+		if err := rows.Scan(&m); err != nil {
+			return nil, pgError(err)
+		}
+		for _, id := range candidates[string(m)] {
+			res[id] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+	return res, nil
+}
+
+// Healthy returns an error if the database can't be reached through either
+// pool.
+func (b *PgxBlocklist) Healthy(ctx context.Context) (err error) {
+	if err := b.PgBlocklist.Healthy(ctx); err != nil {
+		return err
+	}
+	defer wrapError(&err, "pgx", "healthy", cid.Undef)
+	return pgError(b.pool.Ping(ctx))
+}
+
+// Close closes the pgx pool, waiting for running lookups to release their
+// connections, then the connections of the PgBlocklist.
+func (b *PgxBlocklist) Close(ctx context.Context) error {
+	b.pool.Close()
+	return b.PgBlocklist.Close(ctx)
+}
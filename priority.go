@@ -0,0 +1,290 @@
+package blocklist
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Priority is how urgently a request to the blocklist has to be served.
+type Priority int
+
+const (
+	// PriorityInteractive is for requests that someone is waiting on, like
+	// gateway Contains checks. It is the default.
+	PriorityInteractive Priority = iota
+	// PriorityBulk is for background jobs like imports and reconciliation.
+	PriorityBulk
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that makes requests to a prioritized
+// blocklist run with priority `p`.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set with WithPriority, or
+// PriorityInteractive if none or an unknown one was set.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p == PriorityBulk {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// PrioritizedBlocklist limits the number of concurrent requests to a backend
+// that shares its connections between gateway traffic and bulk jobs. When all
+// slots are in use, interactive requests are served before bulk ones, and bulk
+// requests can never hold more than their share of the slots.
+type PrioritizedBlocklist struct {
+	Blocklist
+	sem *prioritySem
+}
+
+// NewPrioritized wraps `b` so that at most `size` requests run concurrently,
+// of which at most `bulk` have PriorityBulk. If `bulk` isn't between 1 and
+// `size`, bulk requests may use all slots, but still yield to interactive ones.
+// If `size` is 0 or less, the number of requests isn't limited, and only bulk
+// requests are, to `bulk` if it is positive. Bulk methods, like BlockMany,
+// always run with PriorityBulk.
+func NewPrioritized(b Blocklist, size, bulk int) *PrioritizedBlocklist {
+	if size < 0 {
+		size = 0
+	}
+	if bulk <= 0 || (size > 0 && bulk > size) {
+		bulk = size
+	}
+	return &PrioritizedBlocklist{b, &prioritySem{size: size, bulk: bulk}}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *PrioritizedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+func (b *PrioritizedBlocklist) do(ctx context.Context, p Priority, fn func() error) error {
+	if err := b.sem.acquire(ctx, p); err != nil {
+		return err
+	}
+	defer b.sem.release(p)
+	return fn()
+}
+
+func (b *PrioritizedBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (existing *BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		existing, err = b.Blocklist.Block(ctx, id, data)
+		return err
+	})
+	return existing, err
+}
+
+func (b *PrioritizedBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) (blocked []cid.Cid, err error) {
+	err = b.do(ctx, PriorityBulk, func() error {
+		blocked, err = b.Blocklist.BlockMany(ctx, ids, data)
+		return err
+	})
+	return blocked, err
+}
+
+func (b *PrioritizedBlocklist) Unblock(ctx context.Context, id cid.Cid) (removed *BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		removed, err = b.Blocklist.Unblock(ctx, id)
+		return err
+	})
+	return removed, err
+}
+
+func (b *PrioritizedBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	err = b.do(ctx, PriorityBulk, func() error {
+		res, err = b.Blocklist.UnblockMany(ctx, ids)
+		return err
+	})
+	return res, err
+}
+
+func (b *PrioritizedBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) error {
+	return b.do(ctx, PriorityFromContext(ctx), func() error {
+		return b.Blocklist.Update(ctx, id, patch)
+	})
+}
+
+func (b *PrioritizedBlocklist) Search(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		item, err = b.Blocklist.Search(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (b *PrioritizedBlocklist) SearchMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]*BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		res, err = b.Blocklist.SearchMany(ctx, ids)
+		return err
+	})
+	return res, err
+}
+
+func (b *PrioritizedBlocklist) SearchByContent(ctx context.Context, url string) (items []*BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		items, err = b.Blocklist.SearchByContent(ctx, url)
+		return err
+	})
+	return items, err
+}
+
+func (b *PrioritizedBlocklist) SearchHistory(ctx context.Context, id cid.Cid) (item *BlocklistItem, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		item, err = b.Blocklist.SearchHistory(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (b *PrioritizedBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) error {
+	return b.do(ctx, PriorityFromContext(ctx), func() error {
+		return b.Blocklist.AddComment(ctx, id, c)
+	})
+}
+
+func (b *PrioritizedBlocklist) List(ctx context.Context, opts ListOptions) (page *ListPage, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		page, err = b.Blocklist.List(ctx, opts)
+		return err
+	})
+	return page, err
+}
+
+func (b *PrioritizedBlocklist) Count(ctx context.Context) (count int64, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		count, err = b.Blocklist.Count(ctx)
+		return err
+	})
+	return count, err
+}
+
+func (b *PrioritizedBlocklist) Purge(ctx context.Context, id cid.Cid) error {
+	return b.do(ctx, PriorityFromContext(ctx), func() error {
+		return b.Blocklist.Purge(ctx, id)
+	})
+}
+
+func (b *PrioritizedBlocklist) GetLogs(ctx context.Context, q LogQuery) (acts []*Action, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		acts, err = b.Blocklist.GetLogs(ctx, q)
+		return err
+	})
+	return acts, err
+}
+
+func (b *PrioritizedBlocklist) AddLog(ctx context.Context, act *Action) error {
+	return b.do(ctx, PriorityFromContext(ctx), func() error {
+		return b.Blocklist.AddLog(ctx, act)
+	})
+}
+
+func (b *PrioritizedBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		exists, err = b.Blocklist.Contains(ctx, id)
+		return err
+	})
+	return exists, err
+}
+
+func (b *PrioritizedBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (res map[cid.Cid]bool, err error) {
+	err = b.do(ctx, PriorityFromContext(ctx), func() error {
+		res, err = b.Blocklist.ContainsMany(ctx, ids)
+		return err
+	})
+	return res, err
+}
+
+func (b *PrioritizedBlocklist) Healthy(ctx context.Context) error {
+	return b.do(ctx, PriorityFromContext(ctx), func() error {
+		return b.Blocklist.Healthy(ctx)
+	})
+}
+
+// prioritySem is a counting semaphore that hands out free slots to waiting
+// interactive requests before waiting bulk requests.
+type prioritySem struct {
+	mu         sync.Mutex
+	size, bulk int
+	used       int
+	usedBulk   int
+	waiters    [2]list.List // of chan struct{}, indexed by Priority
+}
+
+func (s *prioritySem) acquire(ctx context.Context, p Priority) error {
+	s.mu.Lock()
+	if s.waiters[p].Len() == 0 && s.available(p) {
+		s.take(p)
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := s.waiters[p].PushBack(ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// The slot was handed to us while giving up, so pass it on.
+			s.mu.Unlock()
+			s.release(p)
+		default:
+			s.waiters[p].Remove(elem)
+			s.notify()
+			s.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+func (s *prioritySem) release(p Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used--
+	if p == PriorityBulk {
+		s.usedBulk--
+	}
+	s.notify()
+}
+
+// available returns true if a request with priority `p` can take a slot
+// without jumping the queue. A size or bulk of 0 is unlimited. It must be
+// called with s.mu held.
+func (s *prioritySem) available(p Priority) bool {
+	if s.size > 0 && s.used >= s.size {
+		return false
+	} else if p == PriorityBulk {
+		return (s.bulk == 0 || s.usedBulk < s.bulk) && s.waiters[PriorityInteractive].Len() == 0
+	}
+	return true
+}
+
+func (s *prioritySem) take(p Priority) {
+	s.used++
+	if p == PriorityBulk {
+		s.usedBulk++
+	}
+}
+
+// notify hands free slots to waiters, interactive ones first. It must be
+// called with s.mu held.
+func (s *prioritySem) notify() {
+	for _, p := range []Priority{PriorityInteractive, PriorityBulk} {
+		for s.waiters[p].Len() > 0 && s.available(p) {
+			ready := s.waiters[p].Remove(s.waiters[p].Front()).(chan struct{})
+			s.take(p)
+			close(ready)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPrioritizedUnknownPriority(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := NewPrioritized(NewMemoryBlocklist(), 2, 1)
+	for _, p := range []Priority{-1, 2, PriorityBulk, PriorityInteractive} {
+		if _, err := b.Contains(WithPriority(ctx, p), testCID(t, "a")); err != nil {
+			t.Errorf("priority %v: %v", p, err)
+		}
+	}
+}
+
+func TestPrioritizedUnlimited(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, size := range []int{0, -1} {
+		b := NewPrioritized(NewMemoryBlocklist(), size, 1)
+		// Slots are held, as by requests in flight.
+		for i := 0; i < 3; i++ {
+			if err := b.sem.acquire(ctx, PriorityInteractive); err != nil {
+				t.Fatalf("size %v: interactive request %v: %v", size, i, err)
+			}
+		}
+		if _, err := b.Contains(WithPriority(ctx, PriorityBulk), testCID(t, "a")); err != nil {
+			t.Errorf("size %v: bulk request: %v", size, err)
+		}
+
+		// Bulk requests are still limited.
+		if err := b.sem.acquire(ctx, PriorityBulk); err != nil {
+			t.Fatalf("size %v: %v", size, err)
+		}
+		short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+		if err := b.sem.acquire(short, PriorityBulk); err != context.DeadlineExceeded {
+			t.Errorf("size %v: second bulk request = %v, want it to wait", size, err)
+		}
+		cancelShort()
+	}
+}
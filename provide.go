@@ -0,0 +1,74 @@
+package blocklist
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Provider is the part of a content routing provider system that announces
+// content, like provider.System of go-ipfs-provider.
+type Provider interface {
+	Provide(id cid.Cid) error
+}
+
+// FilteredProvider keeps blocked content out of the provide queue of the
+// Provider it wraps.
+type FilteredProvider struct {
+	Provider
+	checker Checker
+}
+
+func NewFilteredProvider(p Provider, c Checker) *FilteredProvider {
+	return &FilteredProvider{p, c}
+}
+
+// Provide announces `id`, unless it is blocked. If the blocklist can't be
+// checked, `id` isn't announced and the error is returned: the reprovider
+// announces it later anyway.
+func (p *FilteredProvider) Provide(id cid.Cid) error {
+	blocked, err := p.checker.Contains(context.Background(), id)
+	if err != nil {
+		return err
+	} else if blocked {
+		log.Debugf("not providing blocked content %v", RedactID(id))
+		return nil
+	}
+	return p.Provider.Provide(id)
+}
+
+// KeyChanFunc lists the content to reprovide, like simple.KeyChanFunc of
+// go-ipfs-provider.
+type KeyChanFunc func(ctx context.Context) (<-chan cid.Cid, error)
+
+// FilterKeys drops blocked content from the keys listed by `keys`. As blocked
+// content isn't reprovided, the provider records already published for it
+// expire. Keys that can't be checked are dropped too.
+func FilterKeys(c Checker, keys KeyChanFunc) KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		in, err := keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out := make(chan cid.Cid)
+		go func() {
+			defer close(out)
+			for id := range in {
+				if blocked, err := c.Contains(ctx, id); ctx.Err() != nil {
+					return
+				} else if err != nil {
+					log.Warnf("not reproviding %v: blocklist lookup failed: %v", RedactID(id), err)
+					continue
+				} else if blocked {
+					continue
+				}
+				select {
+				case out <- id:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out, nil
+	}
+}
@@ -0,0 +1,109 @@
+package blocklist
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	mh "github.com/multiformats/go-multihash"
+	"lukechampine.com/blake3"
+)
+
+// BLAKE3 is the multihash code of blake3, with 32-byte digests. The version
+// of go-multihash the package uses doesn't register it, so the package does.
+const BLAKE3 = 0x1e
+
+func init() {
+	mh.Register(BLAKE3, func() hash.Hash { return blake3.New(32, nil) })
+	if _, ok := mh.Codes[BLAKE3]; !ok {
+		mh.Codes[BLAKE3] = "blake3"
+		mh.Names["blake3"] = BLAKE3
+	}
+}
+
+// RawDigestCodes are the hash functions raw digests of blocked content are
+// computed with.
+var RawDigestCodes = []uint64{mh.SHA2_256, BLAKE3}
+
+// RawDigests returns the digests of the content read from `r` as raw CIDv1s,
+// one for each of RawDigestCodes.
+func RawDigests(r io.Reader) ([]cid.Cid, error) {
+	return rawDigests(func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// LocalRawDigests returns the raw digests of the content referenced by `id`,
+// reassembled from the blocks stored in `d`. Unlike `id`, they don't depend on
+// the chunker or DAG layout the content was added with.
+func LocalRawDigests(ctx context.Context, d ds.Read, id cid.Cid) ([]cid.Cid, error) {
+	return rawDigests(func(w io.Writer) error {
+		return writeRaw(ctx, d, id, w)
+	})
+}
+
+func rawDigests(write func(w io.Writer) error) ([]cid.Cid, error) {
+	hashers := make([]hash.Hash, len(RawDigestCodes))
+	writers := make([]io.Writer, len(RawDigestCodes))
+	for i, code := range RawDigestCodes {
+		h, err := mh.GetHasher(code)
+		if err != nil {
+			return nil, err
+		}
+		hashers[i], writers[i] = h, h
+	}
+	if err := write(io.MultiWriter(writers...)); err != nil {
+		return nil, err
+	}
+
+	digests := make([]cid.Cid, len(hashers))
+	for i, h := range hashers {
+		hash, err := mh.Encode(h.Sum(nil), RawDigestCodes[i])
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = cid.NewCidV1(cid.Raw, hash)
+	}
+	return digests, nil
+}
+
+// ContainsRawDigest returns true if any raw digest of the content read from
+// `r` is blocked, so that content re-added with different chunking is matched
+// against entries that were blocked with BlockData.Rehash.
+func ContainsRawDigest(ctx context.Context, b Blocklist, r io.Reader) (bool, error) {
+	digests, err := RawDigests(r)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range digests {
+		if exists, err := b.Contains(ctx, d); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// rehash adds the raw digests of the content referenced by `id` to
+// `data.Digests` if it was requested and the content is still in `d`.
+func rehash(ctx context.Context, d ds.Read, id cid.Cid, data BlockData) (BlockData, error) {
+	if !data.Rehash || d == nil {
+		return data, nil
+	}
+	digests, err := LocalRawDigests(ctx, d, id)
+	if err == ds.ErrNotFound || err == ErrNotFile {
+		log.Debugf("not rehashing %v: %v", RedactID(id), err)
+		return data, nil
+	} else if err != nil {
+		return data, err
+	}
+
+	for _, digest := range digests {
+		if !digest.Equals(id) {
+			data.Digests = append(data.Digests, digest)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,27 @@
+package blocklist
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestRawDigestsIncludeBlake3(t *testing.T) {
+	digests, err := RawDigests(strings.NewReader("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 2 {
+		t.Fatalf("got %v digests, want 2", len(digests))
+	}
+	d, err := mh.Decode(digests[1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"
+	if d.Code != BLAKE3 || hex.EncodeToString(d.Digest) != want {
+		t.Errorf("got %v %x, want blake3 %v", d.Name, d.Digest, want)
+	}
+}
@@ -0,0 +1,73 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrReadOnly is returned by the methods of a ReadOnlyBlocklist that would
+// change it.
+var ErrReadOnly = fmt.Errorf("blocklist is read-only")
+
+// ReadOnlyBlocklist refuses every change to the wrapped blocklist with
+// ErrReadOnly, for replicas and edge nodes that read the canonical compliance
+// data but must never change it, even through a misconfigured admin API.
+// Block, BlockMany, Unblock, UnblockMany, Update, AddComment, Purge, and
+// AddLog are refused; every other method is passed through.
+type ReadOnlyBlocklist struct {
+	Blocklist
+}
+
+// NewReadOnly wraps `b` so that it can't be changed.
+func NewReadOnly(b Blocklist) *ReadOnlyBlocklist {
+	return &ReadOnlyBlocklist{Blocklist: b}
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist, without
+// Purge and Rehash.
+func (b *ReadOnlyBlocklist) Capabilities() Capabilities {
+	c := CapabilitiesOf(b.Blocklist)
+	c.Purge, c.Rehash = false, false
+	return c
+}
+
+// readOnly returns ErrReadOnly for the operation `op` on `id`.
+func readOnly(op string, id cid.Cid) error {
+	err := ErrReadOnly
+	wrapError(&err, "readonly", op, id)
+	return err
+}
+
+func (b *ReadOnlyBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	return nil, readOnly("block", id)
+}
+
+func (b *ReadOnlyBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error) {
+	return nil, readOnly("blockmany", cid.Undef)
+}
+
+func (b *ReadOnlyBlocklist) Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	return nil, readOnly("unblock", id)
+}
+
+func (b *ReadOnlyBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	return nil, readOnly("unblockmany", cid.Undef)
+}
+
+func (b *ReadOnlyBlocklist) Update(ctx context.Context, id cid.Cid, patch BlockData) error {
+	return readOnly("update", id)
+}
+
+func (b *ReadOnlyBlocklist) AddComment(ctx context.Context, id cid.Cid, c *Comment) error {
+	return readOnly("addcomment", id)
+}
+
+func (b *ReadOnlyBlocklist) Purge(ctx context.Context, id cid.Cid) error {
+	return readOnly("purge", id)
+}
+
+func (b *ReadOnlyBlocklist) AddLog(ctx context.Context, act *Action) error {
+	return readOnly("addlog", cid.Undef)
+}
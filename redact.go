@@ -0,0 +1,165 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// RoleSensitive is the role of the users who may see the audit actions of
+// sensitive categories unredacted.
+const RoleSensitive = "sensitive"
+
+type rolesKey struct{}
+
+// WithRoles returns a context that makes requests to the blocklist on behalf
+// of a user with `roles`, like RoleSensitive.
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, roles)
+}
+
+// RolesFromContext returns the roles set with WithRoles.
+func RolesFromContext(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey{}).([]string)
+	return roles
+}
+
+// HasRole returns true if `role` was set with WithRoles.
+func HasRole(ctx context.Context, role string) bool {
+	for _, r := range RolesFromContext(ctx) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultCategoryKey is the Metadata key that holds the category of entries.
+const DefaultCategoryKey = "category"
+
+// Redacted replaces the fields hidden by RedactedBlocklist.
+const Redacted = "[redacted]"
+
+// RedactOption configures a RedactedBlocklist.
+type RedactOption func(*RedactedBlocklist)
+
+// WithCategoryKey reads the category of entries from the Metadata key `key`,
+// instead of DefaultCategoryKey.
+func WithCategoryKey(key string) RedactOption {
+	return func(b *RedactedBlocklist) {
+		b.key = key
+	}
+}
+
+// RedactedBlocklist masks the audit actions on entries of sensitive
+// categories for users without RoleSensitive in their context. Their Reason,
+// and the old and new values of the Content and Reason they changed, are
+// replaced by Redacted. The ids, type, user, and time of the actions are kept.
+//
+// The category of an action is the category of the current entry of its ids,
+// or of their last unblocked entry. Only GetLogs is redacted: entries
+// returned by Search and List are not.
+type RedactedBlocklist struct {
+	Blocklist
+	sensitive map[string]bool
+	key       string
+}
+
+// NewRedacted wraps `b` so that the actions on entries of the `sensitive`
+// categories are redacted.
+func NewRedacted(b Blocklist, sensitive []string, opts ...RedactOption) *RedactedBlocklist {
+	r := &RedactedBlocklist{
+		Blocklist: b,
+		sensitive: make(map[string]bool, len(sensitive)),
+		key:       DefaultCategoryKey,
+	}
+	for _, c := range sensitive {
+		r.sensitive[c] = true
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Capabilities returns the Capabilities of the wrapped blocklist.
+func (b *RedactedBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// GetLogs returns the auditable actions that match `q`, most recent first,
+// redacted unless the context has RoleSensitive.
+func (b *RedactedBlocklist) GetLogs(ctx context.Context, q LogQuery) ([]*Action, error) {
+	acts, err := b.Blocklist.GetLogs(ctx, q)
+	if err != nil || HasRole(ctx, RoleSensitive) {
+		return acts, err
+	}
+	sensitive, err := b.sensitiveIds(ctx, acts)
+	if err != nil {
+		return nil, err
+	}
+	for i, act := range acts {
+		for _, id := range act.Ids {
+			if sensitive[id] {
+				acts[i] = redact(act)
+				break
+			}
+		}
+	}
+	return acts, nil
+}
+
+// sensitiveIds returns which of the ids of `acts` are of sensitive
+// categories.
+func (b *RedactedBlocklist) sensitiveIds(ctx context.Context, acts []*Action) (map[cid.Cid]bool, error) {
+	var ids []cid.Cid
+	seen := make(map[cid.Cid]bool)
+	for _, act := range acts {
+		for _, id := range act.Ids {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	items, err := b.Blocklist.SearchMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	sensitive := make(map[cid.Cid]bool)
+	for _, id := range ids {
+		item := items[id]
+		if item == nil {
+			if item, err = b.Blocklist.SearchHistory(ctx, id); errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if b.sensitive[item.Metadata[b.key]] {
+			sensitive[id] = true
+		}
+	}
+	return sensitive, nil
+}
+
+// redact returns a copy of `act` with its reason and changed content masked.
+func redact(act *Action) *Action {
+	c := *act
+	c.Reason = Redacted
+	if len(act.Changes) > 0 {
+		c.Changes = make([]Change, len(act.Changes))
+		for i, ch := range act.Changes {
+			if ch.Field == "Content" || ch.Field == "Reason" {
+				ch.Old, ch.New = Redacted, Redacted
+			}
+			c.Changes[i] = ch
+		}
+	}
+	return &c
+}
@@ -0,0 +1,92 @@
+package blocklist
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RefType is the kind of document a Reference points to.
+type RefType string
+
+const (
+	RefTicket     RefType = "ticket"
+	RefCourtOrder RefType = "court-order"
+	RefEmail      RefType = "email"
+	RefReport     RefType = "report"
+)
+
+// Valid returns true if `t` is one of the known reference types.
+func (t RefType) Valid() bool {
+	switch t {
+	case RefTicket, RefCourtOrder, RefEmail, RefReport:
+		return true
+	}
+	return false
+}
+
+// Reference links an entry or action to the external document it is based on,
+// by ID, URL, or both.
+type Reference struct {
+	Typ RefType `json:",omitempty"`
+	Id  string  `json:",omitempty"`
+	URL string  `json:",omitempty"`
+}
+
+func (r Reference) String() string {
+	s := string(r.Typ)
+	if r.Id != "" {
+		s += " " + r.Id
+	}
+	if r.URL != "" {
+		s += " " + r.URL
+	}
+	return s
+}
+
+// validate returns `r` trimmed, or a FieldError named `field` if it is
+// invalid.
+func (r Reference) validate(field string) (Reference, *FieldError) {
+	r.Id, r.URL = strings.TrimSpace(r.Id), strings.TrimSpace(r.URL)
+	if !r.Typ.Valid() {
+		return r, &FieldError{field, string(r.Typ), "unknown reference type"}
+	} else if r.Id == "" && r.URL == "" {
+		return r, &FieldError{field, r.String(), "neither an ID nor a URL"}
+	}
+	if r.URL != "" {
+		if u, err := url.Parse(r.URL); err != nil || !u.IsAbs() {
+			return r, &FieldError{field, r.URL, "not a URL"}
+		}
+	}
+	return r, nil
+}
+
+// Matches returns true if `r` has the type, ID, and URL of `filter`, ignoring
+// the fields that are empty in `filter`.
+func (r Reference) Matches(filter Reference) bool {
+	return (filter.Typ == "" || r.Typ == filter.Typ) &&
+		(filter.Id == "" || r.Id == filter.Id) &&
+		(filter.URL == "" || r.URL == filter.URL)
+}
+
+// hasReference returns true if any of `refs` matches `filter`, or if `filter`
+// is empty.
+func hasReference(refs []Reference, filter Reference) bool {
+	if filter == (Reference{}) {
+		return true
+	}
+	for _, r := range refs {
+		if r.Matches(filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinReferences returns `refs` one per line, for Diff.
+func joinReferences(refs []Reference) string {
+	lines := make([]string, len(refs))
+	for i, r := range refs {
+		lines[i] = r.String()
+	}
+	return strings.Join(lines, "\n")
+}
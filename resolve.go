@@ -0,0 +1,180 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrNotIPFSURL is returned for URLs that don't reference IPFS content.
+var ErrNotIPFSURL = fmt.Errorf("not an IPFS or IPNS URL")
+
+// NameResolver resolves IPNS names to /ipfs/ paths, like the NameSystem of
+// go-namesys. `name` is an /ipns/ path.
+type NameResolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// Proposal is a CID that a gateway URL resolved to, proposed for blocking.
+type Proposal struct {
+	Id cid.Cid
+	// Path is the rest of the path after the CID. Only Id is blocked, so a
+	// Path means that more than the reported content would be blocked.
+	Path string
+	// Name is the /ipns/ name that was resolved to Id, if any. Names can be
+	// repointed, so the CID may not be what the reporter saw.
+	Name string
+	// Data is the submitted BlockData, with Content set to the URLs that
+	// resolved to Id, as evidence.
+	Data BlockData
+}
+
+// URLResolver turns the gateway URLs submitted in BlockData.Content into the
+// CIDs to block. It understands path gateway URLs (https://<gateway>/ipfs/<cid>),
+// subdomain gateway URLs (https://<cid>.ipfs.<gateway>), native URLs
+// (ipfs://<cid>), their /ipns/ counterparts, and bare CIDs.
+type URLResolver struct {
+	names NameResolver
+}
+
+// NewURLResolver returns a URLResolver that resolves IPNS names with `names`.
+// If `names` is nil, IPNS URLs can't be resolved.
+func NewURLResolver(names NameResolver) *URLResolver {
+	return &URLResolver{names}
+}
+
+// Resolve returns the CID referenced by `rawurl`.
+func (r *URLResolver) Resolve(ctx context.Context, rawurl string) (*Proposal, error) {
+	ns, root, rest, err := splitIPFSURL(strings.TrimSpace(rawurl))
+	if err != nil {
+		return nil, err
+	}
+	if ns == "ipfs" {
+		id, err := cid.Decode(root)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+		}
+		return &Proposal{Id: id, Path: rest}, nil
+	}
+
+	if r.names == nil {
+		return nil, fmt.Errorf("no resolver for IPNS name %v", root)
+	}
+	name := "/ipns/" + root
+	resolved, err := r.names.Resolve(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %v: %w", name, err)
+	}
+	ns, root, resolvedRest, err := splitIPFSURL(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %v: %w", name, err)
+	} else if ns != "ipfs" {
+		return nil, fmt.Errorf("resolving %v: unexpected path %v", name, resolved)
+	}
+	id, err := cid.Decode(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %v: %w: %v", name, ErrInvalidCID, err)
+	}
+	return &Proposal{Id: id, Path: joinPath(resolvedRest, rest), Name: name}, nil
+}
+
+// Propose resolves the Content of `data`, and returns one proposal per CID
+// found, in the order of Content. URLs that resolve to the same CID share a
+// proposal, with the Path and Name of the first one. If some entries can't be
+// resolved, the others are still proposed, and a *ValidationError lists the
+// failures.
+func (r *URLResolver) Propose(ctx context.Context, data BlockData) ([]*Proposal, error) {
+	var (
+		proposals []*Proposal
+		byId      = make(map[cid.Cid]*Proposal)
+		errs      []FieldError
+	)
+	for i, c := range data.Content {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		p, err := r.Resolve(ctx, c)
+		if err != nil {
+			errs = append(errs, FieldError{fmt.Sprintf("Content[%d]", i), c, err.Error()})
+			continue
+		}
+		if prev, ok := byId[p.Id]; ok {
+			prev.Data.Content = append(prev.Data.Content, c)
+			continue
+		}
+		p.Data = data
+		p.Data.Content = []string{c}
+		byId[p.Id] = p
+		proposals = append(proposals, p)
+	}
+	if len(errs) > 0 {
+		return proposals, &ValidationError{errs}
+	}
+	return proposals, nil
+}
+
+// splitIPFSURL returns the namespace ("ipfs" or "ipns"), the root CID or
+// name, and the rest of the path referenced by `s`.
+func splitIPFSURL(s string) (ns, root, rest string, err error) {
+	if _, err := cid.Decode(s); err == nil {
+		return "ipfs", s, "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%w: %v", ErrNotIPFSURL, err)
+	}
+
+	switch {
+	case u.Scheme == "ipfs" || u.Scheme == "ipns":
+		// ipfs://<cid>/<path>
+		return u.Scheme, u.Host, u.Path, nil
+	case u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https":
+	default:
+		return "", "", "", ErrNotIPFSURL
+	}
+
+	// https://<root>.ipfs.<gateway>/<path>
+	labels := strings.SplitN(u.Hostname(), ".", 3)
+	if len(labels) == 3 && (labels[1] == "ipfs" || labels[1] == "ipns") {
+		root := labels[0]
+		if labels[1] == "ipns" {
+			root = decodeDNSLinkLabel(root)
+		}
+		return labels[1], root, u.Path, nil
+	}
+
+	// https://<gateway>/ipfs/<root>/<path>
+	segments := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 3)
+	if len(segments) >= 2 && (segments[0] == "ipfs" || segments[0] == "ipns") && segments[1] != "" {
+		if len(segments) == 3 {
+			rest = "/" + segments[2]
+		}
+		return segments[0], segments[1], rest, nil
+	}
+	return "", "", "", ErrNotIPFSURL
+}
+
+// decodeDNSLinkLabel reverses the inlining of DNSLink names into a single DNS
+// label by subdomain gateways: "-" stands for "." and "--" for "-".
+func decodeDNSLinkLabel(label string) string {
+	if _, err := cid.Decode(label); err == nil || !strings.Contains(label, "-") {
+		return label
+	}
+	parts := strings.Split(label, "--")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(p, "-", ".")
+	}
+	return strings.Join(parts, "-")
+}
+
+// joinPath appends the path `rest` to `base`.
+func joinPath(base, rest string) string {
+	if rest == "" || rest == "/" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + rest
+}
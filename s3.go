@@ -0,0 +1,241 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultS3Timeout is how long requests to object storage may take.
+const DefaultS3Timeout = time.Minute
+
+// S3Option configures an S3.
+type S3Option func(*s3Options)
+
+type s3Options struct {
+	client    *http.Client
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// WithS3HTTPClient sends requests with `c` instead of a client with a
+// DefaultS3Timeout timeout.
+func WithS3HTTPClient(c *http.Client) S3Option {
+	return func(o *s3Options) {
+		o.client = c
+	}
+}
+
+// WithS3Region signs requests for `region`, instead of "auto", the region of
+// R2.
+func WithS3Region(region string) S3Option {
+	return func(o *s3Options) {
+		o.region = region
+	}
+}
+
+// WithS3Credentials signs requests with the access key `id`. Requests aren't
+// signed without credentials, which only works for public buckets.
+func WithS3Credentials(id, secret string) S3Option {
+	return func(o *s3Options) {
+		o.accessKey, o.secretKey = id, secret
+	}
+}
+
+// S3 reads and writes the objects of a bucket of S3 or a compatible object
+// storage, like R2, with path-style requests signed with AWS Signature
+// Version 4.
+type S3 struct {
+	endpoint  string
+	bucket    string
+	client    *http.Client
+	region    string
+	accessKey string
+	secretKey string
+	now       func() time.Time
+}
+
+// NewS3 returns the bucket `bucket` of the object storage `endpoint`, like
+// "https://<account>.r2.cloudflarestorage.com" or
+// "https://s3.us-east-1.amazonaws.com".
+func NewS3(endpoint, bucket string, opts ...S3Option) *S3 {
+	o := &s3Options{
+		client: &http.Client{Timeout: DefaultS3Timeout},
+		region: "auto",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &S3{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		client:    o.client,
+		region:    o.region,
+		accessKey: o.accessKey,
+		secretKey: o.secretKey,
+		now:       time.Now,
+	}
+}
+
+// s3Error is an error response of the object storage.
+type s3Error struct {
+	Method string
+	Key    string
+	Status int
+	Body   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %v %v: %v %v", e.Method, e.Key, e.Status, e.Body)
+}
+
+// s3Escape escapes `s` like Signature Version 4 expects: every byte but
+// unreserved characters, and slashes if `path` is true.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+// sign adds a Signature Version 4 Authorization header to `req`, covering
+// its host and headers, and the payload with the SHA-256 `payloadHash`.
+func (s *S3) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.accessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%x",
+		s.accessKey, scope, signed, hmacSHA256(key, toSign)))
+}
+
+// do sends a signed request for the object `key`, and returns the response if
+// its status is one of `ok`. Other responses are returned as an *s3Error,
+// wrapped in ErrBackendUnavailable for server errors, as are network
+// failures.
+func (s *S3) do(ctx context.Context, method, key string, body []byte, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+s3Escape(s.bucket+"/"+key, true), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	hash := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(hash[:]))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	raw, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	apiErr := &s3Error{Method: method, Key: key, Status: resp.StatusCode, Body: strings.TrimSpace(string(raw))}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, apiErr)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: %v", ErrBackendUnavailable, apiErr)
+	}
+	return nil, apiErr
+}
+
+// Get returns the contents of the object `key`, and its ETag. If `etag` is
+// the ETag of the current version of the object, it returns nil and `etag`
+// without downloading the object again. Missing objects are reported as
+// ErrNotFound.
+func (s *S3) Get(ctx context.Context, key, etag string) ([]byte, string, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, http.StatusOK, http.StatusNotModified)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// Put replaces the contents of the object `key` with `data`.
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(ctx, http.MethodPut, key, data, header, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
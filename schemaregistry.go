@@ -0,0 +1,155 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// ActionSchema is the JSON Schema of an Action, as serialized by
+// Action.MarshalBinary. Fields may only be added to it, so that consumers
+// using an older version keep working.
+const ActionSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Action",
+  "type": "object",
+  "properties": {
+    "Seq": {"type": "integer"},
+    "Typ": {"type": "string"},
+    "Ids": {"type": ["array", "null"], "items": {"type": "object"}},
+    "Undoes": {"type": "integer"},
+    "Changes": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "Field": {"type": "string"},
+          "Old": {"type": "string"},
+          "New": {"type": "string"}
+        }
+      }
+    },
+    "Reason": {"type": "string"},
+    "User": {"type": "string"},
+    "CreatedAt": {"type": "string", "format": "date-time"},
+    "References": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "Typ": {"type": "string"},
+          "Id": {"type": "string"},
+          "URL": {"type": "string"}
+        }
+      }
+    },
+    "HLC": {"type": "string"}
+  },
+  "required": ["Seq", "Typ", "User", "CreatedAt"]
+}`
+
+// ActionEncoder serializes actions for publishing to a message broker, like
+// Kafka or NATS.
+type ActionEncoder interface {
+	Encode(ctx context.Context, act *Action) ([]byte, error)
+}
+
+// JSONEncoder encodes actions as plain JSON.
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(ctx context.Context, act *Action) ([]byte, error) {
+	return act.MarshalBinary()
+}
+
+// RegistryEncoder encodes actions as JSON in the Confluent wire format: a
+// zero magic byte and the 4-byte big-endian ID of ActionSchema in a schema
+// registry, followed by the JSON. The schema is registered under Subject on
+// first use, and the registry enforces its compatibility with earlier
+// versions.
+type RegistryEncoder struct {
+	URL     string // URL is the base URL of the schema registry.
+	Subject string // Subject is usually the topic name followed by "-value".
+	Client  *http.Client
+
+	mu sync.Mutex
+	id uint32
+}
+
+// Encode encodes `act`, registering ActionSchema if it hasn't been yet.
+func (e *RegistryEncoder) Encode(ctx context.Context, act *Action) ([]byte, error) {
+	id, err := e.schemaID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := act.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 5, 5+len(raw))
+	binary.BigEndian.PutUint32(out[1:], id)
+	return append(out, raw...), nil
+}
+
+func (e *RegistryEncoder) schemaID(ctx context.Context) (uint32, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.id != 0 {
+		return e.id, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schemaType": "JSON", "schema": ActionSchema})
+	if err != nil {
+		return 0, err
+	}
+	u := e.URL + "/subjects/" + url.PathEscape(e.Subject) + "/versions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("registering schema of subject %v: %v", e.Subject, resp.Status)
+	}
+
+	var res struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return 0, err
+	}
+	e.id = res.ID
+	return e.id, nil
+}
+
+// DecodeAction decodes an action encoded by JSONEncoder or RegistryEncoder.
+// The returned schema ID is zero for plain JSON.
+func DecodeAction(data []byte) (*Action, uint32, error) {
+	var id uint32
+	if len(data) > 0 && data[0] == 0 {
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("truncated wire format header")
+		}
+		id = binary.BigEndian.Uint32(data[1:5])
+		data = data[5:]
+	}
+	act := &Action{}
+	if err := act.UnmarshalBinary(data); err != nil {
+		return nil, 0, err
+	}
+	return act, id, nil
+}
@@ -0,0 +1,242 @@
+package blocklist
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ErrSelfTestFailed is wrapped by the error of a SelfTestReport with failed
+// checks.
+var ErrSelfTestFailed = fmt.Errorf("blocklist self-test failed")
+
+// DefaultSelfTestUser is the user self-tests block content and log actions
+// as.
+const DefaultSelfTestUser = "self-test@localhost"
+
+// SchemaChecker is implemented by backends whose tables and indexes are
+// created outside of the package, so that SelfTest can report a missing
+// migration before the first takedown does.
+type SchemaChecker interface {
+	// CheckSchema returns an error naming the tables and columns the
+	// backend needs that don't exist.
+	CheckSchema(ctx context.Context) error
+	// CheckIndexes returns an error naming the indexes lookups rely on that
+	// don't exist.
+	CheckIndexes(ctx context.Context) error
+}
+
+// SelfTestCheck is the result of one check of a self-test.
+type SelfTestCheck struct {
+	Name     string // Name is "healthy", "schema", "indexes", "roundtrip", or "audit".
+	Err      error
+	Skipped  bool // Skipped is whether the backend doesn't support the check.
+	Duration time.Duration
+}
+
+func (c SelfTestCheck) String() string {
+	switch {
+	case c.Skipped:
+		return c.Name + ": skipped"
+	case c.Err != nil:
+		return fmt.Sprintf("%v: FAILED in %v: %v", c.Name, c.Duration.Round(time.Millisecond), c.Err)
+	}
+	return fmt.Sprintf("%v: ok in %v", c.Name, c.Duration.Round(time.Millisecond))
+}
+
+// SelfTestReport is the result of SelfTest.
+type SelfTestReport struct {
+	Checks []SelfTestCheck
+	// ID is the CID the round-trip and audit checks blocked and logged.
+	ID       cid.Cid
+	Duration time.Duration
+}
+
+// Ok returns true if no check failed.
+func (r SelfTestReport) Ok() bool {
+	return r.Err() == nil
+}
+
+// Err returns an error wrapping ErrSelfTestFailed that lists the failed
+// checks, or nil if none did.
+func (r SelfTestReport) Err() error {
+	var failed []string
+	for _, c := range r.Checks {
+		if c.Err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", c.Name, c.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrSelfTestFailed, strings.Join(failed, "; "))
+}
+
+func (r SelfTestReport) String() string {
+	checks := make([]string, len(r.Checks))
+	for i, c := range r.Checks {
+		checks[i] = c.String()
+	}
+	return fmt.Sprintf("self-test in %v: %v", r.Duration.Round(time.Millisecond), strings.Join(checks, ", "))
+}
+
+// SelfTestOption configures a self-test.
+type SelfTestOption func(*selfTestOptions)
+
+type selfTestOptions struct {
+	user  string
+	audit bool
+}
+
+// WithSelfTestUser blocks content and logs actions as `user`, instead of
+// DefaultSelfTestUser. It has to be an email address.
+func WithSelfTestUser(user string) SelfTestOption {
+	return func(o *selfTestOptions) {
+		o.user = user
+	}
+}
+
+// WithoutSelfTestAudit skips the audit check, for deployments whose audit log
+// is written elsewhere, or that don't want self-tests in it.
+func WithoutSelfTestAudit() SelfTestOption {
+	return func(o *selfTestOptions) {
+		o.audit = false
+	}
+}
+
+// SelfTest checks that `b` is usable, for services to run at boot so that a
+// misconfigured backend fails fast rather than at the first takedown. It
+// checks, in order, that:
+//
+//   - the backend is Healthy;
+//   - its schema and indexes exist, if it implements SchemaChecker;
+//   - content can be blocked, found, looked up, and unblocked;
+//   - actions can be added to the audit log and read back.
+//
+// The round-trip blocks a CID made for this run, which no content has, so it
+// never touches real entries and concurrent self-tests don't conflict. It is
+// unblocked even if a later step fails. The audit check logs the block and
+// unblock of that CID, which stay in the audit log.
+//
+// Every check runs even if an earlier one failed, except that the audit check
+// is skipped when the round-trip failed. Both are skipped for a
+// ReadOnlyBlocklist. See SelfTestReport.Err.
+func SelfTest(ctx context.Context, b Blocklist, opts ...SelfTestOption) SelfTestReport {
+	o := &selfTestOptions{user: DefaultSelfTestUser, audit: true}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	start := time.Now()
+	r := SelfTestReport{ID: selfTestCID()}
+	run := func(name string, fn func() error) error {
+		started := time.Now()
+		err := fn()
+		r.Checks = append(r.Checks, SelfTestCheck{Name: name, Err: err, Duration: time.Since(started)})
+		return err
+	}
+	skip := func(name string) {
+		r.Checks = append(r.Checks, SelfTestCheck{Name: name, Skipped: true})
+	}
+
+	run("healthy", func() error { return b.Healthy(ctx) })
+	if sc, ok := b.(SchemaChecker); ok {
+		run("schema", func() error { return sc.CheckSchema(ctx) })
+		run("indexes", func() error { return sc.CheckIndexes(ctx) })
+	} else {
+		skip("schema")
+		skip("indexes")
+	}
+	if _, ok := b.(*ReadOnlyBlocklist); ok {
+		skip("roundtrip")
+		skip("audit")
+	} else if err := run("roundtrip", func() error { return selfTestRoundTrip(ctx, b, r.ID, o.user) }); o.audit && err == nil {
+		run("audit", func() error { return selfTestAudit(ctx, b, r.ID, o.user) })
+	} else {
+		skip("audit")
+	}
+
+	r.Duration = time.Since(start)
+	return r
+}
+
+// selfTestCID returns a random CID, which no content has.
+func selfTestCID() cid.Cid {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	h, err := mh.Sum([]byte("blocklist self-test "+hex.EncodeToString(nonce)), mh.SHA2_256, -1)
+	if err != nil {
+		// SHA2-256 is always available.
+		panic(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// selfTestRoundTrip blocks `id`, checks that Search and Contains find it, and
+// that it isn't found once unblocked.
+func selfTestRoundTrip(ctx context.Context, b Blocklist, id cid.Cid, user string) error {
+	data := BlockData{Content: []string{id.String()}, Reason: "self-test", User: user}
+	if existing, err := b.Block(ctx, id, data); err != nil {
+		return fmt.Errorf("block: %w", err)
+	} else if existing != nil {
+		return fmt.Errorf("block: %v is already blocked", RedactID(id))
+	}
+	unblocked := false
+	defer func() {
+		if unblocked {
+			return
+		}
+		if _, uerr := b.Unblock(ctx, id); uerr != nil && !errors.Is(uerr, ErrNotFound) {
+			log.Warnf("unblocking self-test content %v: %v", RedactID(id), uerr)
+		}
+	}()
+
+	item, err := b.Search(ctx, id)
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	} else if item.Reason != data.Reason {
+		return fmt.Errorf("search: reason is %q, not %q", item.Reason, data.Reason)
+	}
+	if exists, err := b.Contains(ctx, id); err != nil {
+		return fmt.Errorf("contains: %w", err)
+	} else if !exists {
+		return fmt.Errorf("contains: blocked content isn't found")
+	}
+	if _, err := b.Unblock(ctx, id); err != nil {
+		return fmt.Errorf("unblock: %w", err)
+	}
+	unblocked = true
+	if exists, err := b.Contains(ctx, id); err != nil {
+		return fmt.Errorf("contains: %w", err)
+	} else if exists {
+		return fmt.Errorf("contains: unblocked content is still found")
+	}
+	return nil
+}
+
+// selfTestAudit logs the block and unblock of `id`, and checks that both are
+// read back.
+func selfTestAudit(ctx context.Context, b Blocklist, id cid.Cid, user string) error {
+	for _, typ := range []ActionType{ActionBlock, ActionUnblock} {
+		act := &Action{Typ: typ, Ids: []cid.Cid{id}, Reason: "self-test", User: user, CreatedAt: time.Now()}
+		if err := b.AddLog(ctx, act); err != nil {
+			return fmt.Errorf("add log: %w", err)
+		}
+	}
+	acts, err := b.GetLogs(ctx, LogQuery{Id: id, Limit: 2})
+	if err != nil {
+		return fmt.Errorf("get logs: %w", err)
+	} else if len(acts) != 2 {
+		return fmt.Errorf("get logs: found %d of 2 actions", len(acts))
+	}
+	return nil
+}
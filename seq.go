@@ -0,0 +1,80 @@
+//go:build go1.23
+// +build go1.23
+
+package blocklist
+
+import (
+	"context"
+	"iter"
+)
+
+// Entries returns an iterator over the entries listed with `opts`, reading
+// them opts.Limit at a time, or DefaultBulkBatchSize if it is zero. Pages are
+// read as the loop reaches them, so memory stays bounded however large the
+// blocklist is, and breaking out of the loop reads no further page:
+//
+//	for item, err := range blocklist.Entries(ctx, b, blocklist.ListOptions{}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// An error ends the iteration. It needs Go 1.23; see EntriesIter otherwise.
+func Entries(ctx context.Context, b Blocklist, opts ListOptions) iter.Seq2[*BlocklistItem, error] {
+	return func(yield func(*BlocklistItem, error) bool) {
+		opts.Limit = bulkBatchSize(opts.Limit)
+		for {
+			page, err := b.List(ctx, opts)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if page.Next == "" {
+				return
+			}
+			opts.Cursor = page.Next
+		}
+	}
+}
+
+// Logs returns an iterator over the actions matching `q`, most recent first,
+// reading them `batch` at a time with GetLogs. q.Limit caps the number of
+// actions, if set. Like Entries, batches are read as the loop reaches them,
+// and an error ends the iteration. It needs Go 1.23; see LogsIter otherwise.
+func Logs(ctx context.Context, b Blocklist, q LogQuery, batch int) iter.Seq2[*Action, error] {
+	return func(yield func(*Action, error) bool) {
+		batch, remaining := bulkBatchSize(batch), q.Limit
+		for {
+			page := q
+			page.Limit = batch
+			if remaining > 0 && remaining < batch {
+				page.Limit = remaining
+			}
+			acts, err := b.GetLogs(ctx, page)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, act := range acts {
+				if !yield(act, nil) {
+					return
+				}
+			}
+			if remaining > 0 {
+				if remaining -= len(acts); remaining <= 0 {
+					return
+				}
+			}
+			if len(acts) < page.Limit || acts[len(acts)-1].Seq == 0 {
+				return
+			}
+			q.Before = acts[len(acts)-1].Seq
+		}
+	}
+}
@@ -0,0 +1,109 @@
+//go:build go1.23
+// +build go1.23
+
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// countingBlocklist counts the pages read from its Blocklist.
+type countingBlocklist struct {
+	Blocklist
+	lists, getLogs int
+}
+
+func (b *countingBlocklist) List(ctx context.Context, opts ListOptions) (*ListPage, error) {
+	b.lists++
+	return b.Blocklist.List(ctx, opts)
+}
+
+func (b *countingBlocklist) GetLogs(ctx context.Context, q LogQuery) ([]*Action, error) {
+	b.getLogs++
+	return b.Blocklist.GetLogs(ctx, q)
+}
+
+func TestEntriesPages(t *testing.T) {
+	ctx := context.Background()
+	b := &countingBlocklist{Blocklist: NewMemoryBlocklist()}
+	for i := 0; i < 5; i++ {
+		if _, err := b.Block(ctx, testCID(t, fmt.Sprint(i)), BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for item, err := range Entries(ctx, b, ListOptions{Limit: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		} else if seen[item.Hash] {
+			t.Errorf("entry %v listed twice", item.Hash)
+		}
+		seen[item.Hash] = true
+	}
+	if len(seen) != 5 || b.lists != 3 {
+		t.Errorf("listed %v entries in %v pages, want 5 in 3", len(seen), b.lists)
+	}
+
+	b.lists = 0
+	for _, err := range Entries(ctx, b, ListOptions{Limit: 2}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if b.lists != 1 {
+		t.Errorf("breaking out of the first page read %v pages, want 1", b.lists)
+	}
+}
+
+func TestLogsPages(t *testing.T) {
+	ctx := context.Background()
+	b := &countingBlocklist{Blocklist: NewMemoryBlocklist()}
+	for i := 0; i < 5; i++ {
+		act := &Action{Typ: ActionBlock, Ids: []cid.Cid{testCID(t, fmt.Sprint(i))}, Reason: fmt.Sprint(i), User: "u@x.com"}
+		if err := b.AddLog(ctx, act); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit, want, pages int
+	}{
+		{0, 5, 3},
+		{3, 3, 2},
+		{4, 4, 2},
+	} {
+		b.getLogs = 0
+		var reasons []string
+		for act, err := range Logs(ctx, b, LogQuery{Limit: tc.limit}, 2) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			reasons = append(reasons, act.Reason)
+		}
+		if len(reasons) != tc.want || b.getLogs != tc.pages {
+			t.Errorf("limit %v: read %v actions in %v pages, want %v in %v", tc.limit, len(reasons), b.getLogs, tc.want, tc.pages)
+		}
+		for i, r := range reasons {
+			if want := fmt.Sprint(4 - i); r != want {
+				t.Errorf("limit %v: action %v is %v, want %v", tc.limit, i, r, want)
+			}
+		}
+	}
+
+	b.getLogs = 0
+	for _, err := range Logs(ctx, b, LogQuery{}, 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if b.getLogs != 1 {
+		t.Errorf("breaking out of the first batch read %v batches, want 1", b.getLogs)
+	}
+}
@@ -0,0 +1,130 @@
+package blocklist
+
+import (
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// LatencyStats sums up the durations of one compliance SLA.
+type LatencyStats struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the average duration, or zero if nothing was measured.
+func (s LatencyStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+func (s *LatencyStats) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+}
+
+func (s *LatencyStats) merge(o LatencyStats) {
+	s.Count += o.Count
+	s.Total += o.Total
+	if o.Max > s.Max {
+		s.Max = o.Max
+	}
+}
+
+// SLAStats are the compliance SLAs derived from the audit log, linking the
+// actions on the same CID.
+type SLAStats struct {
+	// IntakeToBlock is the time from the first ActionReport of a CID to its
+	// ActionBlock. Reports logged before an earlier block of the CID don't
+	// count.
+	IntakeToBlock LatencyStats
+	// BlockToPurge is the time from the last ActionBlock of a CID to its
+	// ActionPurge.
+	BlockToPurge LatencyStats
+}
+
+// slaStart returns when the SLA ended by the action of type `end` on `id` at
+// `at` started, and false if no earlier action starts it.
+func slaStart(ctx context.Context, b Blocklist, id cid.Cid, end ActionType, at time.Time) (time.Time, bool, error) {
+	blocks, err := b.GetLogs(ctx, LogQuery{Limit: 1, Typ: ActionBlock, Id: id, Until: at})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if end == ActionPurge {
+		if len(blocks) == 0 {
+			return time.Time{}, false, nil
+		}
+		return blocks[0].CreatedAt, true, nil
+	}
+
+	q := LogQuery{Typ: ActionReport, Id: id, Until: at}
+	if len(blocks) > 0 {
+		q.Since = blocks[0].CreatedAt
+	}
+	reports, err := b.GetLogs(ctx, q)
+	if err != nil || len(reports) == 0 {
+		return time.Time{}, false, err
+	}
+	return reports[len(reports)-1].CreatedAt, true, nil
+}
+
+// observeSLA adds the SLAs ended by `act` to `s`.
+func (s *SLAStats) observeSLA(ctx context.Context, b Blocklist, act *Action) error {
+	var stats *LatencyStats
+	switch act.Typ {
+	case ActionBlock:
+		stats = &s.IntakeToBlock
+	case ActionPurge:
+		stats = &s.BlockToPurge
+	default:
+		return nil
+	}
+	at := act.CreatedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	for _, id := range act.Ids {
+		start, ok, err := slaStart(ctx, b, id, act.Typ, at)
+		if err != nil {
+			return err
+		} else if ok {
+			stats.observe(at.Sub(start))
+		}
+	}
+	return nil
+}
+
+// ComputeSLA returns the SLAs of the blocks and purges logged between `since`
+// and `until`, for periodic compliance reports. Zero times don't bound the
+// period. The actions that started them may have been logged earlier.
+//
+// It looks up the audit log of every CID blocked or purged in the period, so
+// it is meant for reports rather than dashboards, see WithSLAMetrics.
+func ComputeSLA(ctx context.Context, b Blocklist, since, until time.Time) (SLAStats, error) {
+	iterCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var s SLAStats
+	for _, typ := range []ActionType{ActionBlock, ActionPurge} {
+		acts, errc := LogsIter(iterCtx, b, LogQuery{Typ: typ, Since: since, Until: until}, 0)
+		for act := range acts {
+			if err := s.observeSLA(ctx, b, act); err != nil {
+				return SLAStats{}, err
+			}
+		}
+		if err := <-errc; err != nil {
+			return SLAStats{}, err
+		}
+	}
+	return s, nil
+}
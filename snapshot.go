@@ -0,0 +1,211 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultSnapshotInterval is how often a SnapshotBlocklist checks for a new
+// snapshot.
+const DefaultSnapshotInterval = time.Minute
+
+// PublishSnapshot compiles `b` into a hash index, and uploads it to the
+// object `key` of `s`, with its bloom sidecar at `key`.bloom. It returns the
+// number of keys of the snapshot. The bloom is uploaded first, so that
+// readers never combine a new index with an older bloom.
+func PublishSnapshot(ctx context.Context, b Blocklist, s *S3, key string, opts ...HashIndexOption) (n int, err error) {
+	defer wrapError(&err, "s3", "publish", cid.Undef)
+
+	var index, bloom bytes.Buffer
+	if n, err = WriteHashIndex(ctx, b, &index, &bloom, opts...); err != nil {
+		return 0, err
+	}
+	if err := s.Put(ctx, key+".bloom", bloom.Bytes()); err != nil {
+		return 0, err
+	}
+	if err := s.Put(ctx, key, index.Bytes()); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SnapshotOption configures a SnapshotBlocklist.
+type SnapshotOption func(*snapshotOptions)
+
+type snapshotOptions struct {
+	interval time.Duration
+	index    []HashIndexOption
+}
+
+// WithSnapshotInterval sets how often to check for a new snapshot, instead
+// of DefaultSnapshotInterval. Refreshes only download the snapshot when it
+// changed.
+func WithSnapshotInterval(d time.Duration) SnapshotOption {
+	return func(o *snapshotOptions) {
+		o.interval = d
+	}
+}
+
+// WithSnapshotIndexOptions sets the options snapshots are read with, like
+// WithHashIndexTransformer. They have to match the options they were
+// published with.
+func WithSnapshotIndexOptions(opts ...HashIndexOption) SnapshotOption {
+	return func(o *snapshotOptions) {
+		o.index = opts
+	}
+}
+
+// SnapshotBlocklist is a read-only blocklist for stateless edge gateways. It
+// loads a snapshot published with PublishSnapshot from object storage, like
+// S3 or R2, and refreshes it in the background. It only answers Contains,
+// from memory, and implements Checker so it can back Middleware. Like the
+// hash index, it matches content on its multihash, whatever the version or
+// codec of its CID; snapshots published before that have to be published
+// again.
+//
+// If a refresh fails, the previous snapshot keeps being used: see Loaded to
+// alert on stale snapshots.
+type SnapshotBlocklist struct {
+	s        *S3
+	key      string
+	index    []HashIndexOption
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu      sync.RWMutex
+	current *HashIndex
+	etag    string
+	loaded  time.Time
+}
+
+// NewSnapshotBlocklist loads the snapshot `key` of `s`, and refreshes it
+// until Close is called. It fails if the snapshot can't be loaded.
+func NewSnapshotBlocklist(ctx context.Context, s *S3, key string, opts ...SnapshotOption) (*SnapshotBlocklist, error) {
+	o := &snapshotOptions{interval: DefaultSnapshotInterval}
+	for _, opt := range opts {
+		opt(o)
+	}
+	b := &SnapshotBlocklist{
+		s:        s,
+		key:      key,
+		index:    o.index,
+		interval: o.interval,
+		done:     make(chan struct{}),
+	}
+	if _, err := b.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.refresh(refreshCtx)
+	return b, nil
+}
+
+// refresh refreshes the snapshot every interval until `ctx` is done.
+func (b *SnapshotBlocklist) refresh(ctx context.Context) {
+	defer close(b.done)
+	t := time.NewTicker(b.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := b.Refresh(ctx); err != nil && ctx.Err() == nil {
+				log.Warnf("refreshing snapshot %v: %v", b.key, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh loads the snapshot if it changed since it was last loaded, and
+// returns true if it did.
+func (b *SnapshotBlocklist) Refresh(ctx context.Context) (changed bool, err error) {
+	defer wrapError(&err, "s3", "refresh", cid.Undef)
+
+	b.mu.RLock()
+	etag := b.etag
+	b.mu.RUnlock()
+
+	// The index is read before its bloom, which is uploaded first: the
+	// bloom is at least as recent as the index.
+	index, newEtag, err := b.s.Get(ctx, b.key, etag)
+	if err != nil {
+		return false, err
+	}
+	if index == nil {
+		b.mu.Lock()
+		b.loaded = time.Now()
+		b.mu.Unlock()
+		return false, nil
+	}
+	bloom, _, err := b.s.Get(ctx, b.key+".bloom", "")
+	if errors.Is(err, ErrNotFound) {
+		bloom = nil
+	} else if err != nil {
+		return false, err
+	}
+	hi, err := LoadHashIndex(index, bloom, b.index...)
+	if err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	b.current, b.etag, b.loaded = hi, newEtag, time.Now()
+	b.mu.Unlock()
+	log.Infof("loaded snapshot %v with %v keys", b.key, hi.Len())
+	return true, nil
+}
+
+// Contains returns true if the snapshot contains the content referenced by
+// `id`, either as the primary hash of an entry or as one of its digests.
+func (b *SnapshotBlocklist) Contains(ctx context.Context, id cid.Cid) (exists bool, err error) {
+	defer wrapError(&err, "s3", "contains", id)
+
+	b.mu.RLock()
+	hi := b.current
+	b.mu.RUnlock()
+	return hi.Contains(ctx, id)
+}
+
+// Len returns the number of keys of the current snapshot.
+func (b *SnapshotBlocklist) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.current.Len()
+}
+
+// Loaded returns when the snapshot was last loaded or found unchanged.
+func (b *SnapshotBlocklist) Loaded() time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.loaded
+}
+
+// Healthy returns an error if the snapshot wasn't refreshed for three
+// intervals.
+func (b *SnapshotBlocklist) Healthy(ctx context.Context) error {
+	if age := time.Since(b.Loaded()); age > 3*b.interval {
+		return fmt.Errorf("%w: snapshot %v is %v old", ErrBackendUnavailable, b.key, age.Round(time.Second))
+	}
+	return nil
+}
+
+// Close stops refreshing the snapshot.
+func (b *SnapshotBlocklist) Close(ctx context.Context) error {
+	b.cancel()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
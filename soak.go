@@ -0,0 +1,307 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// SoakMix is the relative weight of each operation of a soak test.
+type SoakMix struct {
+	Contains int
+	Block    int
+	Unblock  int
+	GetLogs  int
+}
+
+// DefaultSoakMix is the traffic mix of a gateway: mostly lookups, a few
+// changes, and the occasional look at the audit log.
+var DefaultSoakMix = SoakMix{Contains: 950, Block: 25, Unblock: 20, GetLogs: 5}
+
+// SoakReport is a snapshot of the progress of a soak test.
+type SoakReport struct {
+	Elapsed time.Duration
+	// Ops are the statistics of each operation: "Contains", "Block",
+	// "Unblock", and "GetLogs". Unblocking content that isn't blocked
+	// isn't an error.
+	Ops       map[string]MethodStats
+	LastError error
+	// HeapAlloc is the size of the live heap, after a garbage collection.
+	// HeapGrowth is how much it grew since the test started.
+	HeapAlloc  uint64
+	HeapGrowth int64
+	Goroutines int
+}
+
+// Calls returns the number of operations made.
+func (r SoakReport) Calls() uint64 {
+	var n uint64
+	for _, op := range r.Ops {
+		n += op.Calls
+	}
+	return n
+}
+
+// ErrorRate returns the fraction of operations that failed.
+func (r SoakReport) ErrorRate() float64 {
+	var calls, errs uint64
+	for _, op := range r.Ops {
+		calls += op.Calls
+		errs += op.Errors
+	}
+	if calls == 0 {
+		return 0
+	}
+	return float64(errs) / float64(calls)
+}
+
+func (r SoakReport) String() string {
+	var mean time.Duration
+	if op := r.Ops["Contains"]; op.Calls > 0 {
+		mean = op.Duration / time.Duration(op.Calls)
+	}
+	return fmt.Sprintf("%v: %d ops, %.4f%% errors, contains %v avg, heap %d KiB (%+d KiB), %d goroutines",
+		r.Elapsed.Round(time.Second), r.Calls(), 100*r.ErrorRate(), mean,
+		r.HeapAlloc/1024, r.HeapGrowth/1024, r.Goroutines)
+}
+
+// SoakOption configures a soak test.
+type SoakOption func(*soakOptions)
+
+type soakOptions struct {
+	mix      SoakMix
+	workers  int
+	rate     int
+	keyspace int
+	interval time.Duration
+	report   func(SoakReport)
+}
+
+// WithSoakMix sets the traffic mix, instead of DefaultSoakMix.
+func WithSoakMix(mix SoakMix) SoakOption {
+	return func(o *soakOptions) {
+		o.mix = mix
+	}
+}
+
+// WithSoakWorkers sets how many operations run concurrently. The default is
+// 16.
+func WithSoakWorkers(n int) SoakOption {
+	return func(o *soakOptions) {
+		o.workers = n
+	}
+}
+
+// WithSoakRate limits the operations to `perSecond`, across workers. By
+// default, workers run as fast as the blocklist answers.
+func WithSoakRate(perSecond int) SoakOption {
+	return func(o *soakOptions) {
+		o.rate = perSecond
+	}
+}
+
+// WithSoakKeyspace sets how many distinct CIDs are looked up, blocked, and
+// unblocked. The default is 10000.
+func WithSoakKeyspace(n int) SoakOption {
+	return func(o *soakOptions) {
+		o.keyspace = n
+	}
+}
+
+// WithSoakReport calls `fn` with a report every `interval`, for instance to
+// print the progress of a test that runs for hours.
+func WithSoakReport(interval time.Duration, fn func(SoakReport)) SoakOption {
+	return func(o *soakOptions) {
+		o.interval, o.report = interval, fn
+	}
+}
+
+// SoakCID returns the i-th CID of the keyspace of soak tests.
+func SoakCID(i int) cid.Cid {
+	h, err := mh.Sum([]byte(fmt.Sprintf("blocklist soak test %d", i)), mh.SHA2_256, -1)
+	if err != nil {
+		// SHA2-256 is always available.
+		panic(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// soakStats collects the statistics of the operations of a soak test.
+type soakStats struct {
+	mu      sync.Mutex
+	ops     map[string]*MethodStats
+	lastErr error
+}
+
+func (s *soakStats) observe(op string, start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.ops[op]
+	if !ok {
+		m = &MethodStats{}
+		s.ops[op] = m
+	}
+	m.Calls++
+	m.Duration += time.Since(start)
+	if err != nil {
+		m.Errors++
+		s.lastErr = err
+	}
+}
+
+// heapAlloc returns the size of the live heap.
+func heapAlloc() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// soakAction returns the audit action of a change of `id` by a soak test.
+func soakAction(typ ActionType, id cid.Cid) *Action {
+	return &Action{
+		Typ:       typ,
+		Ids:       []cid.Cid{id},
+		Reason:    "soak test",
+		User:      "soak@example.com",
+		CreatedAt: time.Now(),
+	}
+}
+
+// Soak runs a mix of Contains, Block, Unblock, and GetLogs against `b` for
+// `d`, or until `ctx` is done, to qualify a backend before it goes to
+// production. Blocks and unblocks are logged, like moderation tools do, and
+// their time includes AddLog. It returns the final report, and unblocks the
+// CIDs of the keyspace when done.
+//
+// Soak changes the blocklist and its audit log: run it against a dedicated
+// database or namespace.
+func Soak(ctx context.Context, b Blocklist, d time.Duration, opts ...SoakOption) (SoakReport, error) {
+	o := &soakOptions{mix: DefaultSoakMix, workers: 16, keyspace: 10000}
+	for _, opt := range opts {
+		opt(o)
+	}
+	total := o.mix.Contains + o.mix.Block + o.mix.Unblock + o.mix.GetLogs
+	if total <= 0 || o.mix.Contains < 0 || o.mix.Block < 0 || o.mix.Unblock < 0 || o.mix.GetLogs < 0 {
+		return SoakReport{}, fmt.Errorf("invalid soak mix %+v", o.mix)
+	} else if o.workers <= 0 || o.keyspace <= 0 {
+		return SoakReport{}, fmt.Errorf("soak needs workers and a keyspace")
+	}
+
+	ids := make([]cid.Cid, o.keyspace)
+	for i := range ids {
+		ids[i] = SoakCID(i)
+	}
+	data := BlockData{Reason: "soak test", User: "soak@example.com"}
+
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var tick <-chan time.Time
+	if o.rate > 0 {
+		t := time.NewTicker(time.Second / time.Duration(o.rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	stats := &soakStats{ops: make(map[string]*MethodStats)}
+	start, startHeap := time.Now(), heapAlloc()
+	report := func() SoakReport {
+		heap := heapAlloc()
+		stats.mu.Lock()
+		defer stats.mu.Unlock()
+		ops := make(map[string]MethodStats, len(stats.ops))
+		for name, m := range stats.ops {
+			ops[name] = *m
+		}
+		return SoakReport{
+			Elapsed:    time.Since(start),
+			Ops:        ops,
+			LastError:  stats.lastErr,
+			HeapAlloc:  heap,
+			HeapGrowth: int64(heap) - int64(startHeap),
+			Goroutines: runtime.NumGoroutine(),
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < o.workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-ctx.Done():
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+
+				id := ids[rnd.Intn(len(ids))]
+				op, n := "", rnd.Intn(total)
+				var err error
+				begin := time.Now()
+				switch {
+				case n < o.mix.Contains:
+					op = "Contains"
+					_, err = b.Contains(ctx, id)
+				case n < o.mix.Contains+o.mix.Block:
+					op = "Block"
+					var existing *BlocklistItem
+					if existing, err = b.Block(ctx, id, data); err == nil && existing == nil {
+						err = b.AddLog(ctx, soakAction(ActionBlock, id))
+					}
+				case n < o.mix.Contains+o.mix.Block+o.mix.Unblock:
+					op = "Unblock"
+					if _, err = b.Unblock(ctx, id); err == nil {
+						err = b.AddLog(ctx, soakAction(ActionUnblock, id))
+					} else if errors.Is(err, ErrNotFound) {
+						err = nil
+					}
+				default:
+					op = "GetLogs"
+					_, err = b.GetLogs(ctx, LogQuery{Limit: 50})
+				}
+				if ctx.Err() != nil {
+					// The test ended during the operation.
+					return
+				}
+				stats.observe(op, begin, err)
+			}
+		}(time.Now().UnixNano() + int64(w))
+	}
+
+	if o.report != nil && o.interval > 0 {
+		t := time.NewTicker(o.interval)
+	loop:
+		for {
+			select {
+			case <-t.C:
+				o.report(report())
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		t.Stop()
+	}
+	wg.Wait()
+	final := report()
+
+	cleanup, cancelCleanup := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelCleanup()
+	if _, err := b.UnblockMany(cleanup, ids); err != nil {
+		return final, fmt.Errorf("unblocking the keyspace: %w", err)
+	}
+	return final, nil
+}
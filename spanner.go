@@ -0,0 +1,393 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// Defaults of the Spanner client.
+const (
+	DefaultSpannerEndpoint = "https://spanner.googleapis.com"
+	DefaultSpannerTimeout  = 10 * time.Second
+	DefaultSpannerTable    = "blocklist"
+)
+
+// spannerRows is the maximum number of rows of one commit. Spanner limits
+// commits to 80,000 mutations, counting each column and index entry.
+const spannerRows = 10000
+
+// spannerPage is the number of rows Query reads per request.
+const spannerPage = 1000
+
+// SpannerOption configures a Spanner.
+type SpannerOption func(*spannerOptions)
+
+type spannerOptions struct {
+	endpoint string
+	client   *http.Client
+	table    string
+}
+
+// WithSpannerEndpoint sends requests to `endpoint` instead of
+// DefaultSpannerEndpoint, like a regional endpoint.
+func WithSpannerEndpoint(endpoint string) SpannerOption {
+	return func(o *spannerOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithSpannerHTTPClient sends requests with `c` instead of a client with a
+// DefaultSpannerTimeout timeout.
+func WithSpannerHTTPClient(c *http.Client) SpannerOption {
+	return func(o *spannerOptions) {
+		o.client = c
+	}
+}
+
+// WithSpannerTable stores rows in the table `name` instead of
+// DefaultSpannerTable.
+func WithSpannerTable(name string) SpannerOption {
+	return func(o *spannerOptions) {
+		o.table = name
+	}
+}
+
+// Spanner is a datastore stored in a table of a Cloud Spanner database with
+// the GoogleSQL dialect, through the REST API. The table has to be created
+// with:
+//
+//	CREATE TABLE blocklist (
+//		DsKey STRING(MAX) NOT NULL,
+//		DsValue BYTES(MAX) NOT NULL,
+//	) PRIMARY KEY (DsKey)
+//
+// Reads are strong, so every reader sees the writes committed before it read
+// in any region. Batches are committed as mutations, in commits of up to
+// 10,000 rows, so larger batches aren't atomic.
+type Spanner struct {
+	api      gcpAPI
+	database string
+	table    string
+
+	mu       sync.Mutex
+	sessions []string // sessions are the sessions not in use.
+}
+
+var _ ds.Batching = (*Spanner)(nil)
+
+// NewSpanner returns a datastore stored in the database `database` of the
+// instance `instance` of the Google Cloud project `project`. `token` returns
+// the OAuth 2.0 access token of each request, which needs the spanner.data
+// scope, like the token source of a service account would.
+func NewSpanner(project, instance, database string, token func(context.Context) (string, error), opts ...SpannerOption) *Spanner {
+	o := &spannerOptions{
+		endpoint: DefaultSpannerEndpoint,
+		client:   &http.Client{Timeout: DefaultSpannerTimeout},
+		table:    DefaultSpannerTable,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Spanner{
+		api:      gcpAPI{"spanner", o.client, strings.TrimSuffix(o.endpoint, "/") + "/v1/", token},
+		database: fmt.Sprintf("projects/%v/instances/%v/databases/%v", project, instance, database),
+		table:    o.table,
+	}
+}
+
+// take returns a session not in use, or creates one. Sessions run one
+// request at a time.
+func (s *Spanner) take(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if n := len(s.sessions); n > 0 {
+		session := s.sessions[n-1]
+		s.sessions = s.sessions[:n-1]
+		s.mu.Unlock()
+		return session, nil
+	}
+	s.mu.Unlock()
+
+	raw, err := s.api.do(ctx, http.MethodPost, s.database+"/sessions", struct{}{})
+	if err != nil {
+		return "", err
+	}
+	var res struct{ Name string }
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return "", fmt.Errorf("spanner: creating a session: %w", err)
+	}
+	return res.Name, nil
+}
+
+// call sends `body` to the method `method` of a session, like
+// ":executeSql", and returns the response. Sessions Spanner deleted, after an
+// hour idle, are replaced by new ones.
+func (s *Spanner) call(method string, body interface{}) ([]byte, error) {
+	ctx := context.Background()
+	for retried := false; ; retried = true {
+		session, err := s.take(ctx)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := s.api.do(ctx, http.MethodPost, session+method, body)
+		var apiErr *gcpError
+		if !retried && errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			// "Session not found": leave it out of the pool.
+			continue
+		}
+		s.mu.Lock()
+		s.sessions = append(s.sessions, session)
+		s.mu.Unlock()
+		return raw, err
+	}
+}
+
+// query runs `sql` with the STRING parameters `params`, and returns the rows
+// of the result.
+func (s *Spanner) query(sql string, params map[string]string) ([][]string, error) {
+	types := make(map[string]interface{}, len(params))
+	for p := range params {
+		types[p] = map[string]string{"code": "STRING"}
+	}
+	raw, err := s.call(":executeSql", map[string]interface{}{"sql": sql, "params": params, "paramTypes": types})
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Rows [][]string
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("spanner: reading rows: %w", err)
+	}
+	return res.Rows, nil
+}
+
+// commit applies `mutations` in one transaction.
+func (s *Spanner) commit(mutations []interface{}) error {
+	_, err := s.call(":commit", map[string]interface{}{
+		"singleUseTransaction": map[string]interface{}{"readWrite": struct{}{}},
+		"mutations":            mutations,
+	})
+	return err
+}
+
+// putMutation returns the mutation that sets `k` to `value`.
+func (s *Spanner) putMutation(k ds.Key, value []byte) interface{} {
+	return map[string]interface{}{"insertOrUpdate": map[string]interface{}{
+		"table":   s.table,
+		"columns": []string{"DsKey", "DsValue"},
+		"values":  [][]string{{k.String(), base64.StdEncoding.EncodeToString(value)}},
+	}}
+}
+
+// deleteMutation returns the mutation that deletes `k`.
+func (s *Spanner) deleteMutation(k ds.Key) interface{} {
+	return map[string]interface{}{"delete": map[string]interface{}{
+		"table":  s.table,
+		"keySet": map[string]interface{}{"keys": [][]string{{k.String()}}},
+	}}
+}
+
+func (s *Spanner) Get(k ds.Key) ([]byte, error) {
+	rows, err := s.query(fmt.Sprintf("SELECT DsValue FROM `%v` WHERE DsKey = @k", s.table), map[string]string{"k": k.String()})
+	if err != nil {
+		return nil, err
+	} else if len(rows) == 0 {
+		return nil, ds.ErrNotFound
+	}
+	v, err := base64.StdEncoding.DecodeString(rows[0][0])
+	if err != nil {
+		return nil, fmt.Errorf("spanner: reading %v: %w", k, err)
+	}
+	return v, nil
+}
+
+// Has reads only the key of `k`, to avoid transferring its value.
+func (s *Spanner) Has(k ds.Key) (bool, error) {
+	rows, err := s.query(fmt.Sprintf("SELECT DsKey FROM `%v` WHERE DsKey = @k", s.table), map[string]string{"k": k.String()})
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+func (s *Spanner) GetSize(k ds.Key) (int, error) {
+	v, err := s.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (s *Spanner) Put(k ds.Key, value []byte) error {
+	return s.commit([]interface{}{s.putMutation(k, value)})
+}
+
+func (s *Spanner) Delete(k ds.Key) error {
+	return s.commit([]interface{}{s.deleteMutation(k)})
+}
+
+// page returns a page of the entries whose keys are in the range from `lo`
+// to `hi` excluded, from after `after` if it isn't empty.
+func (s *Spanner) page(lo, hi, after string, keysOnly bool) ([]dsq.Entry, error) {
+	fields := "DsKey, DsValue"
+	if keysOnly {
+		fields = "DsKey"
+	}
+	where, params := "DsKey >= @lo AND DsKey < @hi", map[string]string{"lo": lo, "hi": hi}
+	if after != "" {
+		where, params["lo"] = "DsKey > @lo AND DsKey < @hi", after
+	}
+	rows, err := s.query(fmt.Sprintf("SELECT %v FROM `%v` WHERE %v ORDER BY DsKey LIMIT %d",
+		fields, s.table, where, spannerPage), params)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dsq.Entry, len(rows))
+	for i, row := range rows {
+		if keysOnly {
+			entries[i] = dsq.Entry{Key: row[0], Size: -1}
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("spanner: reading %v: %w", row[0], err)
+		}
+		entries[i] = dsq.Entry{Key: row[0], Value: v, Size: len(v)}
+	}
+	return entries, nil
+}
+
+// Query lists the rows under the prefix of `q` a page at a time, in
+// ascending key order, each page in its own strong read. Ordering by
+// anything else than ascending key loads all results in memory.
+func (s *Spanner) Query(q dsq.Query) (dsq.Results, error) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	var (
+		entries []dsq.Entry
+		after   string
+		done    bool
+	)
+	next := func() (dsq.Result, bool) {
+		for len(entries) == 0 {
+			if done {
+				return dsq.Result{}, false
+			}
+			page, err := s.page(lo, hi, after, q.KeysOnly)
+			if err != nil {
+				done = true
+				return dsq.Result{Error: err}, true
+			}
+			entries, done = page, len(page) < spannerPage
+			if len(page) > 0 {
+				after = page[len(page)-1].Key
+			}
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return dsq.Result{Entry: e}, true
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are listed in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})), nil
+}
+
+// Sync does nothing: writes are durable once Spanner commits them.
+func (s *Spanner) Sync(prefix ds.Key) error {
+	return nil
+}
+
+// Close deletes the sessions of the datastore.
+func (s *Spanner) Close() error {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = nil
+	s.mu.Unlock()
+	var err error
+	for _, session := range sessions {
+		if _, e := s.api.do(context.Background(), http.MethodDelete, session, nil); e != nil {
+			err = e
+		}
+	}
+	s.api.client.CloseIdleConnections()
+	return err
+}
+
+func (s *Spanner) Batch() (ds.Batch, error) {
+	return &spannerBatch{spanner: s, mutations: make(map[ds.Key]interface{})}, nil
+}
+
+// spannerBatch buffers writes until Commit sends them as mutations.
+type spannerBatch struct {
+	spanner   *Spanner
+	mutations map[ds.Key]interface{}
+}
+
+func (b *spannerBatch) Put(k ds.Key, value []byte) error {
+	b.mutations[k] = b.spanner.putMutation(k, value)
+	return nil
+}
+
+func (b *spannerBatch) Delete(k ds.Key) error {
+	b.mutations[k] = b.spanner.deleteMutation(k)
+	return nil
+}
+
+// Commit writes the batch in commits of up to spannerRows rows. If one
+// fails, the commits sent before it stay applied.
+func (b *spannerBatch) Commit() error {
+	mutations := make([]interface{}, 0, len(b.mutations))
+	for _, m := range b.mutations {
+		mutations = append(mutations, m)
+	}
+	for len(mutations) > 0 {
+		n := len(mutations)
+		if n > spannerRows {
+			n = spannerRows
+		}
+		if err := b.spanner.commit(mutations[:n]); err != nil {
+			return err
+		}
+		mutations = mutations[n:]
+	}
+	return nil
+}
+
+// SpannerBlocklist is a DatastoreBlocklist stored in Cloud Spanner, for
+// gateways on Google Cloud that need the blocklist strongly consistent
+// across regions.
+//
+// Only one process should write to the table: audit actions are numbered by
+// the writer.
+type SpannerBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewSpannerBlocklist(s *Spanner, opts ...DatastoreOption) (*SpannerBlocklist, error) {
+	b, err := NewDatastoreBlocklist(s, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &SpannerBlocklist{b}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *SpannerBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
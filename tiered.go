@@ -0,0 +1,214 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// TieredBlocklist answers Contains from a fast blocklist, like a
+// MemoryBlocklist or one on Redis, in front of the authoritative one. Content
+// the fast layer doesn't contain is looked up in the authoritative blocklist,
+// and copied to the fast layer if it is blocked there. Blocks and unblocks
+// are written to the authoritative blocklist, then to the fast layer.
+//
+// The fast layer can be empty at start: it fills up as blocked content is
+// looked up. Every method but Contains, ContainsMany, Block, BlockMany,
+// Unblock, UnblockMany, and Close only uses the authoritative blocklist.
+type TieredBlocklist struct {
+	Blocklist
+	// fillErrors is first to keep it 64-bit aligned for atomic operations.
+	fillErrors uint64
+
+	fast Blocklist
+}
+
+// restorer is implemented by blocklists that can store entries copied from
+// another blocklist without validating them again, like DatastoreBlocklist.
+type restorer interface {
+	restore(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error)
+}
+
+// NewTiered returns a blocklist that answers lookups from `fast` and falls
+// through to `authoritative`.
+func NewTiered(fast, authoritative Blocklist) *TieredBlocklist {
+	return &TieredBlocklist{Blocklist: authoritative, fast: fast}
+}
+
+// Capabilities returns the Capabilities of the authoritative blocklist.
+func (b *TieredBlocklist) Capabilities() Capabilities {
+	return CapabilitiesOf(b.Blocklist)
+}
+
+// Fast returns the fast layer of the blocklist.
+func (b *TieredBlocklist) Fast() Blocklist {
+	return b.fast
+}
+
+// FillErrors returns the number of entries that couldn't be copied to the
+// fast layer. Lookups of their content keep falling through to the
+// authoritative blocklist.
+func (b *TieredBlocklist) FillErrors() uint64 {
+	return atomic.LoadUint64(&b.fillErrors)
+}
+
+// fill copies the entries of `ids` from the authoritative blocklist to the
+// fast layer. Errors are logged and counted: the next lookups fall through
+// again.
+func (b *TieredBlocklist) fill(ctx context.Context, ids []cid.Cid) {
+	items, err := b.Blocklist.SearchMany(ctx, ids)
+	if err != nil {
+		atomic.AddUint64(&b.fillErrors, uint64(len(ids)))
+		log.Warnf("filling the fast layer: %v", err)
+		return
+	}
+	for id, item := range items {
+		if item == nil {
+			continue
+		}
+		if err := b.copy(ctx, item); err != nil {
+			atomic.AddUint64(&b.fillErrors, 1)
+			log.Warnf("filling the fast layer with %v: %v", RedactID(id), err)
+		}
+	}
+}
+
+// copy blocks the content of `item` in the fast layer. Fast layers that are
+// restorers store it as is, so that entries written before the validation
+// rules they break are copied too.
+func (b *TieredBlocklist) copy(ctx context.Context, item *BlocklistItem) error {
+	id, err := cid.Decode(item.Hash)
+	if err != nil {
+		return err
+	}
+	data, err := item.blockData()
+	if err != nil {
+		return err
+	}
+	if r, ok := b.fast.(restorer); ok {
+		_, err = r.restore(ctx, id, data)
+	} else {
+		_, err = b.fast.Block(ctx, id, data)
+	}
+	return err
+}
+
+// Contains returns true if the fast layer contains `id`, and asks the
+// authoritative blocklist otherwise.
+func (b *TieredBlocklist) Contains(ctx context.Context, id cid.Cid) (bool, error) {
+	exists, err := b.fast.Contains(ctx, id)
+	if err == nil && exists {
+		return true, nil
+	} else if err != nil {
+		log.Debugf("fast layer lookup of %v: %v", RedactID(id), err)
+	}
+
+	exists, err = b.Blocklist.Contains(ctx, id)
+	if err == nil && exists {
+		b.fill(ctx, []cid.Cid{id})
+	}
+	return exists, err
+}
+
+// ContainsMany returns which of `ids` the blocklist contains, asking the
+// authoritative blocklist about those the fast layer doesn't contain.
+func (b *TieredBlocklist) ContainsMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res, err := b.fast.ContainsMany(ctx, ids)
+	if err != nil {
+		log.Debugf("fast layer lookup: %v", err)
+		res = nil
+	}
+	var missed []cid.Cid
+	for _, id := range ids {
+		if !res[id] {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) == 0 {
+		return res, nil
+	}
+
+	found, err := b.Blocklist.ContainsMany(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		res = make(map[cid.Cid]bool, len(ids))
+	}
+	var fill []cid.Cid
+	for _, id := range missed {
+		res[id] = found[id]
+		if found[id] {
+			fill = append(fill, id)
+		}
+	}
+	if len(fill) > 0 {
+		b.fill(ctx, fill)
+	}
+	return res, nil
+}
+
+// Block blocks `id` in the authoritative blocklist, then in the fast layer.
+// Failing to write to the fast layer is logged: lookups fall through until
+// it is filled.
+func (b *TieredBlocklist) Block(ctx context.Context, id cid.Cid, data BlockData) (*BlocklistItem, error) {
+	existing, err := b.Blocklist.Block(ctx, id, data)
+	if err != nil {
+		return nil, err
+	}
+	data.Rehash = false
+	if _, err := b.fast.Block(ctx, id, data); err != nil {
+		log.Warnf("blocking %v in the fast layer: %v", RedactID(id), err)
+	}
+	return existing, nil
+}
+
+func (b *TieredBlocklist) BlockMany(ctx context.Context, ids []cid.Cid, data BlockData) ([]cid.Cid, error) {
+	blocked, err := b.Blocklist.BlockMany(ctx, ids, data)
+	if err != nil {
+		return nil, err
+	}
+	data.Rehash = false
+	if _, err := b.fast.BlockMany(ctx, ids, data); err != nil {
+		log.Warnf("blocking %v in the fast layer: %v", redactIDs(ids), err)
+	}
+	return blocked, nil
+}
+
+// Unblock unblocks `id` in the authoritative blocklist, then in the fast
+// layer. Unlike Block, failing to write to the fast layer is returned, as it
+// would keep the content blocked. It is unblocked from the fast layer even if
+// the authoritative blocklist doesn't contain it, so that calling Unblock
+// again completes a failed one.
+func (b *TieredBlocklist) Unblock(ctx context.Context, id cid.Cid) (*BlocklistItem, error) {
+	removed, err := b.Blocklist.Unblock(ctx, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if _, ferr := b.fast.Unblock(ctx, id); ferr != nil && !errors.Is(ferr, ErrNotFound) {
+		return removed, ferr
+	}
+	return removed, err
+}
+
+func (b *TieredBlocklist) UnblockMany(ctx context.Context, ids []cid.Cid) (map[cid.Cid]bool, error) {
+	res, err := b.Blocklist.UnblockMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.fast.UnblockMany(ctx, ids); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// Close closes both blocklists.
+func (b *TieredBlocklist) Close(ctx context.Context) error {
+	err := b.fast.Close(ctx)
+	if aerr := b.Blocklist.Close(ctx); aerr != nil {
+		return aerr
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package blocklist
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTieredFillsEntriesThatPredateValidation(t *testing.T) {
+	ctx := context.Background()
+	authoritative, fast := NewMemoryBlocklist(), NewMemoryBlocklist()
+	id := testCID(t, "a")
+	// Entries written before users had to be email addresses.
+	if _, err := authoritative.restore(ctx, id, BlockData{Reason: "legacy", User: "ops"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewTiered(fast, authoritative)
+	if exists, err := b.Contains(ctx, id); err != nil || !exists {
+		t.Fatalf("got %v, %v, want true", exists, err)
+	}
+	if exists, err := fast.Contains(ctx, id); err != nil || !exists {
+		t.Errorf("fast layer: got %v, %v, want true", exists, err)
+	}
+	if n := b.FillErrors(); n != 0 {
+		t.Errorf("got %v fill errors, want 0", n)
+	}
+}
@@ -0,0 +1,336 @@
+//go:build tikv
+// +build tikv
+
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/txnkv"
+)
+
+// FormatCBOR is the format of CBORCodec.
+const FormatCBOR byte = 'c'
+
+// CBORCodec is a compact binary codec, whose values can be decoded without
+// the Go types they were encoded from. It is the default codec of
+// TiKVBlocklist.
+var CBORCodec Codec = cborCodec{}
+
+// cborMode encodes times with nanoseconds, as JSONCodec does.
+var cborMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+type cborCodec struct{}
+
+func (cborCodec) Format() byte                               { return FormatCBOR }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cborMode.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
+
+func init() {
+	if err := RegisterCodec(CBORCodec); err != nil {
+		panic(err)
+	}
+}
+
+// DefaultTiKVTimeout is how long requests to TiKV may take, except the scans
+// of queries.
+const DefaultTiKVTimeout = 10 * time.Second
+
+// tikvValue prefixes the values stored in TiKV, which doesn't store empty
+// values.
+const tikvValue byte = 0
+
+// TiKVOption configures a TiKV.
+type TiKVOption func(*tikvOptions)
+
+type tikvOptions struct {
+	timeout time.Duration
+}
+
+// WithTiKVTimeout sets how long requests may take, instead of
+// DefaultTiKVTimeout.
+func WithTiKVTimeout(d time.Duration) TiKVOption {
+	return func(o *tikvOptions) {
+		o.timeout = d
+	}
+}
+
+// TiKV is a datastore stored in TiKV, through its transactional API. Queries
+// scan the range of keys under their prefix, in either direction, at the
+// timestamp they start at. Batches are committed in one transaction, so they
+// are atomic.
+type TiKV struct {
+	client  *txnkv.Client
+	timeout time.Duration
+}
+
+var _ ds.Batching = (*TiKV)(nil)
+
+// NewTiKV returns a datastore stored in the cluster of `c`. The client is
+// owned by the caller, and isn't closed by Close.
+func NewTiKV(c *txnkv.Client, opts ...TiKVOption) *TiKV {
+	o := &tikvOptions{timeout: DefaultTiKVTimeout}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &TiKV{client: c, timeout: o.timeout}
+}
+
+// tikvError translates errors of the client: missing keys to ds.ErrNotFound,
+// and timeouts and unavailable regions to ErrBackendUnavailable.
+func tikvError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case tikverr.IsErrNotFound(err):
+		return ds.ErrNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, tikverr.ErrTiKVServerTimeout),
+		errors.Is(err, tikverr.ErrTiKVServerBusy), errors.Is(err, tikverr.ErrRegionUnavailable),
+		errors.Is(err, tikverr.ErrTiKVDiskFull):
+		return fmt.Errorf("%w: tikv: %v", ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+func (t *TiKV) Get(k ds.Key) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	txn, err := t.client.Begin()
+	if err != nil {
+		return nil, tikvError(err)
+	}
+	defer txn.Rollback()
+	v, err := txn.Get(ctx, k.Bytes())
+	if err != nil {
+		return nil, tikvError(err)
+	} else if len(v) == 0 || v[0] != tikvValue {
+		return nil, fmt.Errorf("tikv: value of %v has an unknown format", k)
+	}
+	return v[1:], nil
+}
+
+func (t *TiKV) Has(k ds.Key) (bool, error) {
+	_, err := t.Get(k)
+	if err == ds.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *TiKV) GetSize(k ds.Key) (int, error) {
+	v, err := t.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (t *TiKV) Put(k ds.Key, value []byte) error {
+	b, _ := t.Batch()
+	b.Put(k, value)
+	return b.Commit()
+}
+
+func (t *TiKV) Delete(k ds.Key) error {
+	b, _ := t.Batch()
+	b.Delete(k)
+	return b.Commit()
+}
+
+// tikvIterator is the iterator of a transaction.
+type tikvIterator interface {
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Next() error
+	Close()
+}
+
+// Query scans the keys under the prefix of `q`, in ascending key order, or
+// descending if `q` is ordered by descending key. Key comparisons of `q`
+// narrow the range scanned, and every filter and other order is applied to
+// the results.
+func (t *TiKV) Query(q dsq.Query) (dsq.Results, error) {
+	lo, hi := prefixRange(ds.NewKey(q.Prefix))
+	for _, f := range q.Filters {
+		switch f := f.(type) {
+		case dsq.FilterKeyCompare:
+			if f.Op == dsq.GreaterThanOrEqual && f.Key > lo {
+				lo = f.Key
+			} else if f.Op == dsq.LessThan && f.Key < hi {
+				hi = f.Key
+			}
+		}
+	}
+	naive := q
+	desc := false
+	if len(q.Orders) == 1 {
+		switch q.Orders[0].(type) {
+		case dsq.OrderByKey, *dsq.OrderByKey:
+			naive.Orders = nil
+		case dsq.OrderByKeyDescending, *dsq.OrderByKeyDescending:
+			desc, naive.Orders = true, nil
+		}
+	}
+	if lo >= hi {
+		return dsq.ResultsWithEntries(q, nil), nil
+	}
+
+	txn, err := t.client.Begin()
+	if err != nil {
+		return nil, tikvError(err)
+	}
+	var it tikvIterator
+	if desc {
+		it, err = txn.IterReverse([]byte(hi))
+	} else {
+		it, err = txn.Iter([]byte(lo), []byte(hi))
+	}
+	if err != nil {
+		txn.Rollback()
+		return nil, tikvError(err)
+	}
+
+	done, started := false, false
+	closeIter := func() error {
+		if !done {
+			done = true
+			it.Close()
+			txn.Rollback()
+		}
+		return nil
+	}
+	next := func() (dsq.Result, bool) {
+		if done {
+			return dsq.Result{}, false
+		}
+		if started {
+			if err := it.Next(); err != nil {
+				closeIter()
+				return dsq.Result{Error: tikvError(err)}, true
+			}
+		}
+		started = true
+		if !it.Valid() || string(it.Key()) < lo {
+			// Reverse scans stop at the start of the range.
+			closeIter()
+			return dsq.Result{}, false
+		}
+		k, v := string(it.Key()), it.Value()
+		if len(v) == 0 || v[0] != tikvValue {
+			closeIter()
+			return dsq.Result{Error: fmt.Errorf("tikv: value of %v has an unknown format", k)}, true
+		}
+		if q.KeysOnly {
+			return dsq.Result{Entry: dsq.Entry{Key: k, Size: -1}}, true
+		}
+		v = append([]byte(nil), v[1:]...)
+		return dsq.Result{Entry: dsq.Entry{Key: k, Value: v, Size: len(v)}}, true
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next, Close: closeIter})), nil
+}
+
+// Sync does nothing: writes are durable once TiKV commits them.
+func (t *TiKV) Sync(prefix ds.Key) error {
+	return nil
+}
+
+// Close does nothing: the client belongs to the caller.
+func (t *TiKV) Close() error {
+	return nil
+}
+
+func (t *TiKV) Batch() (ds.Batch, error) {
+	return &tikvBatch{tikv: t, puts: make(map[ds.Key][]byte), deletes: make(map[ds.Key]bool)}, nil
+}
+
+// tikvBatch buffers writes until Commit sends them in a transaction.
+type tikvBatch struct {
+	tikv    *TiKV
+	puts    map[ds.Key][]byte
+	deletes map[ds.Key]bool
+}
+
+func (b *tikvBatch) Put(k ds.Key, value []byte) error {
+	delete(b.deletes, k)
+	b.puts[k] = value
+	return nil
+}
+
+func (b *tikvBatch) Delete(k ds.Key) error {
+	delete(b.puts, k)
+	b.deletes[k] = true
+	return nil
+}
+
+// Commit writes the batch in one optimistic transaction. It fails if a key of
+// the batch was written by another transaction since it started.
+func (b *tikvBatch) Commit() error {
+	if len(b.puts) == 0 && len(b.deletes) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.tikv.timeout)
+	defer cancel()
+
+	txn, err := b.tikv.client.Begin()
+	if err != nil {
+		return tikvError(err)
+	}
+	for k, v := range b.puts {
+		if err := txn.Set(k.Bytes(), append([]byte{tikvValue}, v...)); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	for k := range b.deletes {
+		if err := txn.Delete(k.Bytes()); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return tikvError(txn.Commit(ctx))
+}
+
+// TiKVBlocklist is a DatastoreBlocklist stored in TiKV, for blocklists that
+// outgrow a single database. Entries are keyed on their normalized
+// multihashes, and their values, audit actions and comments are encoded with
+// CBORCodec, unless WithCodec sets other codecs. List and GetLogs scan the
+// ranges of their keys, a batch at a time.
+//
+// Only one process should write to the blocklist: audit actions are numbered
+// by the writer.
+type TiKVBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewTiKVBlocklist(t *TiKV, opts ...DatastoreOption) (*TiKVBlocklist, error) {
+	o, err := newDatastoreOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Codecs set in `opts` override the defaults.
+	opts = append([]DatastoreOption{
+		WithCodec(o.blocklist, CBORCodec),
+		WithCodec(o.audit, CBORCodec),
+		WithCodec(o.comment, CBORCodec),
+	}, opts...)
+	b, err := NewDatastoreBlocklist(t, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TiKVBlocklist{b}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *TiKVBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}
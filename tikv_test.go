@@ -0,0 +1,56 @@
+//go:build tikv
+// +build tikv
+
+package blocklist
+
+import (
+	"context"
+	"testing"
+
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/tikv/client-go/v2/testutils"
+	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/txnkv"
+)
+
+func TestTiKVConformance(t *testing.T) {
+	client, cluster, pd, err := testutils.NewMockTiKV("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.BootstrapWithSingleStore(cluster)
+	store, err := tikv.NewTestTiKVStore(client, pd, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	d := NewTiKV(&txnkv.Client{KVStore: store})
+	b, err := NewTiKVBlocklist(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDatastoreConformance(t, d, b)
+
+	// Entries and audit actions are stored in CBOR, and read back in both
+	// directions.
+	ctx := context.Background()
+	if _, err := b.Block(ctx, testCID(t, "cbor"), BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, order := range []dsq.Order{dsq.OrderByKey{}, dsq.OrderByKeyDescending{}} {
+		res, err := d.Query(dsq.Query{Prefix: SafemodePrefix.Child(BlocklistPrefix).String(), Orders: []dsq.Order{order}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(entries) != 1 || entries[0].Value[0] != FormatCBOR {
+			t.Fatalf("entries in %v order are %+v, want one in CBOR", order, entries)
+		}
+	}
+	if acts, err := b.GetLogs(ctx, LogQuery{Limit: 1}); err != nil || len(acts) != 1 {
+		t.Fatalf("GetLogs = %v, %v, want 1 action", acts, err)
+	}
+}
@@ -0,0 +1,80 @@
+package blocklist
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Transformer canonicalizes the CIDs given to a blocklist into the form
+// entries are stored and looked up under. Changing the Transformer of a
+// backend makes existing entries unreachable.
+type Transformer interface {
+	Transform(id cid.Cid) (cid.Cid, error)
+}
+
+// TransformerFunc is a function used as a Transformer.
+type TransformerFunc func(id cid.Cid) (cid.Cid, error)
+
+func (f TransformerFunc) Transform(id cid.Cid) (cid.Cid, error) {
+	return f(id)
+}
+
+// Pipeline is a Transformer that applies its steps in order.
+type Pipeline []Transformer
+
+func (p Pipeline) Transform(id cid.Cid) (cid.Cid, error) {
+	for _, t := range p {
+		var err error
+		if id, err = t.Transform(id); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return id, nil
+}
+
+var (
+	// CIDv1 converts CIDv0 to CIDv1, leaving the codec and multihash as is.
+	// It is the default Transformer of both backends.
+	CIDv1 Transformer = TransformerFunc(func(id cid.Cid) (cid.Cid, error) {
+		if id.Version() == 0 {
+			return cid.NewCidV1(cid.DagProtobuf, id.Hash()), nil
+		}
+		return id, nil
+	})
+
+	// Multihash drops the version and codec, so that the same content
+	// blocked under any codec is stored under one CID. The backends match
+	// entries on their multihashes with any Transformer, so it only changes
+	// the CIDs entries are listed with.
+	Multihash Transformer = TransformerFunc(func(id cid.Cid) (cid.Cid, error) {
+		return cid.NewCidV1(cid.Raw, id.Hash()), nil
+	})
+
+	// DoubleHash replaces the multihash with its SHA-256, so that the stored
+	// entries don't reveal what content is blocked.
+	DoubleHash Transformer = TransformerFunc(func(id cid.Cid) (cid.Cid, error) {
+		hash, err := mh.Sum(id.Hash(), mh.SHA2_256, -1)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return cid.NewCidV1(cid.Raw, hash), nil
+	})
+)
+
+// HMAC returns a Transformer that replaces the multihash with its
+// HMAC-SHA256 under `key`. Unlike DoubleHash, entries can't be confirmed
+// without the key.
+func HMAC(key []byte) Transformer {
+	return TransformerFunc(func(id cid.Cid) (cid.Cid, error) {
+		m := hmac.New(sha256.New, key)
+		m.Write(id.Hash())
+		hash, err := mh.Encode(m.Sum(nil), mh.IDENTITY)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return cid.NewCidV1(cid.Raw, hash), nil
+	})
+}
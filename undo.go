@@ -0,0 +1,128 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+var (
+	ErrNothingToUndo = fmt.Errorf("no action to undo")
+	ErrUndoUnsafe    = fmt.Errorf("action was followed by conflicting actions of another user")
+	ErrLegalHold     = fmt.Errorf("entry is under legal hold")
+	ErrUndoLost      = fmt.Errorf("unblocked entry can't be restored")
+)
+
+// LegalHoldKey is the Metadata key that puts entries under legal hold. Entries
+// with a non-empty value for it aren't unblocked by UndoLastAction.
+const LegalHoldKey = "legal_hold"
+
+// UndoLastAction inverts the most recent block or unblock action of `user`
+// that hasn't been undone yet, and logs an "undo" action linked to it.
+//
+// Blocks are undone by unblocking the ids that are still blocked, unless one
+// of their entries is under legal hold, see LegalHoldKey. Unblocks are undone
+// by restoring the unblocked entries from SearchHistory, with their reason,
+// content, metadata, and references, and their digests if the backend keeps
+// them in its history; if one of them can't be found, undoing is refused with
+// ErrUndoLost. Undoing is refused with ErrUndoUnsafe
+// if another user acted on the same ids since. Nothing is changed when undoing
+// is refused.
+func UndoLastAction(ctx context.Context, b Blocklist, user string) (*Action, error) {
+	iterCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		target  *Action
+		undone  = make(map[uint64]bool)
+		touched = make(map[cid.Cid]bool) // ids acted on by others since
+	)
+	acts, errc := LogsIter(iterCtx, b, LogQuery{}, DefaultListLimit)
+	for act := range acts {
+		if act.Typ == ActionUndo {
+			undone[act.Undoes] = true
+		} else if act.Typ != ActionBlock && act.Typ != ActionUnblock {
+			continue
+		} else if act.User == user && !undone[act.Seq] {
+			target = act
+			break
+		} else if act.User != user {
+			for _, id := range act.Ids {
+				touched[id] = true
+			}
+		}
+	}
+	if target == nil {
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		return nil, ErrNothingToUndo
+	}
+	cancel() // The rest of the log isn't needed.
+
+	for _, id := range target.Ids {
+		if touched[id] {
+			return nil, ErrUndoUnsafe
+		}
+	}
+
+	undo := &Action{
+		Typ:       ActionUndo,
+		Undoes:    target.Seq,
+		Reason:    fmt.Sprintf("undo %v #%v", target.Typ, target.Seq),
+		User:      user,
+		CreatedAt: time.Now(),
+	}
+	switch target.Typ {
+	case ActionBlock:
+		items, err := b.SearchMany(ctx, target.Ids)
+		if err != nil {
+			return nil, err
+		}
+		for id, item := range items {
+			if item != nil && item.Metadata[LegalHoldKey] != "" {
+				return nil, fmt.Errorf("%w: %v", ErrLegalHold, RedactID(id))
+			}
+		}
+		res, err := b.UnblockMany(ctx, target.Ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range target.Ids {
+			if res[id] {
+				undo.Ids = append(undo.Ids, id)
+			}
+		}
+	case ActionUnblock:
+		entries := make([]BlockData, len(target.Ids))
+		for i, id := range target.Ids {
+			item, err := b.SearchHistory(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				return nil, fmt.Errorf("%w: %v", ErrUndoLost, RedactID(id))
+			} else if err != nil {
+				return nil, err
+			}
+			if entries[i], err = item.blockData(); err != nil {
+				return nil, fmt.Errorf("%w: %v: %v", ErrUndoLost, RedactID(id), err)
+			}
+		}
+		for i, id := range target.Ids {
+			existing, err := b.Block(ctx, id, entries[i])
+			if err != nil {
+				return nil, err
+			} else if existing == nil {
+				undo.Ids = append(undo.Ids, id)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("can't undo action type: '%v'", target.Typ)
+	}
+
+	if err := b.AddLog(ctx, undo); err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
@@ -0,0 +1,165 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// testCID returns a CIDv1 of `s`.
+func testCID(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+// unsequencedBlocklist returns every action of the audit log without its Seq,
+// whatever the cursor, like a backend that doesn't number actions.
+type unsequencedBlocklist struct {
+	*MemoryBlocklist
+}
+
+func (b unsequencedBlocklist) GetLogs(ctx context.Context, q LogQuery) ([]*Action, error) {
+	q.Before = 0
+	acts, err := b.MemoryBlocklist.GetLogs(ctx, q)
+	for _, act := range acts {
+		act.Seq = 0
+	}
+	return acts, err
+}
+
+func TestUndoLastActionEndsWithoutSeq(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := unsequencedBlocklist{NewMemoryBlocklist()}
+	for i := 0; i < 2*DefaultListLimit; i++ {
+		err := b.AddLog(ctx, &Action{Typ: ActionBlock, Ids: []cid.Cid{testCID(t, "x")}, User: "other@x.com", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("got %v, want ErrNothingToUndo", err)
+	}
+}
+
+func TestUndoLastActionEndsAtStartOfLog(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	for i := 0; i < 2*DefaultListLimit+1; i++ {
+		err := b.AddLog(ctx, &Action{Typ: ActionBlock, Ids: []cid.Cid{testCID(t, "x")}, User: "other@x.com", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("got %v, want ErrNothingToUndo", err)
+	}
+}
+
+func TestUndoLastActionUnblocks(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	id := testCID(t, "a")
+	if _, err := b.Block(ctx, id, BlockData{Reason: "test", User: "u@x.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLog(ctx, &Action{Typ: ActionBlock, Ids: []cid.Cid{id}, User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	undo, err := UndoLastAction(ctx, b, "u@x.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undo.Undoes != 1 || len(undo.Ids) != 1 {
+		t.Errorf("unexpected undo action: %+v", undo)
+	}
+	if blocked, err := b.Contains(ctx, id); err != nil || blocked {
+		t.Errorf("got %v, %v after undo, want false", blocked, err)
+	}
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("undoing again: got %v, want ErrNothingToUndo", err)
+	}
+}
+
+func TestUndoLastActionRestoresUnblockedEntry(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	id, digest := testCID(t, "a"), testCID(t, "a-digest")
+	data := BlockData{
+		Content:  []string{"https://example.com/a"},
+		Reason:   "test",
+		User:     "u@x.com",
+		Metadata: map[string]string{DefaultCategoryKey: "malware"},
+		Digests:  []cid.Cid{digest},
+	}
+	if _, err := b.Block(ctx, id, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Unblock(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLog(ctx, &Action{Typ: ActionUnblock, Ids: []cid.Cid{id}, Reason: "mistake", User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); err != nil {
+		t.Fatal(err)
+	}
+	item, err := b.Search(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Reason != "test" || item.Metadata[DefaultCategoryKey] != "malware" || len(item.Content) != 1 {
+		t.Errorf("entry wasn't restored: %+v", item)
+	}
+	if blocked, err := b.Contains(ctx, digest); err != nil || !blocked {
+		t.Errorf("digest: got %v, %v after undo, want true", blocked, err)
+	}
+}
+
+func TestUndoLastActionRefusesLostEntry(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	id := testCID(t, "a")
+	if err := b.AddLog(ctx, &Action{Typ: ActionUnblock, Ids: []cid.Cid{id}, User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); !errors.Is(err, ErrUndoLost) {
+		t.Fatalf("got %v, want ErrUndoLost", err)
+	}
+	if blocked, err := b.Contains(ctx, id); err != nil || blocked {
+		t.Errorf("got %v, %v after refused undo, want false", blocked, err)
+	}
+}
+
+func TestUndoLastActionRefusesLegalHold(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBlocklist()
+	id := testCID(t, "a")
+	data := BlockData{Reason: "test", User: "u@x.com", Metadata: map[string]string{LegalHoldKey: "case 42"}}
+	if _, err := b.Block(ctx, id, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddLog(ctx, &Action{Typ: ActionBlock, Ids: []cid.Cid{id}, User: "u@x.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UndoLastAction(ctx, b, "u@x.com"); !errors.Is(err, ErrLegalHold) {
+		t.Fatalf("got %v, want ErrLegalHold", err)
+	}
+	if blocked, err := b.Contains(ctx, id); err != nil || !blocked {
+		t.Errorf("got %v, %v after refused undo, want true", blocked, err)
+	}
+}
@@ -0,0 +1,160 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+var (
+	ErrMalformedNode = fmt.Errorf("malformed dag-pb node")
+	ErrNotFile       = fmt.Errorf("content is not a unixfs file")
+)
+
+// unixfs data types, as defined in the unixfs protobuf spec.
+const (
+	unixfsRaw  = 0
+	unixfsFile = 2
+)
+
+// writeRaw writes the raw content referenced by `id` to `w`, reassembling
+// unixfs files from the blocks stored in `d`. The result only depends on the
+// content itself, not on how it was chunked or which CID version was used.
+func writeRaw(ctx context.Context, d ds.Read, id cid.Cid, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := d.Get(dshelp.CidToDsKey(id))
+	if err != nil {
+		return err
+	}
+
+	switch id.Type() {
+	case cid.Raw:
+		_, err := w.Write(data)
+		return err
+	case cid.DagProtobuf:
+		links, ufs, err := decodePBNode(data)
+		if err != nil {
+			return err
+		}
+		typ, content, err := decodeUnixfsData(ufs)
+		if err != nil {
+			return err
+		} else if typ != unixfsRaw && typ != unixfsFile {
+			return ErrNotFile
+		}
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err := writeRaw(ctx, d, l, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ErrNotFile
+	}
+}
+
+// decodePBNode returns the links and data of a dag-pb node.
+func decodePBNode(data []byte) ([]cid.Cid, []byte, error) {
+	var (
+		links []cid.Cid
+		ufs   []byte
+	)
+	err := pbFields(data, func(num int, _ uint64, b []byte) error {
+		switch num {
+		case 1: // PBNode.Data
+			ufs = b
+		case 2: // PBNode.Links
+			return pbFields(b, func(num int, _ uint64, b []byte) error {
+				if num != 1 { // PBLink.Hash
+					return nil
+				}
+				l, err := cid.Cast(b)
+				if err != nil {
+					return err
+				}
+				links = append(links, l)
+				return nil
+			})
+		}
+		return nil
+	})
+	return links, ufs, err
+}
+
+// decodeUnixfsData returns the type and inline content of a unixfs Data
+// message.
+func decodeUnixfsData(data []byte) (uint64, []byte, error) {
+	var (
+		typ     uint64
+		content []byte
+	)
+	err := pbFields(data, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1: // Data.Type
+			typ = v
+		case 2: // Data.Data
+			content = b
+		}
+		return nil
+	})
+	return typ, content, err
+}
+
+// pbFields calls `fn` with the field number and value of every field in the
+// protobuf message `data`. Varint fields are passed as `v`, length-delimited
+// fields as `b`; fixed-size fields are skipped.
+func pbFields(data []byte, fn func(num int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrMalformedNode
+		}
+		data = data[n:]
+
+		num := int(key >> 3)
+		switch key & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return ErrMalformedNode
+			}
+			data = data[n:]
+			if err := fn(num, v, nil); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return ErrMalformedNode
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return ErrMalformedNode
+			}
+			b := data[n : n+int(l)]
+			data = data[n+int(l):]
+			if err := fn(num, 0, b); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return ErrMalformedNode
+			}
+			data = data[4:]
+		default:
+			return ErrMalformedNode
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package blocklist
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrInvalidBlockData is wrapped by the errors of BlockData.Validate, and of
+// other input validated by the backends.
+var ErrInvalidBlockData = fmt.Errorf("invalid blocklist data")
+
+// FieldError describes why one field of BlockData is invalid.
+type FieldError struct {
+	Field   string // Field is the name of the field, like "User" or "Content[1]".
+	Value   string
+	Problem string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%v %q: %v", e.Field, e.Value, e.Problem)
+}
+
+// ValidationError lists all invalid fields of a BlockData, so that a form
+// can report them at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Error()
+	}
+	return ErrInvalidBlockData.Error() + ": " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidBlockData
+}
+
+// Validate returns `data` normalized, or a *ValidationError if any field is
+// invalid. User has to be a bare email address, Reason can't be empty, and
+// every Content entry has to be a CID or an absolute URL. Metadata keys can't
+// be empty, and References need a known type and an ID or absolute URL. Surrounding whitespace is trimmed, emails are lowercased, and
+// empty Content entries are dropped.
+func (data BlockData) Validate() (BlockData, error) {
+	return data.validate(false)
+}
+
+// validate is Validate, except that Reason may be empty if `patch` is set, as
+// it is for the patches given to Update.
+func (data BlockData) validate(patch bool) (BlockData, error) {
+	var errs []FieldError
+
+	data.User = strings.ToLower(strings.TrimSpace(data.User))
+	if addr, err := mail.ParseAddress(data.User); err != nil || addr.Address != data.User {
+		errs = append(errs, FieldError{"User", data.User, "not an email address"})
+	}
+
+	data.Reason = strings.TrimSpace(data.Reason)
+	if data.Reason == "" && !patch {
+		errs = append(errs, FieldError{"Reason", data.Reason, "empty"})
+	}
+
+	content := make([]string, 0, len(data.Content))
+	for i, c := range data.Content {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		} else if _, err := cid.Decode(c); err == nil {
+			content = append(content, c)
+			continue
+		}
+		u, err := url.Parse(c)
+		if err != nil || !u.IsAbs() || (u.Host == "" && u.Opaque == "") {
+			errs = append(errs, FieldError{fmt.Sprintf("Content[%d]", i), c, "neither a CID nor a URL"})
+			continue
+		}
+		content = append(content, c)
+	}
+	if data.Content != nil {
+		data.Content = content
+	}
+
+	if data.Metadata != nil {
+		metadata := make(map[string]string, len(data.Metadata))
+		for k, v := range data.Metadata {
+			k = strings.TrimSpace(k)
+			if k == "" {
+				errs = append(errs, FieldError{"Metadata", k, "empty key"})
+				continue
+			}
+			metadata[k] = v
+		}
+		data.Metadata = metadata
+	}
+
+	if data.References != nil {
+		refs := make([]Reference, len(data.References))
+		for i, r := range data.References {
+			var ferr *FieldError
+			if refs[i], ferr = r.validate(fmt.Sprintf("References[%d]", i)); ferr != nil {
+				errs = append(errs, *ferr)
+			}
+		}
+		data.References = refs
+	}
+
+	if len(errs) > 0 {
+		return data, &ValidationError{errs}
+	}
+	return data, nil
+}
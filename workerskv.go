@@ -0,0 +1,320 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// KVPropagationDelay is how long Workers KV takes to make a write visible in
+// every location. Reads in other locations, including the negative lookups of
+// Contains, may be stale for that long.
+const KVPropagationDelay = 60 * time.Second
+
+// kvBulkLimit is the maximum number of keys of one bulk request.
+const kvBulkLimit = 10000
+
+// KVOption configures a WorkersKV.
+type KVOption func(*kvOptions)
+
+type kvOptions struct {
+	endpoint string
+	client   *http.Client
+}
+
+// WithKVEndpoint sends requests to `endpoint` instead of
+// DefaultCloudflareEndpoint,
+// like a proxy or a mock of the API.
+func WithKVEndpoint(endpoint string) KVOption {
+	return func(o *kvOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithKVHTTPClient sends requests with `c` instead of a client with a
+// DefaultCloudflareTimeout timeout.
+func WithKVHTTPClient(c *http.Client) KVOption {
+	return func(o *kvOptions) {
+		o.client = c
+	}
+}
+
+// WorkersKV is a datastore stored in a Workers KV namespace, through the REST
+// API. Keys are stored as is, so that Workers can look up entries with the
+// same keys as DatastoreBlocklist.
+//
+// Workers KV is eventually consistent: writes become visible in other
+// locations within KVPropagationDelay, and keys are listed in lexicographic
+// order only. Batches are written with the bulk API, and aren't atomic.
+type WorkersKV struct {
+	api cfAPI
+}
+
+var _ ds.Batching = (*WorkersKV)(nil)
+
+// NewWorkersKV returns a datastore stored in the KV namespace `namespace` of
+// the Cloudflare account `account`. `apiToken` needs the Workers KV Storage
+// edit permission.
+func NewWorkersKV(account, namespace, apiToken string, opts ...KVOption) *WorkersKV {
+	o := &kvOptions{
+		endpoint: DefaultCloudflareEndpoint,
+		client:   &http.Client{Timeout: DefaultCloudflareTimeout},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	base := fmt.Sprintf("%v/accounts/%v/storage/kv/namespaces/%v",
+		strings.TrimSuffix(o.endpoint, "/"), url.PathEscape(account), url.PathEscape(namespace))
+	return &WorkersKV{cfAPI{o.client, base, apiToken}}
+}
+
+// do sends a request to the API. Missing keys are reported as
+// ds.ErrNotFound.
+func (kv *WorkersKV) do(method, endpoint string, body io.Reader, contentType string) ([]byte, error) {
+	raw, err := kv.api.do(context.Background(), method, endpoint, body, contentType)
+	var apiErr *cfError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		return nil, ds.ErrNotFound
+	}
+	return raw, err
+}
+
+// kvKey returns the path of `k` under an endpoint of the API.
+func kvKey(k ds.Key) string {
+	return url.PathEscape(k.String())
+}
+
+func (kv *WorkersKV) Get(k ds.Key) ([]byte, error) {
+	return kv.do(http.MethodGet, "/values/"+kvKey(k), nil, "")
+}
+
+// Has looks up the metadata of `k`, to avoid transferring its value.
+func (kv *WorkersKV) Has(k ds.Key) (bool, error) {
+	_, err := kv.do(http.MethodGet, "/metadata/"+kvKey(k), nil, "")
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (kv *WorkersKV) GetSize(k ds.Key) (int, error) {
+	v, err := kv.Get(k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (kv *WorkersKV) Put(k ds.Key, value []byte) error {
+	_, err := kv.do(http.MethodPut, "/values/"+kvKey(k), bytes.NewReader(value), "application/octet-stream")
+	return err
+}
+
+func (kv *WorkersKV) Delete(k ds.Key) error {
+	_, err := kv.do(http.MethodDelete, "/values/"+kvKey(k), nil, "")
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// keys returns a page of the names that start with `prefix`, and the cursor
+// of the next page, or "" if it is the last one.
+func (kv *WorkersKV) keys(prefix, cursor string) ([]string, string, error) {
+	params := url.Values{"prefix": {prefix}, "limit": {"1000"}}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	raw, err := kv.do(http.MethodGet, "/keys?"+params.Encode(), nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	var res cfResponse
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, "", fmt.Errorf("workers kv: listing keys: %w", err)
+	}
+	var page []struct{ Name string }
+	if err := json.Unmarshal(res.Result, &page); err != nil {
+		return nil, "", fmt.Errorf("workers kv: listing keys: %w", err)
+	}
+	names := make([]string, len(page))
+	for i, p := range page {
+		names[i] = p.Name
+	}
+	return names, res.ResultInfo.Cursor, nil
+}
+
+// Query lists the keys under the prefix of `q` a page at a time, and gets
+// their values one by one. Ordering by anything else than ascending key
+// loads all results in memory.
+func (kv *WorkersKV) Query(q dsq.Query) (dsq.Results, error) {
+	prefix := ds.NewKey(q.Prefix).String()
+	if prefix != "/" {
+		prefix += "/"
+	}
+	var (
+		names  []string
+		cursor string
+		done   bool
+	)
+	next := func() (dsq.Result, bool) {
+		for {
+			if len(names) == 0 {
+				if done {
+					return dsq.Result{}, false
+				}
+				page, c, err := kv.keys(prefix, cursor)
+				if err != nil {
+					done = true
+					return dsq.Result{Error: err}, true
+				}
+				names, cursor, done = page, c, c == ""
+				continue
+			}
+			name := names[0]
+			names = names[1:]
+			if q.KeysOnly {
+				return dsq.Result{Entry: dsq.Entry{Key: name, Size: -1}}, true
+			}
+			v, err := kv.Get(ds.RawKey(name))
+			if errors.Is(err, ds.ErrNotFound) {
+				// Deleted since it was listed.
+				continue
+			} else if err != nil {
+				return dsq.Result{Error: err}, true
+			}
+			return dsq.Result{Entry: dsq.Entry{Key: name, Value: v, Size: len(v)}}, true
+		}
+	}
+
+	naive := q
+	if len(q.Orders) == 1 {
+		if _, ok := q.Orders[0].(dsq.OrderByKey); ok {
+			// Keys are listed in this order already.
+			naive.Orders = nil
+		}
+	}
+	return dsq.NaiveQueryApply(naive, dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})), nil
+}
+
+// Sync does nothing: writes are durable once the API acknowledges them.
+func (kv *WorkersKV) Sync(prefix ds.Key) error {
+	return nil
+}
+
+func (kv *WorkersKV) Close() error {
+	return nil
+}
+
+func (kv *WorkersKV) Batch() (ds.Batch, error) {
+	return &kvBatch{kv: kv, puts: make(map[ds.Key][]byte), deletes: make(map[ds.Key]bool)}, nil
+}
+
+// kvBatch buffers writes until Commit sends them with the bulk API.
+type kvBatch struct {
+	kv      *WorkersKV
+	puts    map[ds.Key][]byte
+	deletes map[ds.Key]bool
+}
+
+func (b *kvBatch) Put(k ds.Key, value []byte) error {
+	delete(b.deletes, k)
+	b.puts[k] = value
+	return nil
+}
+
+func (b *kvBatch) Delete(k ds.Key) error {
+	delete(b.puts, k)
+	b.deletes[k] = true
+	return nil
+}
+
+// kvPair is a key/value pair of a bulk write.
+type kvPair struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Base64 bool   `json:"base64"`
+}
+
+// Commit writes the batch in requests of up to kvBulkLimit keys. If one
+// fails, the requests sent before it stay applied.
+func (b *kvBatch) Commit() error {
+	pairs := make([]kvPair, 0, len(b.puts))
+	for k, v := range b.puts {
+		pairs = append(pairs, kvPair{k.String(), base64.StdEncoding.EncodeToString(v), true})
+	}
+	for len(pairs) > 0 {
+		n := len(pairs)
+		if n > kvBulkLimit {
+			n = kvBulkLimit
+		}
+		if err := b.bulk(http.MethodPut, "/bulk", pairs[:n]); err != nil {
+			return err
+		}
+		pairs = pairs[n:]
+	}
+
+	keys := make([]string, 0, len(b.deletes))
+	for k := range b.deletes {
+		keys = append(keys, k.String())
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > kvBulkLimit {
+			n = kvBulkLimit
+		}
+		if err := b.bulk(http.MethodPost, "/bulk/delete", keys[:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// bulk sends `body` as JSON to a bulk endpoint.
+func (b *kvBatch) bulk(method, endpoint string, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = b.kv.do(method, endpoint, bytes.NewReader(raw), "application/json")
+	return err
+}
+
+// WorkersKVBlocklist is a DatastoreBlocklist stored in Workers KV, so that
+// Workers at the edge and the origin gateway share one blocklist. Workers
+// look up entries by the keys DatastoreBlocklist stores them under.
+//
+// Only one process should write to the namespace: audit actions are numbered
+// by the writer. As reads are eventually consistent, wrap the blocklist with
+// NewReadYourWrites and KVPropagationDelay for Contains to reflect the
+// writer's own changes immediately. Other readers see them within
+// KVPropagationDelay.
+type WorkersKVBlocklist struct {
+	DatastoreBlocklist
+}
+
+func NewWorkersKVBlocklist(kv *WorkersKV, opts ...DatastoreOption) (*WorkersKVBlocklist, error) {
+	b, err := NewDatastoreBlocklist(kv, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkersKVBlocklist{b}, nil
+}
+
+// Capabilities returns the optional features of the blocklist. There is no
+// content to purge or rehash, and List orders entries by key.
+func (b *WorkersKVBlocklist) Capabilities() Capabilities {
+	return Capabilities{}
+}